@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// accountPurgeInterval is how often deleted accounts are checked for being
+// past -deletion-grace
+const accountPurgeInterval = time.Hour
+
+// AccountData is everything kept about a user, to download. Its attempts are
+// as in an export, so it can be imported elsewhere.
+type AccountData struct {
+	Version    int                 `json:"version"`
+	ExportedAt time.Time           `json:"exportedAt"`
+	Profile    Profile             `json:"profile"`
+	Workspaces []WorkspaceSummary  `json:"workspaces"`
+	Goals      []Goal              `json:"goals"`
+	Comments   []Comment           `json:"comments"`           // they wrote, on anyone's attempts
+	Synced     map[string]SyncItem `json:"synced,omitempty"`   // their draft and settings kept for their devices
+	Attempts   []ExportedAttempt   `json:"attempts,omitempty"` // in every workspace, oldest first
+}
+
+// AccountDeletion is when a deleted account will be purged
+type AccountDeletion struct {
+	PurgeAt time.Time `json:"purgeAt"`
+}
+
+// handleAccountData downloads the signed-in user's profile, workspaces, goals,
+// comments, synced items and attempts. The attempts are written as they're read, so it can be large.
+func handleAccountData(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	data := AccountData{Version: exportVersion, ExportedAt: time.Now()}
+	var err error
+	data.Profile, err = loadProfile(u)
+	if err == nil {
+		data.Workspaces, err = userWorkspaces(u.ID)
+	}
+	if err == nil {
+		data.Goals, err = store.Goals(u.ID)
+	}
+	if err == nil {
+		data.Comments, err = store.UserComments(u.ID)
+	}
+	if err == nil {
+		data.Synced, err = userSyncItems(u.ID)
+	}
+	var header []byte
+	if err == nil {
+		header, err = json.Marshal(data)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load account data", "userId", u.ID, "err", err)
+		httpError(w, "Failed to load account data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="tradra-account.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	// A long history may take longer than the write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	// The attempts take the place of the header's closing brace
+	err = func() error {
+		if _, err := w.Write(append(header[:len(header)-1], `,"attempts":`...)); err != nil {
+			return err
+		}
+		if err := exportAttemptsJSON(w, AttemptFilter{UserID: u.ID, AnyWorkspace: true}); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte("}\n"))
+		return err
+	}()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to export account data", "userId", u.ID, "err", err)
+	}
+}
+
+// handleDeleteAccount deletes the signed-in user's account and signs them
+// out. Their attempts are hidden at once and purged with the account, images
+// and all, after the grace period, unless they sign in again first.
+func handleDeleteAccount(cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := userFrom(r.Context())
+		if !ok {
+			httpError(w, "Not signed in", http.StatusUnauthorized)
+			return
+		}
+		now := time.Now()
+		_, err := loadProfile(u)
+		if err == nil {
+			err = store.DeleteUser(u.ID, now)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to delete account", "userId", u.ID, "err", err)
+			httpError(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Account deleted", "userId", u.ID, "purgeAt", now.Add(cfg.deletionGrace))
+
+		login.setCookie(w, r, sessionCookie, "", sitePath("/"), -1)
+		w.Header().Set("Cache-Control", "no-store")
+		writeResponse(w, r, http.StatusAccepted, AccountDeletion{PurgeAt: now.Add(cfg.deletionGrace)})
+	}
+}
+
+// runAccountPurges purges deleted accounts once their grace period is over,
+// checking every accountPurgeInterval while the server runs
+func runAccountPurges(grace time.Duration) {
+	for {
+		purgeDeletedAccounts(context.Background(), time.Now(), grace)
+		time.Sleep(accountPurgeInterval)
+	}
+}
+
+// purgeDeletedAccounts removes the accounts deleted more than grace ago with
+// their attempts, goals, comments, files and workspace memberships
+func purgeDeletedAccounts(ctx context.Context, now time.Time, grace time.Duration) {
+	ids, err := store.DeletedUsers(now.Add(-grace))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load deleted accounts", "err", err)
+		return
+	}
+	for _, id := range ids {
+		err := store.PurgeUser(id, removeAttemptFiles)
+		if errors.Is(err, errUserNotFound) {
+			continue // they signed in again
+		}
+		if err == nil {
+			err = leaveWorkspaces(id)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to purge deleted account", "userId", id, "err", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Purged deleted account", "userId", id)
+	}
+}
+
+// removeAttemptFiles deletes the files kept for an attempt: its images and
+// its request from before the database
+func removeAttemptFiles(a AttemptSummary) error {
+	if err := removeAttemptImages(a); err != nil {
+		return err
+	}
+	return removeRequestFile(a)
+}
+
+// removeRequestFile deletes the file an attempt's request was kept in before
+// the database, which imported it and left it be
+func removeRequestFile(a AttemptSummary) error {
+	if !validAttemptID(a.ID) {
+		return nil
+	}
+	err := os.Remove(filepath.Join(resultsDir, attemptsDir, a.ID+".json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// removeAttemptImages deletes an attempt's saved image and cached thumbnail,
+// on disk and in object storage
+func removeAttemptImages(a AttemptSummary) error {
+	if objects != nil {
+		var keys []string
+		if key, ok := objectKey(a.SavedFilePath); ok {
+			keys = append(keys, key)
+		}
+		if validAttemptID(a.ID) {
+			keys = append(keys, thumbnailKey(a.ID))
+		}
+		for _, key := range keys {
+			if err := objects.delete(context.Background(), key); err != nil {
+				return err
+			}
+		}
+	}
+	var paths []string
+	// Saved images are directly in the results directory
+	if a.SavedFilePath != "" && filepath.Dir(filepath.Clean(a.SavedFilePath)) == resultsDir {
+		paths = append(paths, a.SavedFilePath)
+	}
+	if validAttemptID(a.ID) {
+		paths = append(paths, thumbnailPath(a.ID))
+	}
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// AchievementStatus is how far a user is toward an achievement
+type AchievementStatus struct {
+	Achievement
+	Earned    bool       `json:"earned"`
+	EarnedAt  *time.Time `json:"earnedAt,omitempty"`
+	AttemptID string     `json:"attemptId,omitempty"` // the attempt that earned it
+	// Progress is what's measured against the threshold so far: the best
+	// score, the attempts made or the longest streak
+	Progress float64 `json:"progress"`
+}
+
+// achievementAttempt is what achievements are judged on of an attempt
+type achievementAttempt struct {
+	AttemptSummary
+	convergence float64 // the lowest of its left, right and vertical scores
+}
+
+// evaluateAchievements judges a user's attempts against each achievement,
+// earning it with the first attempt to meet its rule
+func evaluateAchievements(defs []Achievement, attempts []achievementAttempt) []AchievementStatus {
+	sort.SliceStable(attempts, func(i, j int) bool { return attempts[i].Timestamp.Before(attempts[j].Timestamp) })
+
+	statuses := make([]AchievementStatus, len(defs))
+	for i, def := range defs {
+		s := &statuses[i]
+		s.Achievement = def
+		count, run := 0, 0
+		var lastDay time.Time
+		for _, a := range attempts {
+			if a.Partial || (def.TrainingType != "" && a.TrainingType != def.TrainingType) {
+				continue
+			}
+			var progress float64
+			switch def.Rule {
+			case RuleScore:
+				progress = a.CompositeScore
+			case RuleAttempts:
+				count++
+				progress = float64(count)
+			case RuleStreak:
+				t := a.Timestamp.UTC()
+				day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+				switch {
+				case run > 0 && day.Equal(lastDay):
+				case run > 0 && day.Equal(lastDay.AddDate(0, 0, 1)):
+					run++
+				default:
+					run = 1
+				}
+				lastDay = day
+				progress = float64(run)
+			case RuleConvergence:
+				progress = a.convergence
+			}
+			s.Progress = max(s.Progress, progress)
+			if !s.Earned && progress >= def.Threshold {
+				s.Earned = true
+				s.EarnedAt = &a.Timestamp
+				s.AttemptID = a.ID
+			}
+		}
+	}
+	return statuses
+}
+
+// handleAchievements lists the achievements with the caller's progress toward
+// each, from their own attempts, or only those earned with earned=true
+func handleAchievements(w http.ResponseWriter, r *http.Request) {
+	defs := currentSettings().Achievements
+	convergence := map[TrainingType]bool{}
+	for _, def := range defs {
+		if def.Rule == RuleConvergence {
+			convergence[def.TrainingType] = true
+		}
+	}
+
+	// Admins see everyone's attempts in a workspace, but only their own earn
+	// them achievements
+	var f AttemptFilter
+	visibleAttempts(r.Context(), &f)
+	f.AllUsers = false
+	var attempts []achievementAttempt
+	err := store.ExportAttempts(f, func(a AttemptSummary, _, result []byte) error {
+		attempt := achievementAttempt{AttemptSummary: a}
+		// Only convergence achievements need the result, and only for
+		// complete attempts at their exercise
+		if !a.Partial && len(result) > 0 && (convergence[""] || convergence[a.TrainingType]) {
+			var res AnalysisResult
+			if err := json.Unmarshal(result, &res); err != nil {
+				return err
+			}
+			attempt.convergence = min(res.LeftConvergenceScore, res.RightConvergenceScore, res.VerticalScore)
+		}
+		attempts = append(attempts, attempt)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load history", "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	statuses := evaluateAchievements(defs, attempts)
+	if r.URL.Query().Get("earned") == "true" {
+		earned := []AchievementStatus{}
+		for _, s := range statuses {
+			if s.Earned {
+				earned = append(earned, s)
+			}
+		}
+		statuses = earned
+	}
+	writeResponse(w, r, http.StatusOK, statuses)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// apiPrefix is where the current version of the API is served
+const apiPrefix = "/api/v1"
+
+// handleAPI registers an API endpoint under apiPrefix, and at its original
+// unversioned path so existing clients keep working. Responses on the old
+// path are marked deprecated and link to the versioned one.
+func handleAPI(pattern string, handler http.HandlerFunc) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	withMethod := func(p string) string {
+		if method == "" {
+			return p
+		}
+		return method + " " + p
+	}
+
+	http.HandleFunc(withMethod(apiPrefix+path), handler)
+	http.HandleFunc(withMethod(path), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+sitePath(apiPrefix+r.URL.Path)+`>; rel="successor-version"`)
+		handler(w, r)
+	})
+}
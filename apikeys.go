@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeysFile holds the keys managed through the admin API inside the results
+// directory. Only hashes are stored, so the file doesn't grant access.
+const apiKeysFile = "api_keys.json"
+
+// apiKeyPrefix starts every generated key, so leaked keys are recognizable
+const apiKeyPrefix = "tradra_"
+
+// maxAPIKeyName is the longest name a key may be given
+const maxAPIKeyName = 64
+
+// APIKey describes a key without revealing it
+type APIKey struct {
+	Name      string    `json:"name"`
+	Hint      string    `json:"hint"` // the start of the key, to tell keys apart
+	Hash      string    `json:"hash,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Source    string    `json:"source"` // "admin" for managed keys, "env" for TRADRA_API_KEYS
+
+	// Where the key's jobs report back when they don't give a callback URL,
+	// and the secret signing its callbacks, which is only shown at creation
+	CallbackURL   string `json:"callbackUrl,omitempty"`
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+}
+
+// NewAPIKey is returned once when a key is created, with the key itself
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyRequest names a new key
+type CreateAPIKeyRequest struct {
+	Name        string `json:"name"`
+	CallbackURL string `json:"callbackUrl,omitempty"`
+}
+
+var (
+	apiKeysMu sync.Mutex
+	apiKeys   map[string]APIKey // managed keys by name, loaded on first use
+	envKeys   map[string]APIKey // keys from TRADRA_API_KEYS by hash
+)
+
+// hashAPIKey returns the hex SHA-256 of a key as stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyHint returns the start of a key, enough to tell keys apart in lists
+// without giving much of it away
+func keyHint(key string) string {
+	n := min(4, len(key)/4)
+	if strings.HasPrefix(key, apiKeyPrefix) {
+		n += len(apiKeyPrefix)
+	}
+	return key[:n] + "…"
+}
+
+// loadEnvAPIKeys reads keys from TRADRA_API_KEYS, a comma-separated list of
+// keys, each optionally named as name=key
+func loadEnvAPIKeys() {
+	keys := map[string]APIKey{}
+	for i, entry := range splitList(os.Getenv("TRADRA_API_KEYS")) {
+		name, key, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, key = fmt.Sprintf("env-%d", i+1), entry
+		}
+		keys[hashAPIKey(key)] = APIKey{Name: name, Hint: keyHint(key), CreatedAt: startTime, Source: "env"}
+	}
+	apiKeysMu.Lock()
+	envKeys = keys
+	apiKeysMu.Unlock()
+}
+
+// lookupAPIKey returns the key with the given value, if there is one
+func lookupAPIKey(key string) (APIKey, bool) {
+	hash := hashAPIKey(key)
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+
+	if k, ok := envKeys[hash]; ok {
+		return k, true
+	}
+	if err := loadAPIKeysLocked(); err != nil {
+		slog.Error("Failed to load API keys", "err", err)
+		return APIKey{}, false
+	}
+	for _, k := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// loadAPIKeysLocked reads the managed keys from disk on first use.
+// The caller must hold apiKeysMu.
+func loadAPIKeysLocked() error {
+	if apiKeys != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(resultsDir, apiKeysFile))
+	if errors.Is(err, os.ErrNotExist) {
+		apiKeys = map[string]APIKey{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]APIKey{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	apiKeys = loaded
+	return nil
+}
+
+// saveAPIKeysLocked writes the managed keys, readable only by the server's
+// user. The caller must hold apiKeysMu.
+func saveAPIKeysLocked() error {
+	data, err := json.MarshalIndent(apiKeys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(resultsDir, apiKeysFile), data, 0600)
+}
+
+// createAPIKey generates and stores a new managed key with its webhook secret
+func createAPIKey(req CreateAPIKeyRequest) (NewAPIKey, error) {
+	name := req.Name
+	if name == "" || len(name) > maxAPIKeyName {
+		return NewAPIKey{}, fmt.Errorf("Key name must be 1 to %d characters", maxAPIKeyName)
+	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			return NewAPIKey{}, err
+		}
+	}
+
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	if err := loadAPIKeysLocked(); err != nil {
+		return NewAPIKey{}, err
+	}
+	if _, ok := apiKeys[name]; ok {
+		return NewAPIKey{}, fmt.Errorf("A key named %q already exists", name)
+	}
+
+	b := make([]byte, 24)
+	rand.Read(b)
+	key := apiKeyPrefix + hex.EncodeToString(b)
+	k := APIKey{
+		Name: name, Hint: keyHint(key), Hash: hashAPIKey(key), CreatedAt: time.Now(), Source: "admin",
+		CallbackURL: req.CallbackURL, WebhookSecret: newWebhookSecret(),
+	}
+	apiKeys[name] = k
+	if err := saveAPIKeysLocked(); err != nil {
+		delete(apiKeys, name)
+		return NewAPIKey{}, err
+	}
+	k.Hash = ""
+	return NewAPIKey{APIKey: k, Key: key}, nil
+}
+
+// deleteAPIKey revokes a managed key
+func deleteAPIKey(name string) (bool, error) {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	if err := loadAPIKeysLocked(); err != nil {
+		return false, err
+	}
+	k, ok := apiKeys[name]
+	if !ok {
+		return false, nil
+	}
+	delete(apiKeys, name)
+	if err := saveAPIKeysLocked(); err != nil {
+		apiKeys[name] = k
+		return false, err
+	}
+	return true, nil
+}
+
+// listAPIKeys returns every key, managed and from the environment, by name
+func listAPIKeys() ([]APIKey, error) {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	if err := loadAPIKeysLocked(); err != nil {
+		return nil, err
+	}
+	keys := []APIKey{}
+	for _, k := range apiKeys {
+		k.Hash, k.WebhookSecret = "", ""
+		keys = append(keys, k)
+	}
+	for _, k := range envKeys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// requestAPIKey returns the key a request carries, as a bearer token or in
+// X-API-Key
+func requestAPIKey(header http.Header) string {
+	if key := header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// fromBrowserPage reports whether a request was made by a page of this site
+// or of an origin allowed by CORS, going by the headers browsers set. Other
+// clients could send the same headers, so this keeps casual scripts out
+// rather than determined ones.
+func fromBrowserPage(r *http.Request, cfg config) bool {
+	site := r.Header.Get("Sec-Fetch-Site")
+	if site == "same-origin" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	return site != "" && origin != "" && corsAllowed(cfg.corsOrigins, origin)
+}
+
+type apiKeyKey struct{}
+
+// apiKeyFrom returns the API key a request's context was authenticated with
+func apiKeyFrom(ctx context.Context) (APIKey, bool) {
+	k, ok := ctx.Value(apiKeyKey{}).(APIKey)
+	return k, ok
+}
+
+// requireAPIKey wraps an API handler so requests that don't come from a
+// browser page need a valid API key, when keys are required. Valid keys are
+// put in the request context either way, for their callbacks.
+func requireAPIKey(next http.HandlerFunc, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := requestAPIKey(r.Header); key != "" {
+			k, ok := lookupAPIKey(key)
+			switch {
+			case ok:
+				addLogAttrs(r.Context(), "apiKey", k.Name)
+				next(w, r.WithContext(context.WithValue(r.Context(), apiKeyKey{}, k)))
+			case cfg.requireAPIKey:
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				httpError(w, "Invalid API key", http.StatusUnauthorized)
+			default:
+				next(w, r)
+			}
+			return
+		}
+		if cfg.requireAPIKey && !fromBrowserPage(r, cfg) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, "An API key is required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdmin wraps an admin handler so it needs the admin token as a
+// bearer token
+func requireAdmin(next http.HandlerFunc, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleListAPIKeys lists the keys without revealing them
+func handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := listAPIKeys()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load API keys", "err", err)
+		httpError(w, "Failed to load API keys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// handleCreateAPIKey generates a key. It's only shown in this response.
+func handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	k, err := createAPIKey(req)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "Created API key", "name", k.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(k)
+}
+
+// handleDeleteAPIKey revokes a managed key. Keys from the environment are
+// revoked by removing them from TRADRA_API_KEYS.
+func handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ok, err := deleteAPIKey(name)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete API key", "name", name, "err", err)
+		httpError(w, "Failed to delete API key", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	slog.InfoContext(r.Context(), "Deleted API key", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAttemptsLimit = 20
+	maxAttemptsLimit     = 100
+)
+
+// attemptSorts are what attempts can be listed by
+var attemptSorts = []string{"timestamp", "compositeScore", "averageLineScore", "perspectiveScore"}
+
+// Attempt is an attempt as the history API lists it, with links to what was
+// kept of it
+type Attempt struct {
+	ID               string       `json:"id,omitempty"`
+	UserID           string       `json:"userId,omitempty"`
+	WorkspaceID      string       `json:"workspaceId,omitempty"`
+	Timestamp        time.Time    `json:"timestamp"`
+	TrainingType     TrainingType `json:"trainingType"`
+	Difficulty       Difficulty   `json:"difficulty,omitempty"`
+	CompositeScore   float64      `json:"compositeScore"`
+	AverageLineScore float64      `json:"averageLineScore"`
+	PerspectiveScore float64      `json:"perspectiveScore"`
+	Partial          bool         `json:"partial,omitempty"`
+	Assisted         bool         `json:"assisted,omitempty"`
+	Notes            string       `json:"notes,omitempty"`
+	Tags             []string     `json:"tags,omitempty"`
+	ResultURL        string       `json:"resultUrl,omitempty"`    // the full result, when it was kept
+	ImageURL         string       `json:"imageUrl,omitempty"`     // the saved visualization
+	ThumbnailURL     string       `json:"thumbnailUrl,omitempty"` // when the request was kept to render it from
+	// Result is the full result, given only when a single attempt is asked for
+	Result *AnalysisResult `json:"result,omitempty"`
+}
+
+// AttemptPage is a page of the attempt history
+type AttemptPage struct {
+	Attempts []Attempt `json:"attempts"`
+	Total    int       `json:"total"`          // how many attempts match over all pages
+	Next     string    `json:"next,omitempty"` // the next page, when there is one
+}
+
+// newAttempt lists a stored attempt, linking only to what can be served.
+// Attempts from before IDs were added can't be linked to at all.
+func newAttempt(a StoredAttempt) Attempt {
+	item := Attempt{
+		ID:               a.ID,
+		UserID:           a.UserID,
+		WorkspaceID:      a.WorkspaceID,
+		Timestamp:        a.Timestamp,
+		TrainingType:     a.TrainingType,
+		Difficulty:       a.Difficulty,
+		CompositeScore:   a.CompositeScore,
+		AverageLineScore: a.AverageLineScore,
+		PerspectiveScore: a.PerspectiveScore,
+		Partial:          a.Partial,
+		Assisted:         a.Assisted,
+		Notes:            a.Notes,
+		Tags:             a.Tags,
+	}
+	if a.ID == "" {
+		return item
+	}
+	if a.HasResult {
+		item.ResultURL = sitePath(apiPrefix + "/attempts/" + a.ID)
+	}
+	if a.SavedFilePath != "" {
+		item.ImageURL = resultImageURL(a.ID, a.SavedFilePath)
+	}
+	if a.HasRequest {
+		item.ThumbnailURL = thumbnailURL(a.ID)
+	}
+	return item
+}
+
+// handleListAttempts lists the attempts the caller can see a page at a time,
+// newest first unless asked otherwise
+func handleListAttempts(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAttemptFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	visibleAttempts(r.Context(), &f)
+
+	stored, total, err := store.ListAttempts(f)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list attempts", "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	page := AttemptPage{Attempts: make([]Attempt, 0, len(stored)), Total: total}
+	for _, a := range stored {
+		page.Attempts = append(page.Attempts, newAttempt(a))
+	}
+	if next := f.Offset + len(stored); len(stored) > 0 && next < total {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(next))
+		page.Next = sitePath(apiPrefix + "/attempts?" + q.Encode())
+	}
+	writeResponse(w, r, http.StatusOK, page)
+}
+
+// visibleAttempts narrows a filter to the attempts the caller may see, like
+// visibleHistory
+func visibleAttempts(ctx context.Context, f *AttemptFilter) {
+	ws, ok := workspaceFrom(ctx)
+	f.WorkspaceID = ws.ID
+	f.AllUsers = ok && ws.role == RoleAdmin
+	f.UserID = ownerID(ctx)
+}
+
+// findVisibleAttempt returns an attempt if the caller may see it, like
+// visibleAttempts but outside the workspace they picked: it's theirs, or
+// they're an admin of the workspace it was made in. Others get
+// errAttemptNotFound, so they can't tell it exists.
+func findVisibleAttempt(ctx context.Context, id string) (StoredAttempt, error) {
+	a, err := findAttempt(id)
+	if err != nil || a.UserID == ownerID(ctx) {
+		return a, err
+	}
+	user, ok := userFrom(ctx)
+	if !ok || a.WorkspaceID == "" {
+		return StoredAttempt{}, errAttemptNotFound
+	}
+	_, role, err := memberWorkspace(a.WorkspaceID, user.ID)
+	if errors.Is(err, errWorkspaceNotFound) || (err == nil && role != RoleAdmin) {
+		return StoredAttempt{}, errAttemptNotFound
+	}
+	return a, err
+}
+
+// parseAttemptFilter reads the filters, order and page of a listing
+func parseAttemptFilter(q url.Values) (AttemptFilter, error) {
+	f, err := parseAttemptRange(q)
+	if err != nil {
+		return f, err
+	}
+	f.Sort = "timestamp"
+	f.Limit = defaultAttemptsLimit
+
+	if v := q.Get("sort"); v != "" {
+		if !slices.Contains(attemptSorts, v) {
+			return f, fieldError(CodeInvalidValue, "sort", "Unknown sort %q, expected one of %v", v, attemptSorts)
+		}
+		f.Sort = v
+	}
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		f.Ascending = true
+	default:
+		return f, fieldError(CodeInvalidValue, "order", "Unknown order %q, expected asc or desc", q.Get("order"))
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAttemptsLimit {
+			return f, fieldError(CodeOutOfRange, "limit", "Limit must be between 1 and %d", maxAttemptsLimit)
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, fieldError(CodeOutOfRange, "offset", "Offset must be 0 or more")
+		}
+		f.Offset = n
+	}
+	return f, nil
+}
+
+// parseAttemptRange reads the exercise, tags and dates attempts are picked
+// by. Dates may be given as RFC 3339 times or as days in UTC, with to's day
+// included.
+func parseAttemptRange(q url.Values) (AttemptFilter, error) {
+	f := AttemptFilter{TrainingType: TrainingType(q.Get("trainingType"))}
+	for _, t := range q["tag"] {
+		tag, err := normalizeTag(t)
+		if err != nil {
+			err.(*APIError).Field = "tag"
+			return f, err
+		}
+		f.Tags = append(f.Tags, tag)
+	}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, _, err = parseDay(v); err != nil {
+			return f, fieldError(CodeInvalidValue, "from", "Expected a date such as 2024-05-01 or an RFC 3339 time")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var day bool
+		if f.To, day, err = parseDay(v); err != nil {
+			return f, fieldError(CodeInvalidValue, "to", "Expected a date such as 2024-05-31 or an RFC 3339 time")
+		}
+		if day {
+			f.To = f.To.AddDate(0, 0, 1)
+		}
+	}
+	return f, nil
+}
+
+// parseDay reads an RFC 3339 time or a YYYY-MM-DD day, reporting which
+func parseDay(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+// handleAttempt returns an attempt with its full result, to whoever made it
+// and the admins of its workspace
+func handleAttempt(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a, err := findVisibleAttempt(r.Context(), id)
+	if errors.Is(err, errAttemptNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load attempt", "attemptId", id, "err", err)
+		httpError(w, "Failed to load attempt", http.StatusInternalServerError)
+		return
+	}
+
+	item := newAttempt(a)
+	if a.HasResult {
+		data, err := store.AttemptResult(id)
+		if err == nil {
+			item.Result = new(AnalysisResult)
+			err = json.Unmarshal(data, item.Result)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load attempt result", "attemptId", id, "err", err)
+			httpError(w, "Failed to load attempt", http.StatusInternalServerError)
+			return
+		}
+	}
+	writeResponse(w, r, http.StatusOK, item)
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login flow
+const (
+	loginCookie   = "tradra_login"
+	loginDuration = 10 * time.Minute // how long a user has to sign in at the provider
+	loginTimeout  = 10 * time.Second // for each call to the provider
+)
+
+// loginProviderGitHub signs in with GitHub, which uses plain OAuth rather
+// than OIDC; loginProviderGoogle is shorthand for Google's OIDC issuer
+const (
+	loginProviderGitHub = "github"
+	loginProviderGoogle = "google"
+	googleIssuer        = "https://accounts.google.com"
+)
+
+// login is set at startup when a login provider is configured
+var login *auth
+
+// auth signs users in with an OIDC issuer or GitHub using the authorization
+// code flow with PKCE, and keeps them signed in with a signed session cookie
+type auth struct {
+	provider     string // prefix of user IDs, e.g. "google"
+	issuer       string // OIDC issuer, empty for GitHub
+	clientID     string
+	clientSecret string
+	publicURL    string         // external base URL for the callback, empty to use the request's
+	proxies      trustedProxies // whose X-Forwarded-Proto is believed
+	signer       cookieSigner
+	client       *http.Client
+
+	mu        sync.Mutex // guards the endpoints, discovered on first use for OIDC
+	endpoints *loginEndpoints
+}
+
+type loginEndpoints struct {
+	auth, token, userInfo string
+	scopes                string
+}
+
+// loginState is kept in a short-lived cookie between starting a login and
+// the provider redirecting back
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Return   string    `json:"return"`
+	Expires  time.Time `json:"expires"`
+}
+
+// newAuth sets up login with the configured provider: "google", "github" or
+// the URL of an OIDC issuer
+func newAuth(cfg config) (*auth, error) {
+	a := &auth{
+		clientID:     cfg.loginClientID,
+		clientSecret: cfg.loginClientSecret,
+		publicURL:    strings.TrimSuffix(cfg.publicURL, "/"),
+		proxies:      cfg.trustedProxies,
+		signer:       newCookieSigner(cfg.sessionSecret),
+		client:       &http.Client{Timeout: loginTimeout},
+	}
+	switch cfg.loginProvider {
+	case loginProviderGitHub:
+		a.provider = loginProviderGitHub
+		a.endpoints = &loginEndpoints{
+			auth:     "https://github.com/login/oauth/authorize",
+			token:    "https://github.com/login/oauth/access_token",
+			userInfo: "https://api.github.com/user",
+			scopes:   "read:user user:email",
+		}
+	case loginProviderGoogle:
+		a.provider, a.issuer = loginProviderGoogle, googleIssuer
+	default:
+		u, err := url.Parse(cfg.loginProvider)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Hostname() != "localhost") {
+			return nil, fmt.Errorf("login provider must be google, github or an https OIDC issuer URL, not %q", cfg.loginProvider)
+		}
+		a.provider, a.issuer = u.Hostname(), strings.TrimSuffix(cfg.loginProvider, "/")
+	}
+	if cfg.sessionSecret == "" {
+		slog.Warn("No session secret set, so users are signed out when the server restarts")
+	}
+	return a, nil
+}
+
+// discover returns the provider's endpoints, reading the OIDC discovery
+// document the first time
+func (a *auth) discover(ctx context.Context) (*loginEndpoints, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.endpoints != nil {
+		return a.endpoints, nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	if err := a.getJSON(ctx, a.issuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if doc.Issuer != a.issuer {
+		return nil, fmt.Errorf("OIDC discovery: issuer is %q, expected %q", doc.Issuer, a.issuer)
+	}
+	a.endpoints = &loginEndpoints{
+		auth:     doc.AuthorizationEndpoint,
+		token:    doc.TokenEndpoint,
+		userInfo: doc.UserInfoEndpoint,
+		scopes:   "openid profile email",
+	}
+	return a.endpoints, nil
+}
+
+// callbackURL is where the provider sends users back to, which must be
+// registered with it
+func (a *auth) callbackURL(r *http.Request) string {
+	return a.externalURL(r) + sitePath("/auth/callback")
+}
+
+// externalURL returns the scheme and host the site is reached at, from
+// -public-url or else the request
+func (a *auth) externalURL(r *http.Request) string {
+	if a.publicURL != "" {
+		return a.publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil || (a.proxies.connectedFrom(r) && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// secureCookies reports whether cookies should be limited to HTTPS
+func (a *auth) secureCookies(r *http.Request) bool {
+	return strings.HasPrefix(a.callbackURL(r), "https://")
+}
+
+// setCookie sets or, with a negative maxAge, deletes a cookie. Cookies are
+// hidden from scripts, only sent over HTTPS when the site uses it, and not
+// sent with requests from other sites other than following a link.
+func (a *auth) setCookie(w http.ResponseWriter, r *http.Request, name, value, path string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name: name, Value: value, Path: path, MaxAge: maxAge,
+		HttpOnly: true, Secure: a.secureCookies(r), SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// randomToken returns a random URL-safe string
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// safeReturnPath only allows returning to a path on this site after login
+func safeReturnPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return sitePath("/")
+	}
+	return p
+}
+
+// handleLogin sends the user to the provider to sign in
+func (a *auth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := a.discover(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to reach login provider", "err", err)
+		httpError(w, "Login provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state := loginState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		Return:   safeReturnPath(r.URL.Query().Get("return")),
+		Expires:  time.Now().Add(loginDuration),
+	}
+	value, err := a.signer.encode(state)
+	if err != nil {
+		httpError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	a.setCookie(w, r, loginCookie, value, sitePath("/auth/"), int(loginDuration.Seconds()))
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.clientID},
+		"redirect_uri":          {a.callbackURL(r)},
+		"scope":                 {endpoints.scopes},
+		"state":                 {state.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if a.issuer != "" {
+		q.Set("nonce", state.Nonce)
+	}
+	http.Redirect(w, r, endpoints.auth+"?"+q.Encode(), http.StatusFound)
+}
+
+// handleCallback finishes signing in when the provider sends the user back
+func (a *auth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	var state loginState
+	c, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = a.signer.decode(c.Value, &state)
+	}
+	if err != nil || time.Now().After(state.Expires) {
+		httpError(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	a.setCookie(w, r, loginCookie, "", sitePath("/auth/"), -1)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		httpError(w, "Login failed: "+e, http.StatusBadRequest)
+		return
+	}
+	if q.Get("state") != state.State {
+		httpError(w, "Login state doesn't match, please try again", http.StatusBadRequest)
+		return
+	}
+
+	user, err := a.exchange(r, q.Get("code"), state)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to complete login", "err", err)
+		httpError(w, "Login failed", http.StatusBadGateway)
+		return
+	}
+	// Signing in registers the user. Their profile is a nicety, so failing
+	// to save it doesn't keep them out.
+	if err := store.SaveUser(user); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save user", "userId", user.ID, "err", err)
+	}
+	login.mergeGuest(w, r, user)
+
+	if _, err := a.startSession(w, r, user); err != nil {
+		httpError(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "User signed in", "userId", user.ID)
+	http.Redirect(w, r, state.Return, http.StatusFound)
+}
+
+// startSession signs a user in with a new session cookie. A new session gets
+// a new CSRF token, so tokens seen before signing in are no use afterwards.
+func (a *auth) startSession(w http.ResponseWriter, r *http.Request, user User) (session, error) {
+	s := session{User: user, CSRF: randomToken(), Expires: time.Now().Add(sessionDuration)}
+	value, err := a.signer.encode(s)
+	if err != nil {
+		return s, err
+	}
+	a.setCookie(w, r, sessionCookie, value, sitePath("/"), int(sessionDuration.Seconds()))
+	return s, nil
+}
+
+// exchange trades the authorization code for tokens and reads who signed in
+func (a *auth) exchange(r *http.Request, code string, state loginState) (User, error) {
+	endpoints, err := a.discover(r.Context())
+	if err != nil {
+		return User{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.callbackURL(r)},
+		"client_id":     {a.clientID},
+		"client_secret": {a.clientSecret},
+		"code_verifier": {state.Verifier},
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, endpoints.token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var tokens struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := a.doJSON(req, &tokens); err != nil {
+		return User{}, fmt.Errorf("token exchange: %w", err)
+	}
+	if tokens.Error != "" {
+		return User{}, fmt.Errorf("token exchange: %s: %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	if a.issuer == "" {
+		return a.githubUser(r.Context(), endpoints, tokens.AccessToken)
+	}
+	return a.idTokenUser(tokens.IDToken, state.Nonce)
+}
+
+// idTokenUser reads the user from an OIDC ID token. It came straight from
+// the token endpoint over TLS, so its claims are checked but its signature
+// needn't be (OpenID Connect Core 1.0, section 3.1.3.7).
+func (a *auth) idTokenUser(idToken, nonce string) (User, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return User{}, errors.New("ID token is malformed")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return User{}, errors.New("ID token is malformed")
+	}
+	var claims struct {
+		Issuer   string          `json:"iss"`
+		Subject  string          `json:"sub"`
+		Audience json.RawMessage `json:"aud"` // a string or a list of strings
+		Expires  int64           `json:"exp"`
+		Nonce    string          `json:"nonce"`
+		Name     string          `json:"name"`
+		Email    string          `json:"email"`
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return User{}, fmt.Errorf("ID token: %w", err)
+	}
+
+	var audiences []string
+	if err := json.Unmarshal(claims.Audience, &audiences); err != nil {
+		audiences = []string{strings.Trim(string(claims.Audience), `"`)}
+	}
+	switch {
+	case claims.Issuer != a.issuer:
+		return User{}, fmt.Errorf("ID token is from %q, expected %q", claims.Issuer, a.issuer)
+	case !slices.Contains(audiences, a.clientID):
+		return User{}, errors.New("ID token is for another client")
+	case time.Now().Unix() > claims.Expires:
+		return User{}, errors.New("ID token has expired")
+	case claims.Nonce != nonce:
+		return User{}, errors.New("ID token nonce doesn't match")
+	case claims.Subject == "":
+		return User{}, errors.New("ID token has no subject")
+	}
+	return User{ID: a.provider + ":" + claims.Subject, Name: claims.Name, Email: claims.Email, Provider: a.provider}, nil
+}
+
+// githubUser reads the signed-in GitHub account
+func (a *auth) githubUser(ctx context.Context, endpoints *loginEndpoints, accessToken string) (User, error) {
+	var gh struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := a.getJSON(ctx, endpoints.userInfo, accessToken, &gh); err != nil {
+		return User{}, fmt.Errorf("GitHub user: %w", err)
+	}
+	if gh.ID == 0 {
+		return User{}, errors.New("GitHub user has no ID")
+	}
+	name := gh.Name
+	if name == "" {
+		name = gh.Login
+	}
+	return User{ID: loginProviderGitHub + ":" + strconv.FormatInt(gh.ID, 10), Name: name, Email: gh.Email, Provider: loginProviderGitHub}, nil
+}
+
+// getJSON fetches a JSON document, with a bearer token when one is given
+func (a *auth) getJSON(ctx context.Context, u, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return a.doJSON(req, v)
+}
+
+// doJSON sends a request to the provider and decodes its JSON response
+func (a *auth) doJSON(req *http.Request, v any) error {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// session returns the session of a request, if it has a valid one. Sessions
+// from before CSRF tokens existed are treated as signed out.
+func (a *auth) session(r *http.Request) (session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	var s session
+	if err := a.signer.decode(c.Value, &s); err != nil || time.Now().After(s.Expires) || s.CSRF == "" {
+		return session{}, false
+	}
+	return s, true
+}
+
+// handleLogout signs the user out
+func (a *auth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	a.setCookie(w, r, sessionCookie, "", sitePath("/"), -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// meResponse is the signed-in user and the CSRF token the page sends back
+type meResponse struct {
+	User
+	DisplayName string `json:"displayName,omitempty"` // from their profile
+	CSRFToken   string `json:"csrfToken"`
+}
+
+// handleMe returns the signed-in user. Browsers that aren't signed in are
+// given a guest cookie.
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	s, ok := r.Context().Value(sessionKey{}).(session)
+	if !ok {
+		login.setGuest(w, r)
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	// The token is per session, so the response mustn't be shared
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meResponse{User: s.User, DisplayName: userSettings(r.Context(), s.User).DisplayName, CSRFToken: s.CSRF})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// backupManifest is the first file of a backup archive, describing it
+	backupManifest = "backup.json"
+	backupVersion  = 1
+)
+
+// errBackupUnsupported is returned by storage whose database can't be copied
+// into a backup, such as PostgreSQL, which has its own tools
+var errBackupUnsupported = errors.New("the database can't be backed up by the server")
+
+// BackupManifest says what a backup archive holds
+type BackupManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Database  bool      `json:"database"` // the SQLite database is in it, which it isn't with PostgreSQL
+}
+
+// backupSkipped reports whether a file in the results directory is left out
+// of backups: the live database, which is copied consistently instead, and
+// files that are only caches or scratch
+func backupSkipped(rel string) bool {
+	base := filepath.Base(rel)
+	switch {
+	case rel == dbFile, rel == dbFile+"-wal", rel == dbFile+"-shm":
+		return true
+	case strings.HasPrefix(base, ".readyz-"):
+		return true
+	case filepath.Dir(rel) == attemptsDir && strings.HasSuffix(base, "_thumbnail.png"):
+		return true
+	}
+	return false
+}
+
+// writeBackup writes a gzipped tar of the database and the results directory:
+// saved images, attempts kept as files, workspaces, API keys, settings and
+// calibrations
+func writeBackup(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	dir, err := os.MkdirTemp("", "tradra-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, dbFile)
+	manifest := BackupManifest{Version: backupVersion, CreatedAt: now, Database: true}
+	if err := store.Backup(snapshot); errors.Is(err, errBackupUnsupported) {
+		manifest.Database = false
+	} else if err != nil {
+		return fmt.Errorf("copying the database: %w", err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: backupManifest, Mode: 0644, Size: int64(len(data)), ModTime: now}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if manifest.Database {
+		if err := addBackupFile(tw, snapshot, dbFile); err != nil {
+			return err
+		}
+	}
+
+	err = filepath.WalkDir(resultsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(resultsDir, path)
+		if err != nil || !d.Type().IsRegular() || backupSkipped(rel) {
+			return err
+		}
+		return addBackupFile(tw, path, filepath.ToSlash(rel))
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addBackupFile copies a file into a backup under name
+func addBackupFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// A file still being written is cut at the size it had
+	_, err = io.CopyN(tw, f, hdr.Size)
+	return err
+}
+
+// handleBackup downloads a backup archive, for the admin. It's written as
+// it's made, so an error partway only shows in the log and a cut archive.
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	name := "tradra-backup-" + time.Now().UTC().Format("2006-01-02T150405") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	// Large results directories may take longer than the write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err := writeBackup(w); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write backup", "err", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Backup downloaded")
+}
+
+// restoreBackup replaces the results directory with the contents of a backup
+// archive, before the server opens it. The old directory is moved aside,
+// not removed, and its new name returned, or "" when there was none.
+func restoreBackup(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("not a backup archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != backupManifest {
+		return "", errors.New("not a backup archive: it doesn't start with " + backupManifest)
+	}
+	var manifest BackupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return "", fmt.Errorf("reading %s: %w", backupManifest, err)
+	}
+	if manifest.Version < 1 || manifest.Version > backupVersion {
+		return "", fmt.Errorf("backup version %d isn't supported by this server, which reads up to %d", manifest.Version, backupVersion)
+	}
+
+	// Everything is unpacked beside the results directory first, so a bad
+	// archive leaves it be
+	staging := resultsDir + ".restoring"
+	if err := os.RemoveAll(staging); err != nil {
+		return "", err
+	}
+	if err := extractBackup(tr, staging); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+
+	aside := ""
+	if _, err := os.Stat(resultsDir); err == nil {
+		aside = resultsDir + ".before-restore-" + time.Now().UTC().Format("20060102-150405")
+		if err := os.Rename(resultsDir, aside); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(staging, resultsDir); err != nil {
+		return aside, err
+	}
+	slog.Info("Restored backup", "file", path, "createdAt", manifest.CreatedAt, "database", manifest.Database)
+	return aside, nil
+}
+
+// extractBackup unpacks the files of a backup archive into dir, refusing
+// any that would land outside it
+func extractBackup(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading the archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("the archive has a file outside the results directory: %q", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, hdr.FileInfo().Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
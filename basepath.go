@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// basePath is the path the site is mounted under behind a reverse proxy,
+// such as "/tradra", or empty when it's served from the root. It's set at
+// startup.
+var basePath string
+
+// parseBasePath normalizes -base-path to start with a slash and end without
+// one, so "tradra/" and "/tradra" mean the same and "/" means the root
+func parseBasePath(p string) (string, error) {
+	p = "/" + strings.Trim(p, "/")
+	if p == "/" {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "?#\\") || strings.Contains(p, "//") || strings.Contains(p, "/../") || strings.HasSuffix(p, "/..") {
+		return "", fmt.Errorf("-base-path must be a plain path such as /tradra, not %q", p)
+	}
+	return p, nil
+}
+
+// sitePath turns a path the server routes into the one clients see
+func sitePath(p string) string {
+	return basePath + p
+}
+
+// withBasePath serves the site under the base path by stripping it from
+// requests. Requests without it are served too, for proxies that strip it
+// themselves; the bare base path is redirected to the page.
+func withBasePath(next http.Handler) http.Handler {
+	stripped := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == basePath:
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync"
+)
+
+// maxBatchSize is the most attempts one batch request may analyze
+const maxBatchSize = 100
+
+// BatchResult is the outcome of one attempt in a batch: its analysis, or why
+// it couldn't be analyzed
+type BatchResult struct {
+	Result *AnalysisResult `json:"result,omitempty"`
+	Error  *APIError       `json:"error,omitempty"`
+}
+
+// handleAnalyzeBatch analyzes an array of attempts concurrently, such as a
+// scanned set of homework drawings. Results are in the order of the
+// requests, and an invalid attempt fails on its own without failing the rest.
+func handleAnalyzeBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reqs []AnalysisRequest
+	if !decodeRequest(w, r, &reqs) {
+		return
+	}
+	if err := validateBatchSize(reqs); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if !chargeRate(w, r, len(reqs)) {
+		return
+	}
+
+	results := analyzeBatch(r.Context(), reqs, nil)
+	addLogAttrs(r.Context(), "batchSize", len(reqs))
+
+	writeResponse(w, r, http.StatusOK, results)
+}
+
+// validateBatchSize checks a batch has at least one attempt and isn't too big
+func validateBatchSize(reqs []AnalysisRequest) error {
+	if len(reqs) == 0 || len(reqs) > maxBatchSize {
+		return fieldError(CodeOutOfRange, "", "Expected 1 to %d attempts", maxBatchSize)
+	}
+	return nil
+}
+
+// analyzeBatch validates and analyzes each attempt, running up to one
+// analysis per CPU at a time. onResult, if given, is called with each
+// attempt's result as it's ready, from any goroutine.
+func analyzeBatch(ctx context.Context, reqs []AnalysisRequest, onResult func(int, BatchResult)) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	done := func(i int) {
+		if onResult != nil {
+			onResult(i, results[i])
+		}
+	}
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i := range reqs {
+		if err := validateWorkspaceRequest(ctx, &reqs[i]); err != nil {
+			results[i].Error = apiError(err, http.StatusBadRequest)
+			done(i)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result := analyzeStrokes(ctx, reqs[i])
+			results[i].Result = &result
+			done(i)
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// scoreMetric is a score kept with each attempt
+type scoreMetric struct {
+	name  string // of its JSON field
+	label string
+	score func(AttemptSummary) float64
+}
+
+// personalBestMetrics are the scores personal bests, and goals, are kept for
+var personalBestMetrics = []scoreMetric{
+	{"compositeScore", "composite score", func(a AttemptSummary) float64 { return a.CompositeScore }},
+	{"averageLineScore", "line score", func(a AttemptSummary) float64 { return a.AverageLineScore }},
+	{"perspectiveScore", "perspective score", func(a AttemptSummary) float64 { return a.PerspectiveScore }},
+}
+
+// PersonalBest is the best score a user has had on one metric of an exercise
+type PersonalBest struct {
+	Metric    string    `json:"metric"` // compositeScore, averageLineScore or perspectiveScore
+	Score     float64   `json:"score"`
+	Previous  *float64  `json:"previous,omitempty"` // the best it beat, in an analysis response
+	AttemptID string    `json:"attemptId,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ExerciseBests are a user's personal bests at one exercise
+type ExerciseBests struct {
+	TrainingType TrainingType   `json:"trainingType"`
+	Attempts     int            `json:"attempts"` // complete ones, which are all that count
+	Bests        []PersonalBest `json:"bests"`
+}
+
+// calculateBests finds the best score on each metric of each exercise over
+// complete attempts, in the order they were recorded. A score that's only
+// equaled stays with the attempt that set it first.
+func calculateBests(history []AttemptSummary) []ExerciseBests {
+	byType := map[TrainingType]*ExerciseBests{}
+	for _, a := range history {
+		if a.Partial {
+			continue
+		}
+		e := byType[a.TrainingType]
+		if e == nil {
+			e = &ExerciseBests{TrainingType: a.TrainingType}
+			byType[a.TrainingType] = e
+		}
+		e.Attempts++
+		for i, m := range personalBestMetrics {
+			if e.Attempts == 1 {
+				e.Bests = append(e.Bests, PersonalBest{Metric: m.name, Score: m.score(a), AttemptID: a.ID, Timestamp: a.Timestamp})
+			} else if score := m.score(a); score > e.Bests[i].Score {
+				e.Bests[i] = PersonalBest{Metric: m.name, Score: score, AttemptID: a.ID, Timestamp: a.Timestamp}
+			}
+		}
+	}
+
+	bests := make([]ExerciseBests, 0, len(byType))
+	for _, e := range byType {
+		bests = append(bests, *e)
+	}
+	sort.Slice(bests, func(i, j int) bool { return bests[i].TrainingType < bests[j].TrainingType })
+	return bests
+}
+
+// newPersonalBests returns the metrics an attempt beat its maker's earlier
+// bests at the exercise on. A first complete attempt has nothing to beat, so
+// sets none.
+func newPersonalBests(a AttemptSummary, history []AttemptSummary) []PersonalBest {
+	if a.Partial {
+		return nil
+	}
+	earlier := slices.DeleteFunc(slices.Clone(history), func(h AttemptSummary) bool {
+		return h.TrainingType != a.TrainingType || h.UserID != a.UserID || h.WorkspaceID != a.WorkspaceID
+	})
+	bests := calculateBests(earlier)
+	if len(bests) == 0 {
+		return nil
+	}
+
+	var set []PersonalBest
+	for i, m := range personalBestMetrics {
+		previous := bests[0].Bests[i].Score
+		if score := m.score(a); score > previous {
+			set = append(set, PersonalBest{Metric: m.name, Score: score, Previous: &previous, AttemptID: a.ID, Timestamp: a.Timestamp})
+		}
+	}
+	return set
+}
+
+// handlePersonalBests returns the caller's best scores at each exercise, from
+// their own attempts
+func handlePersonalBests(w http.ResponseWriter, r *http.Request) {
+	history, err := loadHistory(TrainingType(r.URL.Query().Get("trainingType")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load history", "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	// Admins see everyone's attempts in a workspace, but only their own are
+	// personal bests
+	attempts := visibleHistory(r.Context(), history)
+	owner := ownerID(r.Context())
+	attempts = slices.DeleteFunc(attempts, func(a AttemptSummary) bool { return a.UserID != owner })
+
+	writeResponse(w, r, http.StatusOK, calculateBests(attempts))
+}
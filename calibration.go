@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// calibrationsFile stores per-device calibrations inside the results directory
+const calibrationsFile = "calibrations.json"
+
+const (
+	// minCalibrationStrokes is the number of test strokes needed to calibrate a device
+	minCalibrationStrokes = 3
+	// maxNoiseFloor is the most jitter a calibration may subtract, in pixels.
+	// It's more than any real sensor's, so calibrating with shaky strokes
+	// can't excuse every line.
+	maxNoiseFloor = 3.0
+	// maxOwnerCalibrations is the most devices each user, guest or the
+	// anonymous callers keep calibrated; calibrating another forgets their
+	// oldest
+	maxOwnerCalibrations = 10
+	// maxCalibrations is the most kept in all, past which the oldest are
+	// forgotten
+	maxCalibrations = 10000
+)
+
+// CalibrationRequest contains the test strokes drawn on a device
+type CalibrationRequest struct {
+	DeviceID string   `json:"deviceId"`
+	Strokes  []Stroke `json:"strokes"`
+}
+
+// Calibration is the personal noise floor derived for a device. It's kept
+// for whoever calibrated it, under calibrationKey.
+type Calibration struct {
+	DeviceID     string    `json:"deviceId"`
+	NoiseFloor   float64   `json:"noiseFloor"`   // sensor jitter in pixels, subtracted from RMSE
+	PointSpacing float64   `json:"pointSpacing"` // median distance between samples, a proxy for input resolution
+	StrokeCount  int       `json:"strokeCount"`
+	CalibratedAt time.Time `json:"calibratedAt"`
+}
+
+var (
+	calibrationsMu sync.Mutex
+	calibrations   map[string]Calibration
+)
+
+// calibrationKey is what an owner's calibration of a device is kept under.
+// Anonymous callers' are kept under the device ID alone, as all were before
+// they had owners.
+func calibrationKey(owner, deviceID string) string {
+	if owner == "" {
+		return deviceID
+	}
+	return owner + "/" + deviceID
+}
+
+// validDeviceID reports whether a device ID can be calibrated: letters,
+// digits, hyphens and underscores, up to maxDeviceID
+func validDeviceID(id string) bool {
+	if id == "" || len(id) > maxDeviceID {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// handleCalibrate calibrates one of the caller's devices, replacing its
+// earlier calibration
+func handleCalibrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CalibrationRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	cal, err := calibrateDevice(req)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := saveCalibration(ownerID(r.Context()), cal); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save calibration", "deviceId", cal.DeviceID, "err", err)
+		httpError(w, "Failed to save calibration", http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, cal)
+}
+
+// calibrateDevice measures jitter and sampling density from test strokes.
+// The noise floor is the median high-frequency wobble, so one shaky stroke
+// doesn't inflate it.
+func calibrateDevice(req CalibrationRequest) (Calibration, error) {
+	if req.DeviceID != "" && !validDeviceID(req.DeviceID) {
+		return Calibration{}, fieldError(CodeInvalidValue, "deviceId", "Device ID must be up to %d letters, digits, hyphens and underscores", maxDeviceID)
+	}
+	var wobbles, spacings []float64
+	for _, stroke := range req.Strokes {
+		if len(stroke) < 10 {
+			continue
+		}
+		shape := measureStrokeShape(stroke, calculateIdealLine(stroke))
+		wobbles = append(wobbles, shape.Wobble)
+
+		for i := 1; i < len(stroke); i++ {
+			spacings = append(spacings, math.Hypot(stroke[i].X-stroke[i-1].X, stroke[i].Y-stroke[i-1].Y))
+		}
+	}
+	if len(wobbles) < minCalibrationStrokes {
+		return Calibration{}, fmt.Errorf("Expected at least %d calibration strokes with 10 or more points", minCalibrationStrokes)
+	}
+
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		deviceID = newDeviceID()
+	}
+
+	return Calibration{
+		DeviceID:     deviceID,
+		NoiseFloor:   math.Min(median(wobbles), maxNoiseFloor),
+		PointSpacing: median(spacings),
+		StrokeCount:  len(wobbles),
+		CalibratedAt: time.Now(),
+	}, nil
+}
+
+// applyNoiseFloor removes the device jitter from a line's RMSE.
+// The caller is responsible for rescoring the line afterwards.
+func applyNoiseFloor(line Line, noiseFloor float64) Line {
+	if noiseFloor <= 0 {
+		return line
+	}
+	line.RMSE = math.Sqrt(math.Max(line.RMSE*line.RMSE-noiseFloor*noiseFloor, 0))
+	return line
+}
+
+// getCalibration returns an owner's stored calibration for a device, if any
+func getCalibration(owner, deviceID string) (Calibration, bool) {
+	if !validDeviceID(deviceID) {
+		return Calibration{}, false
+	}
+
+	calibrationsMu.Lock()
+	defer calibrationsMu.Unlock()
+
+	if err := loadCalibrationsLocked(); err != nil {
+		slog.Error("Failed to load calibrations", "err", err)
+		return Calibration{}, false
+	}
+	cal, ok := calibrations[calibrationKey(owner, deviceID)]
+	cal.NoiseFloor = math.Min(cal.NoiseFloor, maxNoiseFloor)
+	return cal, ok
+}
+
+// saveCalibration stores an owner's calibration and persists all
+// calibrations to disk, forgetting the oldest past the owner's share or the
+// total
+func saveCalibration(owner string, cal Calibration) error {
+	calibrationsMu.Lock()
+	defer calibrationsMu.Unlock()
+
+	if err := loadCalibrationsLocked(); err != nil {
+		return err
+	}
+	key := calibrationKey(owner, cal.DeviceID)
+	calibrations[key] = cal
+	var mine []string
+	for k, c := range calibrations {
+		if k != key && k == calibrationKey(owner, c.DeviceID) {
+			mine = append(mine, k)
+		}
+	}
+	dropOldestCalibrations(mine, len(mine)-(maxOwnerCalibrations-1))
+	dropOldestCalibrations(slices.Collect(maps.Keys(calibrations)), len(calibrations)-maxCalibrations)
+
+	data, err := json.MarshalIndent(calibrations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(resultsDir, calibrationsFile), data, 0644)
+}
+
+// dropOldestCalibrations forgets the n least recently calibrated of the
+// given keys. The caller must hold calibrationsMu.
+func dropOldestCalibrations(keys []string, n int) {
+	if n <= 0 {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return calibrations[keys[i]].CalibratedAt.Before(calibrations[keys[j]].CalibratedAt)
+	})
+	for _, k := range keys[:min(n, len(keys))] {
+		delete(calibrations, k)
+	}
+}
+
+// loadCalibrationsLocked reads calibrations from disk on first use.
+// The caller must hold calibrationsMu.
+func loadCalibrationsLocked() error {
+	if calibrations != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(resultsDir, calibrationsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		calibrations = map[string]Calibration{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]Calibration{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	calibrations = loaded
+	return nil
+}
+
+// newDeviceID generates a random identifier for an uncalibrated device
+func newDeviceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// median returns the middle value of a slice without modifying it
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Class roles. Teachers set a class's assignments and follow each student's
+// results; students get the assignments. Workspace admins teach every class.
+const (
+	RoleTeacher = "teacher"
+	RoleStudent = "student"
+)
+
+// Assignment statuses, worked out from each student's attempts
+const (
+	AssignmentPending = "pending"
+	AssignmentDone    = "done"
+	AssignmentMissed  = "missed" // it fell due first
+)
+
+const (
+	maxClasses         = 100 // per workspace
+	maxAssignments     = 100 // per class
+	maxAssignmentTitle = 100
+	maxAssignmentCount = 1000
+)
+
+// Errors from managing classes
+var (
+	errClassNotFound      = errors.New("Class not found")
+	errAssignmentNotFound = errors.New("Assignment not found")
+)
+
+// Class is a group of a workspace's members taught together, such as one
+// course of a school, with the assignments set for it
+type Class struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Members     map[string]string `json:"members,omitempty"`     // teacher or student by user ID
+	Assignments []Assignment      `json:"assignments,omitempty"` // in the order they were set
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
+// Assignment is a number of attempts at an exercise for each student to make
+// by a time, such as 10 two-point boxes by Friday
+type Assignment struct {
+	ID           string       `json:"id"`
+	Title        string       `json:"title"`
+	TrainingType TrainingType `json:"trainingType"`
+	Count        int          `json:"count"`              // complete attempts to make
+	MinScore     float64      `json:"minScore,omitempty"` // the composite score an attempt needs to count
+	Due          time.Time    `json:"due"`
+	CreatedAt    time.Time    `json:"createdAt"` // attempts from before it was set don't count
+}
+
+// CreateClassRequest sets up a class
+type CreateClassRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ClassSummary is a class as listed for one of its members
+type ClassSummary struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	Students    int    `json:"students"`
+	Assignments int    `json:"assignments"`
+}
+
+// ClassView is a class with how its assignments are going, each student's
+// for teachers and their own for students
+type ClassView struct {
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	Role        string             `json:"role"`
+	Members     map[string]string  `json:"members,omitempty"` // for teachers
+	Assignments []AssignmentStatus `json:"assignments"`
+}
+
+// AssignmentStatus is an assignment with its students' submissions
+type AssignmentStatus struct {
+	Assignment
+	Submissions []Submission `json:"submissions"`
+}
+
+// Submission is the attempts a student has made toward an assignment
+type Submission struct {
+	UserID       string     `json:"userId"`
+	Name         string     `json:"name,omitempty"`
+	Status       string     `json:"status"`    // pending, done or missed
+	Completed    int        `json:"completed"` // attempts that count, all of them even past the count
+	AverageScore *float64   `json:"averageScore,omitempty"`
+	BestScore    *float64   `json:"bestScore,omitempty"`
+	DoneAt       *time.Time `json:"doneAt,omitempty"`
+	AttemptIDs   []string   `json:"attemptIds"`
+}
+
+// updateClass applies change to a copy of a class of a workspace and saves it
+func updateClass(workspaceID, classID string, change func(*Class) error) (Class, error) {
+	var updated Class
+	_, err := updateWorkspace(workspaceID, func(ws *Workspace) error {
+		c, ok := ws.Classes[classID]
+		if !ok {
+			return errClassNotFound
+		}
+		c.Members = maps.Clone(c.Members)
+		c.Assignments = slices.Clone(c.Assignments)
+		if err := change(&c); err != nil {
+			return err
+		}
+		ws.Classes[classID] = c
+		updated = c
+		return nil
+	})
+	return updated, err
+}
+
+// dropFromClasses removes a user from every class of a workspace, as they
+// leave it
+func dropFromClasses(ws *Workspace, userID string) {
+	for id, c := range ws.Classes {
+		if _, ok := c.Members[userID]; ok {
+			c.Members = maps.Clone(c.Members)
+			delete(c.Members, userID)
+			ws.Classes[id] = c
+		}
+	}
+}
+
+// classRole is a user's role in a class, teaching it when they're an admin
+// of its workspace, or "" when they aren't in it
+func classRole(c Class, workspaceRole, userID string) string {
+	if workspaceRole == RoleAdmin {
+		return RoleTeacher
+	}
+	return c.Members[userID]
+}
+
+// requireClassMember wraps a handler for /workspaces/{workspace}/classes/{class}/...
+// so only the class's members may call it, and only its teachers when
+// teacher is set
+func requireClassMember(teacher bool, next func(http.ResponseWriter, *http.Request, Workspace, Class, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := userFrom(r.Context())
+		if !ok {
+			httpError(w, "Not signed in", http.StatusUnauthorized)
+			return
+		}
+		ws, wsRole, err := memberWorkspace(r.PathValue("workspace"), user.ID)
+		if errors.Is(err, errWorkspaceNotFound) {
+			writeError(w, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load workspaces", "err", err)
+			httpError(w, "Failed to load workspace", http.StatusInternalServerError)
+			return
+		}
+		c, ok := ws.Classes[r.PathValue("class")]
+		role := classRole(c, wsRole, user.ID)
+		if !ok || role == "" {
+			writeError(w, errClassNotFound, http.StatusNotFound)
+			return
+		}
+		if teacher && role != RoleTeacher {
+			httpError(w, "Only the class's teachers can do this", http.StatusForbidden)
+			return
+		}
+		next(w, r, ws, c, role)
+	}
+}
+
+// validateAssignment rejects assignments that can't be done in a workspace
+func validateAssignment(a Assignment, ws Workspace, now time.Time) error {
+	if a.Title == "" || len(a.Title) > maxAssignmentTitle {
+		return fieldError(CodeOutOfRange, "title", "Title must be 1 to %d characters", maxAssignmentTitle)
+	}
+	if _, ok := defaultExercises[a.TrainingType]; !ok {
+		return fieldError(CodeInvalidValue, "trainingType", "Unknown training type %q", a.TrainingType)
+	}
+	if !ws.allows(a.TrainingType) {
+		return fieldError(CodeExerciseNotAllowed, "trainingType", "Exercise %q isn't available in this workspace", a.TrainingType)
+	}
+	if a.Count < 1 || a.Count > maxAssignmentCount {
+		return fieldError(CodeOutOfRange, "count", "Count must be 1 to %d attempts", maxAssignmentCount)
+	}
+	if a.MinScore < 0 || a.MinScore > 100 {
+		return fieldError(CodeOutOfRange, "minScore", "Minimum score must be 0 to 100")
+	}
+	if !a.Due.After(now) {
+		return fieldError(CodeInvalidValue, "due", "Due must be a time in the future")
+	}
+	return nil
+}
+
+// submission works out how far a student is with an assignment from the
+// attempts: complete ones at its exercise in the workspace, made between
+// setting it and its due time, that reach its minimum score
+func submission(a Assignment, workspaceID, userID string, history []AttemptSummary, now time.Time) Submission {
+	s := Submission{UserID: userID, Status: AssignmentPending, AttemptIDs: []string{}}
+	total := 0.0
+	for _, h := range history {
+		if h.UserID != userID || h.WorkspaceID != workspaceID || h.TrainingType != a.TrainingType || h.Partial ||
+			h.Timestamp.Before(a.CreatedAt) || h.Timestamp.After(a.Due) || h.CompositeScore < a.MinScore {
+			continue
+		}
+		s.Completed++
+		s.AttemptIDs = append(s.AttemptIDs, h.ID)
+		total += h.CompositeScore
+		if s.BestScore == nil || h.CompositeScore > *s.BestScore {
+			s.BestScore = &h.CompositeScore
+		}
+		if s.Completed == a.Count {
+			s.DoneAt = &h.Timestamp
+		}
+	}
+	if s.Completed > 0 {
+		average := total / float64(s.Completed)
+		s.AverageScore = &average
+	}
+	switch {
+	case s.Completed >= a.Count:
+		s.Status = AssignmentDone
+	case now.After(a.Due):
+		s.Status = AssignmentMissed
+	}
+	return s
+}
+
+// handleListClasses lists the classes of a workspace the signed-in user is
+// in, or all of them for its admins
+func handleListClasses(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	ws, wsRole, err := memberWorkspace(r.PathValue("workspace"), user.ID)
+	if errors.Is(err, errWorkspaceNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load workspaces", "err", err)
+		httpError(w, "Failed to load workspace", http.StatusInternalServerError)
+		return
+	}
+
+	classes := []ClassSummary{}
+	for _, c := range ws.Classes {
+		role := classRole(c, wsRole, user.ID)
+		if role == "" {
+			continue
+		}
+		students := 0
+		for _, m := range c.Members {
+			if m == RoleStudent {
+				students++
+			}
+		}
+		classes = append(classes, ClassSummary{ID: c.ID, Name: c.Name, Role: role, Students: students, Assignments: len(c.Assignments)})
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].ID < classes[j].ID })
+	writeResponse(w, r, http.StatusOK, classes)
+}
+
+// handleCreateClass sets up a class in a workspace, for its admins
+func handleCreateClass(w http.ResponseWriter, r *http.Request, ws Workspace) {
+	var req CreateClassRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.ID, req.Name = strings.TrimSpace(req.ID), strings.TrimSpace(req.Name)
+	if !workspaceIDPattern.MatchString(req.ID) {
+		writeError(w, fieldError(CodeInvalidValue, "id", "Class ID must be 1 to 32 lowercase letters, digits or dashes"), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || len(req.Name) > maxWorkspaceName {
+		writeError(w, fieldError(CodeOutOfRange, "name", "Class name must be 1 to %d characters", maxWorkspaceName), http.StatusBadRequest)
+		return
+	}
+
+	c := Class{ID: req.ID, Name: req.Name, Members: map[string]string{}, CreatedAt: time.Now()}
+	var conflict error
+	_, err := updateWorkspace(ws.ID, func(ws *Workspace) error {
+		if _, ok := ws.Classes[c.ID]; ok {
+			conflict = errors.New("A class with this ID already exists")
+		} else if len(ws.Classes) >= maxClasses {
+			conflict = fmt.Errorf("A workspace can have at most %d classes", maxClasses)
+		}
+		if conflict != nil {
+			return conflict
+		}
+		if ws.Classes == nil {
+			ws.Classes = map[string]Class{}
+		}
+		ws.Classes[c.ID] = c
+		return nil
+	})
+	if conflict != nil {
+		writeError(w, conflict, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update workspace", "workspace", ws.ID, "err", err)
+		httpError(w, "Failed to update workspace", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Created class", "workspace", ws.ID, "class", c.ID)
+	w.Header().Set("Location", sitePath(apiPrefix+"/workspaces/"+ws.ID+"/classes/"+c.ID))
+	writeResponse(w, r, http.StatusCreated, c)
+}
+
+// handleDeleteClass removes a class with its assignments, for the
+// workspace's admins. The attempts made for them stay.
+func handleDeleteClass(w http.ResponseWriter, r *http.Request, ws Workspace) {
+	id := r.PathValue("class")
+	_, err := updateWorkspace(ws.ID, func(ws *Workspace) error {
+		if _, ok := ws.Classes[id]; !ok {
+			return errClassNotFound
+		}
+		delete(ws.Classes, id)
+		return nil
+	})
+	if errors.Is(err, errClassNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update workspace", "workspace", ws.ID, "err", err)
+		httpError(w, "Failed to update workspace", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Deleted class", "workspace", ws.ID, "class", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleClass returns a class with its assignments: teachers see every
+// student's submissions, students their own
+func handleClass(w http.ResponseWriter, r *http.Request, ws Workspace, c Class, role string) {
+	user, _ := userFrom(r.Context())
+	students := []string{user.ID}
+	view := ClassView{ID: c.ID, Name: c.Name, Role: role, Assignments: []AssignmentStatus{}}
+	if role == RoleTeacher {
+		view.Members = c.Members
+		students = students[:0]
+		for id, m := range c.Members {
+			if m == RoleStudent {
+				students = append(students, id)
+			}
+		}
+		sort.Strings(students)
+	}
+
+	history, err := loadHistory("")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load history", "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	history = inWorkspace(history, ws.ID)
+
+	names := map[string]string{}
+	if role == RoleTeacher {
+		for _, id := range students {
+			if p, err := store.Profile(id); err == nil {
+				names[id] = cmp.Or(p.DisplayName, p.Name)
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, a := range c.Assignments {
+		status := AssignmentStatus{Assignment: a, Submissions: []Submission{}}
+		for _, id := range students {
+			s := submission(a, ws.ID, id, history, now)
+			s.Name = names[id]
+			status.Submissions = append(status.Submissions, s)
+		}
+		view.Assignments = append(view.Assignments, status)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, view)
+}
+
+// handleSetClassMember adds a member of the workspace to a class as a
+// teacher or student, for its teachers
+func handleSetClassMember(w http.ResponseWriter, r *http.Request, ws Workspace, c Class, _ string) {
+	var req MemberRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Role != RoleTeacher && req.Role != RoleStudent {
+		httpError(w, "Role must be teacher or student", http.StatusBadRequest)
+		return
+	}
+	user := r.PathValue("user")
+	if ws.Members[user] == "" {
+		writeError(w, errMemberNotFound, http.StatusNotFound)
+		return
+	}
+	updated, err := updateClass(ws.ID, c.ID, func(c *Class) error {
+		c.Members[user] = req.Role
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update class", "workspace", ws.ID, "class", c.ID, "err", err)
+		httpError(w, "Failed to update class", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Set class member", "workspace", ws.ID, "class", c.ID, "member", user, "role", req.Role)
+	writeResponse(w, r, http.StatusOK, updated)
+}
+
+// handleRemoveClassMember takes a member out of a class, for its teachers
+func handleRemoveClassMember(w http.ResponseWriter, r *http.Request, ws Workspace, c Class, _ string) {
+	user := r.PathValue("user")
+	_, err := updateClass(ws.ID, c.ID, func(c *Class) error {
+		if _, ok := c.Members[user]; !ok {
+			return errMemberNotFound
+		}
+		delete(c.Members, user)
+		return nil
+	})
+	if errors.Is(err, errMemberNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update class", "workspace", ws.ID, "class", c.ID, "err", err)
+		httpError(w, "Failed to update class", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Removed class member", "workspace", ws.ID, "class", c.ID, "member", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateAssignment sets an assignment for a class, for its teachers
+func handleCreateAssignment(w http.ResponseWriter, r *http.Request, ws Workspace, c Class, _ string) {
+	var a Assignment
+	if !decodeRequest(w, r, &a) {
+		return
+	}
+	a.Title = strings.TrimSpace(a.Title)
+	now := time.Now()
+	if err := validateAssignment(a, ws, now); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if len(c.Assignments) >= maxAssignments {
+		httpError(w, fmt.Sprintf("A class can have at most %d assignments", maxAssignments), http.StatusConflict)
+		return
+	}
+	a.ID, a.CreatedAt = newAttemptID(), now
+
+	_, err := updateClass(ws.ID, c.ID, func(c *Class) error {
+		c.Assignments = append(c.Assignments, a)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update class", "workspace", ws.ID, "class", c.ID, "err", err)
+		httpError(w, "Failed to update class", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Set assignment", "workspace", ws.ID, "class", c.ID, "assignment", a.ID)
+	writeResponse(w, r, http.StatusCreated, a)
+}
+
+// handleDeleteAssignment removes an assignment from a class, for its teachers
+func handleDeleteAssignment(w http.ResponseWriter, r *http.Request, ws Workspace, c Class, _ string) {
+	id := r.PathValue("assignment")
+	_, err := updateClass(ws.ID, c.ID, func(c *Class) error {
+		i := slices.IndexFunc(c.Assignments, func(a Assignment) bool { return a.ID == id })
+		if i < 0 {
+			return errAssignmentNotFound
+		}
+		c.Assignments = slices.Delete(c.Assignments, i, i+1)
+		return nil
+	})
+	if errors.Is(err, errAssignmentNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update class", "workspace", ws.ID, "class", c.ID, "err", err)
+		httpError(w, "Failed to update class", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Removed assignment", "workspace", ws.ID, "class", c.ID, "assignment", id)
+	w.WriteHeader(http.StatusNoContent)
+}
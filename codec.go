@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	"tradra/tradrapb"
+)
+
+// API body formats besides JSON. MessagePack bodies have the same fields as
+// JSON; protobuf bodies are the tradra.v1 messages, which only exist for
+// analyses.
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeMsgpack  = "application/msgpack"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// bodyFormat maps a media type and its aliases to the format it names, or ""
+// for anything else
+func bodyFormat(mediaType string) string {
+	switch mediaType {
+	case "application/json":
+		return contentTypeJSON
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return contentTypeMsgpack
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return contentTypeProtobuf
+	}
+	return ""
+}
+
+// requestFormat returns the format of a request's body. Bodies without a
+// known Content-Type are read as JSON, as they always have been.
+func requestFormat(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if format := bodyFormat(mediaType); format != "" {
+		return format
+	}
+	return contentTypeJSON
+}
+
+// responseFormat picks the format the client prefers in Accept, defaulting to
+// JSON. Protobuf is only offered when there's a message for the response.
+func responseFormat(r *http.Request, protobuf bool) string {
+	best, bestQ := contentTypeJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format := bodyFormat(mediaType)
+		if format == "" || (format == contentTypeProtobuf && !protobuf) {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// unmarshalBody decodes a request body in the given format into v
+func unmarshalBody(body io.Reader, format string, v any) error {
+	switch format {
+	case contentTypeMsgpack:
+		dec := msgpack.NewDecoder(body)
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+	case contentTypeProtobuf:
+		req, ok := v.(*AnalysisRequest)
+		if !ok {
+			return errNoProtobufMessage
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		var in tradrapb.AnalyzeRequest
+		if err := proto.Unmarshal(data, &in); err != nil {
+			return err
+		}
+		*req, err = analysisRequestFromProto(&in)
+		return err
+	}
+	return json.NewDecoder(body).Decode(v)
+}
+
+// writeResponse encodes v in the format the client accepts
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v any) {
+	result, isResult := v.(AnalysisResult)
+	format := responseFormat(r, isResult)
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case contentTypeMsgpack:
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		err = enc.Encode(v)
+	case contentTypeProtobuf:
+		var data []byte
+		data, err = proto.Marshal(analyzeResponseToProto(result))
+		buf.Write(data)
+	default:
+		err = json.NewEncoder(&buf).Encode(v)
+	}
+	if err != nil {
+		httpError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// decodeMsgpackAsJSON decodes a MessagePack value through v's JSON decoding,
+// for types such as Theme that accept a name in place of an object
+func decodeMsgpackAsJSON(dec *msgpack.Decoder, v json.Unmarshaler) error {
+	value, err := dec.DecodeInterface()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return v.UnmarshalJSON(data)
+}
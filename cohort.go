@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+)
+
+// scoreBands is how many equal bands of the 0 to 100 score range a
+// distribution counts attempts in
+const scoreBands = 10
+
+// CohortStats is how a group's complete attempts went, for its teachers to
+// see what the group as a whole struggles with
+type CohortStats struct {
+	Users      int             `json:"users"` // who made attempts
+	Attempts   int             `json:"attempts"`
+	Exercises  []ExerciseStats `json:"exercises"`  // most failed first
+	StrokeTags []TagCount      `json:"strokeTags"` // at every exercise, most common first
+}
+
+// ExerciseStats is how a group's attempts at one exercise went
+type ExerciseStats struct {
+	TrainingType TrainingType `json:"trainingType"`
+	Users        int          `json:"users"`
+	Attempts     int          `json:"attempts"`
+	MeanScore    float64      `json:"meanScore"`
+	MedianScore  float64      `json:"medianScore"`
+	FailRate     float64      `json:"failRate"` // the share of attempts graded F
+	// Distribution counts composite scores in bands of 10: 0 to 10, 10 to
+	// 20 and so on, with 100 in the last
+	Distribution []int      `json:"distribution"`
+	StrokeTags   []TagCount `json:"strokeTags"` // most common first
+}
+
+// TagCount is how often strokes were tagged with a problem
+type TagCount struct {
+	Tag      string  `json:"tag"`
+	Strokes  int     `json:"strokes"`
+	Attempts int     `json:"attempts"` // with at least one stroke so tagged
+	Share    float64 `json:"share"`    // of the attempts
+}
+
+// cohortTally gathers a group's attempts at one exercise, or at all of them
+type cohortTally struct {
+	users  map[string]bool
+	scores []float64
+	failed int
+	tags   map[string]*TagCount
+}
+
+func newCohortTally() *cohortTally {
+	return &cohortTally{users: map[string]bool{}, tags: map[string]*TagCount{}}
+}
+
+// add counts an attempt, with the stroke tags of its result
+func (t *cohortTally) add(a AttemptSummary, failed bool, strokeTags [][]string) {
+	t.users[a.UserID] = true
+	t.scores = append(t.scores, a.CompositeScore)
+	if failed {
+		t.failed++
+	}
+	seen := map[string]bool{}
+	for _, tags := range strokeTags {
+		for _, tag := range tags {
+			c := t.tags[tag]
+			if c == nil {
+				c = &TagCount{Tag: tag}
+				t.tags[tag] = c
+			}
+			c.Strokes++
+			if !seen[tag] {
+				seen[tag] = true
+				c.Attempts++
+			}
+		}
+	}
+}
+
+// tagCounts returns the tags counted, most common first
+func (t *cohortTally) tagCounts() []TagCount {
+	counts := []TagCount{}
+	for _, c := range t.tags {
+		c.Share = float64(c.Attempts) / float64(len(t.scores))
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Attempts != b.Attempts {
+			return a.Attempts > b.Attempts
+		}
+		if a.Strokes != b.Strokes {
+			return a.Strokes > b.Strokes
+		}
+		return a.Tag < b.Tag
+	})
+	return counts
+}
+
+// exerciseStats sums up the attempts at one exercise
+func (t *cohortTally) exerciseStats(trainingType TrainingType) ExerciseStats {
+	s := ExerciseStats{
+		TrainingType: trainingType,
+		Users:        len(t.users),
+		Attempts:     len(t.scores),
+		FailRate:     float64(t.failed) / float64(len(t.scores)),
+		Distribution: make([]int, scoreBands),
+		StrokeTags:   t.tagCounts(),
+	}
+	sort.Float64s(t.scores)
+	for _, score := range t.scores {
+		s.MeanScore += score / float64(len(t.scores))
+		band := int(score / (100 / scoreBands))
+		s.Distribution[min(max(band, 0), scoreBands-1)]++
+	}
+	mid := len(t.scores) / 2
+	s.MedianScore = t.scores[mid]
+	if len(t.scores)%2 == 0 {
+		s.MedianScore = (t.scores[mid-1] + t.scores[mid]) / 2
+	}
+	return s
+}
+
+// cohortStats sums up the complete attempts a filter picks, keeping only
+// those of the given users unless it's nil
+func cohortStats(f AttemptFilter, users map[string]bool) (CohortStats, error) {
+	all := newCohortTally()
+	byType := map[TrainingType]*cohortTally{}
+	err := store.ExportAttempts(f, func(a AttemptSummary, _, result []byte) error {
+		if a.Partial || (users != nil && !users[a.UserID]) {
+			return nil
+		}
+		// Attempts from before results were kept are graded on their
+		// composite score, and have no stroke tags
+		var res AnalysisResult
+		res.Grade.Letter = letterGrade(a.CompositeScore)
+		if len(result) > 0 {
+			if err := json.Unmarshal(result, &res); err != nil {
+				return err
+			}
+		}
+		failed := res.Grade.Letter == "F"
+		all.add(a, failed, res.StrokeTags)
+		t := byType[a.TrainingType]
+		if t == nil {
+			t = newCohortTally()
+			byType[a.TrainingType] = t
+		}
+		t.add(a, failed, res.StrokeTags)
+		return nil
+	})
+	if err != nil {
+		return CohortStats{}, err
+	}
+
+	stats := CohortStats{Users: len(all.users), Attempts: len(all.scores), Exercises: []ExerciseStats{}, StrokeTags: all.tagCounts()}
+	for trainingType, t := range byType {
+		stats.Exercises = append(stats.Exercises, t.exerciseStats(trainingType))
+	}
+	sort.Slice(stats.Exercises, func(i, j int) bool {
+		a, b := stats.Exercises[i], stats.Exercises[j]
+		if a.FailRate != b.FailRate {
+			return a.FailRate > b.FailRate
+		}
+		return a.TrainingType < b.TrainingType
+	})
+	return stats, nil
+}
+
+// handleWorkspaceStats sums up the attempts of everyone in a workspace, for
+// its admins, filtered like a listing
+func handleWorkspaceStats(w http.ResponseWriter, r *http.Request, ws Workspace) {
+	writeCohortStats(w, r, ws, nil)
+}
+
+// handleClassStats sums up the attempts of a class's students in its
+// workspace, for its teachers, filtered like a listing
+func handleClassStats(w http.ResponseWriter, r *http.Request, ws Workspace, c Class, _ string) {
+	students := map[string]bool{}
+	for id, role := range c.Members {
+		if role == RoleStudent {
+			students[id] = true
+		}
+	}
+	writeCohortStats(w, r, ws, students)
+}
+
+// writeCohortStats answers with the stats of a workspace's attempts, those of
+// the given users unless it's nil
+func writeCohortStats(w http.ResponseWriter, r *http.Request, ws Workspace, users map[string]bool) {
+	f, err := parseAttemptRange(r.URL.Query())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	f.WorkspaceID, f.AllUsers = ws.ID, true
+
+	stats, err := cohortStats(f, users)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load history", "workspace", ws.ID, "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, stats)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxCommentLength = 2000
+	// maxAttemptComments is how many comments an attempt can hold
+	maxAttemptComments = 200
+	// commentHideReports is how many users reporting a comment hide it until
+	// an admin looks at it
+	commentHideReports = 3
+)
+
+// errCommentNotFound is returned for comments that don't exist
+var errCommentNotFound = errors.New("Comment not found")
+
+// Comment is what a signed-in user wrote about a shared attempt, optionally
+// about one of its strokes or a point on its canvas
+type Comment struct {
+	ID        string    `json:"id"`
+	AttemptID string    `json:"attemptId"`
+	UserID    string    `json:"-"`
+	Author    string    `json:"author"` // the user's display name, or the provider's name for them
+	Body      string    `json:"body"`
+	Stroke    *int      `json:"stroke,omitempty"` // the index of the stroke it's about
+	Point     *Point    `json:"point,omitempty"`  // the canvas point it's about
+	CreatedAt time.Time `json:"createdAt"`
+	Yours     bool      `json:"yours,omitempty"`     // the caller wrote it
+	CanDelete bool      `json:"canDelete,omitempty"` // the caller wrote it or made the attempt
+	Reports   int       `json:"reports,omitempty"`   // users who reported it, for admins
+}
+
+// CommentThread is the comments on an attempt
+type CommentThread struct {
+	AttemptID string    `json:"attemptId"`
+	Closed    bool      `json:"closed"` // no more can be added
+	Comments  []Comment `json:"comments"`
+}
+
+// CommentSettings are what the user who made an attempt chooses for its
+// comments
+type CommentSettings struct {
+	Closed bool `json:"closed"`
+}
+
+// validateComment tidies a new comment and rejects one that's empty, too
+// long, or about a stroke or point its attempt doesn't have
+func validateComment(c *Comment, req AnalysisRequest, hasRequest bool) error {
+	c.Body = strings.TrimSpace(c.Body)
+	if c.Body == "" {
+		return fieldError(CodeInvalidValue, "body", "Expected the comment's text")
+	}
+	if len(c.Body) > maxCommentLength {
+		return fieldError(CodeOutOfRange, "body", "Comments must be at most %d characters", maxCommentLength)
+	}
+	if (c.Stroke != nil || c.Point != nil) && !hasRequest {
+		return fieldError(CodeInvalidValue, "stroke", "This attempt's strokes weren't kept, so comments can't point at them")
+	}
+	if c.Stroke != nil && (*c.Stroke < 0 || *c.Stroke >= len(req.Strokes)) {
+		return fieldError(CodeOutOfRange, "stroke", "Stroke must be between 0 and %d", len(req.Strokes)-1)
+	}
+	if p := c.Point; p != nil {
+		if p.X < 0 || p.Y < 0 || p.X > req.Width || p.Y > req.Height {
+			return fieldError(CodeOutOfRange, "point", "Point must be on the %gx%g canvas", req.Width, req.Height)
+		}
+		p.T = 0
+	}
+	return nil
+}
+
+// markComments fills in what the caller may do with comments on an attempt
+// made by owner
+func markComments(r *http.Request, comments []Comment, owner string) {
+	user, _ := userFrom(r.Context())
+	for i := range comments {
+		c := &comments[i]
+		c.Yours = user.ID != "" && c.UserID == user.ID
+		c.CanDelete = c.Yours || (user.ID != "" && owner == user.ID)
+		c.Reports = 0
+	}
+}
+
+// findSharedAttempt loads the attempt a comment route names, answering with
+// an error when it can't
+func findSharedAttempt(w http.ResponseWriter, r *http.Request) (StoredAttempt, bool) {
+	id := r.PathValue("id")
+	a, err := findAttempt(id)
+	if errors.Is(err, errAttemptNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return a, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load attempt", "attemptId", id, "err", err)
+		httpError(w, "Failed to load attempt", http.StatusInternalServerError)
+		return a, false
+	}
+	return a, true
+}
+
+// handleListComments returns the comments on an attempt. Like its saved
+// result, anyone with its link can see them.
+func handleListComments(w http.ResponseWriter, r *http.Request) {
+	a, ok := findSharedAttempt(w, r)
+	if !ok {
+		return
+	}
+	thread := CommentThread{AttemptID: a.ID}
+	var err error
+	thread.Closed, err = store.CommentsClosed(a.ID)
+	if err == nil {
+		thread.Comments, err = store.Comments(a.ID, commentHideReports)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load comments", "attemptId", a.ID, "err", err)
+		httpError(w, "Failed to load comments", http.StatusInternalServerError)
+		return
+	}
+	markComments(r, thread.Comments, a.UserID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, thread)
+}
+
+// handleCreateComment adds the signed-in user's comment to an attempt,
+// unless its user closed its comments
+func handleCreateComment(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	var c Comment
+	if !decodeRequest(w, r, &c) {
+		return
+	}
+	a, ok := findSharedAttempt(w, r)
+	if !ok {
+		return
+	}
+	var req AnalysisRequest
+	if a.HasRequest {
+		var err error
+		if req, err = loadAttempt(a.ID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load attempt", "attemptId", a.ID, "err", err)
+			httpError(w, "Failed to load attempt", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := validateComment(&c, req, a.HasRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	closed, err := store.CommentsClosed(a.ID)
+	var comments []Comment
+	if err == nil {
+		comments, err = store.Comments(a.ID, commentHideReports)
+	}
+	if err == nil && closed {
+		httpError(w, "Comments on this attempt are closed", http.StatusConflict)
+		return
+	}
+	if err == nil && len(comments) >= maxAttemptComments {
+		httpError(w, fmt.Sprintf("Attempts can have at most %d comments", maxAttemptComments), http.StatusConflict)
+		return
+	}
+	// Users who signed in before users were recorded need saving to be
+	// named on their comments
+	if err == nil {
+		_, err = loadProfile(u)
+	}
+	if err == nil {
+		c.ID, c.AttemptID, c.UserID, c.CreatedAt = newAttemptID(), a.ID, u.ID, time.Now()
+		err = store.AddComment(c)
+	}
+	if err == nil {
+		c, err = store.Comment(c.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save comment", "attemptId", a.ID, "err", err)
+		httpError(w, "Failed to save comment", http.StatusInternalServerError)
+		return
+	}
+	comments = []Comment{c}
+	markComments(r, comments, a.UserID)
+	writeResponse(w, r, http.StatusCreated, comments[0])
+}
+
+// findComment loads the comment a route names on the attempt it names,
+// answering with an error when it can't
+func findComment(w http.ResponseWriter, r *http.Request, a StoredAttempt) (Comment, bool) {
+	c, err := store.Comment(r.PathValue("comment"))
+	if errors.Is(err, errCommentNotFound) || (err == nil && c.AttemptID != a.ID) {
+		writeError(w, errCommentNotFound, http.StatusNotFound)
+		return c, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load comment", "commentId", r.PathValue("comment"), "err", err)
+		httpError(w, "Failed to load comment", http.StatusInternalServerError)
+		return c, false
+	}
+	return c, true
+}
+
+// handleDeleteComment removes a comment, for whoever wrote it or made the
+// attempt it's on
+func handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	a, ok := findSharedAttempt(w, r)
+	if !ok {
+		return
+	}
+	c, ok := findComment(w, r, a)
+	if !ok {
+		return
+	}
+	if c.UserID != u.ID && a.UserID != u.ID {
+		httpError(w, "Only whoever wrote a comment or made the attempt can delete it", http.StatusForbidden)
+		return
+	}
+	deleteComment(w, r, c.ID)
+}
+
+// deleteComment removes a comment and answers with no content
+func deleteComment(w http.ResponseWriter, r *http.Request, id string) {
+	err := store.DeleteComment(id)
+	if errors.Is(err, errCommentNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete comment", "commentId", id, "err", err)
+		httpError(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Comment deleted", "commentId", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReportComment records the signed-in user reporting a comment for
+// the admin to look at. Enough reports hide it in the meantime.
+func handleReportComment(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	a, ok := findSharedAttempt(w, r)
+	if !ok {
+		return
+	}
+	c, ok := findComment(w, r, a)
+	if !ok {
+		return
+	}
+	if err := store.ReportComment(c.ID, u.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to report comment", "commentId", c.ID, "err", err)
+		httpError(w, "Failed to report comment", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Comment reported", "commentId", c.ID, "userId", u.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePutCommentSettings closes or reopens the comments on an attempt, for
+// whoever made it. Comments already written stay.
+func handlePutCommentSettings(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	var settings CommentSettings
+	if !decodeRequest(w, r, &settings) {
+		return
+	}
+	a, ok := findSharedAttempt(w, r)
+	if !ok {
+		return
+	}
+	if a.UserID != u.ID {
+		httpError(w, "Only whoever made an attempt can close its comments", http.StatusForbidden)
+		return
+	}
+	if err := store.SetCommentsClosed(a.ID, settings.Closed); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save comment settings", "attemptId", a.ID, "err", err)
+		httpError(w, "Failed to save comment settings", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, settings)
+}
+
+// handleListReportedComments returns every reported comment, most reported
+// first, for the admin
+func handleListReportedComments(w http.ResponseWriter, r *http.Request) {
+	comments, err := store.ReportedComments()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load comments", "err", err)
+		httpError(w, "Failed to load comments", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, comments)
+}
+
+// handleAdminDeleteComment removes any comment
+func handleAdminDeleteComment(w http.ResponseWriter, r *http.Request) {
+	deleteComment(w, r, r.PathValue("comment"))
+}
+
+// handleClearCommentReports dismisses the reports of a comment, showing it
+// again if they hid it
+func handleClearCommentReports(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("comment")
+	_, err := store.Comment(id)
+	if errors.Is(err, errCommentNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = store.ClearCommentReports(id)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to clear comment reports", "commentId", id, "err", err)
+		httpError(w, "Failed to clear comment reports", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"log/slog"
+	"math"
+	"net/http"
+)
+
+// Layout of the side-by-side comparison image, in pixels at 1x
+const (
+	compareHeader = 36.0 // band above each panel holding its caption
+	compareGap    = 16.0 // space between panels
+)
+
+// CompareRequest names the two attempts to show side by side, either as
+// stored attempt IDs or as stroke sets to analyze. The first is shown on the
+// left as "Before", the second on the right as "After".
+type CompareRequest struct {
+	AttemptIDs []string          `json:"attemptIds,omitempty"`
+	Attempts   []AnalysisRequest `json:"attempts,omitempty"`
+	Scale      float64           `json:"scale,omitempty"`
+	Theme      *Theme            `json:"theme,omitempty"`
+	Locale     string            `json:"locale,omitempty"`
+}
+
+// ComparedAttempt summarizes one side of a comparison
+type ComparedAttempt struct {
+	AttemptID      string  `json:"attemptId,omitempty"`
+	CompositeScore float64 `json:"compositeScore"`
+	Grade          string  `json:"grade"`
+}
+
+// CompareResult is the comparison image and the scores it shows
+type CompareResult struct {
+	ImageData     string            `json:"imageData"`
+	Attempts      []ComparedAttempt `json:"attempts"`
+	Improvement   float64           `json:"improvement"` // after minus before composite score
+	SavedFilePath string            `json:"savedFilePath"`
+}
+
+// handleCompare renders two attempts side by side with their scores
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompareRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	attempts, ids, err := compareAttempts(r.Context(), req)
+	if errors.Is(err, errAttemptNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result := compareStrokes(attempts, ids)
+
+	writeResponse(w, r, http.StatusOK, result)
+}
+
+// compareAttempts resolves the request into two validated analysis requests
+// rendered with the comparison's scale and theme. Stored attempts must be
+// ones the caller may see.
+func compareAttempts(ctx context.Context, req CompareRequest) ([]AnalysisRequest, []string, error) {
+	if req.Scale == 0 {
+		req.Scale = 1
+	}
+
+	var attempts []AnalysisRequest
+	ids := make([]string, 2)
+	switch {
+	case len(req.AttemptIDs) == 2 && len(req.Attempts) == 0:
+		for i, id := range req.AttemptIDs {
+			_, err := findVisibleAttempt(ctx, id)
+			var a AnalysisRequest
+			if err == nil {
+				a, err = loadAttempt(id)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("attempt %q: %w", id, err)
+			}
+			attempts = append(attempts, a)
+			ids[i] = id
+		}
+	case len(req.Attempts) == 2 && len(req.AttemptIDs) == 0:
+		attempts = req.Attempts
+	default:
+		return nil, nil, errors.New("Expected either 2 attemptIds or 2 attempts")
+	}
+
+	for i := range attempts {
+		attempts[i].Format = FormatPNG
+		attempts[i].Scale = req.Scale
+		if req.Theme != nil {
+			attempts[i].Theme = req.Theme
+		}
+		if req.Locale != "" {
+			attempts[i].Locale = req.Locale
+		}
+		if err := validateAnalysisRequest(&attempts[i]); err != nil {
+			return nil, nil, fmt.Errorf("attempt %d: %w", i+1, err)
+		}
+	}
+	// The panels are drawn side by side in one image
+	width := attempts[0].Width + compareGap + attempts[1].Width
+	height := math.Max(attempts[0].Height, attempts[1].Height) + compareHeader
+	if width*height*req.Scale*req.Scale > maxRenderPixels {
+		return nil, nil, fieldError(CodeOutOfRange, "scale", "The comparison would be larger than %d pixels; lower the scale", maxRenderPixels)
+	}
+	return attempts, ids, nil
+}
+
+// compareStrokes scores both attempts without recording them and renders the
+// comparison image
+func compareStrokes(attempts []AnalysisRequest, ids []string) CompareResult {
+	var results []AnalysisResult
+	var visuals []visualization
+	for _, a := range attempts {
+		result, vis := scoreStrokes(a)
+		results = append(results, result)
+		visuals = append(visuals, vis)
+	}
+
+	data := renderComparison(visuals, results, []string{"Before", "After"})
+	savedPath := saveResultToFile(data, FormatPNG, "compare", results[1].CompositeScore, "")
+
+	compared := make([]ComparedAttempt, len(results))
+	for i, r := range results {
+		compared[i] = ComparedAttempt{AttemptID: ids[i], CompositeScore: r.CompositeScore, Grade: r.Grade.Letter}
+	}
+	return CompareResult{
+		ImageData:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(data),
+		Attempts:      compared,
+		Improvement:   results[1].CompositeScore - results[0].CompositeScore,
+		SavedFilePath: savedPath,
+	}
+}
+
+// renderComparison draws each attempt's visualization in its own panel, left to
+// right, under a caption with its score and grade
+func renderComparison(visuals []visualization, results []AnalysisResult, captions []string) []byte {
+	scale := visuals[0].req.Scale
+	pal, _ := visuals[0].req.Theme.palette()
+	loc := findLocale(visuals[0].req.Locale)
+
+	var width, height float64
+	for i, v := range visuals {
+		if i > 0 {
+			width += compareGap
+		}
+		width += v.req.Width
+		height = math.Max(height, v.req.Height)
+	}
+	height += compareHeader
+
+	c := newGGCanvas(width, height, scale, viewport{0, 0, width, height})
+	c.Clear(pal.background)
+
+	x := 0.0
+	for i, v := range visuals {
+		panel := newGGCanvas(v.req.Width, v.req.Height, scale, viewportFor(v))
+		drawVisualization(panel, v)
+		c.dc.Push()
+		c.dc.Identity()
+		c.dc.DrawImage(panel.dc.Image(), int(math.Round(x*scale)), int(math.Round(compareHeader*scale)))
+		c.dc.Pop()
+
+		c.SetColor(pal.text)
+		caption := fmt.Sprintf("%s — %s (%s)", loc.text(captions[i]), loc.number(results[i].CompositeScore, 0), results[i].Grade.Letter)
+		c.DrawString(caption, x+10, compareHeader-12)
+		x += v.req.Width + compareGap
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.dc.Image()); err != nil {
+		slog.Error("Failed to encode comparison", "err", err)
+	}
+	return buf.Bytes()
+}
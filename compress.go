@@ -0,0 +1,176 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressBytes is the smallest response worth compressing; below it the
+// encoding overhead outweighs the savings
+const minCompressBytes = 1024
+
+// compressibleTypes are the content types compressed. Images other than SVG
+// and PDFs are compressed already, and base64 PNGs inside JSON barely shrink,
+// but the numbers around them and SVG markup shrink a lot.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/msgpack":    true,
+	"application/x-protobuf": true,
+	"image/svg+xml":          true,
+	"text/html":              true,
+	"text/plain":             true,
+	"text/css":               true,
+	"text/javascript":        true,
+}
+
+// withCompression compresses responses with brotli or gzip, whichever the
+// client accepts, brotli first. WebSocket upgrades pass through untouched.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks br or gzip from an Accept-Encoding header, or ""
+// when the client takes neither
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[strings.ToLower(name)] = q > 0
+	}
+	switch {
+	case accepted["br"]:
+		return "br"
+	case accepted["gzip"]:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// it's worth compressing: a compressible type, not encoded already, and at
+// least minCompressBytes long
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool           // the headers have been sent
+	enc      io.WriteCloser // set once compressing
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if !w.decided {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	if !w.compressible() {
+		w.start(false)
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= minCompressBytes {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// compressible reports whether the response so far could be compressed
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	switch {
+	case h.Get("Content-Encoding") != "", w.status < 200,
+		w.status == http.StatusNoContent, w.status == http.StatusNotModified, w.status == http.StatusPartialContent:
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return compressibleTypes[mediaType]
+}
+
+// start sends the headers, compressed or not, and whatever was held back
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	if compress {
+		w.Header().Set("Content-Encoding", w.encoding)
+		w.Header().Del("Content-Length")
+		// The compressed bytes differ, so a strong validator would be wrong
+		if etag := w.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			w.Header().Set("ETag", "W/"+etag)
+		}
+		if w.encoding == "br" {
+			w.enc = brotli.NewWriterLevel(w.ResponseWriter, brotli.DefaultCompression)
+		} else {
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush sends what's been written so far, so streamed responses aren't held
+// back waiting to be compressed
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.start(w.compressible() && len(w.buf) > 0)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Close finishes the response once the handler returns, sending short
+// responses uncompressed
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		w.start(false)
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the server settings, from flags falling back to the
+// environment so the server runs under orchestrators without edits
+type config struct {
+	addr       string      // listen address as host:port, or unix:<path> for a socket
+	socketMode os.FileMode // permissions of a unix socket
+	basePath   string      // path the site is mounted under behind a proxy, empty for the root
+
+	// TLS from a certificate and key on disk, or from Let's Encrypt for the
+	// autocert domains
+	tlsCert, tlsKey string
+	autocert        []string
+	autocertDir     string
+
+	drainTimeout time.Duration // how long shutdown waits for in-flight requests
+
+	// HTTP versions served over TCP, and whether HTTP/3 is served over UDP
+	protocols http.Protocols
+	http3     bool
+
+	grpcAddr string // listen address for the gRPC service, off when empty
+
+	jobWorkers int // how many background jobs run at once
+
+	// Signing key for job callbacks from requests without a keyed secret,
+	// and whether callbacks may go to private addresses
+	webhookSecret       string
+	webhookAllowPrivate bool
+
+	staticDir string // directory to serve the frontend from instead of the embedded files
+
+	database string // PostgreSQL URL for the attempt history, SQLite in resultsDir when empty
+	restore  string // backup archive to replace resultsDir with before starting
+
+	// Per-client limit on analyses, off when the rate is 0
+	rateLimit float64 // requests per second
+	rateBurst int
+	// Reverse proxies whose X-Forwarded-For is believed, for servers behind one
+	trustedProxies trustedProxies
+	// Client IPs that may use the server, all when empty, and those that may not
+	allowIPs, denyIPs []netip.Prefix
+
+	// Require an API key from clients other than the site's own pages, and
+	// the token for managing keys through the admin API
+	requireAPIKey bool
+	adminToken    string
+
+	debug bool // serve pprof and expvar to the admin
+
+	maintenance bool // start in maintenance mode
+
+	// Sign-in with an OIDC issuer or GitHub, off when no provider is given
+	loginProvider     string
+	loginClientID     string
+	loginClientSecret string
+	publicURL         string // external base URL, for the login callback
+	sessionSecret     string
+	deletionGrace     time.Duration // how long deleted accounts wait before they're purged
+
+	// How long attempts keep their strokes and saved images, for ever when 0
+	retainStrokes time.Duration
+	retainImages  time.Duration
+
+	// Email for goal reminders and weekly digests, off when no SMTP server is given
+	smtpAddr     string
+	smtpFrom     string
+	smtpUsername string
+	smtpPassword string
+
+	// S3-compatible object storage for saved images and thumbnails, off when
+	// no bucket is given
+	s3Endpoint  string
+	s3Region    string
+	s3Bucket    string
+	s3Prefix    string
+	s3AccessKey string
+	s3SecretKey string
+	s3PathStyle bool          // address the bucket in the path, as MinIO and others want, not the host
+	s3URLExpiry time.Duration // how long presigned image links work
+
+	logFormat string // LogFormatText or LogFormatJSON
+	logLevel  slog.Level
+
+	// Limits so slow or oversized requests can't tie the server up
+	maxBodyBytes   int64
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	handlerTimeout time.Duration
+	idleTimeout    time.Duration
+
+	// Cross-origin access for frontends hosted elsewhere, off when no
+	// origins are given
+	corsOrigins []string
+	corsMethods []string
+	corsHeaders []string
+}
+
+// parseConfig reads the command line. -addr takes precedence over -port,
+// which takes precedence over $PORT, and $HOST picks the interface.
+func parseConfig() (config, error) {
+	addr := flag.String("addr", "", "listen address as host:port, unix:<path> for a unix socket, or systemd:[name] for a socket from systemd, overriding -port and $HOST")
+	socketMode := flag.String("socket-mode", "0660", "permissions of the unix socket given with -addr, in octal")
+	port := flag.String("port", envOr("PORT", "8080"), "port to listen on, defaulting to $PORT")
+	basePath := flag.String("base-path", os.Getenv("TRADRA_BASE_PATH"), "path the site is served under behind a reverse proxy, e.g. /tradra, defaulting to $TRADRA_BASE_PATH; -public-url doesn't include it")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file, serving HTTPS with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	autocert := flag.String("autocert", "", "comma-separated domains to get Let's Encrypt certificates for")
+	autocertDir := flag.String("autocert-dir", "certs", "directory to cache Let's Encrypt certificates in")
+	protocols := flag.String("protocols", envOr("TRADRA_PROTOCOLS", protoHTTP1+","+protoH2), "comma-separated protocols to serve: http1, h2 (HTTP/2 over TLS), h2c (HTTP/2 without TLS) and h3 (HTTP/3 over UDP, needs TLS), defaulting to $TRADRA_PROTOCOLS")
+	grpcAddr := flag.String("grpc-addr", os.Getenv("GRPC_ADDR"), "listen address for the gRPC service as host:port, unix:<path> or systemd:[name], defaulting to $GRPC_ADDR; off when empty")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long to wait for in-flight requests when shutting down")
+	maxBodyBytes := flag.Int64("max-body-bytes", 32<<20, "largest request body accepted, in bytes")
+	readTimeout := flag.Duration("read-timeout", time.Minute, "how long a client may take to send a request")
+	writeTimeout := flag.Duration("write-timeout", 2*time.Minute, "how long a response may take to write, including the handler")
+	handlerTimeout := flag.Duration("handler-timeout", 90*time.Second, "how long a request may take to handle before failing with 503")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long to keep idle connections open")
+	database := flag.String("database", os.Getenv("DATABASE_URL"), "postgres:// URL of a PostgreSQL database to keep attempts in, shared by several servers, defaulting to $DATABASE_URL; SQLite in the results directory when empty")
+	restore := flag.String("restore", "", "backup archive from /api/v1/admin/backup to restore the results directory from before starting, moving the current one aside")
+	staticDir := flag.String("static-dir", os.Getenv("TRADRA_STATIC_DIR"), "serve the frontend from this directory instead of the built-in files, defaulting to $TRADRA_STATIC_DIR")
+	jobWorkers := flag.Int("job-workers", runtime.NumCPU(), "how many background jobs run at once, defaulting to the number of CPUs")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("TRADRA_WEBHOOK_SECRET"), "key signing job callbacks from requests without an API key of their own, defaulting to $TRADRA_WEBHOOK_SECRET")
+	webhookAllowPrivate := flag.Bool("webhook-allow-private", false, "let job callbacks go to loopback and private network addresses")
+	rateLimit := flag.Float64("rate-limit", 5, "analyses per second allowed from each client IP, or 0 for no limit")
+	rateBurst := flag.Int("rate-burst", 20, "analyses a client IP may make at once before being rate limited")
+	trustedProxies := flag.String("trusted-proxies", os.Getenv("TRADRA_TRUSTED_PROXIES"), "comma-separated IPs and CIDR ranges of reverse proxies, and unix for connections over a unix socket, whose X-Forwarded-For and X-Forwarded-Proto are believed, defaulting to $TRADRA_TRUSTED_PROXIES")
+	trustForwardedFor := flag.Bool("trust-forwarded-for", false, "replaced by -trusted-proxies")
+	allowIPs := flag.String("allow-ips", os.Getenv("TRADRA_ALLOW_IPS"), "comma-separated IPs and CIDR ranges allowed to use the server, all when empty, defaulting to $TRADRA_ALLOW_IPS")
+	denyIPs := flag.String("deny-ips", os.Getenv("TRADRA_DENY_IPS"), "comma-separated IPs and CIDR ranges refused, even when allowed by -allow-ips, defaulting to $TRADRA_DENY_IPS")
+	requireAPIKey := flag.Bool("require-api-key", false, "require an API key for API requests that don't come from a browser page")
+	adminToken := flag.String("admin-token", os.Getenv("TRADRA_ADMIN_TOKEN"), "bearer token for the admin API, defaulting to $TRADRA_ADMIN_TOKEN; the admin API is off without one")
+	debug := flag.Bool("debug", false, "serve pprof profiles and expvar metrics under /debug/ to the admin; needs -admin-token")
+	maintenance := flag.Bool("maintenance", false, "start in maintenance mode, answering users with 503 until the admin turns it off or the server restarts without it")
+	loginProvider := flag.String("login-provider", os.Getenv("TRADRA_LOGIN_PROVIDER"), "sign users in with google, github or an OIDC issuer URL, defaulting to $TRADRA_LOGIN_PROVIDER")
+	loginClientID := flag.String("login-client-id", os.Getenv("TRADRA_LOGIN_CLIENT_ID"), "OAuth client ID registered with the login provider, defaulting to $TRADRA_LOGIN_CLIENT_ID")
+	loginClientSecret := flag.String("login-client-secret", os.Getenv("TRADRA_LOGIN_CLIENT_SECRET"), "OAuth client secret, defaulting to $TRADRA_LOGIN_CLIENT_SECRET")
+	publicURL := flag.String("public-url", os.Getenv("TRADRA_PUBLIC_URL"), "external base URL of the server, e.g. https://tradra.example.com, defaulting to $TRADRA_PUBLIC_URL or the request's host")
+	sessionSecret := flag.String("session-secret", os.Getenv("TRADRA_SESSION_SECRET"), "key signing session cookies, defaulting to $TRADRA_SESSION_SECRET or a random key per start")
+	deletionGrace := flag.Duration("deletion-grace", 30*24*time.Hour, "how long a deleted account is kept, hidden, before it's purged for good; signing in again before then cancels it")
+	retainStrokes := flag.Duration("retain-strokes", 0, "how long attempts keep their strokes, after which only their scores and results are kept, e.g. 2160h for 90 days; for ever when 0")
+	retainImages := flag.Duration("retain-images", 0, "how long saved result images and thumbnails are kept; for ever when 0")
+	smtpAddr := flag.String("smtp-addr", os.Getenv("TRADRA_SMTP_ADDR"), "SMTP server as host:port to email goal reminders and weekly digests through, defaulting to $TRADRA_SMTP_ADDR; no email when empty")
+	smtpFrom := flag.String("smtp-from", os.Getenv("TRADRA_SMTP_FROM"), "address goal reminders and digests are sent from, defaulting to $TRADRA_SMTP_FROM")
+	smtpUsername := flag.String("smtp-username", os.Getenv("TRADRA_SMTP_USERNAME"), "user to sign in to the SMTP server as, if it needs one, defaulting to $TRADRA_SMTP_USERNAME")
+	smtpPassword := flag.String("smtp-password", os.Getenv("TRADRA_SMTP_PASSWORD"), "password for -smtp-username, defaulting to $TRADRA_SMTP_PASSWORD")
+	s3Endpoint := flag.String("s3-endpoint", envOr("TRADRA_S3_ENDPOINT", "https://s3.amazonaws.com"), "URL of the S3-compatible object storage, defaulting to $TRADRA_S3_ENDPOINT or AWS")
+	s3Region := flag.String("s3-region", envOr("TRADRA_S3_REGION", "us-east-1"), "region of the -s3-bucket, defaulting to $TRADRA_S3_REGION")
+	s3Bucket := flag.String("s3-bucket", os.Getenv("TRADRA_S3_BUCKET"), "bucket to keep saved images and thumbnails in instead of the results directory, defaulting to $TRADRA_S3_BUCKET")
+	s3Prefix := flag.String("s3-prefix", os.Getenv("TRADRA_S3_PREFIX"), "prefix of the keys of the images in -s3-bucket, such as tradra/, defaulting to $TRADRA_S3_PREFIX")
+	s3AccessKey := flag.String("s3-access-key", os.Getenv("TRADRA_S3_ACCESS_KEY"), "access key ID for -s3-bucket, defaulting to $TRADRA_S3_ACCESS_KEY")
+	s3SecretKey := flag.String("s3-secret-key", os.Getenv("TRADRA_S3_SECRET_KEY"), "secret access key for -s3-bucket, defaulting to $TRADRA_S3_SECRET_KEY")
+	s3PathStyle := flag.Bool("s3-path-style", os.Getenv("TRADRA_S3_PATH_STYLE") == "true", "address -s3-bucket in the URL path rather than the host name, as MinIO and some other stores want")
+	s3URLExpiry := flag.Duration("s3-url-expiry", time.Hour, "how long the presigned links images are served through work")
+	logFormat := flag.String("log-format", envOr("LOG_FORMAT", LogFormatText), "log output format, text or json, defaulting to $LOG_FORMAT")
+	logLevel := flag.String("log-level", envOr("LOG_LEVEL", "info"), "least severe level logged: debug, info, warn or error, defaulting to $LOG_LEVEL")
+	corsOrigins := flag.String("cors-origins", os.Getenv("CORS_ORIGINS"), "comma-separated origins allowed to call the API from a browser, or * for any, defaulting to $CORS_ORIGINS")
+	corsMethods := flag.String("cors-methods", "GET, POST", "comma-separated methods allowed for cross-origin requests")
+	corsHeaders := flag.String("cors-headers", "Content-Type", "comma-separated request headers allowed for cross-origin requests")
+	flag.Parse()
+
+	cfg := config{
+		addr: *addr, tlsCert: *tlsCert, tlsKey: *tlsKey, autocertDir: *autocertDir,
+		grpcAddr: *grpcAddr, drainTimeout: *drainTimeout, maxBodyBytes: *maxBodyBytes, jobWorkers: *jobWorkers,
+		staticDir: *staticDir, database: *database, restore: *restore, webhookSecret: *webhookSecret, webhookAllowPrivate: *webhookAllowPrivate,
+		rateLimit: *rateLimit, rateBurst: *rateBurst,
+		requireAPIKey: *requireAPIKey, adminToken: *adminToken, debug: *debug, maintenance: *maintenance,
+		loginProvider: *loginProvider, loginClientID: *loginClientID, loginClientSecret: *loginClientSecret,
+		publicURL: *publicURL, sessionSecret: *sessionSecret, deletionGrace: *deletionGrace,
+		retainStrokes: *retainStrokes, retainImages: *retainImages,
+		smtpAddr: *smtpAddr, smtpFrom: *smtpFrom, smtpUsername: *smtpUsername, smtpPassword: *smtpPassword,
+		s3Endpoint: *s3Endpoint, s3Region: *s3Region, s3Bucket: *s3Bucket, s3Prefix: *s3Prefix,
+		s3AccessKey: *s3AccessKey, s3SecretKey: *s3SecretKey, s3PathStyle: *s3PathStyle, s3URLExpiry: *s3URLExpiry,
+		readTimeout: *readTimeout, writeTimeout: *writeTimeout, handlerTimeout: *handlerTimeout, idleTimeout: *idleTimeout,
+	}
+	if cfg.addr == "" {
+		cfg.addr = net.JoinHostPort(os.Getenv("HOST"), *port)
+	}
+	cfg.autocert = splitList(*autocert)
+	cfg.corsOrigins = splitList(*corsOrigins)
+	cfg.corsMethods = splitList(*corsMethods)
+	cfg.corsHeaders = splitList(*corsHeaders)
+
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return cfg, errors.New("-tls-cert and -tls-key must be given together")
+	}
+	switch *logFormat {
+	case LogFormatText, LogFormatJSON:
+		cfg.logFormat = *logFormat
+	default:
+		return cfg, fmt.Errorf("unknown log format %q", *logFormat)
+	}
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.logLevel = level
+	if cfg.basePath, err = parseBasePath(*basePath); err != nil {
+		return cfg, err
+	}
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return cfg, fmt.Errorf("-socket-mode must be octal permissions such as 0660, not %q", *socketMode)
+	}
+	cfg.socketMode = os.FileMode(mode)
+	if *trustForwardedFor {
+		return cfg, errors.New("-trust-forwarded-for believed any client's X-Forwarded-For; list your proxies in -trusted-proxies instead")
+	}
+	if cfg.trustedProxies, err = parseTrustedProxies(splitList(*trustedProxies)); err != nil {
+		return cfg, fmt.Errorf("-trusted-proxies: %w", err)
+	}
+	if cfg.allowIPs, err = parseIPList(splitList(*allowIPs)); err != nil {
+		return cfg, fmt.Errorf("-allow-ips: %w", err)
+	}
+	if cfg.denyIPs, err = parseIPList(splitList(*denyIPs)); err != nil {
+		return cfg, fmt.Errorf("-deny-ips: %w", err)
+	}
+	if cfg.protocols, cfg.http3, err = parseProtocols(splitList(*protocols)); err != nil {
+		return cfg, err
+	}
+	if cfg.http3 && !cfg.tls() {
+		return cfg, errors.New("h3 needs HTTPS, from -tls-cert or -autocert")
+	}
+	if cfg.http3 && (strings.HasPrefix(cfg.addr, unixPrefix) || strings.HasPrefix(cfg.addr, systemdPrefix)) {
+		return cfg, errors.New("h3 needs a host:port -addr to listen on over UDP")
+	}
+
+	if cfg.rateLimit < 0 || (cfg.rateLimit > 0 && cfg.rateBurst < 1) {
+		return cfg, errors.New("-rate-limit can't be negative and -rate-burst must be at least 1")
+	}
+	if cfg.loginProvider != "" && (cfg.loginClientID == "" || cfg.loginClientSecret == "") {
+		return cfg, errors.New("-login-provider needs -login-client-id and -login-client-secret")
+	}
+	if cfg.deletionGrace < 0 {
+		return cfg, errors.New("-deletion-grace can't be negative")
+	}
+	if cfg.retainStrokes < 0 || cfg.retainImages < 0 {
+		return cfg, errors.New("-retain-strokes and -retain-images can't be negative")
+	}
+	if cfg.smtpAddr != "" && cfg.smtpFrom == "" {
+		return cfg, errors.New("-smtp-addr needs -smtp-from")
+	}
+	if cfg.s3Bucket != "" && (cfg.s3AccessKey == "" || cfg.s3SecretKey == "") {
+		return cfg, errors.New("-s3-bucket needs -s3-access-key and -s3-secret-key")
+	}
+	// SigV4 presigned URLs can't last longer than a week
+	if cfg.s3URLExpiry < time.Second || cfg.s3URLExpiry > 7*24*time.Hour {
+		return cfg, errors.New("-s3-url-expiry must be between 1s and 168h")
+	}
+	if cfg.debug && cfg.adminToken == "" {
+		return cfg, errors.New("-debug needs -admin-token, since only the admin may see the debug pages")
+	}
+	if cfg.jobWorkers < 1 {
+		return cfg, errors.New("-job-workers must be at least 1")
+	}
+	if cfg.maxBodyBytes <= 0 {
+		return cfg, errors.New("-max-body-bytes must be positive")
+	}
+	if cfg.writeTimeout > 0 && cfg.handlerTimeout >= cfg.writeTimeout {
+		return cfg, errors.New("-handler-timeout must be shorter than -write-timeout so timed out requests get an answer")
+	}
+	if cfg.tlsCert != "" && len(cfg.autocert) > 0 {
+		return cfg, errors.New("-autocert can't be combined with -tls-cert")
+	}
+	return cfg, nil
+}
+
+// envOr returns an environment variable, or fallback when it's unset or empty
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// splitList splits a comma-separated flag, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// tls reports whether the server serves HTTPS
+func (cfg config) tls() bool {
+	return cfg.tlsCert != "" || len(cfg.autocert) > 0
+}
+
+// displayURL is the address to show for opening the server in a browser
+func (cfg config) displayURL() string {
+	scheme := "http://"
+	if cfg.tls() {
+		scheme = "https://"
+	}
+	if len(cfg.autocert) > 0 {
+		return scheme + cfg.autocert[0] + cfg.basePath
+	}
+	if strings.HasPrefix(cfg.addr, unixPrefix) || strings.HasPrefix(cfg.addr, systemdPrefix) {
+		return cfg.addr
+	}
+	host, port, err := net.SplitHostPort(cfg.addr)
+	if err != nil {
+		return scheme + cfg.addr + cfg.basePath
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + net.JoinHostPort(host, port) + cfg.basePath
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsMaxAge is how long browsers may cache a preflight response, in seconds
+const corsMaxAge = "600"
+
+// withCORS lets pages on the configured origins call the API from the
+// browser. Preflight requests are answered here; other requests get the
+// allow headers added and go through to the handler. Requests from other
+// origins get no CORS headers, so the browser blocks them.
+func withCORS(next http.Handler, cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := corsAllowed(cfg.corsOrigins, origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(cfg.corsMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.corsHeaders, ", "))
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowed reports whether an origin is in the list, where "*" allows any
+func corsAllowed(origins []string, origin string) bool {
+	return slices.ContainsFunc(origins, func(o string) bool {
+		return o == "*" || strings.EqualFold(o, origin)
+	})
+}
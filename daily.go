@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The canvas the daily challenge's box is laid out on unless a client gives
+// its own. The box is placed in proportion to the canvas, so it's the same
+// prompt at any size.
+const (
+	dailyWidth  = 800
+	dailyHeight = 600
+)
+
+// DailyChallenge is the day's box for everyone to copy, with its board
+type DailyChallenge struct {
+	Day          string             `json:"day"` // YYYY-MM-DD in UTC
+	TrainingType TrainingType       `json:"trainingType"`
+	Target       Target             `json:"target"` // send its seed as the analysis target to enter
+	EndsAt       time.Time          `json:"endsAt"`
+	Entries      []LeaderboardEntry `json:"entries"`
+}
+
+// dailySeed is the target seed of a day's challenge, its date as YYYYMMDD
+func dailySeed(day time.Time) int64 {
+	y, m, d := day.UTC().Date()
+	return int64(y*10000 + int(m)*100 + d)
+}
+
+// dailyChallengeDay returns the day of the challenge an analysis was entered
+// in, when its target is that day's box as generated
+func dailyChallengeDay(req AnalysisRequest, now time.Time) (string, bool) {
+	t := req.Target
+	if t == nil || req.TrainingType != TwoPointPerspective || t.Seed != dailySeed(now) ||
+		t.LeftVP != nil || t.RightVP != nil || t.Corner != nil {
+		return "", false
+	}
+	return now.UTC().Format(time.DateOnly), true
+}
+
+// handleDaily returns a day's challenge, today's unless another day is asked
+// for, with the best matches with its box by users on leaderboards
+func handleDaily(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	day := today
+	if v := query.Get("day"); v != "" {
+		d, err := time.Parse(time.DateOnly, v)
+		if err != nil || d.After(today) {
+			writeError(w, fieldError(CodeInvalidValue, "day", "Expected a day such as 2024-05-01, not in the future"), http.StatusBadRequest)
+			return
+		}
+		day = d
+	}
+	req := TargetRequest{Seed: dailySeed(day), Width: dailyWidth, Height: dailyHeight}
+	for _, p := range []struct {
+		name string
+		v    *float64
+	}{{"width", &req.Width}, {"height", &req.Height}} {
+		if v := query.Get(p.name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f <= 0 {
+				writeError(w, fieldError(CodeInvalidValue, p.name, "Expected the canvas %s in pixels", p.name), http.StatusBadRequest)
+				return
+			}
+			*p.v = f
+		}
+	}
+	if ws, ok := workspaceFrom(r.Context()); ok && !ws.allows(TwoPointPerspective) {
+		httpError(w, "This workspace doesn't offer the daily challenge", http.StatusNotFound)
+		return
+	}
+
+	q := LeaderboardQuery{
+		WorkspaceID:  workspaceID(r.Context()),
+		TrainingType: TwoPointPerspective,
+		Daily:        day.Format(time.DateOnly),
+		Limit:        defaultLeaderboardLimit,
+	}
+	entries, err := store.Leaderboard(q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load daily challenge board", "err", err)
+		httpError(w, "Failed to load daily challenge", http.StatusInternalServerError)
+		return
+	}
+	rankEntries(r.Context(), q, entries, func(e LeaderboardEntry) float64 { return e.TargetScore })
+
+	writeResponse(w, r, http.StatusOK, DailyChallenge{
+		Day:          day.Format(time.DateOnly),
+		TrainingType: TwoPointPerspective,
+		Target:       generateTarget(req),
+		EndsAt:       day.AddDate(0, 0, 1),
+		Entries:      entries,
+	})
+}
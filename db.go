@@ -0,0 +1,1558 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the pgx driver
+	_ "github.com/mattn/go-sqlite3"    // registers the sqlite3 driver
+)
+
+// dbFile is the SQLite database of attempts inside the results directory
+const dbFile = "tradra.db"
+
+// postgresMigrationLock is the advisory lock held while migrating PostgreSQL,
+// so servers starting together don't both migrate
+const postgresMigrationLock = 7237241
+
+// sqlStore keeps attempts in SQLite or PostgreSQL. Queries are written with
+// ? placeholders and rewritten for PostgreSQL.
+type sqlStore struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// migration brings the schema one version forward
+type migration func(s *sqlStore, tx *sql.Tx) error
+
+// The migrations bring each database's schema up to date, in order. The
+// number applied is kept in the database, so a migration must never change
+// once released; add another instead.
+var (
+	sqliteMigrations = []migration{
+		createAttemptsTable,
+		importHistoryFiles,
+		createUsersTable,
+		addLeaderboardColumns,
+		addNotesColumns,
+		addDailyColumns,
+		createGoalsTable,
+		addDeletionColumns,
+		addGalleryColumn,
+		createCommentsTable,
+		addDigestColumns,
+		createDeviceTables,
+		rekeyDeviceLinks,
+	}
+	postgresMigrations = []migration{
+		createPostgresAttemptsTable,
+		importHistoryFiles,
+		createPostgresUsersTable,
+		addLeaderboardColumns,
+		addNotesColumns,
+		addDailyColumns,
+		createPostgresGoalsTable,
+		addDeletionColumns,
+		addGalleryColumn,
+		createCommentsTable,
+		addDigestColumns,
+		createDeviceTables,
+		rekeyDeviceLinks,
+	}
+)
+
+// openSQLite opens the database in the results directory, creating it when
+// it's missing, and applies any migrations it's behind on
+func openSQLite() (*sqlStore, error) {
+	path := filepath.Join(resultsDir, dbFile)
+	d, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	s := &sqlStore{db: d}
+	if err := s.migrate(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openPostgres connects to PostgreSQL and applies any migrations it's behind on
+func openPostgres(dsn string) (*sqlStore, error) {
+	d, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &sqlStore{db: d, postgres: true}
+	if err := s.migrate(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// name identifies the database in logs and errors
+func (s *sqlStore) name() string {
+	if s.postgres {
+		return "PostgreSQL"
+	}
+	return dbFile
+}
+
+// bind rewrites ? placeholders to PostgreSQL's $1, $2, ...
+func (s *sqlStore) bind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// migrate applies the migrations the database hasn't had yet, each in a
+// transaction so a failed one leaves it as it was
+func (s *sqlStore) migrate() error {
+	migrations := sqliteMigrations
+	if s.postgres {
+		migrations = postgresMigrations
+		if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+			return err
+		}
+	}
+	for {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		version, err := s.schemaVersion(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if version >= len(migrations) {
+			tx.Rollback()
+			if version > len(migrations) {
+				return fmt.Errorf("%s is from a newer version of the server (schema %d, this one knows %d)", s.name(), version, len(migrations))
+			}
+			return nil
+		}
+		if err := migrations[version](s, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if err := s.setSchemaVersion(tx, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		slog.Info("Migrated database", "database", s.name(), "version", version+1)
+	}
+}
+
+// schemaVersion returns how many migrations have been applied. SQLite keeps
+// it in user_version; PostgreSQL in a table, locked until the transaction ends.
+func (s *sqlStore) schemaVersion(tx *sql.Tx) (int, error) {
+	var version int
+	if !s.postgres {
+		err := tx.QueryRow(`PRAGMA user_version`).Scan(&version)
+		return version, err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return 0, err
+	}
+	err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+func (s *sqlStore) setSchemaVersion(tx *sql.Tx, version int) error {
+	var err error
+	if s.postgres {
+		_, err = tx.Exec(`INSERT INTO schema_version (version) VALUES ($1)`, version)
+	} else {
+		// PRAGMA doesn't take parameters
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	}
+	return err
+}
+
+// createAttemptsTable adds the table of attempts. Attempts recorded before
+// IDs were added have none, which UNIQUE allows more than one of.
+func createAttemptsTable(s *sqlStore, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE attempts (
+			seq INTEGER PRIMARY KEY,
+			id TEXT UNIQUE,
+			user_id TEXT NOT NULL DEFAULT '',
+			workspace_id TEXT NOT NULL DEFAULT '',
+			timestamp TEXT NOT NULL,
+			training_type TEXT NOT NULL,
+			composite_score REAL NOT NULL,
+			average_line_score REAL NOT NULL,
+			perspective_score REAL NOT NULL,
+			partial INTEGER NOT NULL DEFAULT 0,
+			assisted INTEGER NOT NULL DEFAULT 0,
+			saved_file_path TEXT NOT NULL DEFAULT '',
+			request TEXT,
+			result TEXT
+		);
+		CREATE INDEX attempts_training_type ON attempts (training_type);
+		CREATE INDEX attempts_user_id ON attempts (user_id);
+	`)
+	return err
+}
+
+// createPostgresAttemptsTable is createAttemptsTable for PostgreSQL
+func createPostgresAttemptsTable(s *sqlStore, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE attempts (
+			seq BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			id TEXT UNIQUE,
+			user_id TEXT NOT NULL DEFAULT '',
+			workspace_id TEXT NOT NULL DEFAULT '',
+			timestamp TIMESTAMPTZ NOT NULL,
+			training_type TEXT NOT NULL,
+			composite_score DOUBLE PRECISION NOT NULL,
+			average_line_score DOUBLE PRECISION NOT NULL,
+			perspective_score DOUBLE PRECISION NOT NULL,
+			partial BOOLEAN NOT NULL DEFAULT false,
+			assisted BOOLEAN NOT NULL DEFAULT false,
+			saved_file_path TEXT NOT NULL DEFAULT '',
+			request JSONB,
+			result JSONB
+		);
+		CREATE INDEX attempts_training_type ON attempts (training_type);
+		CREATE INDEX attempts_user_id ON attempts (user_id);
+	`)
+	return err
+}
+
+// createUsersTable adds the table of users, recorded as they sign in, with
+// the profile they've set
+func createUsersTable(s *sqlStore, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			email TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL DEFAULT '',
+			display_name TEXT NOT NULL DEFAULT '',
+			handedness TEXT NOT NULL DEFAULT '',
+			preferred_device TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			last_login_at TEXT NOT NULL
+		)
+	`)
+	return err
+}
+
+// createPostgresUsersTable is createUsersTable for PostgreSQL
+func createPostgresUsersTable(s *sqlStore, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			email TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL DEFAULT '',
+			display_name TEXT NOT NULL DEFAULT '',
+			handedness TEXT NOT NULL DEFAULT '',
+			preferred_device TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL,
+			last_login_at TIMESTAMPTZ NOT NULL
+		)
+	`)
+	return err
+}
+
+// addLeaderboardColumns records what leaderboards need: the difficulty an
+// attempt was scored at, a fingerprint of its strokes to spot replays, whether
+// it passed the other checks, and whether its user opted in
+func addLeaderboardColumns(s *sqlStore, tx *sql.Tx) error {
+	boolean := "INTEGER NOT NULL DEFAULT 0"
+	if s.postgres {
+		boolean = "BOOLEAN NOT NULL DEFAULT false"
+	}
+	_, err := tx.Exec(`
+		ALTER TABLE attempts ADD COLUMN difficulty TEXT NOT NULL DEFAULT '';
+		ALTER TABLE attempts ADD COLUMN fingerprint TEXT;
+		ALTER TABLE attempts ADD COLUMN ranked ` + boolean + `;
+		ALTER TABLE users ADD COLUMN leaderboard ` + boolean + `;
+		CREATE INDEX attempts_fingerprint ON attempts (fingerprint);
+	`)
+	return err
+}
+
+// addNotesColumns adds the notes and tags users keep with their attempts.
+// Tags are kept joined by commas, which they can't contain.
+func addNotesColumns(s *sqlStore, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE attempts ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+		ALTER TABLE attempts ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+	`)
+	return err
+}
+
+// addDailyColumns records which attempts were entered in a daily challenge,
+// by its day, and how closely they matched its box
+func addDailyColumns(s *sqlStore, tx *sql.Tx) error {
+	real := "REAL"
+	if s.postgres {
+		real = "DOUBLE PRECISION"
+	}
+	_, err := tx.Exec(`
+		ALTER TABLE attempts ADD COLUMN daily TEXT NOT NULL DEFAULT '';
+		ALTER TABLE attempts ADD COLUMN daily_score ` + real + `;
+		CREATE INDEX attempts_daily ON attempts (daily);
+	`)
+	return err
+}
+
+// createGoalsTable adds the table of users' goals, with when each was last
+// reminded of
+func createGoalsTable(s *sqlStore, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE goals (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			workspace_id TEXT NOT NULL DEFAULT '',
+			training_type TEXT NOT NULL DEFAULT '',
+			metric TEXT NOT NULL,
+			target REAL NOT NULL,
+			deadline TEXT NOT NULL,
+			remind_after_days INTEGER NOT NULL,
+			webhook_url TEXT NOT NULL DEFAULT '',
+			email INTEGER NOT NULL DEFAULT 0,
+			created_at TEXT NOT NULL,
+			last_reminded_at TEXT
+		);
+		CREATE INDEX goals_user ON goals (user_id);
+	`)
+	return err
+}
+
+// createPostgresGoalsTable is createGoalsTable for PostgreSQL
+func createPostgresGoalsTable(s *sqlStore, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE goals (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			workspace_id TEXT NOT NULL DEFAULT '',
+			training_type TEXT NOT NULL DEFAULT '',
+			metric TEXT NOT NULL,
+			target DOUBLE PRECISION NOT NULL,
+			deadline TEXT NOT NULL,
+			remind_after_days INTEGER NOT NULL,
+			webhook_url TEXT NOT NULL DEFAULT '',
+			email BOOLEAN NOT NULL DEFAULT false,
+			created_at TIMESTAMPTZ NOT NULL,
+			last_reminded_at TIMESTAMPTZ
+		);
+		CREATE INDEX goals_user ON goals (user_id);
+	`)
+	return err
+}
+
+// addDeletionColumns records when users asked for their account to be
+// deleted, and hides their attempts until it's purged
+func addDeletionColumns(s *sqlStore, tx *sql.Tx) error {
+	timestamp, boolean := "TEXT", "INTEGER NOT NULL DEFAULT 0"
+	if s.postgres {
+		timestamp, boolean = "TIMESTAMPTZ", "BOOLEAN NOT NULL DEFAULT false"
+	}
+	_, err := tx.Exec(`
+		ALTER TABLE users ADD COLUMN deleted_at ` + timestamp + `;
+		ALTER TABLE attempts ADD COLUMN deleted ` + boolean + `;
+	`)
+	return err
+}
+
+// addGalleryColumn records whether users let their attempts be shown,
+// without their name, in the public gallery
+func addGalleryColumn(s *sqlStore, tx *sql.Tx) error {
+	boolean := "INTEGER NOT NULL DEFAULT 0"
+	if s.postgres {
+		boolean = "BOOLEAN NOT NULL DEFAULT false"
+	}
+	_, err := tx.Exec(`ALTER TABLE users ADD COLUMN gallery ` + boolean)
+	return err
+}
+
+// createCommentsTable adds the table of comments on attempts, the reports
+// users make of them, and whether an attempt's user closed its comments
+func createCommentsTable(s *sqlStore, tx *sql.Tx) error {
+	real, timestamp, boolean := "REAL", "TEXT", "INTEGER NOT NULL DEFAULT 0"
+	if s.postgres {
+		real, timestamp, boolean = "DOUBLE PRECISION", "TIMESTAMPTZ", "BOOLEAN NOT NULL DEFAULT false"
+	}
+	_, err := tx.Exec(`
+		CREATE TABLE comments (
+			id TEXT PRIMARY KEY,
+			attempt_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			body TEXT NOT NULL,
+			stroke INTEGER,
+			x ` + real + `,
+			y ` + real + `,
+			created_at ` + timestamp + ` NOT NULL
+		);
+		CREATE INDEX comments_attempt ON comments (attempt_id);
+		CREATE INDEX comments_user ON comments (user_id);
+		CREATE TABLE comment_reports (
+			comment_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (comment_id, user_id)
+		);
+		ALTER TABLE attempts ADD COLUMN comments_closed ` + boolean + `;
+	`)
+	return err
+}
+
+// addDigestColumns records whether users want weekly digests emailed, and
+// the last week they were sent one for
+func addDigestColumns(s *sqlStore, tx *sql.Tx) error {
+	boolean := "INTEGER NOT NULL DEFAULT 0"
+	if s.postgres {
+		boolean = "BOOLEAN NOT NULL DEFAULT false"
+	}
+	_, err := tx.Exec(`
+		ALTER TABLE users ADD COLUMN weekly_digest ` + boolean + `;
+		ALTER TABLE users ADD COLUMN digest_week TEXT;
+	`)
+	return err
+}
+
+// createDeviceTables adds the codes devices are linked to accounts with, and
+// the drafts and settings users sync between their devices
+func createDeviceTables(s *sqlStore, tx *sql.Tx) error {
+	timestamp := "TEXT"
+	if s.postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := tx.Exec(`
+		CREATE TABLE device_links (
+			code_hash TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			expires_at ` + timestamp + ` NOT NULL
+		);
+		CREATE INDEX device_links_user ON device_links (user_id);
+		CREATE TABLE sync_items (
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			data TEXT NOT NULL,
+			revision INTEGER NOT NULL,
+			updated_at ` + timestamp + ` NOT NULL,
+			PRIMARY KEY (user_id, name)
+		);
+	`)
+	return err
+}
+
+// rekeyDeviceLinks looks link codes up by their first half, so failed claims
+// can be counted against the code they were meant for. Codes last minutes,
+// so those outstanding are dropped rather than kept.
+func rekeyDeviceLinks(s *sqlStore, tx *sql.Tx) error {
+	timestamp := "TEXT"
+	if s.postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := tx.Exec(`
+		DROP TABLE device_links;
+		CREATE TABLE device_links (
+			code_id TEXT PRIMARY KEY,
+			code_hash TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			expires_at ` + timestamp + ` NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX device_links_user ON device_links (user_id);
+	`)
+	return err
+}
+
+// importHistoryFiles copies the attempts from history.jsonl and the attempts
+// directory, where they were kept before the database, leaving the files be
+func importHistoryFiles(s *sqlStore, tx *sql.Tx) error {
+	f, err := os.Open(filepath.Join(resultsDir, historyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	imported, seen := 0, map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var a AttemptSummary
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			continue // Skip corrupt lines like the history log did
+		}
+		if a.ID != "" && seen[a.ID] {
+			continue
+		}
+		seen[a.ID] = true
+		var request []byte
+		if validAttemptID(a.ID) {
+			request, err = os.ReadFile(filepath.Join(resultsDir, attemptsDir, a.ID+".json"))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		// The columns are spelled out, since later migrations add more
+		_, err := tx.Exec(s.bind(`INSERT INTO attempts (id, user_id, workspace_id, timestamp, training_type,
+			composite_score, average_line_score, perspective_score, partial, assisted, saved_file_path, request)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			sql.NullString{String: a.ID, Valid: a.ID != ""}, a.UserID, a.WorkspaceID,
+			s.timeArg(a.Timestamp), a.TrainingType, a.CompositeScore,
+			a.AverageLineScore, a.PerspectiveScore, a.Partial, a.Assisted, a.SavedFilePath, nullJSON(request))
+		if err != nil {
+			return err
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	slog.Info("Imported attempts into the database", "from", historyFile, "attempts", imported)
+	return nil
+}
+
+// summaryColumns are the columns scanned by scanSummary, in order
+const summaryColumns = `id, user_id, workspace_id, timestamp, training_type, difficulty, composite_score,
+	average_line_score, perspective_score, partial, assisted, saved_file_path, notes, tags`
+
+// storedColumns are summaryColumns followed by what else was kept, as
+// scanned by scanStored
+const storedColumns = summaryColumns + `, request IS NOT NULL, result IS NOT NULL`
+
+// scanSummary reads a row of summaryColumns, followed by the columns extra
+// is scanned into
+func scanSummary(row interface{ Scan(...any) error }, extra ...any) (AttemptSummary, error) {
+	var a AttemptSummary
+	var id sql.NullString
+	var timestamp any
+	var tags string
+	dest := append([]any{&id, &a.UserID, &a.WorkspaceID, &timestamp, &a.TrainingType, &a.Difficulty, &a.CompositeScore,
+		&a.AverageLineScore, &a.PerspectiveScore, &a.Partial, &a.Assisted, &a.SavedFilePath, &a.Notes, &tags}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return a, err
+	}
+	a.ID = id.String
+	if tags != "" {
+		a.Tags = strings.Split(tags, ",")
+	}
+	var err error
+	a.Timestamp, err = scanTime(timestamp)
+	return a, err
+}
+
+// scanTime reads a time scanned into an any. SQLite keeps times as text,
+// PostgreSQL as timestamps.
+func scanTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, t)
+	case []byte:
+		return time.Parse(time.RFC3339Nano, string(t))
+	}
+	return time.Time{}, fmt.Errorf("unexpected time %T", v)
+}
+
+// scanStored reads a row of storedColumns
+func scanStored(row interface{ Scan(...any) error }) (StoredAttempt, error) {
+	var a StoredAttempt
+	var err error
+	a.AttemptSummary, err = scanSummary(row, &a.HasRequest, &a.HasResult)
+	return a, err
+}
+
+// timeColumn and timeParam compare times in SQL. SQLite's are text that may
+// carry different zones, so they're compared as Julian days.
+func (s *sqlStore) timeColumn() string {
+	return s.timeColumnOf("timestamp")
+}
+
+// timeColumnOf is timeColumn for another column
+func (s *sqlStore) timeColumnOf(column string) string {
+	if s.postgres {
+		return column
+	}
+	return "julianday(" + column + ")"
+}
+
+func (s *sqlStore) timeParam() string {
+	if s.postgres {
+		return "?"
+	}
+	return "julianday(?)"
+}
+
+// timeArg is a time as the database keeps it
+func (s *sqlStore) timeArg(t time.Time) any {
+	if s.postgres {
+		return t
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// nullJSON stores missing JSON as NULL rather than an empty string
+func nullJSON(data []byte) sql.NullString {
+	return sql.NullString{String: string(data), Valid: len(data) > 0}
+}
+
+func (s *sqlStore) AddAttempt(a AttemptSummary, request, result []byte) error {
+	_, err := s.db.Exec(s.bind(`INSERT INTO attempts (`+summaryColumns+`, fingerprint, ranked, daily, daily_score, request, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		sql.NullString{String: a.ID, Valid: a.ID != ""}, a.UserID, a.WorkspaceID,
+		s.timeArg(a.Timestamp), a.TrainingType, a.Difficulty, a.CompositeScore,
+		a.AverageLineScore, a.PerspectiveScore, a.Partial, a.Assisted, a.SavedFilePath,
+		a.Notes, strings.Join(a.Tags, ","),
+		sql.NullString{String: a.Fingerprint, Valid: a.Fingerprint != ""}, a.Ranked,
+		a.Daily, sql.NullFloat64{Float64: a.DailyScore, Valid: a.Daily != ""},
+		nullJSON(request), nullJSON(result))
+	return err
+}
+
+func (s *sqlStore) ImportAttempt(a AttemptSummary, request, result []byte) (bool, error) {
+	var n int
+	err := s.db.QueryRow(s.bind(`SELECT COUNT(*) FROM attempts WHERE id = ?
+		OR (user_id = ? AND workspace_id = ? AND training_type = ? AND `+s.timeColumn()+` = `+s.timeParam()+`)`),
+		a.ID, a.UserID, a.WorkspaceID, a.TrainingType, s.timeArg(a.Timestamp)).Scan(&n)
+	if err != nil || n > 0 {
+		return false, err
+	}
+	return true, s.AddAttempt(a, request, result)
+}
+
+func (s *sqlStore) SetAttemptNotes(id, notes string, tags []string) error {
+	res, err := s.db.Exec(s.bind(`UPDATE attempts SET notes = ?, tags = ? WHERE id = ?`), notes, strings.Join(tags, ","), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return errAttemptNotFound
+	}
+	return err
+}
+
+func (s *sqlStore) History(trainingType TrainingType) ([]AttemptSummary, error) {
+	rows, err := s.db.Query(s.bind(`SELECT `+summaryColumns+` FROM attempts
+		WHERE NOT deleted AND (? = '' OR training_type = ?) ORDER BY seq`), trainingType, trainingType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []AttemptSummary
+	for rows.Next() {
+		a, err := scanSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+func (s *sqlStore) Attempt(id string) (StoredAttempt, error) {
+	a, err := scanStored(s.db.QueryRow(s.bind(`SELECT `+storedColumns+` FROM attempts WHERE id = ? AND NOT deleted`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, errAttemptNotFound
+	}
+	return a, err
+}
+
+// attemptSortColumns are the columns attempts can be sorted by, by the name
+// of their JSON field, with the time added by sortColumn
+var attemptSortColumns = map[string]string{
+	"compositeScore":   "composite_score",
+	"averageLineScore": "average_line_score",
+	"perspectiveScore": "perspective_score",
+}
+
+// sortColumn is the column to sort by for a name in attemptSorts
+func (s *sqlStore) sortColumn(sort string) string {
+	if c, ok := attemptSortColumns[sort]; ok {
+		return c
+	}
+	return s.timeColumn()
+}
+
+// where is the condition for the attempts a filter picks, and its arguments
+func (s *sqlStore) where(f AttemptFilter) (string, []any) {
+	where := []string{"NOT deleted"}
+	var args []any
+	if !f.AnyWorkspace {
+		where = append(where, "workspace_id = ?")
+		args = append(args, f.WorkspaceID)
+	}
+	if !f.AllUsers {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.TrainingType != "" {
+		where = append(where, "training_type = ?")
+		args = append(args, f.TrainingType)
+	}
+	// Tags are checked for commas and LIKE's wildcards when they're parsed
+	for _, tag := range f.Tags {
+		where = append(where, "',' || tags || ',' LIKE ?")
+		args = append(args, "%,"+tag+",%")
+	}
+	if !f.From.IsZero() {
+		where = append(where, s.timeColumn()+" >= "+s.timeParam())
+		args = append(args, s.timeArg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, s.timeColumn()+" < "+s.timeParam())
+		args = append(args, s.timeArg(f.To))
+	}
+	return strings.Join(where, " AND "), args
+}
+
+func (s *sqlStore) ListAttempts(f AttemptFilter) ([]StoredAttempt, int, error) {
+	cond, args := s.where(f)
+
+	var total int
+	if err := s.db.QueryRow(s.bind(`SELECT COUNT(*) FROM attempts WHERE `+cond), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// seq breaks ties, so pages don't overlap
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+	rows, err := s.db.Query(s.bind(`SELECT `+storedColumns+` FROM attempts WHERE `+cond+
+		` ORDER BY `+s.sortColumn(f.Sort)+` `+order+`, seq `+order+` LIMIT ? OFFSET ?`),
+		append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var attempts []StoredAttempt
+	for rows.Next() {
+		a, err := scanStored(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, total, rows.Err()
+}
+
+func (s *sqlStore) ExportAttempts(f AttemptFilter, fn func(a AttemptSummary, request, result []byte) error) error {
+	cond, args := s.where(f)
+	rows, err := s.db.Query(s.bind(`SELECT `+summaryColumns+`, request, result FROM attempts
+		WHERE `+cond+` ORDER BY seq`), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var request, result sql.NullString
+		a, err := scanSummary(rows, &request, &result)
+		if err != nil {
+			return err
+		}
+		if err := fn(a, []byte(request.String), []byte(result.String)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// bucketStart is the day, as YYYY-MM-DD in UTC, that starts an attempt's
+// bucket; weeks start on Monday
+func (s *sqlStore) bucketStart(bucket string) string {
+	if s.postgres {
+		return `to_char(date_trunc('` + bucket + `', timestamp AT TIME ZONE 'UTC'), 'YYYY-MM-DD')`
+	}
+	switch bucket {
+	case "week":
+		return `date(timestamp, '-6 days', 'weekday 1')`
+	case "month":
+		return `date(timestamp, 'start of month')`
+	}
+	return `date(timestamp)`
+}
+
+func (s *sqlStore) ProgressChart(f AttemptFilter, bucket string) ([]ChartPoint, error) {
+	cond, args := s.where(f)
+	start := s.bucketStart(bucket)
+	rows, err := s.db.Query(s.bind(`
+		SELECT `+start+`, training_type, COUNT(*), AVG(composite_score), AVG(average_line_score),
+			MAX(composite_score), MAX(perspective_score)
+		FROM attempts WHERE `+cond+` AND NOT partial
+		GROUP BY 1, 2 ORDER BY 1, 2`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []ChartPoint{}
+	for rows.Next() {
+		var p ChartPoint
+		err := rows.Scan(&p.Start, &p.TrainingType, &p.Attempts, &p.AverageCompositeScore,
+			&p.AverageLineScore, &p.BestCompositeScore, &p.BestPerspectiveScore)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (s *sqlStore) AttemptRequest(id string) ([]byte, error) {
+	return s.attemptJSON("request", id)
+}
+
+func (s *sqlStore) AttemptResult(id string) ([]byte, error) {
+	return s.attemptJSON("result", id)
+}
+
+// attemptJSON reads the request or result column of an attempt
+func (s *sqlStore) attemptJSON(column, id string) ([]byte, error) {
+	var data sql.NullString
+	err := s.db.QueryRow(s.bind(`SELECT `+column+` FROM attempts WHERE id = ? AND NOT deleted`), id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !data.Valid) {
+		return nil, errAttemptNotFound
+	}
+	return []byte(data.String), err
+}
+
+func (s *sqlStore) SaveUser(u User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.timeArg(time.Now())
+	_, err = tx.Exec(s.bind(`INSERT INTO users (id, name, email, provider, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, email = excluded.email,
+			provider = excluded.provider, last_login_at = excluded.last_login_at, deleted_at = NULL`),
+		u.ID, u.Name, u.Email, u.Provider, now, now)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.bind(`UPDATE attempts SET deleted = ? WHERE user_id = ? AND deleted`), false, u.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Profile(userID string) (Profile, error) {
+	var p Profile
+	var createdAt any
+	err := s.db.QueryRow(s.bind(`SELECT id, name, email, provider, display_name, handedness, preferred_device,
+		leaderboard, gallery, weekly_digest, created_at FROM users WHERE id = ?`), userID).Scan(&p.ID, &p.Name, &p.Email, &p.Provider,
+		&p.DisplayName, &p.Handedness, &p.PreferredDevice, &p.Leaderboard, &p.Gallery, &p.WeeklyDigest, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, errUserNotFound
+	}
+	if err != nil {
+		return p, err
+	}
+	p.CreatedAt, err = scanTime(createdAt)
+	return p, err
+}
+
+func (s *sqlStore) SetProfile(userID string, settings ProfileSettings) error {
+	res, err := s.db.Exec(s.bind(`UPDATE users SET display_name = ?, handedness = ?, preferred_device = ?,
+		leaderboard = ?, gallery = ?, weekly_digest = ? WHERE id = ?`),
+		settings.DisplayName, settings.Handedness, settings.PreferredDevice, settings.Leaderboard, settings.Gallery,
+		settings.WeeklyDigest, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteUser(userID string, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.bind(`UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`), s.timeArg(now), userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errUserNotFound
+	}
+	if _, err := tx.Exec(s.bind(`UPDATE attempts SET deleted = ? WHERE user_id = ?`), true, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) DeletedUsers(before time.Time) ([]string, error) {
+	rows, err := s.db.Query(s.bind(`SELECT id FROM users WHERE deleted_at IS NOT NULL
+		AND `+s.timeColumnOf("deleted_at")+` < `+s.timeParam()), s.timeArg(before))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqlStore) PurgeUser(userID string, removeFiles func(AttemptSummary) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Deleting the user first means one who signed in again is left alone
+	res, err := tx.Exec(s.bind(`DELETE FROM users WHERE id = ? AND deleted_at IS NOT NULL`), userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errUserNotFound
+	}
+
+	rows, err := tx.Query(s.bind(`SELECT `+summaryColumns+` FROM attempts WHERE user_id = ?`), userID)
+	if err != nil {
+		return err
+	}
+	var attempts []AttemptSummary
+	for rows.Next() {
+		a, err := scanSummary(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		attempts = append(attempts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, a := range attempts {
+		if err := removeFiles(a); err != nil {
+			return err
+		}
+	}
+
+	// Comments on their attempts go with them, and their reports with
+	// their comments
+	_, err = tx.Exec(s.bind(`DELETE FROM comment_reports WHERE user_id = ? OR comment_id IN (SELECT c.id FROM comments c
+		WHERE c.user_id = ? OR c.attempt_id IN (SELECT id FROM attempts WHERE user_id = ?))`), userID, userID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.bind(`DELETE FROM comments WHERE user_id = ? OR attempt_id IN (SELECT id FROM attempts WHERE user_id = ?)`),
+		userID, userID)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"attempts", "goals", "device_links", "sync_items"} {
+		if _, err := tx.Exec(s.bind(`DELETE FROM `+table+` WHERE user_id = ?`), userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) PracticeDays(userID string) ([]PracticeDay, error) {
+	rows, err := s.db.Query(s.bind(`SELECT `+s.bucketStart("day")+`, COUNT(*) FROM attempts
+		WHERE user_id = ? AND NOT deleted GROUP BY 1 ORDER BY 1`), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []PracticeDay
+	for rows.Next() {
+		var d PracticeDay
+		if err := rows.Scan(&d.Day, &d.Attempts); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+func (s *sqlStore) Leaderboard(q LeaderboardQuery) ([]LeaderboardEntry, error) {
+	// Each opted-in user's best ranked attempt, leaving out repeats of
+	// strokes seen before. Daily challenges rank by the match with their box,
+	// at any difficulty.
+	where := `a.workspace_id = ? AND a.training_type = ? AND a.ranked AND NOT a.deleted
+		AND a.request IS NOT NULL AND a.user_id IN (SELECT id FROM users WHERE leaderboard)
+		AND NOT EXISTS (SELECT 1 FROM attempts b WHERE b.fingerprint = a.fingerprint AND b.seq < a.seq)`
+	args := []any{q.WorkspaceID, q.TrainingType}
+	score := "a.composite_score"
+	if q.Daily != "" {
+		where += ` AND a.daily = ?`
+		args = append(args, q.Daily)
+		score = "a.daily_score"
+	} else {
+		where += ` AND a.difficulty = ?`
+		args = append(args, q.Difficulty)
+	}
+	if !q.Since.IsZero() {
+		where += ` AND ` + s.timeColumn() + ` >= ` + s.timeParam()
+		args = append(args, s.timeArg(q.Since))
+	}
+	rows, err := s.db.Query(s.bind(`
+		SELECT best.id, best.user_id, COALESCE(NULLIF(u.display_name, ''), u.name), best.composite_score,
+			best.score, best.timestamp
+		FROM (
+			SELECT a.id, a.user_id, a.composite_score, a.timestamp, a.seq, `+score+` AS score,
+				ROW_NUMBER() OVER (PARTITION BY a.user_id ORDER BY `+score+` DESC, a.seq) AS n
+			FROM attempts a WHERE `+where+`
+		) best JOIN users u ON u.id = best.user_id
+		WHERE best.n = 1
+		ORDER BY best.score DESC, best.seq LIMIT ?`), append(args, q.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LeaderboardEntry{}
+	for rows.Next() {
+		var e LeaderboardEntry
+		var timestamp any
+		var score float64
+		if err := rows.Scan(&e.AttemptID, &e.UserID, &e.Name, &e.CompositeScore, &score, &timestamp); err != nil {
+			return nil, err
+		}
+		if q.Daily != "" {
+			e.TargetScore = score
+		}
+		if e.Timestamp, err = scanTime(timestamp); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) Gallery(q GalleryQuery) ([]GalleryEntry, error) {
+	// Attempts outside workspaces pass the same checks as on leaderboards
+	where := `a.workspace_id = '' AND a.ranked AND NOT a.deleted AND a.request IS NOT NULL
+		AND a.composite_score >= ? AND a.user_id IN (SELECT id FROM users WHERE gallery)
+		AND NOT EXISTS (SELECT 1 FROM attempts b WHERE b.fingerprint = a.fingerprint AND b.seq < a.seq)`
+	args := []any{q.MinScore}
+	if q.TrainingType != "" {
+		where += ` AND a.training_type = ?`
+		args = append(args, q.TrainingType)
+	}
+	rows, err := s.db.Query(s.bind(`SELECT a.id, a.training_type, a.difficulty, a.composite_score,
+		a.average_line_score, a.perspective_score, a.timestamp
+		FROM attempts a WHERE `+where+` ORDER BY a.seq DESC LIMIT ?`), append(args, q.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []GalleryEntry{}
+	for rows.Next() {
+		var e GalleryEntry
+		var timestamp any
+		if err := rows.Scan(&e.AttemptID, &e.TrainingType, &e.Difficulty, &e.CompositeScore,
+			&e.AverageLineScore, &e.PerspectiveScore, &timestamp); err != nil {
+			return nil, err
+		}
+		t, err := scanTime(timestamp)
+		if err != nil {
+			return nil, err
+		}
+		e.Day = t.UTC().Format(time.DateOnly)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// goalColumns are the columns scanned by scanGoal, in order
+const goalColumns = `id, user_id, workspace_id, training_type, metric, target, deadline, remind_after_days,
+	webhook_url, email, created_at, last_reminded_at`
+
+// scanGoal reads a row of goalColumns
+func scanGoal(row interface{ Scan(...any) error }) (Goal, error) {
+	var g Goal
+	var createdAt, remindedAt any
+	err := row.Scan(&g.ID, &g.UserID, &g.WorkspaceID, &g.TrainingType, &g.Metric, &g.Target, &g.Deadline,
+		&g.RemindAfterDays, &g.WebhookURL, &g.Email, &createdAt, &remindedAt)
+	if err != nil {
+		return g, err
+	}
+	if g.CreatedAt, err = scanTime(createdAt); err != nil {
+		return g, err
+	}
+	if remindedAt != nil {
+		t, err := scanTime(remindedAt)
+		if err != nil {
+			return g, err
+		}
+		g.LastRemindedAt = &t
+	}
+	return g, nil
+}
+
+// queryGoals returns the goals a query picks
+func (s *sqlStore) queryGoals(query string, args ...any) ([]Goal, error) {
+	rows, err := s.db.Query(s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := []Goal{}
+	for rows.Next() {
+		g, err := scanGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+func (s *sqlStore) AddGoal(g Goal) error {
+	_, err := s.db.Exec(s.bind(`INSERT INTO goals (`+goalColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL)`),
+		g.ID, g.UserID, g.WorkspaceID, g.TrainingType, g.Metric, g.Target, g.Deadline,
+		g.RemindAfterDays, g.WebhookURL, g.Email, s.timeArg(g.CreatedAt))
+	return err
+}
+
+func (s *sqlStore) Goals(userID string) ([]Goal, error) {
+	return s.queryGoals(`SELECT `+goalColumns+` FROM goals WHERE user_id = ? ORDER BY created_at`, userID)
+}
+
+func (s *sqlStore) DeleteGoal(userID, id string) error {
+	res, err := s.db.Exec(s.bind(`DELETE FROM goals WHERE user_id = ? AND id = ?`), userID, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errGoalNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) ActiveGoals(today string) ([]Goal, error) {
+	return s.queryGoals(`SELECT `+goalColumns+` FROM goals WHERE deadline >= ?
+		AND user_id NOT IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)`, today)
+}
+
+func (s *sqlStore) MergeGuest(guestID, userID string) (int64, error) {
+	res, err := s.db.Exec(s.bind(`UPDATE attempts SET user_id = ? WHERE user_id = ?`), userID, guestID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) AddDeviceLink(codeID, codeHash, userID string, expires, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(s.bind(`DELETE FROM device_links WHERE user_id = ? OR `+s.timeColumnOf("expires_at")+` <= `+s.timeParam()),
+		userID, s.timeArg(now))
+	if err != nil {
+		return err
+	}
+	var taken bool
+	err = tx.QueryRow(s.bind(`SELECT EXISTS (SELECT 1 FROM device_links WHERE code_id = ?)`), codeID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errLinkTaken
+	}
+	_, err = tx.Exec(s.bind(`INSERT INTO device_links (code_id, code_hash, user_id, expires_at) VALUES (?, ?, ?, ?)`),
+		codeID, codeHash, userID, s.timeArg(expires))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) ClaimDeviceLink(codeID, codeHash string, maxFailures int, now time.Time) (string, error) {
+	var userID, hash string
+	err := s.db.QueryRow(s.bind(`SELECT user_id, code_hash FROM device_links WHERE code_id = ? AND `+
+		s.timeColumnOf("expires_at")+` > `+s.timeParam()), codeID, s.timeArg(now)).Scan(&userID, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errLinkNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(codeHash)) != 1 {
+		_, err := s.db.Exec(s.bind(`UPDATE device_links SET failures = failures + 1 WHERE code_id = ?`), codeID)
+		if err == nil {
+			_, err = s.db.Exec(s.bind(`DELETE FROM device_links WHERE code_id = ? AND failures >= ?`), codeID, maxFailures)
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errLinkNotFound
+	}
+	// Only the request that deletes the code gets to use it
+	res, err := s.db.Exec(s.bind(`DELETE FROM device_links WHERE code_id = ? AND code_hash = ?`), codeID, codeHash)
+	if err != nil {
+		return "", err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if n != 1 {
+		return "", errLinkNotFound
+	}
+	return userID, nil
+}
+
+func (s *sqlStore) SyncItem(userID, name string) (SyncItem, error) {
+	var item SyncItem
+	var data string
+	var updatedAt any
+	err := s.db.QueryRow(s.bind(`SELECT data, revision, updated_at FROM sync_items WHERE user_id = ? AND name = ?`),
+		userID, name).Scan(&data, &item.Revision, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return item, errSyncNotFound
+	}
+	if err != nil {
+		return item, err
+	}
+	item.Data = json.RawMessage(data)
+	item.UpdatedAt, err = scanTime(updatedAt)
+	return item, err
+}
+
+func (s *sqlStore) PutSyncItem(userID, name string, data []byte, revision int64, now time.Time) (SyncItem, error) {
+	var res sql.Result
+	var err error
+	if revision == 0 {
+		res, err = s.db.Exec(s.bind(`INSERT INTO sync_items (user_id, name, data, revision, updated_at) VALUES (?, ?, ?, 1, ?)
+			ON CONFLICT DO NOTHING`), userID, name, string(data), s.timeArg(now))
+	} else {
+		res, err = s.db.Exec(s.bind(`UPDATE sync_items SET data = ?, revision = revision + 1, updated_at = ?
+			WHERE user_id = ? AND name = ? AND revision = ?`), string(data), s.timeArg(now), userID, name, revision)
+	}
+	if err != nil {
+		return SyncItem{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return SyncItem{}, err
+	}
+	if n != 1 {
+		return SyncItem{}, errSyncConflict
+	}
+	return SyncItem{Data: data, Revision: revision + 1, UpdatedAt: now}, nil
+}
+
+func (s *sqlStore) DeleteSyncItem(userID, name string) error {
+	_, err := s.db.Exec(s.bind(`DELETE FROM sync_items WHERE user_id = ? AND name = ?`), userID, name)
+	return err
+}
+
+func (s *sqlStore) ClaimGoalReminder(id string, previous *time.Time, now time.Time) (bool, error) {
+	cond, args := "last_reminded_at IS NULL", []any{s.timeArg(now), id}
+	if previous != nil {
+		cond, args = "last_reminded_at = ?", append(args, s.timeArg(*previous))
+	}
+	res, err := s.db.Exec(s.bind(`UPDATE goals SET last_reminded_at = ? WHERE id = ? AND `+cond), args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlStore) DigestUsers(week string) ([]User, error) {
+	rows, err := s.db.Query(s.bind(`SELECT id, COALESCE(NULLIF(display_name, ''), name), email, provider FROM users
+		WHERE weekly_digest AND email <> '' AND deleted_at IS NULL AND (digest_week IS NULL OR digest_week < ?)
+		ORDER BY id`), week)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Provider); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStore) ClaimDigest(userID, week string) (bool, error) {
+	res, err := s.db.Exec(s.bind(`UPDATE users SET digest_week = ?
+		WHERE id = ? AND (digest_week IS NULL OR digest_week < ?)`), week, userID, week)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// commentColumns are the columns scanned by scanComment, in order, from
+// comments c joined with the users u who wrote them
+const commentColumns = `c.id, c.attempt_id, c.user_id, COALESCE(NULLIF(u.display_name, ''), u.name), c.body,
+	c.stroke, c.x, c.y, c.created_at, (SELECT COUNT(*) FROM comment_reports r WHERE r.comment_id = c.id)`
+
+// scanComment reads a row of commentColumns
+func scanComment(row interface{ Scan(...any) error }) (Comment, error) {
+	var c Comment
+	var stroke sql.NullInt64
+	var x, y sql.NullFloat64
+	var createdAt any
+	err := row.Scan(&c.ID, &c.AttemptID, &c.UserID, &c.Author, &c.Body, &stroke, &x, &y, &createdAt, &c.Reports)
+	if err != nil {
+		return c, err
+	}
+	if stroke.Valid {
+		i := int(stroke.Int64)
+		c.Stroke = &i
+	}
+	if x.Valid && y.Valid {
+		c.Point = &Point{X: x.Float64, Y: y.Float64}
+	}
+	c.CreatedAt, err = scanTime(createdAt)
+	return c, err
+}
+
+func (s *sqlStore) queryComments(query string, args ...any) ([]Comment, error) {
+	rows, err := s.db.Query(s.bind(`SELECT `+commentColumns+` FROM comments c JOIN users u ON u.id = c.user_id `+query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *sqlStore) AddComment(c Comment) error {
+	stroke := sql.NullInt64{Valid: c.Stroke != nil}
+	if c.Stroke != nil {
+		stroke.Int64 = int64(*c.Stroke)
+	}
+	var x, y sql.NullFloat64
+	if c.Point != nil {
+		x = sql.NullFloat64{Float64: c.Point.X, Valid: true}
+		y = sql.NullFloat64{Float64: c.Point.Y, Valid: true}
+	}
+	_, err := s.db.Exec(s.bind(`INSERT INTO comments (id, attempt_id, user_id, body, stroke, x, y, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`), c.ID, c.AttemptID, c.UserID, c.Body, stroke, x, y, s.timeArg(c.CreatedAt))
+	return err
+}
+
+func (s *sqlStore) Comments(attemptID string, hideReports int) ([]Comment, error) {
+	return s.queryComments(`WHERE c.attempt_id = ? AND u.deleted_at IS NULL
+		AND (SELECT COUNT(*) FROM comment_reports r WHERE r.comment_id = c.id) < ?
+		ORDER BY c.created_at, c.id`, attemptID, hideReports)
+}
+
+func (s *sqlStore) Comment(id string) (Comment, error) {
+	comments, err := s.queryComments(`WHERE c.id = ?`, id)
+	if err != nil {
+		return Comment{}, err
+	}
+	if len(comments) == 0 {
+		return Comment{}, errCommentNotFound
+	}
+	return comments[0], nil
+}
+
+func (s *sqlStore) UserComments(userID string) ([]Comment, error) {
+	return s.queryComments(`WHERE c.user_id = ? ORDER BY c.created_at, c.id`, userID)
+}
+
+func (s *sqlStore) ReportedComments() ([]Comment, error) {
+	return s.queryComments(`WHERE EXISTS (SELECT 1 FROM comment_reports r WHERE r.comment_id = c.id)
+		ORDER BY (SELECT COUNT(*) FROM comment_reports r WHERE r.comment_id = c.id) DESC, c.created_at`)
+}
+
+func (s *sqlStore) DeleteComment(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.bind(`DELETE FROM comments WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errCommentNotFound
+	}
+	if _, err := tx.Exec(s.bind(`DELETE FROM comment_reports WHERE comment_id = ?`), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) ReportComment(id, userID string) error {
+	_, err := s.db.Exec(s.bind(`INSERT INTO comment_reports (comment_id, user_id) VALUES (?, ?)
+		ON CONFLICT DO NOTHING`), id, userID)
+	return err
+}
+
+func (s *sqlStore) ClearCommentReports(id string) error {
+	_, err := s.db.Exec(s.bind(`DELETE FROM comment_reports WHERE comment_id = ?`), id)
+	return err
+}
+
+func (s *sqlStore) CommentsClosed(attemptID string) (bool, error) {
+	var closed bool
+	err := s.db.QueryRow(s.bind(`SELECT comments_closed FROM attempts WHERE id = ? AND NOT deleted`), attemptID).Scan(&closed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errAttemptNotFound
+	}
+	return closed, err
+}
+
+func (s *sqlStore) SetCommentsClosed(attemptID string, closed bool) error {
+	res, err := s.db.Exec(s.bind(`UPDATE attempts SET comments_closed = ? WHERE id = ? AND NOT deleted`), closed, attemptID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errAttemptNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) ClearAttemptRequests(before time.Time, removeFiles func(AttemptSummary) error) (int64, error) {
+	rows, err := s.db.Query(s.bind(`SELECT `+summaryColumns+` FROM attempts WHERE request IS NOT NULL
+		AND `+s.timeColumn()+` < `+s.timeParam()), s.timeArg(before))
+	if err != nil {
+		return 0, err
+	}
+	var attempts []AttemptSummary
+	for rows.Next() {
+		a, err := scanSummary(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		attempts = append(attempts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// The requests are only dropped once every file is gone, so a failure
+	// leaves them all for next time
+	for _, a := range attempts {
+		if err := removeFiles(a); err != nil {
+			return 0, err
+		}
+	}
+	res, err := s.db.Exec(s.bind(`UPDATE attempts SET request = NULL WHERE request IS NOT NULL
+		AND `+s.timeColumn()+` < `+s.timeParam()), s.timeArg(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) ClearSavedFiles(before time.Time, removeFiles func(AttemptSummary) error) (int, error) {
+	rows, err := s.db.Query(s.bind(`SELECT `+summaryColumns+` FROM attempts WHERE saved_file_path != ''
+		AND `+s.timeColumn()+` < `+s.timeParam()), s.timeArg(before))
+	if err != nil {
+		return 0, err
+	}
+	var attempts []AttemptSummary
+	for rows.Next() {
+		a, err := scanSummary(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		attempts = append(attempts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Each is forgotten once its files are gone, so a failure partway
+	// leaves the rest for next time
+	for i, a := range attempts {
+		if err := removeFiles(a); err != nil {
+			return i, err
+		}
+		if _, err := s.db.Exec(s.bind(`UPDATE attempts SET saved_file_path = '' WHERE id = ?`), a.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(attempts), nil
+}
+
+func (s *sqlStore) Backup(path string) error {
+	if s.postgres {
+		return errBackupUnsupported
+	}
+	_, err := s.db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
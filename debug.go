@@ -0,0 +1,37 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	_ "net/http/pprof" // registers the profiles under /debug/pprof/
+	"strings"
+)
+
+// debugPrefix is where net/http/pprof, expvar and gRPC's request tracing
+// register their pages on the default mux
+const debugPrefix = "/debug/"
+
+// analysisStats counts analyses and the time spent scoring and rendering
+// them, for /debug/vars
+var analysisStats = expvar.NewMap("analyses")
+
+func init() {
+	expvar.Publish("build", expvar.Func(func() any { return buildInfo() }))
+}
+
+// withDebug hides the debug pages unless -debug is given, and then serves
+// them only to the admin. Profiles and metrics reveal more about the server
+// than anyone else should see.
+func withDebug(next http.Handler, cfg config) http.Handler {
+	admin := requireAdmin(next.ServeHTTP, cfg)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !strings.HasPrefix(r.URL.Path, debugPrefix):
+			next.ServeHTTP(w, r)
+		case !cfg.debug:
+			handleNotFound(w, r)
+		default:
+			admin(w, r)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// linkCodeAlphabet leaves out letters and digits easily mistaken for
+	// each other, such as O and 0
+	linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	linkCodeLength   = 8
+	linkDuration     = 10 * time.Minute
+	// linkIDLength is how much of a code finds it, so wrong guesses at the
+	// rest count against it
+	linkIDLength = 4
+	// maxLinkFailures is how many wrong guesses a code survives
+	maxLinkFailures = 5
+	// linkQRScale is the pixels per module of link QR codes
+	linkQRScale = 6
+)
+
+var (
+	// errLinkNotFound is returned for link codes that are wrong, used or expired
+	errLinkNotFound = errors.New("Unknown or expired code")
+	// errLinkTaken is returned for new link codes that begin like one
+	// already waiting to be used
+	errLinkTaken = errors.New("Link code in use")
+)
+
+// DeviceLink is a short-lived code that signs another device in to the
+// account that made it
+type DeviceLink struct {
+	Code      string    `json:"code"`   // typed on the other device, as ABCD-EFGH
+	URL       string    `json:"url"`    // opens the site on the other device, ready to link
+	QRCode    string    `json:"qrCode"` // a PNG data URL of a QR code of URL
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// LinkRequest is what a device sends to be linked
+type LinkRequest struct {
+	Code string `json:"code"`
+}
+
+// newLinkCode returns a random code, grouped in fours to read out
+func newLinkCode() string {
+	b := make([]byte, linkCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = linkCodeAlphabet[int(b[i])%len(linkCodeAlphabet)]
+	}
+	return string(b[:4]) + "-" + string(b[4:])
+}
+
+// parseLinkCode returns the ID a code is looked up by and the hash kept of
+// all of it, taking it in any case and with or without its dash and spaces
+func parseLinkCode(code string) (id, hash string, ok bool) {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != linkCodeLength {
+		return "", "", false
+	}
+	sum := sha256.Sum256([]byte(code))
+	return code[:linkIDLength], hex.EncodeToString(sum[:]), true
+}
+
+// addDeviceLink records a new code for a user, drawing another the rare
+// times one waiting to be used begins the same way
+func addDeviceLink(userID string, expires, now time.Time) (string, error) {
+	var code string
+	err := errLinkTaken
+	for i := 0; i < 3 && errors.Is(err, errLinkTaken); i++ {
+		code = newLinkCode()
+		id, hash, _ := parseLinkCode(code)
+		err = store.AddDeviceLink(id, hash, userID, expires, now)
+	}
+	return code, err
+}
+
+// handleCreateDeviceLink makes a code for the signed-in user to link another
+// device with, replacing any they made before
+func handleCreateDeviceLink(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	now := time.Now()
+	link := DeviceLink{ExpiresAt: now.Add(linkDuration)}
+	var err error
+	link.Code, err = addDeviceLink(u.ID, link.ExpiresAt, now)
+	link.URL = login.externalURL(r) + sitePath("/") + "?link=" + link.Code
+
+	var qr *qrCode
+	if err == nil {
+		qr, err = encodeQR(link.URL)
+	}
+	var image []byte
+	if err == nil {
+		image, err = qr.png(linkQRScale)
+	}
+	if err == nil {
+		link.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(image)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create device link", "userId", u.ID, "err", err)
+		httpError(w, "Failed to create device link", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusCreated, link)
+}
+
+// handleLinkDevice signs the calling device in to the account a link code
+// was made by, taking its guest history along. Each code works once, and is
+// forgotten after maxLinkFailures wrong guesses at it.
+// Browsers can only link from the site's own pages, so other sites can't
+// sign their visitors in to an account of theirs.
+func handleLinkDevice(w http.ResponseWriter, r *http.Request) {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		httpError(w, "Devices can only be linked from this site", http.StatusForbidden)
+		return
+	}
+	var req LinkRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	id, hash, ok := parseLinkCode(req.Code)
+	userID, err := "", errLinkNotFound
+	if ok {
+		userID, err = store.ClaimDeviceLink(id, hash, maxLinkFailures, time.Now())
+	}
+	var p Profile
+	if err == nil {
+		p, err = store.Profile(userID)
+	}
+	if errors.Is(err, errLinkNotFound) || errors.Is(err, errUserNotFound) {
+		writeError(w, fieldError(CodeInvalidValue, "code", "%v", errLinkNotFound), http.StatusBadRequest)
+		return
+	}
+	// Linking a device is signing in, so it cancels a pending deletion too
+	if err == nil {
+		err = store.SaveUser(p.User)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to link device", "err", err)
+		httpError(w, "Failed to link device", http.StatusInternalServerError)
+		return
+	}
+
+	login.mergeGuest(w, r, p.User)
+	s, err := login.startSession(w, r, p.User)
+	if err != nil {
+		httpError(w, "Failed to link device", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Device linked", "userId", p.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, meResponse{User: p.User, DisplayName: p.DisplayName, CSRFToken: s.CSRF})
+}
@@ -0,0 +1,35 @@
+package main
+
+// Difficulty selects how strictly an attempt is scored
+type Difficulty string
+
+const (
+	Beginner     Difficulty = "beginner"
+	Intermediate Difficulty = "intermediate"
+	Advanced     Difficulty = "advanced"
+)
+
+// defaultDifficulty is used when a request doesn't specify one
+const defaultDifficulty = Intermediate
+
+// ScoringProfile holds the tolerances used to turn raw errors into 0-100 scores.
+// Each tolerance is the error at which a score decays to about 37.
+type ScoringProfile struct {
+	RMSEThreshold        float64 `json:"rmseThreshold"`        // pixels of deviation from the ideal line
+	ConvergenceTolerance float64 `json:"convergenceTolerance"` // convergence error as a fraction of the canvas diagonal
+	VerticalTolerance    float64 `json:"verticalTolerance"`    // average tilt of verticals in degrees
+}
+
+// defaultScoringProfiles holds the built-in difficulty levels, which the
+// admin can retune in the settings
+var defaultScoringProfiles = map[Difficulty]ScoringProfile{
+	Beginner:     {RMSEThreshold: 8.0, ConvergenceTolerance: 0.2, VerticalTolerance: 8.0},
+	Intermediate: {RMSEThreshold: 5.0, ConvergenceTolerance: 0.1, VerticalTolerance: 5.0},
+	Advanced:     {RMSEThreshold: 3.0, ConvergenceTolerance: 0.05, VerticalTolerance: 3.0},
+}
+
+// getScoringProfile returns the current profile for a difficulty level
+func getScoringProfile(difficulty Difficulty) (ScoringProfile, bool) {
+	profile, ok := currentSettings().ScoringProfiles[difficulty]
+	return profile, ok
+}
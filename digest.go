@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// digestInterval is how often users are checked for weekly digests due
+const digestInterval = time.Hour
+
+// weekSummary is what a user practiced in a week
+type weekSummary struct {
+	attempts int
+	complete int
+	days     int
+	// averages are the means of personalBestMetrics over the complete
+	// attempts, when there are any
+	averages  []float64
+	exercises []exerciseSummary
+	best      *AttemptSummary // the complete attempt with the best composite score
+}
+
+// exerciseSummary is what a user practiced of one exercise in a week
+type exerciseSummary struct {
+	trainingType TrainingType
+	attempts     int
+	complete     int
+	average      float64 // composite score over the complete attempts
+}
+
+// summarizeWeek sums up a week's attempts, oldest first
+func summarizeWeek(attempts []AttemptSummary) weekSummary {
+	s := weekSummary{attempts: len(attempts)}
+	days := map[string]bool{}
+	byType := map[TrainingType]*exerciseSummary{}
+	sums := make([]float64, len(personalBestMetrics))
+	for i, a := range attempts {
+		days[a.Timestamp.UTC().Format(time.DateOnly)] = true
+		e := byType[a.TrainingType]
+		if e == nil {
+			e = &exerciseSummary{trainingType: a.TrainingType}
+			byType[a.TrainingType] = e
+		}
+		e.attempts++
+		if a.Partial {
+			continue
+		}
+		s.complete++
+		e.complete++
+		e.average += a.CompositeScore
+		for j, m := range personalBestMetrics {
+			sums[j] += m.score(a)
+		}
+		if s.best == nil || a.CompositeScore > s.best.CompositeScore {
+			s.best = &attempts[i]
+		}
+	}
+	s.days = len(days)
+	if s.complete > 0 {
+		for _, sum := range sums {
+			s.averages = append(s.averages, sum/float64(s.complete))
+		}
+	}
+	for _, e := range byType {
+		if e.complete > 0 {
+			e.average /= float64(e.complete)
+		}
+		s.exercises = append(s.exercises, *e)
+	}
+	sort.Slice(s.exercises, func(i, j int) bool {
+		a, b := s.exercises[i], s.exercises[j]
+		if a.attempts != b.attempts {
+			return a.attempts > b.attempts
+		}
+		return a.trainingType < b.trainingType
+	})
+	return s
+}
+
+// describeWeek writes a digest's text from the summaries of a week, which
+// starts on a Monday, and the week before, with the user's streak and a link
+// to the site when its URL is known
+func describeWeek(name string, week time.Time, this, previous weekSummary, bestAttached bool, streak *Streak, siteURL string) string {
+	var b strings.Builder
+	if name != "" {
+		fmt.Fprintf(&b, "Hi %s,\n\n", name)
+	}
+	fmt.Fprintf(&b, "Here's your perspective practice for the week of %s (UTC).\n\n", week.Format("Monday 2 January 2006"))
+
+	fmt.Fprintf(&b, "You made %s on %s", plural(this.attempts, "attempt"), plural(this.days, "day"))
+	if this.complete < this.attempts {
+		fmt.Fprintf(&b, ", %d of them complete", this.complete)
+	}
+	b.WriteString(".\n")
+	for i, avg := range this.averages {
+		fmt.Fprintf(&b, "  Average %s: %.1f", personalBestMetrics[i].label, avg)
+		if len(previous.averages) > 0 {
+			switch change := avg - previous.averages[i]; {
+			case change >= 0.05:
+				fmt.Fprintf(&b, ", up %.1f on the week before", change)
+			case change <= -0.05:
+				fmt.Fprintf(&b, ", down %.1f on the week before", -change)
+			default:
+				b.WriteString(", as the week before")
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if len(this.exercises) > 1 {
+		b.WriteString("\nBy exercise:\n")
+		for _, e := range this.exercises {
+			fmt.Fprintf(&b, "  %s: %s", getExercise(e.trainingType).Name, plural(e.attempts, "attempt"))
+			if e.complete > 0 {
+				fmt.Fprintf(&b, ", averaging %.1f", e.average)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	if a := this.best; a != nil {
+		fmt.Fprintf(&b, "\nYour best was the %s on %s, scoring %.1f (%s).",
+			getExercise(a.TrainingType).Name, a.Timestamp.UTC().Format("Monday"), a.CompositeScore, letterGrade(a.CompositeScore))
+		if bestAttached {
+			b.WriteString(" Its drawing is attached.")
+		}
+		b.WriteString("\n")
+	}
+	if streak != nil && streak.Current > 1 {
+		fmt.Fprintf(&b, "\nYou're on a %d-day streak; your longest is %s.\n", streak.Current, plural(streak.Longest, "day"))
+	}
+	if siteURL != "" {
+		fmt.Fprintf(&b, "\nKeep practicing at %s\n", siteURL)
+	}
+	b.WriteString("\nYou get this because you turned on weekly digests in your profile. Turn them off there to stop them.\n")
+	return b.String()
+}
+
+// plural counts things in words, such as "1 day" or "3 days"
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return fmt.Sprintf("%d %ss", n, thing)
+}
+
+// runWeeklyDigests emails digests of the week just gone, checking every
+// digestInterval while the server runs. They link to the site when
+// -public-url says where it is.
+func runWeeklyDigests(cfg config) {
+	siteURL := ""
+	if cfg.publicURL != "" {
+		siteURL = strings.TrimSuffix(cfg.publicURL, "/") + sitePath("/")
+	}
+	for {
+		sendWeeklyDigests(context.Background(), time.Now(), siteURL)
+		time.Sleep(digestInterval)
+	}
+}
+
+// sendWeeklyDigests emails users who want them a summary of their practice
+// in the last full week, once each, and nothing when they didn't practice
+func sendWeeklyDigests(ctx context.Context, now time.Time, siteURL string) {
+	if mailer == nil {
+		return
+	}
+	week := periodStart("week", now).AddDate(0, 0, -7)
+	users, err := store.DigestUsers(week.Format(time.DateOnly))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load digest users", "err", err)
+		return
+	}
+	for _, u := range users {
+		// Another server sharing the database may have sent it already
+		claimed, err := store.ClaimDigest(u.ID, week.Format(time.DateOnly))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record weekly digest", "userId", u.ID, "err", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := emailDigest(ctx, u, week, siteURL); err != nil {
+			slog.WarnContext(ctx, "Failed to email weekly digest", "userId", u.ID, "err", err)
+		}
+	}
+}
+
+// emailDigest emails a user the digest of a week, with the drawing of their
+// best attempt when its strokes were kept
+func emailDigest(ctx context.Context, u User, week time.Time, siteURL string) error {
+	var this, previous []AttemptSummary
+	f := AttemptFilter{UserID: u.ID, AnyWorkspace: true, From: week.AddDate(0, 0, -7), To: week.AddDate(0, 0, 7)}
+	err := store.ExportAttempts(f, func(a AttemptSummary, request, result []byte) error {
+		if a.Timestamp.Before(week) {
+			previous = append(previous, a)
+		} else {
+			this = append(this, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(this) == 0 {
+		return nil
+	}
+
+	summary := summarizeWeek(this)
+	var attachments []mailAttachment
+	if summary.best != nil && summary.best.ID != "" {
+		data, err := loadThumbnail(summary.best.ID)
+		if err == nil {
+			attachments = append(attachments, mailAttachment{name: "best.png", contentType: "image/png", data: data})
+		} else if !errors.Is(err, errAttemptNotFound) {
+			slog.WarnContext(ctx, "Failed to render thumbnail", "attemptId", summary.best.ID, "err", err)
+		}
+	}
+	text := describeWeek(u.Name, week, summary, summarizeWeek(previous), len(attachments) > 0, userStreak(ctx, u.ID), siteURL)
+	if err := mailer.send(u.Email, "Your week of perspective practice", text, attachments...); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Weekly digest sent", "userId", u.ID, "week", week.Format(time.DateOnly))
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error codes. Every status has a general code; validation errors have more
+// specific ones so clients can say exactly what to fix.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRateLimited          = "rate_limited"
+	CodeInternal             = "internal"
+	CodeBadGateway           = "bad_gateway"
+	CodeUnavailable          = "unavailable"
+
+	CodeInvalidValue       = "invalid_value"        // a field has an unknown or malformed value
+	CodeOutOfRange         = "out_of_range"         // a number is too small or too large
+	CodeWrongStrokeCount   = "wrong_stroke_count"   // too few or too many strokes for the exercise
+	CodeStrokeTooShort     = "stroke_too_short"     // a stroke has fewer than 2 points
+	CodeExerciseNotAllowed = "exercise_not_allowed" // the workspace doesn't allow the exercise
+
+	CodeIdempotencyKeyReused = "idempotency_key_reused" // the key was sent before with a different request
+	CodeMaintenance          = "maintenance"            // the server is down for maintenance
+)
+
+// statusCodes are the general codes for each error status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeBodyTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusBadGateway:            CodeBadGateway,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// APIError describes what went wrong with a request. Field and StrokeIndex
+// point at the part of the request at fault, when it's one part.
+type APIError struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	Field       string `json:"field,omitempty"`       // JSON name of the request field
+	StrokeIndex *int   `json:"strokeIndex,omitempty"` // of the stroke in strokes, counting from 0
+}
+
+func (e *APIError) Error() string { return e.Message }
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// fieldError returns an error about one field of a request
+func fieldError(code, field, format string, args ...any) *APIError {
+	return &APIError{Code: code, Message: fmt.Sprintf(format, args...), Field: field}
+}
+
+// strokeError returns an error about one stroke of a request
+func strokeError(code string, index int, format string, args ...any) *APIError {
+	return &APIError{Code: code, Message: fmt.Sprintf(format, args...), Field: "strokes", StrokeIndex: &index}
+}
+
+// apiError turns any error into an APIError, with the general code for
+// status unless it already is one
+func apiError(err error, status int) *APIError {
+	var e *APIError
+	if errors.As(err, &e) {
+		// Keep any context it was wrapped in, such as which attempt it's about
+		wrapped := *e
+		wrapped.Message = err.Error()
+		return &wrapped
+	}
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInvalidRequest
+		if status >= 500 {
+			code = CodeInternal
+		}
+	}
+	return &APIError{Code: code, Message: err.Error()}
+}
+
+// httpError answers a request with an error message, like http.Error but as
+// an ErrorResponse
+func httpError(w http.ResponseWriter, message string, status int) {
+	writeError(w, errors.New(message), status)
+}
+
+// writeError answers a request with err as an ErrorResponse
+func writeError(w http.ResponseWriter, err error, status int) {
+	h := w.Header()
+	// A handler may have set it for the response it meant to send
+	h.Del("Content-Length")
+	h.Set("Content-Type", contentTypeJSON)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: apiError(err, status)})
+}
+
+// handleNotFound answers 404 for paths with nothing to serve
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	httpError(w, "Not found", http.StatusNotFound)
+}
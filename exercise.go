@@ -0,0 +1,114 @@
+package main
+
+import "math"
+
+// RubricWeights controls how much each rubric criterion contributes to the grade
+type RubricWeights struct {
+	LineQuality float64 `json:"lineQuality"`
+	Convergence float64 `json:"convergence"`
+	Proportion  float64 `json:"proportion"`
+	Composition float64 `json:"composition"`
+}
+
+// ScoreWeights controls how the component scores combine into the composite score
+type ScoreWeights struct {
+	LineQuality float64 `json:"lineQuality"`
+	Convergence float64 `json:"convergence"`
+	Proportion  float64 `json:"proportion"`
+}
+
+// TimeBudget defines the speed modifier applied to timed drills
+type TimeBudget struct {
+	Seconds    float64 `json:"seconds"`
+	MaxBonus   float64 `json:"maxBonus"`   // points added for finishing instantly
+	MaxPenalty float64 `json:"maxPenalty"` // points removed at twice the budget or slower
+}
+
+// Exercise describes a training type and how attempts at it are graded
+type Exercise struct {
+	Type            TrainingType  `json:"type"`
+	Name            string        `json:"name"`
+	ExpectedStrokes int           `json:"expectedStrokes"`
+	RubricWeights   RubricWeights `json:"rubricWeights"`
+	ScoreWeights    ScoreWeights  `json:"scoreWeights"`
+	TimeBudget      TimeBudget    `json:"timeBudget"`
+}
+
+// defaultExercises holds the built-in exercise definitions keyed by training
+// type, which the admin can retune in the settings
+var defaultExercises = map[TrainingType]Exercise{
+	OnePointPerspective: {
+		Type:            OnePointPerspective,
+		Name:            "1-Point Perspective Box",
+		ExpectedStrokes: 8, // 4 verticals, 4 converging to center
+		RubricWeights:   RubricWeights{LineQuality: 0.4, Convergence: 0.4, Proportion: 0.1, Composition: 0.1},
+		ScoreWeights:    ScoreWeights{LineQuality: 0.5, Convergence: 0.4, Proportion: 0.1},
+		TimeBudget:      TimeBudget{Seconds: 45, MaxBonus: 10, MaxPenalty: 20},
+	},
+	TwoPointPerspective: {
+		Type:            TwoPointPerspective,
+		Name:            "2-Point Perspective Cube",
+		ExpectedStrokes: 9, // 3 verticals, 3 left, 3 right
+		RubricWeights:   RubricWeights{LineQuality: 0.35, Convergence: 0.35, Proportion: 0.2, Composition: 0.1},
+		ScoreWeights:    ScoreWeights{LineQuality: 0.4, Convergence: 0.4, Proportion: 0.2},
+		TimeBudget:      TimeBudget{Seconds: 60, MaxBonus: 10, MaxPenalty: 20},
+	},
+	ThreePointPerspective: {
+		Type:            ThreePointPerspective,
+		Name:            "3-Point Perspective Cube",
+		ExpectedStrokes: 9, // 3 to each vanishing point
+		RubricWeights:   RubricWeights{LineQuality: 0.3, Convergence: 0.4, Proportion: 0.2, Composition: 0.1},
+		ScoreWeights:    ScoreWeights{LineQuality: 0.3, Convergence: 0.5, Proportion: 0.2},
+		TimeBudget:      TimeBudget{Seconds: 75, MaxBonus: 10, MaxPenalty: 20},
+	},
+}
+
+// What achievements are earned by. Each counts only complete attempts, at the
+// achievement's exercise when it names one.
+const (
+	RuleScore       = "score"       // a composite score of at least the threshold
+	RuleAttempts    = "attempts"    // the threshold's number of attempts
+	RuleStreak      = "streak"      // practice on the threshold's number of days in a row, in UTC
+	RuleConvergence = "convergence" // left, right and vertical scores all at least the threshold
+)
+
+// achievementRules are the rules an achievement can have
+var achievementRules = []string{RuleScore, RuleAttempts, RuleStreak, RuleConvergence}
+
+// withinTolerance is the convergence or vertical score of an error equal to
+// the difficulty's tolerance
+const withinTolerance = 100 / math.E
+
+// Achievement is a badge users earn by meeting its rule
+type Achievement struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	Rule         string       `json:"rule"` // one of achievementRules
+	Threshold    float64      `json:"threshold"`
+	TrainingType TrainingType `json:"trainingType,omitempty"` // only attempts at this exercise count, when set
+}
+
+// defaultAchievements are the built-in badges, which the admin can replace in
+// the settings
+var defaultAchievements = []Achievement{
+	{ID: "first-90", Name: "Sharp Eye", Description: "Score 90 or more", Rule: RuleScore, Threshold: 90},
+	{ID: "100-boxes", Name: "Hundred Boxes", Description: "Draw 100 complete boxes", Rule: RuleAttempts, Threshold: 100},
+	{ID: "7-day-streak", Name: "Week Streak", Description: "Practice 7 days in a row", Rule: RuleStreak, Threshold: 7},
+	{
+		ID: "three-vps", Name: "Three Vanishing Points", Description: "Get all three vanishing points of a 3-point cube within tolerance",
+		Rule: RuleConvergence, Threshold: withinTolerance, TrainingType: ThreePointPerspective,
+	},
+}
+
+// getExercise returns the current exercise definition for a training type,
+// falling back to 2-point perspective for unknown types
+func getExercise(trainingType TrainingType) Exercise {
+	current := currentSettings().Exercises
+	if ex, ok := current[trainingType]; ok {
+		return ex
+	}
+	ex := current[TwoPointPerspective]
+	ex.Type = trainingType
+	return ex
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportVersion is the version of the JSON export format, for importers
+const exportVersion = 1
+
+// ExportedAttempt is an attempt as exported, with everything kept of it
+type ExportedAttempt struct {
+	ID               string          `json:"id,omitempty"`
+	UserID           string          `json:"userId,omitempty"`
+	WorkspaceID      string          `json:"workspaceId,omitempty"`
+	Timestamp        time.Time       `json:"timestamp"`
+	TrainingType     TrainingType    `json:"trainingType"`
+	Difficulty       Difficulty      `json:"difficulty,omitempty"`
+	CompositeScore   float64         `json:"compositeScore"`
+	AverageLineScore float64         `json:"averageLineScore"`
+	PerspectiveScore float64         `json:"perspectiveScore"`
+	Partial          bool            `json:"partial,omitempty"`
+	Assisted         bool            `json:"assisted,omitempty"`
+	Notes            string          `json:"notes,omitempty"`
+	Tags             []string        `json:"tags,omitempty"`
+	Request          json.RawMessage `json:"request,omitempty"` // the analysis request, strokes and all
+	Result           json.RawMessage `json:"result,omitempty"`  // without its images
+}
+
+// Export is the JSON export: its header, then the attempts, oldest first
+type Export struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exportedAt"`
+	Attempts   []ExportedAttempt `json:"attempts"`
+}
+
+// exportColumns head the CSV export
+var exportColumns = []string{
+	"id", "timestamp", "trainingType", "difficulty", "compositeScore", "averageLineScore", "perspectiveScore",
+	"leftConvergenceScore", "rightConvergenceScore", "verticalScore", "convergenceScore", "proportionScore",
+	"compositionScore", "grade", "strokes", "noiseFloor", "elapsedSeconds", "timeModifier", "partial", "assisted",
+	"userId", "workspaceId", "tags", "notes",
+}
+
+// handleExportAttempts downloads the attempts the caller can see, filtered
+// like a listing, as CSV with a row of metrics per attempt or as JSON with
+// their strokes. It's written as the attempts are read, so it can be large.
+func handleExportAttempts(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAttemptRange(r.URL.Query())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	visibleAttempts(r.Context(), &f)
+
+	var export func(http.ResponseWriter, AttemptFilter) error
+	switch format := cmp.Or(r.URL.Query().Get("format"), "json"); format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="tradra-attempts.json"`)
+		export = exportJSON
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="tradra-attempts.csv"`)
+		export = exportCSV
+	default:
+		writeError(w, fieldError(CodeInvalidValue, "format", "Unknown format %q, expected csv or json", format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	// A long history may take longer than the write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// Once the first attempt is written the status can't change, so a
+	// failure only cuts the download short
+	if err := export(w, f); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to export attempts", "err", err)
+	}
+}
+
+// exportJSON writes an Export one attempt at a time
+func exportJSON(w http.ResponseWriter, f AttemptFilter) error {
+	exportedAt, err := json.Marshal(time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, `{"version":%d,"exportedAt":%s,"attempts":`, exportVersion, exportedAt); err != nil {
+		return err
+	}
+	if err := exportAttemptsJSON(w, f); err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("}\n"))
+	return err
+}
+
+// exportAttemptsJSON writes the attempts of an export as a JSON array, one
+// attempt at a time
+func exportAttemptsJSON(w io.Writer, f AttemptFilter) error {
+	if _, err := w.Write([]byte("[\n")); err != nil {
+		return err
+	}
+	first := true
+	err := store.ExportAttempts(f, func(a AttemptSummary, request, result []byte) error {
+		data, err := json.Marshal(ExportedAttempt{
+			ID: a.ID, UserID: a.UserID, WorkspaceID: a.WorkspaceID, Timestamp: a.Timestamp,
+			TrainingType: a.TrainingType, Difficulty: a.Difficulty, CompositeScore: a.CompositeScore,
+			AverageLineScore: a.AverageLineScore, PerspectiveScore: a.PerspectiveScore,
+			Partial: a.Partial, Assisted: a.Assisted, Notes: a.Notes, Tags: a.Tags,
+			Request: rawJSON(request), Result: rawJSON(result),
+		})
+		if err != nil {
+			return err
+		}
+		if !first {
+			data = append([]byte(",\n"), data...)
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("\n]"))
+	return err
+}
+
+// rawJSON leaves missing JSON out rather than writing it as null
+func rawJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+// exportCSV writes a row of exportColumns per attempt. The metrics beyond the
+// headline scores come from the kept result, so are blank without one.
+func exportCSV(w http.ResponseWriter, f AttemptFilter) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(exportColumns); err != nil {
+		return err
+	}
+	num := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	err := store.ExportAttempts(f, func(a AttemptSummary, request, result []byte) error {
+		row := []string{
+			a.ID, a.Timestamp.UTC().Format(time.RFC3339), string(a.TrainingType), string(a.Difficulty),
+			num(a.CompositeScore), num(a.AverageLineScore), num(a.PerspectiveScore),
+			"", "", "", "", "", "", "", "", "", "", "",
+			strconv.FormatBool(a.Partial), strconv.FormatBool(a.Assisted), a.UserID, a.WorkspaceID,
+			strings.Join(a.Tags, ";"), a.Notes,
+		}
+		var res AnalysisResult
+		if len(result) > 0 && json.Unmarshal(result, &res) == nil {
+			copy(row[7:18], []string{
+				num(res.LeftConvergenceScore), num(res.RightConvergenceScore), num(res.VerticalScore),
+				num(res.ConvergenceScore), num(res.ProportionScore), num(res.CompositionScore),
+				res.Grade.Letter, strconv.Itoa(len(res.LineScores)), num(res.NoiseFloor),
+				num(res.ElapsedSeconds), num(res.TimeModifier),
+			})
+		}
+		return out.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Thresholds (in pixels) above which a stroke shape is considered a problem
+const (
+	arcThreshold    = 3.0
+	hookThreshold   = 4.0
+	wobbleThreshold = 1.5
+)
+
+// misangleThreshold is the angle in degrees by which a stroke may miss its vanishing direction
+const misangleThreshold = 5.0
+
+// minStrokeLength is the shortest acceptable stroke as a fraction of the canvas diagonal
+const minStrokeLength = 0.05
+
+// Stroke tags reported per stroke
+const (
+	TagWobbly    = "wobbly"
+	TagArced     = "arced"
+	TagHooked    = "hooked"
+	TagMisangled = "misangled"
+	TagTooShort  = "too short"
+)
+
+// StrokeShape describes how a stroke deviates from its ideal line
+type StrokeShape struct {
+	Length float64 // distance between first and last point
+	Bow    float64 // signed bulge of the middle of the stroke away from the ideal line
+	Hook   float64 // largest sudden deviation at either end of the stroke
+	Wobble float64 // high-frequency jitter left after smoothing
+}
+
+// measureStrokeShape decomposes the residuals of a stroke around its ideal line.
+// Residuals are signed along the line normal, which points down for mostly
+// horizontal lines and right for mostly vertical ones.
+func measureStrokeShape(stroke Stroke, line Line) StrokeShape {
+	if len(stroke) < 2 {
+		return StrokeShape{}
+	}
+
+	shape := StrokeShape{
+		Length: math.Hypot(stroke[len(stroke)-1].X-stroke[0].X, stroke[len(stroke)-1].Y-stroke[0].Y),
+	}
+	if len(stroke) < 10 {
+		return shape
+	}
+
+	residuals := strokeResiduals(stroke, line)
+	n := len(residuals)
+
+	// Bow: middle third versus the outer thirds
+	third := n / 3
+	middle := meanOf(residuals[third : n-third])
+	outer := (meanOf(residuals[:third]) + meanOf(residuals[n-third:])) / 2
+	shape.Bow = middle - outer
+
+	// Hook: the last 10% of each end compared to the trend of the two sections
+	// next to it, so a gentle arc doesn't count as a hook
+	seg := n / 10
+	if seg < 2 {
+		seg = 2
+	}
+	startHook := meanOf(residuals[:seg]) - 2*meanOf(residuals[seg:2*seg]) + meanOf(residuals[2*seg:3*seg])
+	endHook := meanOf(residuals[n-seg:]) - 2*meanOf(residuals[n-2*seg:n-seg]) + meanOf(residuals[n-3*seg:n-2*seg])
+	shape.Hook = math.Max(math.Abs(startHook), math.Abs(endHook))
+
+	// Wobble: RMS of residuals around their moving average
+	const window = 3
+	var sumSq float64
+	for i := range residuals {
+		lo, hi := max(0, i-window), min(n, i+window+1)
+		d := residuals[i] - meanOf(residuals[lo:hi])
+		sumSq += d * d
+	}
+	shape.Wobble = math.Sqrt(sumSq / float64(n))
+
+	return shape
+}
+
+// strokeResiduals returns the signed distance of each point from the ideal line
+func strokeResiduals(stroke Stroke, line Line) []float64 {
+	var meanX, meanY float64
+	for _, p := range stroke {
+		meanX += p.X
+		meanY += p.Y
+	}
+	meanX /= float64(len(stroke))
+	meanY /= float64(len(stroke))
+
+	theta := line.Angle * math.Pi / 180.0
+	nx, ny := -math.Sin(theta), math.Cos(theta)
+	if math.Abs(line.Angle) > 45 && nx < 0 {
+		nx, ny = -nx, -ny
+	}
+
+	residuals := make([]float64, len(stroke))
+	for i, p := range stroke {
+		residuals[i] = (p.X-meanX)*nx + (p.Y-meanY)*ny
+	}
+	return residuals
+}
+
+// meanOf returns the arithmetic mean of a slice
+func meanOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// bowDirection names the direction a stroke bulges in
+func bowDirection(line Line, bow float64) string {
+	if math.Abs(line.Angle) > 45 {
+		if bow > 0 {
+			return "to the right"
+		}
+		return "to the left"
+	}
+	if bow > 0 {
+		return "downward"
+	}
+	return "upward"
+}
+
+// tagStrokes labels each stroke with the problems found in its shape and direction.
+// Strokes in a converging group are misangled when they miss the group's VP;
+// verticals are misangled when they tilt away from true vertical.
+func tagStrokes(strokes []Stroke, lines []Line, shapes []StrokeShape, verticals, leftGroup, rightGroup []int, leftVP, rightVP *Point, width, height float64) [][]string {
+	vpFor := map[int]*Point{}
+	for _, idx := range leftGroup {
+		vpFor[idx] = leftVP
+	}
+	for _, idx := range rightGroup {
+		vpFor[idx] = rightVP
+	}
+	isVertical := map[int]bool{}
+	for _, idx := range verticals {
+		isVertical[idx] = true
+	}
+
+	diagonal := math.Hypot(width, height)
+	tags := make([][]string, len(strokes))
+	for i, shape := range shapes {
+		tags[i] = []string{}
+		if shape.Wobble > wobbleThreshold {
+			tags[i] = append(tags[i], TagWobbly)
+		}
+		if math.Abs(shape.Bow) > arcThreshold {
+			tags[i] = append(tags[i], TagArced)
+		}
+		if shape.Hook > hookThreshold {
+			tags[i] = append(tags[i], TagHooked)
+		}
+
+		if isVertical[i] {
+			if 90.0-math.Abs(lines[i].Angle) > misangleThreshold {
+				tags[i] = append(tags[i], TagMisangled)
+			}
+		} else if vp := vpFor[i]; vp != nil && len(strokes[i]) > 0 {
+			if angleToPoint(strokes[i], lines[i], *vp) > misangleThreshold {
+				tags[i] = append(tags[i], TagMisangled)
+			}
+		}
+
+		if diagonal > 0 && shape.Length < diagonal*minStrokeLength {
+			tags[i] = append(tags[i], TagTooShort)
+		}
+	}
+	return tags
+}
+
+// angleToPoint returns the angle in degrees between a stroke's ideal line and
+// the direction from the stroke's center to a point
+func angleToPoint(stroke Stroke, line Line, p Point) float64 {
+	c := strokeCenter(stroke)
+	toPoint := math.Atan2(p.Y-c.Y, p.X-c.X) * 180.0 / math.Pi
+	diff := math.Mod(math.Abs(toPoint-line.Angle), 180.0)
+	return math.Min(diff, 180.0-diff)
+}
+
+// generateFeedback turns the analysis metrics into actionable hints. Hints
+// about a group of lines are only given when the drawing has that group, with
+// the two lines a vanishing point needs for the receding ones and the three
+// verticals a box's proportions are judged by.
+func generateFeedback(trainingType TrainingType, lines []Line, shapes []StrokeShape, verticals, leftGroup, rightGroup []int, leftScore, rightScore, verticalScore, proportionScore, compositionScore float64) []string {
+	var feedback []string
+
+	// Group arcs by direction so consistent habits are reported together
+	arcs := map[string][]int{}
+	var directions []string
+	var hooked, wobbly []int
+	for i, shape := range shapes {
+		if math.Abs(shape.Bow) > arcThreshold {
+			dir := bowDirection(lines[i], shape.Bow)
+			if _, seen := arcs[dir]; !seen {
+				directions = append(directions, dir)
+			}
+			arcs[dir] = append(arcs[dir], i)
+		}
+		if shape.Hook > hookThreshold {
+			hooked = append(hooked, i)
+		}
+		if shape.Wobble > wobbleThreshold {
+			wobbly = append(wobbly, i)
+		}
+	}
+
+	for _, dir := range directions {
+		idx := arcs[dir]
+		if len(idx) > 1 {
+			feedback = append(feedback, fmt.Sprintf("%s arc consistently %s — try ghosting the motion a few times and drawing from the shoulder rather than the wrist.", lineList(idx), dir))
+		} else {
+			feedback = append(feedback, fmt.Sprintf("%s bows %s — rotate the page so the stroke follows your natural arm movement.", lineList(idx), dir))
+		}
+	}
+	if len(hooked) > 0 {
+		feedback = append(feedback, fmt.Sprintf("%s %s at the ends — start moving before the pen touches down and lift off while still moving.", lineList(hooked), pluralize(len(hooked), "hooks", "hook")))
+	}
+	if len(wobbly) > 0 {
+		feedback = append(feedback, fmt.Sprintf("%s %s — draw a little faster and commit to the stroke; speed straightens lines.", lineList(wobbly), pluralize(len(wobbly), "wobbles", "wobble")))
+	}
+
+	if len(leftGroup) >= 2 && leftScore < 60 {
+		feedback = append(feedback, "Your left-receding lines don't meet at a common point — pick the left vanishing point first and aim every line at it.")
+	}
+	if len(rightGroup) >= 2 && rightScore < 60 {
+		feedback = append(feedback, "Your right-receding lines don't meet at a common point — pick the right vanishing point first and aim every line at it.")
+	}
+	switch {
+	case trainingType == ThreePointPerspective:
+		if len(verticals) >= 2 && verticalScore < 60 {
+			feedback = append(feedback, "Your verticals don't meet at a common point — in 3-point perspective aim every one at the third vanishing point above or below the box.")
+		}
+	case len(verticals) > 0 && verticalScore < 60:
+		kind := "2-point"
+		if trainingType == OnePointPerspective {
+			kind = "1-point"
+		}
+		feedback = append(feedback, fmt.Sprintf("Your verticals lean — in %s perspective they should stay parallel to the side of the page.", kind))
+	}
+	if len(verticals) >= 3 && proportionScore < 60 {
+		feedback = append(feedback, "The front vertical should be the longest edge of the box, with the side edges slightly shorter as they recede.")
+	}
+	if compositionScore < 60 {
+		feedback = append(feedback, "Place the box near the middle of the page and draw it larger so the page is comfortably filled.")
+	}
+
+	if len(feedback) == 0 {
+		feedback = append(feedback, "Clean lines and solid convergence — try pushing the vanishing points further apart for a harder variation.")
+	}
+	return feedback
+}
+
+// lineList formats stroke indices as "Line 3" or "Lines 3, 5 and 7"
+func lineList(indices []int) string {
+	names := make([]string, len(indices))
+	for i, idx := range indices {
+		names[i] = fmt.Sprintf("%d", idx+1)
+	}
+	if len(names) == 1 {
+		return "Line " + names[0]
+	}
+	return "Lines " + strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// pluralize picks the verb form matching the number of lines
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
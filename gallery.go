@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultGalleryLimit = 20
+	maxGalleryLimit     = 100
+	// galleryMinScore is the composite score an attempt needs to be shown
+	galleryMinScore = 80
+)
+
+// gallerySigner keys the IDs attempts are shown under in the gallery and on
+// leaderboards, so they can't be matched with each other or with attempts
+// elsewhere. It's set at startup from the session secret.
+var gallerySigner cookieSigner
+
+// GalleryQuery picks the attempts a gallery shows
+type GalleryQuery struct {
+	TrainingType TrainingType // any when empty
+	MinScore     float64
+	Limit        int
+}
+
+// GalleryEntry is an attempt shown in the gallery, with nothing to tell who
+// made it
+type GalleryEntry struct {
+	ID               string       `json:"id"` // not the attempt's
+	AttemptID        string       `json:"-"`
+	TrainingType     TrainingType `json:"trainingType"`
+	Difficulty       Difficulty   `json:"difficulty"`
+	Grade            string       `json:"grade"`
+	CompositeScore   float64      `json:"compositeScore"`
+	AverageLineScore float64      `json:"averageLineScore"`
+	PerspectiveScore float64      `json:"perspectiveScore"`
+	ThumbnailURL     string       `json:"thumbnailUrl"`
+	Day              string       `json:"day"` // YYYY-MM-DD in UTC, rather than the time it was made
+}
+
+// galleryID is the ID an attempt is shown under in the gallery
+func galleryID(attemptID string) string {
+	return gallerySigner.mac("gallery:" + attemptID)[:22]
+}
+
+// loadGallery returns the newest attempts of users who opted in to the
+// gallery that score at least galleryMinScore
+func loadGallery(trainingType TrainingType, limit int) ([]GalleryEntry, error) {
+	entries, err := store.Gallery(GalleryQuery{TrainingType: trainingType, MinScore: galleryMinScore, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		e := &entries[i]
+		e.ID = galleryID(e.AttemptID)
+		e.Grade = letterGrade(e.CompositeScore)
+		e.ThumbnailURL = sitePath(apiPrefix + "/gallery/" + string(e.TrainingType) + "/" + e.ID + "/thumbnail.png")
+	}
+	return entries, nil
+}
+
+// handleGallery lists recent high-scoring attempts, for anyone to see what
+// good results look like
+func handleGallery(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultGalleryLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxGalleryLimit {
+			writeError(w, fieldError(CodeOutOfRange, "limit", "Limit must be between 1 and %d", maxGalleryLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := loadGallery(TrainingType(query.Get("trainingType")), limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load gallery", "err", err)
+		httpError(w, "Failed to load gallery", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, entries)
+}
+
+// handleGalleryThumbnail serves the thumbnail of an attempt while it's in
+// the gallery. Looking among the newest of its exercise finds any a listing
+// can show.
+func handleGalleryThumbnail(w http.ResponseWriter, r *http.Request) {
+	entries, err := loadGallery(TrainingType(r.PathValue("trainingType")), maxGalleryLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load gallery", "err", err)
+		httpError(w, "Failed to load thumbnail", http.StatusInternalServerError)
+		return
+	}
+	id := r.PathValue("id")
+	for _, e := range entries {
+		if e.ID != id {
+			continue
+		}
+		data, err := loadThumbnail(e.AttemptID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to render thumbnail", "attemptId", e.AttemptID, "err", err)
+			httpError(w, "Failed to load thumbnail", http.StatusInternalServerError)
+			return
+		}
+		// Not immutable, as the user may leave the gallery
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		http.ServeContent(w, r, "thumbnail.png", time.Time{}, bytes.NewReader(data))
+		return
+	}
+	httpError(w, "Not in the gallery", http.StatusNotFound)
+}
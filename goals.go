@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	maxGoals               = 20
+	defaultRemindAfterDays = 3
+	maxRemindAfterDays     = 30
+	// goalReminderInterval is how often goals are checked for lapsed practice
+	goalReminderInterval = time.Hour
+)
+
+// Goal statuses, worked out from the attempts at the goal's exercise
+const (
+	GoalActive   = "active"
+	GoalAchieved = "achieved"
+	GoalMissed   = "missed" // the deadline passed first
+)
+
+// errGoalNotFound is returned for goals a user doesn't have
+var errGoalNotFound = errors.New("goal not found")
+
+// Goal is a score a user means to reach by a day, such as an average line
+// score of 85 by March, and how to remind them to practice for it
+type Goal struct {
+	ID              string       `json:"id"`
+	UserID          string       `json:"-"`
+	WorkspaceID     string       `json:"workspaceId,omitempty"`  // attempts in this workspace count, as where it was set
+	TrainingType    TrainingType `json:"trainingType,omitempty"` // attempts at any exercise count when empty
+	Metric          string       `json:"metric"`                 // compositeScore, averageLineScore or perspectiveScore
+	Target          float64      `json:"target"`                 // the mean of the metric over the last few complete attempts to reach
+	Deadline        string       `json:"deadline"`               // YYYY-MM-DD, included
+	RemindAfterDays int          `json:"remindAfterDays"`        // days without practice before a reminder
+	WebhookURL      string       `json:"webhookUrl,omitempty"`   // where to post reminders
+	Email           bool         `json:"email"`                  // whether to email reminders too
+	CreatedAt       time.Time    `json:"createdAt"`
+	LastRemindedAt  *time.Time   `json:"lastRemindedAt,omitempty"`
+}
+
+// GoalStatus is a goal with how it's going
+type GoalStatus struct {
+	Goal
+	Status        string     `json:"status"`            // active, achieved or missed
+	Current       *float64   `json:"current,omitempty"` // the metric's mean over the last few complete attempts
+	Attempts      int        `json:"attempts"`          // complete attempts that count
+	LastPracticed *time.Time `json:"lastPracticed,omitempty"`
+}
+
+// GoalReminder is posted to a goal's webhook when practice for it lapses
+type GoalReminder struct {
+	UserID  string     `json:"userId"`
+	Goal    GoalStatus `json:"goal"`
+	Message string     `json:"message"`
+}
+
+// validateGoal fills in a new goal's defaults and rejects goals that can't
+// be tracked or reminded of
+func validateGoal(g *Goal, now time.Time) error {
+	if g.TrainingType != "" {
+		if _, ok := defaultExercises[g.TrainingType]; !ok {
+			return fieldError(CodeInvalidValue, "trainingType", "Unknown training type %q", g.TrainingType)
+		}
+	}
+	if !slices.ContainsFunc(personalBestMetrics, func(m scoreMetric) bool { return m.name == g.Metric }) {
+		return fieldError(CodeInvalidValue, "metric", "Unknown metric %q, expected compositeScore, averageLineScore or perspectiveScore", g.Metric)
+	}
+	if g.Target <= 0 || g.Target > 100 {
+		return fieldError(CodeOutOfRange, "target", "Target must be more than 0 and at most 100")
+	}
+	deadline, err := time.Parse(time.DateOnly, g.Deadline)
+	if err != nil || deadline.Before(now.UTC().Truncate(24*time.Hour)) {
+		return fieldError(CodeInvalidValue, "deadline", "Expected a day such as 2024-05-01, not in the past")
+	}
+	if g.RemindAfterDays == 0 {
+		g.RemindAfterDays = defaultRemindAfterDays
+	}
+	if g.RemindAfterDays < 1 || g.RemindAfterDays > maxRemindAfterDays {
+		return fieldError(CodeOutOfRange, "remindAfterDays", "Reminders can come after 1 to %d days", maxRemindAfterDays)
+	}
+	if g.WebhookURL != "" {
+		if err := validateCallbackURL(g.WebhookURL); err != nil {
+			return fieldError(CodeInvalidValue, "webhookUrl", "%v", err)
+		}
+		if webhooks.secret == "" {
+			return fieldError(CodeInvalidValue, "webhookUrl", "Reminder webhooks need the server to have a webhook secret")
+		}
+	}
+	if g.Email && mailer == nil {
+		return fieldError(CodeInvalidValue, "email", "This server doesn't send email")
+	}
+	return nil
+}
+
+// goalMetric returns the metric a goal is measured by
+func goalMetric(g Goal) scoreMetric {
+	i := slices.IndexFunc(personalBestMetrics, func(m scoreMetric) bool { return m.name == g.Metric })
+	return personalBestMetrics[max(i, 0)]
+}
+
+// goalStatus works out how a goal is going from the attempts, keeping its
+// user's at its exercise in its workspace
+func goalStatus(g Goal, history []AttemptSummary, now time.Time) GoalStatus {
+	s := GoalStatus{Goal: g, Status: GoalActive}
+	var complete []AttemptSummary
+	for _, a := range history {
+		if a.UserID != g.UserID || a.WorkspaceID != g.WorkspaceID || (g.TrainingType != "" && a.TrainingType != g.TrainingType) {
+			continue
+		}
+		if s.LastPracticed == nil || a.Timestamp.After(*s.LastPracticed) {
+			s.LastPracticed = &a.Timestamp
+		}
+		if !a.Partial {
+			complete = append(complete, a)
+		}
+	}
+
+	s.Attempts = len(complete)
+	if len(complete) > 0 {
+		sort.SliceStable(complete, func(i, j int) bool { return complete[i].Timestamp.Before(complete[j].Timestamp) })
+		recent := complete[max(0, len(complete)-scheduleWindow):]
+		m := goalMetric(g)
+		current := 0.0
+		for _, a := range recent {
+			current += m.score(a)
+		}
+		current /= float64(len(recent))
+		s.Current = &current
+	}
+
+	switch {
+	case s.Current != nil && *s.Current >= g.Target:
+		s.Status = GoalAchieved
+	case now.UTC().Format(time.DateOnly) > g.Deadline:
+		s.Status = GoalMissed
+	}
+	return s
+}
+
+// reminderDue reports whether a goal's user should be reminded to practice:
+// it's still active, has somewhere to send reminders, and they haven't
+// practiced for it, or been reminded, for its number of days
+func reminderDue(s GoalStatus, now time.Time) bool {
+	if s.Status != GoalActive || (s.WebhookURL == "" && !s.Email) {
+		return false
+	}
+	since := s.CreatedAt
+	for _, t := range []*time.Time{s.LastPracticed, s.LastRemindedAt} {
+		if t != nil && t.After(since) {
+			since = *t
+		}
+	}
+	return now.Sub(since) >= time.Duration(s.RemindAfterDays)*24*time.Hour
+}
+
+// describeGoal says what a goal is, for reminders
+func describeGoal(s GoalStatus) string {
+	exercise := "any exercise"
+	if s.TrainingType != "" {
+		exercise = "the " + getExercise(s.TrainingType).Name
+	}
+	text := fmt.Sprintf("Your goal is an average %s of %g at %s by %s.", goalMetric(s.Goal).label, s.Target, exercise, s.Deadline)
+	if s.Current != nil {
+		text += fmt.Sprintf(" You're at %.1f over your last complete attempts.", *s.Current)
+	}
+	if s.LastPracticed != nil {
+		text += fmt.Sprintf(" You last practiced for it on %s.", s.LastPracticed.UTC().Format(time.DateOnly))
+	} else {
+		text += " You haven't practiced for it yet."
+	}
+	return text
+}
+
+// runGoalReminders checks goals for lapsed practice every
+// goalReminderInterval while the server runs
+func runGoalReminders() {
+	for {
+		sendGoalReminders(context.Background(), time.Now())
+		time.Sleep(goalReminderInterval)
+	}
+}
+
+// sendGoalReminders reminds users whose practice for a goal has lapsed, by
+// its webhook and by email
+func sendGoalReminders(ctx context.Context, now time.Time) {
+	goals, err := store.ActiveGoals(now.UTC().Format(time.DateOnly))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load goals", "err", err)
+		return
+	}
+	if len(goals) == 0 {
+		return
+	}
+	history, err := loadHistory("")
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load history", "err", err)
+		return
+	}
+
+	for _, g := range goals {
+		s := goalStatus(g, history, now)
+		if !reminderDue(s, now) {
+			continue
+		}
+		// Another server sharing the database may have sent it already
+		claimed, err := store.ClaimGoalReminder(g.ID, g.LastRemindedAt, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record goal reminder", "goalId", g.ID, "err", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		s.LastRemindedAt = &now
+		message := "Time to practice! " + describeGoal(s)
+		slog.InfoContext(ctx, "Reminding user of goal", "goalId", g.ID, "userId", g.UserID)
+
+		if g.WebhookURL != "" && webhooks.secret != "" {
+			hook := &webhook{url: g.WebhookURL, secret: webhooks.secret}
+			go webhooks.send(ctx, hook, webhookEventGoal, GoalReminder{UserID: g.UserID, Goal: s, Message: message})
+		}
+		if g.Email && mailer != nil {
+			go emailGoalReminder(ctx, g.UserID, message)
+		}
+	}
+}
+
+// emailGoalReminder emails a reminder to the address the user's provider
+// last reported
+func emailGoalReminder(ctx context.Context, userID, message string) {
+	p, err := store.Profile(userID)
+	if err == nil && p.Email == "" {
+		err = errors.New("no email address")
+	}
+	if err == nil {
+		err = mailer.send(p.Email, "Time to practice perspective", message)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to email goal reminder", "userId", userID, "err", err)
+	}
+}
+
+// handleListGoals returns the signed-in user's goals with how each is going
+func handleListGoals(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	goals, err := store.Goals(u.ID)
+	var history []AttemptSummary
+	if err == nil {
+		history, err = loadHistory("")
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load goals", "userId", u.ID, "err", err)
+		httpError(w, "Failed to load goals", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	statuses := make([]GoalStatus, 0, len(goals))
+	for _, g := range goals {
+		statuses = append(statuses, goalStatus(g, history, now))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, statuses)
+}
+
+// handleCreateGoal sets a new goal for the signed-in user, tracking their
+// attempts in the workspace it's set in
+func handleCreateGoal(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	var g Goal
+	if !decodeRequest(w, r, &g) {
+		return
+	}
+	now := time.Now()
+	if err := validateGoal(&g, now); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if g.Email && u.Email == "" {
+		writeError(w, fieldError(CodeInvalidValue, "email", "Your account has no email address to remind you at"), http.StatusBadRequest)
+		return
+	}
+
+	goals, err := store.Goals(u.ID)
+	if err == nil && len(goals) >= maxGoals {
+		httpError(w, fmt.Sprintf("You can have at most %d goals", maxGoals), http.StatusConflict)
+		return
+	}
+	g.ID, g.UserID, g.WorkspaceID, g.CreatedAt, g.LastRemindedAt = newAttemptID(), u.ID, workspaceID(r.Context()), now, nil
+	var history []AttemptSummary
+	if err == nil {
+		err = store.AddGoal(g)
+	}
+	if err == nil {
+		history, err = loadHistory(g.TrainingType)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save goal", "userId", u.ID, "err", err)
+		httpError(w, "Failed to save goal", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusCreated, goalStatus(g, history, now))
+}
+
+// handleDeleteGoal removes one of the signed-in user's goals
+func handleDeleteGoal(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	err := store.DeleteGoal(u.ID, r.PathValue("id"))
+	if errors.Is(err, errGoalNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete goal", "userId", u.ID, "err", err)
+		httpError(w, "Failed to delete goal", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
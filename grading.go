@@ -0,0 +1,196 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// RubricItem is one scored criterion of the grading rubric
+type RubricItem struct {
+	Criterion string  `json:"criterion"`
+	Score     float64 `json:"score"`
+	Weight    float64 `json:"weight"`
+}
+
+// Grade is the letter grade for an attempt along with its rubric breakdown
+type Grade struct {
+	Letter string       `json:"letter"`
+	Score  float64      `json:"score"`
+	Rubric []RubricItem `json:"rubric"`
+}
+
+// gradeAttempt combines the rubric criteria using the exercise weights
+func gradeAttempt(exercise Exercise, lineQuality, convergence, proportion, composition float64) Grade {
+	w := exercise.RubricWeights
+	rubric := []RubricItem{
+		{Criterion: "lineQuality", Score: lineQuality, Weight: w.LineQuality},
+		{Criterion: "convergence", Score: convergence, Weight: w.Convergence},
+		{Criterion: "proportion", Score: proportion, Weight: w.Proportion},
+		{Criterion: "composition", Score: composition, Weight: w.Composition},
+	}
+
+	var total, weightSum float64
+	for _, item := range rubric {
+		total += item.Score * item.Weight
+		weightSum += item.Weight
+	}
+	if weightSum > 0 {
+		total /= weightSum
+	}
+
+	return Grade{
+		Letter: letterGrade(total),
+		Score:  total,
+		Rubric: rubric,
+	}
+}
+
+// calculateCompositeScore combines the component scores into one headline score
+func calculateCompositeScore(w ScoreWeights, lineQuality, convergence, proportion float64) float64 {
+	weightSum := w.LineQuality + w.Convergence + w.Proportion
+	if weightSum <= 0 {
+		return 0
+	}
+	return (lineQuality*w.LineQuality + convergence*w.Convergence + proportion*w.Proportion) / weightSum
+}
+
+// calculateTimeModifier returns the points added to (or removed from) the
+// composite score for a timed drill. Finishing under budget earns a bonus that
+// grows linearly toward MaxBonus; going over costs up to MaxPenalty at twice the budget.
+func calculateTimeModifier(budget TimeBudget, elapsedSeconds float64) float64 {
+	if budget.Seconds <= 0 || elapsedSeconds <= 0 {
+		return 0
+	}
+	ratio := elapsedSeconds / budget.Seconds
+	if ratio <= 1 {
+		return budget.MaxBonus * (1 - ratio)
+	}
+	return -budget.MaxPenalty * math.Min(ratio-1, 1)
+}
+
+// elapsedSeconds returns the drawing time from per-point timestamps (in
+// milliseconds), or 0 when the strokes carry no timing information
+func elapsedSeconds(strokes []Stroke) float64 {
+	first, last := math.Inf(1), math.Inf(-1)
+	for _, stroke := range strokes {
+		for _, p := range stroke {
+			if p.T == 0 {
+				continue
+			}
+			first = math.Min(first, p.T)
+			last = math.Max(last, p.T)
+		}
+	}
+	if last <= first {
+		return 0
+	}
+	return (last - first) / 1000.0
+}
+
+// letterGrade maps a 0-100 score to a letter using the usual 10-point bands
+func letterGrade(score float64) string {
+	switch {
+	case score >= 90:
+		return "A"
+	case score >= 80:
+		return "B"
+	case score >= 70:
+		return "C"
+	case score >= 60:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// calculateProportionScore checks that the verticals diminish away from the front edge.
+// The front (middle) vertical of a cube is nearest the viewer and should be the longest,
+// while the side verticals should be somewhat shorter but not collapse.
+func calculateProportionScore(strokes []Stroke, verticals []int) float64 {
+	if len(verticals) < 3 {
+		return 0
+	}
+
+	// Sort verticals left to right by their x-position
+	type vertical struct {
+		x, length float64
+	}
+	vs := make([]vertical, 0, len(verticals))
+	for _, idx := range verticals {
+		minX, minY, maxX, maxY := strokeBounds(strokes[idx])
+		vs = append(vs, vertical{x: (minX + maxX) / 2, length: maxY - minY})
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		return vs[i].x < vs[j].x
+	})
+
+	front := vs[len(vs)/2].length
+	if front <= 0 {
+		return 0
+	}
+
+	score := 100.0
+	for i, v := range vs {
+		if i == len(vs)/2 {
+			continue
+		}
+		ratio := v.length / front
+		if ratio > 1 {
+			// Side edge longer than the front edge
+			score *= math.Exp(-(ratio - 1) * 5)
+		} else if ratio < 0.5 {
+			// Side edge receding too sharply
+			score *= math.Exp(-(0.5 - ratio) * 5)
+		}
+	}
+	return score
+}
+
+// calculateCompositionScore rewards drawings that are centered on the canvas
+// and fill a comfortable portion of it
+func calculateCompositionScore(strokes []Stroke, width, height float64) float64 {
+	if len(strokes) == 0 || width <= 0 || height <= 0 {
+		return 0
+	}
+
+	minX, minY, maxX, maxY := strokeBounds(strokes[0])
+	for _, stroke := range strokes[1:] {
+		sMinX, sMinY, sMaxX, sMaxY := strokeBounds(stroke)
+		minX = math.Min(minX, sMinX)
+		minY = math.Min(minY, sMinY)
+		maxX = math.Max(maxX, sMaxX)
+		maxY = math.Max(maxY, sMaxY)
+	}
+
+	// Centering: offset of the drawing center from the canvas center, relative to canvas size
+	offsetX := ((minX+maxX)/2 - width/2) / width
+	offsetY := ((minY+maxY)/2 - height/2) / height
+	centering := math.Exp(-math.Hypot(offsetX, offsetY) * 4)
+
+	// Size: the drawing should cover 20-80% of the smaller canvas dimension
+	fill := math.Max(maxX-minX, maxY-minY) / math.Min(width, height)
+	size := 1.0
+	if fill < 0.2 {
+		size = math.Exp(-(0.2 - fill) * 10)
+	} else if fill > 0.8 {
+		size = math.Exp(-(fill - 0.8) * 5)
+	}
+
+	return 100.0 * centering * size
+}
+
+// strokeBounds returns the bounding box of a stroke
+func strokeBounds(stroke Stroke) (minX, minY, maxX, maxY float64) {
+	if len(stroke) == 0 {
+		return 0, 0, 0, 0
+	}
+	minX, maxX = stroke[0].X, stroke[0].X
+	minY, maxY = stroke[0].Y, stroke[0].Y
+	for _, p := range stroke[1:] {
+		minX = math.Min(minX, p.X)
+		maxX = math.Max(maxX, p.X)
+		minY = math.Min(minY, p.Y)
+		maxY = math.Max(maxY, p.Y)
+	}
+	return minX, minY, maxX, maxY
+}
@@ -0,0 +1,382 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tradrapb/tradra.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"tradra/tradrapb"
+)
+
+// analyzerServer serves the analysis over gRPC for native apps and other
+// backends, alongside the HTTP API
+type analyzerServer struct {
+	tradrapb.UnimplementedAnalyzerServer
+}
+
+// newGRPCServer returns the gRPC server with the same IP, API key, rate limit
+// and size checks as the HTTP API. It serves TLS when tlsConfig is set.
+func newGRPCServer(cfg config, tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(int(cfg.maxBodyBytes)),
+		grpc.ChainUnaryInterceptor(logRPC, filterRPCIP(cfg), maintenanceRPC, requireRPCAPIKey(cfg), rateLimitRPC()),
+		grpc.ChainStreamInterceptor(logStreamRPC, filterStreamIP(cfg), maintenanceStreamRPC, requireStreamAPIKey(cfg), rateLimitStreamRPC()),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+	tradrapb.RegisterAnalyzerServer(s, analyzerServer{})
+	return s
+}
+
+// serveGRPC serves gRPC on its listener until the server is stopped
+func serveGRPC(s *grpc.Server, ln net.Listener) error {
+	slog.Info("gRPC server starting", "addr", ln.Addr().String())
+	return s.Serve(ln)
+}
+
+// stopGRPC waits for in-flight calls to finish until ctx is done, then ends
+// the rest, such as streams that stay open while someone draws
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+func (analyzerServer) Analyze(ctx context.Context, in *tradrapb.AnalyzeRequest) (*tradrapb.AnalyzeResponse, error) {
+	req, err := analysisRequestFromProto(in)
+	if err == nil {
+		err = validateAnalysisRequest(&req)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return analyzeResponseToProto(analyzeStrokes(ctx, req)), nil
+}
+
+func (analyzerServer) AnalyzeStream(stream tradrapb.Analyzer_AnalyzeStreamServer) error {
+	s := liveSession{ctx: stream.Context()}
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msg := LiveMessage{Type: in.Type, Points: pointsFromProto(in.Points)}
+		if in.Request != nil {
+			req, err := analysisRequestFromProto(in.Request)
+			if err != nil {
+				if err := stream.Send(&tradrapb.LiveResponse{Type: LiveError, Error: err.Error()}); err != nil {
+					return err
+				}
+				continue
+			}
+			msg.Request = &req
+		}
+
+		reply, err := s.handle(msg)
+		if err != nil {
+			reply = LiveMessage{Type: LiveError, Error: err.Error()}
+		}
+		if reply.Type == "" {
+			continue
+		}
+		if err := stream.Send(liveResponseToProto(reply)); err != nil {
+			return err
+		}
+	}
+}
+
+// analysisRequestFromProto converts a gRPC request, looking up the theme and
+// style by name
+func analysisRequestFromProto(in *tradrapb.AnalyzeRequest) (AnalysisRequest, error) {
+	req := AnalysisRequest{
+		Width:        in.Width,
+		Height:       in.Height,
+		TrainingType: TrainingType(in.TrainingType),
+		DeviceID:     in.DeviceId,
+		Difficulty:   Difficulty(in.Difficulty),
+		Format:       in.Format,
+		Scale:        in.Scale,
+		Locale:       in.Locale,
+		Heatmap:      in.Heatmap,
+		FitVPs:       in.FitVanishingPoints,
+		GridRays:     int(in.GridRays),
+		Transparent:  in.Transparent,
+		OmitImage:    in.OmitImage,
+		SkipRender:   in.SkipRender,
+		Timed:        in.Timed,
+		ElapsedMs:    in.ElapsedMs,
+	}
+	for _, s := range in.Strokes {
+		req.Strokes = append(req.Strokes, pointsFromProto(s.Points))
+	}
+	if in.Theme != "" {
+		theme, ok := themes[in.Theme]
+		if !ok {
+			return req, errors.New("Unknown theme " + in.Theme)
+		}
+		req.Theme = &theme
+	}
+	if in.Style != "" {
+		style, ok := styles[in.Style]
+		if !ok {
+			return req, errors.New("Unknown style " + in.Style)
+		}
+		req.Style = &style
+	}
+	return req, nil
+}
+
+func pointsFromProto(in []*tradrapb.Point) Stroke {
+	points := make(Stroke, len(in))
+	for i, p := range in {
+		points[i] = Point{X: p.X, Y: p.Y, T: p.T}
+	}
+	return points
+}
+
+func pointToProto(p *Point) *tradrapb.Point {
+	if p == nil {
+		return nil
+	}
+	return &tradrapb.Point{X: p.X, Y: p.Y, T: p.T}
+}
+
+// analyzeResponseToProto converts a result, sending the visualization as
+// raw bytes rather than base64
+func analyzeResponseToProto(r AnalysisResult) *tradrapb.AnalyzeResponse {
+	out := &tradrapb.AnalyzeResponse{
+		AttemptId:        r.AttemptID,
+		ImageUrl:         r.ImageURL,
+		ThumbnailUrl:     r.ThumbnailURL,
+		CompositeScore:   r.CompositeScore,
+		AverageLineScore: r.AverageLineScore,
+		PerspectiveScore: r.PerspectiveScore,
+		ConvergenceScore: r.ConvergenceScore,
+		ProportionScore:  r.ProportionScore,
+		CompositionScore: r.CompositionScore,
+		LineScores:       r.LineScores,
+		LeftVp:           pointToProto(r.LeftVP),
+		RightVp:          pointToProto(r.RightVP),
+		Percentile:       r.Percentile,
+		Grade:            &tradrapb.Grade{Letter: r.Grade.Letter, Score: r.Grade.Score},
+		Feedback:         r.Feedback,
+		Partial:          r.Partial,
+		Assisted:         r.Assisted,
+		ExpectedStrokes:  int32(r.ExpectedStrokes),
+	}
+	switch {
+	case r.SVG != "":
+		out.Image, out.ImageType = []byte(r.SVG), "image/svg+xml"
+	case r.ImageData != "":
+		// A data URI such as data:image/png;base64,...
+		header, data, _ := strings.Cut(strings.TrimPrefix(r.ImageData, "data:"), ",")
+		out.ImageType, _, _ = strings.Cut(header, ";")
+		out.Image, _ = base64.StdEncoding.DecodeString(data)
+	}
+	return out
+}
+
+func liveResponseToProto(m LiveMessage) *tradrapb.LiveResponse {
+	out := &tradrapb.LiveResponse{Type: m.Type, Error: m.Error}
+	if u := m.Update; u != nil {
+		out.Update = &tradrapb.LiveAnalysis{
+			Strokes:           int32(u.Strokes),
+			ExpectedStrokes:   int32(u.ExpectedStrokes),
+			LeftVp:            pointToProto(u.LeftVP),
+			RightVp:           pointToProto(u.RightVP),
+			ConvergenceErrorL: u.ConvergenceErrorL,
+			ConvergenceErrorR: u.ConvergenceErrorR,
+			AverageLineScore:  u.AverageLineScore,
+			PerspectiveScore:  u.PerspectiveScore,
+			CompositeScore:    u.CompositeScore,
+			Feedback:          u.Feedback,
+		}
+		for _, l := range u.Lines {
+			out.Update.Lines = append(out.Update.Lines, &tradrapb.LiveLine{
+				From: pointToProto(&l.From), To: pointToProto(&l.To), Angle: l.Angle, Score: l.Score, Group: l.Group,
+			})
+		}
+	}
+	return out
+}
+
+// rpcPeerIP returns the IP a call came from
+func rpcPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// withRPCLog gives a call a request ID, from x-request-id when the client set
+// a valid one, so its log lines can be told apart like HTTP requests
+func withRPCLog(ctx context.Context) (context.Context, *requestLog) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	rl := &requestLog{id: id}
+	return context.WithValue(ctx, requestLogKey{}, rl), rl
+}
+
+// logRPCDone writes the access log line of a finished call
+func logRPCDone(ctx context.Context, rl *requestLog, method string, start time.Time, err error) {
+	code := status.Code(err)
+	attrs := []any{
+		"method", method,
+		"code", code.String(),
+		"durationMs", milliseconds(time.Since(start)),
+		"remote", rpcPeerIP(ctx),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+	rl.mu.Lock()
+	attrs = append(attrs, rl.attrs...)
+	rl.mu.Unlock()
+
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "RPC", attrs...)
+}
+
+func logRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, rl := withRPCLog(ctx)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logRPCDone(ctx, rl, info.FullMethod, start, err)
+	return resp, err
+}
+
+func logStreamRPC(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, rl := withRPCLog(ss.Context())
+	start := time.Now()
+	err := handler(srv, contextStream{ss, ctx})
+	logRPCDone(ctx, rl, info.FullMethod, start, err)
+	return err
+}
+
+// contextStream replaces a stream's context, so handlers see values the
+// interceptors added
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// checkRPCAPIKey requires a valid API key in the call's metadata, as
+// authorization: Bearer or x-api-key, when keys are required. Unlike HTTP
+// there are no browser pages to exempt.
+func checkRPCAPIKey(ctx context.Context, cfg config) error {
+	if !cfg.requireAPIKey {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := http.Header{}
+	for k, v := range md {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+	key := requestAPIKey(header)
+	if key == "" {
+		return status.Error(codes.Unauthenticated, "An API key is required")
+	}
+	k, ok := lookupAPIKey(key)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "Invalid API key")
+	}
+	addLogAttrs(ctx, "apiKey", k.Name)
+	return nil
+}
+
+func requireRPCAPIKey(cfg config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkRPCAPIKey(ctx, cfg); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func requireStreamAPIKey(cfg config) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkRPCAPIKey(ss.Context(), cfg); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// rateLimitRPC limits analyses per client IP like rateLimit does for HTTP,
+// failing with ResourceExhausted
+func rateLimitRPC() grpc.UnaryServerInterceptor {
+	limiter := newRateLimiter()
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ok, wait := limiter.allow(rpcPeerIP(ctx), time.Now()); !ok {
+			return nil, status.Errorf(codes.ResourceExhausted, "Too many requests, slow down; retry in %ds", int(math.Ceil(wait.Seconds())))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// rateLimitStreamRPC limits streams like rateLimitRPC, taking a token to open
+// one and leaving the live session to take one for each analysis
+func rateLimitStreamRPC() grpc.StreamServerInterceptor {
+	limiter := newRateLimiter()
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		client := rpcPeerIP(ss.Context())
+		if ok, wait := limiter.allow(client, time.Now()); !ok {
+			return status.Errorf(codes.ResourceExhausted, "Too many requests, slow down; retry in %ds", int(math.Ceil(wait.Seconds())))
+		}
+		charge := func(n int) (bool, time.Duration) { return limiter.allowN(client, n, time.Now()) }
+		return handler(srv, contextStream{ss, context.WithValue(ss.Context(), rateChargeKey{}, charge)})
+	}
+}
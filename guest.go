@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Guest cookies, which keep the history of browsers that aren't signed in
+// apart from other anonymous attempts until they sign in
+const (
+	guestCookie   = "tradra_guest"
+	guestDuration = 365 * 24 * time.Hour // from the browser's last visit
+	// guestPrefix starts the user IDs guests' attempts are kept under
+	guestPrefix = "guest:"
+)
+
+// guestSession is the content of the guest cookie
+type guestSession struct {
+	ID      string    `json:"id"`
+	Expires time.Time `json:"expires"`
+}
+
+type guestKey struct{}
+
+// guest returns the ID of a request's guest cookie, if it has a valid one
+func (a *auth) guest(r *http.Request) (string, bool) {
+	c, err := r.Cookie(guestCookie)
+	if err != nil {
+		return "", false
+	}
+	var g guestSession
+	if err := a.signer.decode(c.Value, &g); err != nil || time.Now().After(g.Expires) || g.ID == "" {
+		return "", false
+	}
+	return g.ID, true
+}
+
+// setGuest gives a browser that isn't signed in a guest cookie, or renews
+// the one it has. Its attempts are kept under it from its next request on.
+func (a *auth) setGuest(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.guest(r)
+	if !ok {
+		id = randomToken()[:22]
+	}
+	value, err := a.signer.encode(guestSession{ID: id, Expires: time.Now().Add(guestDuration)})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set guest cookie", "err", err)
+		return
+	}
+	a.setCookie(w, r, guestCookie, value, sitePath("/"), int(guestDuration.Seconds()))
+}
+
+// mergeGuest moves the attempts of a request's guest to the user who just
+// signed in, so practice from before they had an account isn't lost, and
+// drops the guest cookie
+func (a *auth) mergeGuest(w http.ResponseWriter, r *http.Request, user User) {
+	id, ok := a.guest(r)
+	if !ok {
+		return
+	}
+	n, err := store.MergeGuest(guestPrefix+id, user.ID)
+	if err != nil {
+		// The cookie's kept, so signing in again tries again
+		slog.ErrorContext(r.Context(), "Failed to merge guest history", "userId", user.ID, "err", err)
+		return
+	}
+	a.setCookie(w, r, guestCookie, "", sitePath("/"), -1)
+	if n > 0 {
+		slog.InfoContext(r.Context(), "Merged guest history", "userId", user.ID, "attempts", n)
+	}
+}
+
+// ownerID returns the user ID a request's attempts are kept under: the
+// signed-in user's, the guest's, or "" for anonymous attempts
+func ownerID(ctx context.Context) string {
+	if u, ok := userFrom(ctx); ok {
+		return u.ID
+	}
+	if id, ok := ctx.Value(guestKey{}).(string); ok {
+		return guestPrefix + id
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// startTime is when the server started, for reporting uptime
+var startTime = time.Now()
+
+// BuildInfo identifies the running build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	GoVersion string `json:"goVersion"`
+}
+
+// HealthStatus reports whether the server is up and able to serve analyses
+type HealthStatus struct {
+	Status        string            `json:"status"` // "ok" or "unavailable"
+	Checks        map[string]string `json:"checks,omitempty"`
+	Build         BuildInfo         `json:"build"`
+	UptimeSeconds float64           `json:"uptimeSeconds"`
+}
+
+// buildInfo reads the version and VCS details embedded by go build
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: "unknown"}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Version = bi.Main.Version
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.BuildTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// handleHealthz is the liveness probe: it answers as long as the server runs
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthStatus{Status: "ok"})
+}
+
+// handleReadyz is the readiness probe: it fails when results can't be saved
+// or the database can't be reached, so load balancers stop sending analyses
+// here. A missing label font only degrades the rendering, and maintenance mode needs the server kept to
+// show its page, so they're reported without failing.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{Status: "ok", Checks: map[string]string{"results": "ok", "database": "ok", "labelFont": "ok"}}
+	if err := checkResultsWritable(); err != nil {
+		status.Status = "unavailable"
+		status.Checks["results"] = err.Error()
+	}
+	if err := store.Ping(r.Context()); err != nil {
+		status.Status = "unavailable"
+		status.Checks["database"] = err.Error()
+	}
+	if _, err := os.Stat(labelFontPath); err != nil {
+		status.Checks["labelFont"] = "not installed, using the built-in font"
+	}
+	if currentMaintenance().Enabled {
+		status.Checks["maintenance"] = "on, users are turned away"
+	}
+	writeHealth(w, status)
+}
+
+// checkResultsWritable checks that results can be saved by creating and
+// removing a file in the results directory
+func checkResultsWritable() error {
+	f, err := os.CreateTemp(resultsDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func writeHealth(w http.ResponseWriter, status HealthStatus) {
+	status.Build = buildInfo()
+	status.UptimeSeconds = time.Since(startTime).Seconds()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
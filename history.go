@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// historyFile is the log attempts were kept in before the database, inside
+// the results directory. It's imported once by a migration.
+const historyFile = "history.jsonl"
+
+// AttemptSummary is the per-attempt record kept in the history
+type AttemptSummary struct {
+	ID               string       `json:"id,omitempty"`          // empty for attempts recorded before IDs were added
+	UserID           string       `json:"userId,omitempty"`      // the signed-in user, empty for anonymous attempts
+	WorkspaceID      string       `json:"workspaceId,omitempty"` // the workspace it was made in, if any
+	Timestamp        time.Time    `json:"timestamp"`
+	TrainingType     TrainingType `json:"trainingType"`
+	Difficulty       Difficulty   `json:"difficulty,omitempty"` // empty for attempts recorded before it was
+	CompositeScore   float64      `json:"compositeScore"`
+	AverageLineScore float64      `json:"averageLineScore"`
+	PerspectiveScore float64      `json:"perspectiveScore"`
+	Partial          bool         `json:"partial,omitempty"`
+	Assisted         bool         `json:"assisted,omitempty"`
+	SavedFilePath    string       `json:"savedFilePath"`
+	Notes            string       `json:"notes,omitempty"` // the user's, about the conditions it was drawn in
+	Tags             []string     `json:"tags,omitempty"`
+	// Recorded for leaderboards, but not read back
+	Fingerprint string  `json:"-"`
+	Ranked      bool    `json:"-"`
+	Daily       string  `json:"-"` // the day of the daily challenge it was entered in
+	DailyScore  float64 `json:"-"` // its match with the challenge's box
+}
+
+// attemptsDir holds cached thumbnails inside the results directory, and the
+// original requests of attempts from before the database
+const attemptsDir = "attempts"
+
+// errAttemptNotFound is returned when no attempt is stored under an ID
+var errAttemptNotFound = errors.New("attempt not found")
+
+// loadHistory reads all previous attempts for a training type,
+// or for every training type when it is empty
+func loadHistory(trainingType TrainingType) ([]AttemptSummary, error) {
+	return store.History(trainingType)
+}
+
+// recordAttempt adds an attempt to the history with the request it was
+// analyzed from, so it can be rendered again later, and its result. Images
+// are left out of the result since they're saved as files.
+func recordAttempt(attempt AttemptSummary, req AnalysisRequest, result AnalysisResult) error {
+	request, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	result.ImageData, result.SVG, result.Layers = "", "", nil
+	stored, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return store.AddAttempt(attempt, request, stored)
+}
+
+// calculatePercentile returns the percentage of previous complete attempts that
+// scored below the given score, or nil when there is nothing to compare against
+func calculatePercentile(score float64, history []AttemptSummary) *float64 {
+	below, total := 0, 0
+	for _, a := range history {
+		if a.Partial {
+			continue
+		}
+		total++
+		if a.CompositeScore < score {
+			below++
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	percentile := 100.0 * float64(below) / float64(total)
+	return &percentile
+}
+
+// newAttemptID generates a random identifier for an attempt
+func newAttemptID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validAttemptID reports whether an ID looks like one from newAttemptID, so
+// IDs from requests can't escape the attempts directory
+func validAttemptID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// loadAttempt reads the request a stored attempt was analyzed from
+func loadAttempt(id string) (AnalysisRequest, error) {
+	var req AnalysisRequest
+	if !validAttemptID(id) {
+		return req, errAttemptNotFound
+	}
+	data, err := store.AttemptRequest(id)
+	if err != nil {
+		return req, err
+	}
+	err = json.Unmarshal(data, &req)
+	return req, err
+}
+
+// findAttempt returns the history record of an attempt
+func findAttempt(id string) (StoredAttempt, error) {
+	if !validAttemptID(id) {
+		return StoredAttempt{}, errAttemptNotFound
+	}
+	return store.Attempt(id)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// locale holds the translations and number format of one language. Messages
+// are keyed by their English text, which is also the fallback.
+type locale struct {
+	decimal  string // decimal separator
+	messages map[string]string
+}
+
+// locales holds the languages rendered labels are available in, keyed by
+// their ISO 639-1 code
+var locales = map[string]locale{
+	"en": {decimal: "."},
+	"de": {decimal: ",", messages: map[string]string{
+		"Verticals: %d, Left Group: %d, Right Group: %d": "Vertikale: %d, linke Gruppe: %d, rechte Gruppe: %d",
+		"Line %d: %s":                             "Linie %d: %s",
+		"Left VP: %s px error (%d lines)":         "Linker FP: %s px Fehler (%d Linien)",
+		"Right VP: %s px error (%d lines)":        "Rechter FP: %s px Fehler (%d Linien)",
+		"Perspective grid":                        "Perspektivraster",
+		"Your stroke":                             "Dein Strich",
+		"Your stroke, on the line":                "Dein Strich, auf der Linie",
+		"Your stroke, off by the tolerance":       "Dein Strich, um die Toleranz daneben",
+		"Your stroke, off by twice the tolerance": "Dein Strich, um die doppelte Toleranz daneben",
+		"Ideal line":                              "Ideale Linie",
+		"Ideal box":                               "Idealer Quader",
+		"Horizon":                                 "Horizont",
+		"VP ray":                                  "FP-Strahl",
+		"Before":                                  "Vorher",
+		"After":                                   "Nachher",
+	}},
+	"fr": {decimal: ",", messages: map[string]string{
+		"Verticals: %d, Left Group: %d, Right Group: %d": "Verticales : %d, groupe gauche : %d, groupe droit : %d",
+		"Line %d: %s":                             "Ligne %d : %s",
+		"Left VP: %s px error (%d lines)":         "PF gauche : erreur de %s px (%d lignes)",
+		"Right VP: %s px error (%d lines)":        "PF droit : erreur de %s px (%d lignes)",
+		"Perspective grid":                        "Grille de perspective",
+		"Your stroke":                             "Votre trait",
+		"Your stroke, on the line":                "Votre trait, sur la ligne",
+		"Your stroke, off by the tolerance":       "Votre trait, écart d'une tolérance",
+		"Your stroke, off by twice the tolerance": "Votre trait, écart de deux tolérances",
+		"Ideal line":                              "Ligne idéale",
+		"Ideal box":                               "Boîte idéale",
+		"Horizon":                                 "Horizon",
+		"VP ray":                                  "Rayon vers le PF",
+		"Before":                                  "Avant",
+		"After":                                   "Après",
+	}},
+	"es": {decimal: ",", messages: map[string]string{
+		"Verticals: %d, Left Group: %d, Right Group: %d": "Verticales: %d, grupo izquierdo: %d, grupo derecho: %d",
+		"Line %d: %s":                             "Línea %d: %s",
+		"Left VP: %s px error (%d lines)":         "PF izquierdo: error de %s px (%d líneas)",
+		"Right VP: %s px error (%d lines)":        "PF derecho: error de %s px (%d líneas)",
+		"Perspective grid":                        "Cuadrícula de perspectiva",
+		"Your stroke":                             "Tu trazo",
+		"Your stroke, on the line":                "Tu trazo, sobre la línea",
+		"Your stroke, off by the tolerance":       "Tu trazo, desviado una tolerancia",
+		"Your stroke, off by twice the tolerance": "Tu trazo, desviado dos tolerancias",
+		"Ideal line":                              "Línea ideal",
+		"Ideal box":                               "Caja ideal",
+		"Horizon":                                 "Horizonte",
+		"VP ray":                                  "Rayo al PF",
+		"Before":                                  "Antes",
+		"After":                                   "Después",
+	}},
+}
+
+// findLocale returns the locale for a language tag such as "de" or "de-AT",
+// falling back to English for languages without translations
+func findLocale(tag string) locale {
+	lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	if l, ok := locales[lang]; ok {
+		return l
+	}
+	return locales["en"]
+}
+
+// text translates a message
+func (l locale) text(msg string) string {
+	if t, ok := l.messages[msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// format translates a format string and fills it in. Numbers with decimals
+// should be passed through number first.
+func (l locale) format(msg string, args ...any) string {
+	return fmt.Sprintf(l.text(msg), args...)
+}
+
+// number formats a number with the locale's decimal separator
+func (l locale) number(v float64, decimals int) string {
+	return strings.Replace(strconv.FormatFloat(v, 'f', decimals, 64), ".", l.decimal, 1)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Idempotency keys let clients on flaky connections retry a submission
+// without it being recorded twice. Responses are kept in memory, so they're
+// forgotten when the server restarts.
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyReplayed  = "Idempotent-Replayed"
+	idempotencyRetention = 24 * time.Hour
+	maxIdempotencyKey    = 255
+	maxIdempotentEntries = 10000
+	// Responses larger than maxIdempotentBody aren't kept, and the oldest
+	// are forgotten early to keep all together under maxIdempotentBytes, so
+	// a client sending new keys can't fill the memory
+	maxIdempotentBody  = 4 << 20
+	maxIdempotentBytes = 64 << 20
+)
+
+// idempotentHeaders are the response headers replayed with the body
+var idempotentHeaders = []string{"Content-Type", "Location", "Vary", "Deprecation", "Link"}
+
+// idempotentEntry is a request seen with an idempotency key: still running
+// until done is closed, then the response to replay
+type idempotentEntry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	expires     time.Time
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+var (
+	idempotencyMu      sync.Mutex
+	idempotencyEntries = map[string]*idempotentEntry{}
+	idempotencyBytes   int // of the bodies kept in idempotencyEntries
+)
+
+// idempotent wraps a handler that records something so a request repeated
+// with the same Idempotency-Key gets the first response back rather than
+// running again. Keys are per client, and reusing one for a different
+// request is an error. Errors from the server and rate limits aren't kept,
+// so retrying after them runs the request again, and neither are responses
+// too large to keep.
+func idempotent(next http.HandlerFunc, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, fieldError(CodeInvalidValue, "", "%s must be at most %d characters", idempotencyHeader, maxIdempotencyKey), http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				httpError(w, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			httpError(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The same key from another client, or for another endpoint, is a
+		// different request
+		id := idempotencyClient(r, cfg) + " " + r.URL.Path + " " + key
+		fingerprint := sha256.Sum256(bytes.Join([][]byte{
+			[]byte(r.Header.Get("Content-Type")), []byte(r.Header.Get("Accept")), body,
+		}, []byte{0}))
+
+		entry, first := claimIdempotencyKey(id, fingerprint)
+		switch {
+		case entry.fingerprint != fingerprint:
+			writeError(w, &APIError{Code: CodeIdempotencyKeyReused, Message: "This Idempotency-Key was used for a different request"}, http.StatusUnprocessableEntity)
+			return
+		case first:
+			addLogAttrs(r.Context(), "idempotencyKey", key)
+			rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				// A panicking handler sent no real response
+				if p := recover(); p != nil {
+					rec.status = http.StatusInternalServerError
+					finishIdempotent(id, entry, rec)
+					panic(p)
+				}
+				finishIdempotent(id, entry, rec)
+			}()
+			next(rec, r)
+			return
+		}
+
+		select {
+		case <-entry.done:
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, &APIError{Code: CodeConflict, Message: "A request with this Idempotency-Key is still in progress"}, http.StatusConflict)
+			return
+		}
+		// The response wasn't kept, so the key's free to run again
+		if entry.status == 0 {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, &APIError{Code: CodeConflict, Message: "The response to this Idempotency-Key wasn't kept; send the request again"}, http.StatusConflict)
+			return
+		}
+		addLogAttrs(r.Context(), "idempotencyKey", key, "replayed", true)
+		for k, v := range entry.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(idempotencyReplayed, "true")
+		w.WriteHeader(entry.status)
+		w.Write(entry.body)
+	}
+}
+
+// idempotencyClient identifies who sent a request: its API key, its
+// signed-in user or guest, or else its IP
+func idempotencyClient(r *http.Request, cfg config) string {
+	if k, ok := apiKeyFrom(r.Context()); ok {
+		return "key:" + k.Name
+	}
+	if id := ownerID(r.Context()); id != "" {
+		return "user:" + id
+	}
+	return "ip:" + clientIP(r, cfg)
+}
+
+// claimIdempotencyKey returns the entry for a key, adding a running one and
+// reporting true when the key is new
+func claimIdempotencyKey(id string, fingerprint [sha256.Size]byte) (*idempotentEntry, bool) {
+	now := time.Now()
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	if e, ok := idempotencyEntries[id]; ok && now.Before(e.expires) {
+		return e, false
+	}
+	dropIdempotentEntry(id)
+	if len(idempotencyEntries) >= maxIdempotentEntries {
+		dropExpiredIdempotentEntries(now)
+	}
+	e := &idempotentEntry{fingerprint: fingerprint, done: make(chan struct{}), expires: now.Add(idempotencyRetention)}
+	// When the cache is still full, the request runs without being kept
+	if len(idempotencyEntries) < maxIdempotentEntries {
+		idempotencyEntries[id] = e
+	}
+	return e, true
+}
+
+// finishIdempotent keeps a finished response for replaying, unless it's one
+// a retry might get a different answer to, in which case the key is freed
+func finishIdempotent(id string, e *idempotentEntry, rec *idempotencyRecorder) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	keep := rec.status < http.StatusInternalServerError && rec.status != http.StatusTooManyRequests && !rec.overflowed
+	if keep && idempotencyBytes+rec.body.Len() > maxIdempotentBytes {
+		makeIdempotentRoom(rec.body.Len())
+	}
+	if keep {
+		e.status, e.body = rec.status, rec.body.Bytes()
+		e.header = http.Header{}
+		for _, k := range idempotentHeaders {
+			if v := rec.Header().Values(k); len(v) > 0 {
+				e.header[k] = v
+			}
+		}
+		if idempotencyEntries[id] == e {
+			idempotencyBytes += len(e.body)
+		}
+	} else if idempotencyEntries[id] == e {
+		delete(idempotencyEntries, id)
+	}
+	close(e.done)
+}
+
+// dropIdempotentEntry forgets a key's entry, if it has one. The caller holds
+// idempotencyMu.
+func dropIdempotentEntry(id string) {
+	if e, ok := idempotencyEntries[id]; ok {
+		idempotencyBytes -= len(e.body)
+		delete(idempotencyEntries, id)
+	}
+}
+
+// makeIdempotentRoom forgets expired entries, then the oldest finished ones,
+// until a body of n bytes fits in maxIdempotentBytes. The caller holds
+// idempotencyMu.
+func makeIdempotentRoom(n int) {
+	dropExpiredIdempotentEntries(time.Now())
+	var finished []string
+	for k, e := range idempotencyEntries {
+		if len(e.body) > 0 {
+			finished = append(finished, k)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return idempotencyEntries[finished[i]].expires.Before(idempotencyEntries[finished[j]].expires)
+	})
+	for _, k := range finished {
+		if idempotencyBytes+n <= maxIdempotentBytes {
+			break
+		}
+		dropIdempotentEntry(k)
+	}
+}
+
+// dropExpiredIdempotentEntries forgets the entries past their retention. The
+// caller holds idempotencyMu.
+func dropExpiredIdempotentEntries(now time.Time) {
+	for k, e := range idempotencyEntries {
+		if !now.Before(e.expires) {
+			dropIdempotentEntry(k)
+		}
+	}
+}
+
+// idempotencyRecorder copies a response as it's written
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status     int
+	wrote      bool
+	body       bytes.Buffer
+	overflowed bool // the body grew past maxIdempotentBody, so it isn't kept
+}
+
+func (w *idempotencyRecorder) WriteHeader(status int) {
+	if !w.wrote {
+		w.status = status
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.wrote = true
+	if !w.overflowed {
+		if w.body.Len()+len(b) > maxIdempotentBody {
+			w.overflowed = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
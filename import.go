@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+)
+
+// ImportResult says what an import added
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // already kept, from an earlier import or this instance
+}
+
+// handleImportAttempts adds the attempts of a JSON export to the caller's
+// history, in their workspace if they're in one. It's all or nothing: one
+// attempt that can't be imported rejects the lot, before any is added.
+func handleImportAttempts(w http.ResponseWriter, r *http.Request) {
+	var export Export
+	if !decodeRequest(w, r, &export) {
+		return
+	}
+	if export.Version != exportVersion {
+		writeError(w, fieldError(CodeInvalidValue, "version", "Unsupported export version %d, expected %d", export.Version, exportVersion), http.StatusBadRequest)
+		return
+	}
+
+	owner := ownerID(r.Context())
+	ws, inWorkspace := workspaceFrom(r.Context())
+	attempts := make([]AttemptSummary, len(export.Attempts))
+	for i, e := range export.Attempts {
+		a, err := importedAttempt(e, time.Now())
+		if err == nil && inWorkspace && !ws.allows(a.TrainingType) {
+			err = fieldError(CodeInvalidValue, "trainingType", "This workspace doesn't offer %s", a.TrainingType)
+		}
+		if err != nil {
+			writeError(w, fmt.Errorf("attempt %d: %w", i+1, err), http.StatusBadRequest)
+			return
+		}
+		a.UserID, a.WorkspaceID = owner, ws.ID
+		attempts[i] = a
+	}
+
+	var result ImportResult
+	for i, a := range attempts {
+		e := export.Attempts[i]
+		added, err := store.ImportAttempt(a, rawJSON(e.Request), rawJSON(e.Result))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to import attempt", "attemptId", a.ID, "err", err)
+			httpError(w, fmt.Sprintf("Failed to import attempt %d; the %d before it were imported", i+1, result.Imported), http.StatusInternalServerError)
+			return
+		}
+		if added {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+	slog.InfoContext(r.Context(), "Imported attempts", "imported", result.Imported, "skipped", result.Skipped)
+	writeResponse(w, r, http.StatusOK, result)
+}
+
+// importedAttempt checks an exported attempt and returns it as it's kept.
+// Its scores weren't worked out here, so it's never ranked on leaderboards.
+func importedAttempt(e ExportedAttempt, now time.Time) (AttemptSummary, error) {
+	a := AttemptSummary{
+		ID: e.ID, Timestamp: e.Timestamp, TrainingType: e.TrainingType, Difficulty: e.Difficulty,
+		CompositeScore: e.CompositeScore, AverageLineScore: e.AverageLineScore, PerspectiveScore: e.PerspectiveScore,
+		Partial: e.Partial, Assisted: e.Assisted,
+	}
+	notes := AttemptNotes{Notes: e.Notes, Tags: e.Tags}
+	if err := validateNotes(&notes); err != nil {
+		return a, err
+	}
+	a.Notes, a.Tags = notes.Notes, notes.Tags
+	if a.ID != "" && !validAttemptID(a.ID) {
+		return a, fieldError(CodeInvalidValue, "id", "Invalid attempt ID %q", a.ID)
+	}
+	if a.Timestamp.IsZero() || a.Timestamp.After(now.Add(time.Minute)) {
+		return a, fieldError(CodeInvalidValue, "timestamp", "Expected the time of the attempt, not in the future")
+	}
+	if _, ok := defaultExercises[a.TrainingType]; !ok {
+		return a, fieldError(CodeInvalidValue, "trainingType", "Unknown training type %q", a.TrainingType)
+	}
+	if a.Difficulty != "" {
+		if _, ok := getScoringProfile(a.Difficulty); !ok {
+			return a, fieldError(CodeInvalidValue, "difficulty", "Unknown difficulty %q", a.Difficulty)
+		}
+	}
+	for field, score := range map[string]float64{
+		"compositeScore": a.CompositeScore, "averageLineScore": a.AverageLineScore,
+	} {
+		if math.IsNaN(score) || score < 0 || score > 100 {
+			return a, fieldError(CodeOutOfRange, field, "Scores must be between 0 and 100")
+		}
+	}
+	if math.IsNaN(a.PerspectiveScore) || math.IsInf(a.PerspectiveScore, 0) {
+		return a, fieldError(CodeInvalidValue, "perspectiveScore", "Expected a number")
+	}
+
+	if len(e.Request) > 0 {
+		var req AnalysisRequest
+		if !isJSONObject(e.Request) || json.Unmarshal(e.Request, &req) != nil {
+			return a, fieldError(CodeInvalidValue, "request", "Expected the analysis request as an object")
+		}
+		if req.TrainingType != "" && req.TrainingType != a.TrainingType {
+			return a, fieldError(CodeInvalidValue, "request", "The request is for %s, not %s", req.TrainingType, a.TrainingType)
+		}
+		a.Fingerprint = strokeFingerprint(req.Strokes)
+	}
+	if len(e.Result) > 0 {
+		var res AnalysisResult
+		if !isJSONObject(e.Result) || json.Unmarshal(e.Result, &res) != nil {
+			return a, fieldError(CodeInvalidValue, "result", "Expected the analysis result as an object")
+		}
+	}
+	return a, nil
+}
+
+// isJSONObject reports whether data is a JSON object, rather than null or
+// some other value
+func isJSONObject(data json.RawMessage) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parseIPList reads a list of CIDR ranges, taking a bare address as a range
+// of just itself
+func parseIPList(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP address nor a CIDR range such as 192.168.1.0/24", s)
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// trustedProxies are the reverse proxies whose forwarding headers are believed
+type trustedProxies struct {
+	prefixes []netip.Prefix
+	unix     bool // connections over a unix socket, which only local processes can make
+}
+
+// parseTrustedProxies reads -trusted-proxies: CIDR ranges, as parseIPList
+// takes them, and "unix"
+func parseTrustedProxies(list []string) (trustedProxies, error) {
+	var t trustedProxies
+	var ranges []string
+	for _, s := range list {
+		if s == "unix" {
+			t.unix = true
+		} else {
+			ranges = append(ranges, s)
+		}
+	}
+	var err error
+	t.prefixes, err = parseIPList(ranges)
+	return t, err
+}
+
+// connectedFrom reports whether a request's connection comes from a trusted
+// proxy. Connections without an IP are over a unix socket.
+func (t trustedProxies) connectedFrom(r *http.Request) bool {
+	ip := remoteIP(r)
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return t.unix
+	}
+	return t.contains(ip)
+}
+
+// contains reports whether an IP is in a trusted proxy's range
+func (t trustedProxies) contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range t.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed reports whether a client IP may use the server. A denied range
+// wins over an allowed one, and when there's an allowlist, addresses outside
+// it are denied. With either list, addresses that can't be told, such as over
+// a unix socket or a forwarded one that isn't an IP, are denied too, as they
+// could be anyone.
+func (cfg config) ipAllowed(ip string) bool {
+	if len(cfg.allowIPs) == 0 && len(cfg.denyIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range cfg.denyIPs {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(cfg.allowIPs) == 0 {
+		return true
+	}
+	for _, p := range cfg.allowIPs {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// withIPFilter refuses requests from client IPs outside -allow-ips or inside
+// -deny-ips with 403
+func withIPFilter(next http.Handler, cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.ipAllowed(clientIP(r, cfg)) {
+			httpError(w, "Your address isn't allowed to use this server", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkRPCIP refuses calls from client IPs that aren't allowed, like
+// withIPFilter, failing with PermissionDenied
+func checkRPCIP(ctx context.Context, cfg config) error {
+	if !cfg.ipAllowed(rpcPeerIP(ctx)) {
+		return status.Error(codes.PermissionDenied, "Your address isn't allowed to use this server")
+	}
+	return nil
+}
+
+func filterRPCIP(cfg config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkRPCIP(ctx, cfg); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func filterStreamIP(cfg config) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkRPCIP(ss.Context(), cfg); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+)
+
+// maxQueuedJobs is how many jobs may wait for a worker before new ones are
+// turned away
+const maxQueuedJobs = 100
+
+// jobRetention is how long a finished job's result is kept for polling
+const jobRetention = time.Hour
+
+// JobRequest is the work for a job: one attempt, for heavy ones such as GIF
+// replays, or a batch as for /analyze/batch
+type JobRequest struct {
+	Analyze     *AnalysisRequest  `json:"analyze,omitempty"`
+	Batch       []AnalysisRequest `json:"batch,omitempty"`
+	CallbackURL string            `json:"callbackUrl,omitempty"` // gets the finished job, overriding the API key's
+}
+
+// Job is a submitted job, with its result once it's done
+type Job struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Result     *AnalysisResult `json:"result,omitempty"`  // for an analyze job
+	Results    []BatchResult   `json:"results,omitempty"` // for a batch job
+	Completed  int             `json:"completed"`         // attempts analyzed so far
+	Total      int             `json:"total"`             // attempts in the job
+	// Where the finished job is posted, if anywhere
+	CallbackURL string `json:"callbackUrl,omitempty"`
+
+	partial []JobPartial  // batch results in the order they finished
+	changed chan struct{} // closed and replaced whenever the job changes
+}
+
+// JobPartial is one attempt of a batch job, sent as soon as it's analyzed
+type JobPartial struct {
+	Index int `json:"index"` // of the attempt in the batch
+	BatchResult
+}
+
+// JobProgress is how far along a job is
+type JobProgress struct {
+	Status    string  `json:"status"`
+	Completed int     `json:"completed"`
+	Total     int     `json:"total"`
+	Percent   float64 `json:"percent"`
+}
+
+// queuedJob is a job waiting for a worker, with the context of the request
+// that submitted it
+type queuedJob struct {
+	ctx  context.Context
+	id   string
+	req  JobRequest
+	hook *webhook // nil without a callback
+}
+
+var (
+	jobsMu   sync.Mutex
+	jobs     = map[string]*Job{}
+	jobQueue chan queuedJob
+)
+
+// errJobQueueFull is returned when every queue slot is taken
+var errJobQueueFull = errors.New("too many queued jobs")
+
+// startJobWorkers starts the workers that run submitted jobs. Jobs live in
+// memory, so queued and finished jobs are lost when the server restarts.
+func startJobWorkers(n int) {
+	jobQueue = make(chan queuedJob, maxQueuedJobs)
+	for range n {
+		go func() {
+			for q := range jobQueue {
+				runJob(q)
+			}
+		}()
+	}
+}
+
+// newJobID returns a random ID. Jobs aren't tied to who submitted them, so
+// it's long enough that knowing it is what grants access.
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submitJob queues a job and returns it as queued
+func submitJob(ctx context.Context, req JobRequest, hook *webhook) (Job, error) {
+	now := time.Now()
+	job := &Job{ID: newJobID(), Status: JobQueued, CreatedAt: now, Total: len(req.Batch), changed: make(chan struct{})}
+	if req.Analyze != nil {
+		job.Total = 1
+	}
+	if hook != nil {
+		job.CallbackURL = hook.url
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for id, j := range jobs {
+		if j.FinishedAt != nil && now.Sub(*j.FinishedAt) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+	select {
+	case jobQueue <- queuedJob{ctx: context.WithoutCancel(ctx), id: job.ID, req: req, hook: hook}:
+	default:
+		return Job{}, errJobQueueFull
+	}
+	jobs[job.ID] = job
+	return *job, nil
+}
+
+// runJob analyzes a job's attempts and stores the result
+func runJob(q queuedJob) {
+	now := time.Now()
+	// submitJob holds the lock until the job is added
+	jobsMu.Lock()
+	job := jobs[q.id]
+	job.Status, job.StartedAt = JobRunning, &now
+	job.notifyLocked()
+	jobsMu.Unlock()
+
+	var result *AnalysisResult
+	var results []BatchResult
+	if q.req.Analyze != nil {
+		r := analyzeStrokes(q.ctx, *q.req.Analyze)
+		result = &r
+	} else {
+		results = analyzeBatch(q.ctx, q.req.Batch, func(i int, r BatchResult) {
+			jobsMu.Lock()
+			job.partial = append(job.partial, JobPartial{Index: i, BatchResult: r})
+			job.Completed++
+			job.notifyLocked()
+			jobsMu.Unlock()
+		})
+	}
+
+	now = time.Now()
+	jobsMu.Lock()
+	job.Status, job.FinishedAt = JobDone, &now
+	job.Result, job.Results = result, results
+	job.Completed = job.Total
+	job.notifyLocked()
+	done := *job
+	jobsMu.Unlock()
+	slog.InfoContext(q.ctx, "Finished job", "jobId", q.id, "durationMs", milliseconds(now.Sub(*job.StartedAt)))
+
+	// Retries can take a while, so they don't hold up the next job
+	if q.hook != nil {
+		go webhooks.send(q.ctx, q.hook, webhookEventJobDone, done)
+	}
+}
+
+// notifyLocked wakes whoever is watching the job. The caller must hold jobsMu.
+func (j *Job) notifyLocked() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// progress reports how far along the job is
+func (j *Job) progress() JobProgress {
+	p := JobProgress{Status: j.Status, Completed: j.Completed, Total: j.Total}
+	if j.Total > 0 {
+		p.Percent = 100 * float64(j.Completed) / float64(j.Total)
+	}
+	return p
+}
+
+// lookupJob returns a copy of a job, if it exists
+func lookupJob(id string) (Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// handleSubmitJob queues an analysis to run in the background, answering 202
+// with the job to poll at its Location
+func handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	switch {
+	case (req.Analyze == nil) == (req.Batch == nil):
+		httpError(w, "Expected either analyze or batch", http.StatusBadRequest)
+		return
+	case req.Analyze != nil:
+		if err := validateWorkspaceRequest(r.Context(), req.Analyze); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+	default:
+		if err := validateBatchSize(req.Batch); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if !chargeRate(w, r, len(req.Batch)) {
+			return
+		}
+	}
+
+	hook, err := webhooks.hookFor(r.Context(), req.CallbackURL)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	job, err := submitJob(r.Context(), req, hook)
+	if errors.Is(err, errJobQueueFull) {
+		w.Header().Set("Retry-After", "10")
+		httpError(w, "Too many queued jobs, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	addLogAttrs(r.Context(), "jobId", job.ID)
+
+	w.Header().Set("Location", sitePath(apiPrefix+"/jobs/"+job.ID))
+	writeResponse(w, r, http.StatusAccepted, job)
+}
+
+// handleJob returns a job's status, and its result once it's done
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := lookupJob(r.PathValue("id"))
+	if !ok {
+		httpError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, job)
+}
+
+// sseKeepAlive is how often an idle event stream gets a comment, so proxies
+// don't close it while a long job runs
+const sseKeepAlive = 15 * time.Second
+
+// handleJobEvents streams a job's progress as server-sent events until it's
+// done: a progress event whenever it changes, a result event for each batch
+// attempt as it's analyzed, and a done event with the whole job at the end
+func handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := lookupJob(r.PathValue("id"))
+	if !ok {
+		httpError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	// The stream lasts as long as the job, which may outlast the write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // for nginx
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	sent := 0
+	for {
+		for ; sent < len(job.partial); sent++ {
+			writeEvent(w, "result", job.partial[sent])
+		}
+		if job.Status == JobDone {
+			writeEvent(w, "done", job)
+			rc.Flush()
+			return
+		}
+		writeEvent(w, "progress", job.progress())
+		if rc.Flush() != nil {
+			return
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-job.changed:
+				waiting = false
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				if rc.Flush() != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if job, ok = lookupJob(job.ID); !ok {
+			return
+		}
+	}
+}
+
+// writeEvent writes a server-sent event with v as its JSON data
+func writeEvent(w io.Writer, event string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+)
+
+// ResultLayer is one overlay element rendered on its own transparent PNG, so
+// the frontend can stack the layers and toggle each one
+type ResultLayer struct {
+	Name      string `json:"name"` // the SVG group class, e.g. "vp-rays"
+	ImageData string `json:"imageData"`
+}
+
+// layeredCanvas draws each group onto its own image. Shapes drawn outside a
+// group, including the background, go on a base layer. Each group's image is
+// encoded when the group ends so only two full-size images are held at once.
+type layeredCanvas struct {
+	width, height, scale float64
+	view                 viewport
+
+	base, group *ggCanvas
+	groupName   string
+	baseDrawn   bool
+	groupDrawn  bool
+	layers      []ResultLayer // groups in drawing order, bottom to top
+
+	// Drawing state, re-applied to each new group's canvas
+	color     color.Color
+	lineWidth float64
+	dashes    []float64
+}
+
+func newLayeredCanvas(width, height, scale float64, view viewport) *layeredCanvas {
+	return &layeredCanvas{
+		width: width, height: height, scale: scale, view: view,
+		base:  newGGCanvas(width, height, scale, view),
+		color: color.Black, lineWidth: 1,
+	}
+}
+
+// current returns the canvas shapes are drawn on and marks it as used
+func (c *layeredCanvas) current() *ggCanvas {
+	if c.group != nil {
+		c.groupDrawn = true
+		return c.group
+	}
+	c.baseDrawn = true
+	return c.base
+}
+
+// activeCanvas returns the canvas state changes apply to without marking it
+// as drawn on
+func (c *layeredCanvas) activeCanvas() *ggCanvas {
+	if c.group != nil {
+		return c.group
+	}
+	return c.base
+}
+
+// Layers returns the base layer followed by each group, skipping any that
+// had nothing drawn on them
+func (c *layeredCanvas) Layers() []ResultLayer {
+	if !c.baseDrawn {
+		return c.layers
+	}
+	return append([]ResultLayer{{Name: "background", ImageData: encodeLayer(c.base.dc.Image())}}, c.layers...)
+}
+
+func (c *layeredCanvas) Clear(col color.Color) { c.current().Clear(col) }
+
+func (c *layeredCanvas) SetColor(col color.Color) {
+	c.color = col
+	c.activeCanvas().SetColor(col)
+}
+
+func (c *layeredCanvas) SetLineWidth(w float64) {
+	c.lineWidth = w
+	c.activeCanvas().SetLineWidth(w)
+}
+
+func (c *layeredCanvas) SetDash(dashes ...float64) {
+	c.dashes = dashes
+	c.activeCanvas().SetDash(dashes...)
+}
+
+func (c *layeredCanvas) DrawLine(x1, y1, x2, y2 float64) { c.current().DrawLine(x1, y1, x2, y2) }
+func (c *layeredCanvas) DrawPolyline(points []Point)     { c.current().DrawPolyline(points) }
+func (c *layeredCanvas) FillCircle(x, y, r float64)      { c.current().FillCircle(x, y, r) }
+func (c *layeredCanvas) DrawString(s string, x, y float64) {
+	c.current().DrawString(s, x, y)
+}
+func (c *layeredCanvas) DrawImage(img image.Image, x, y, w, h float64) {
+	c.current().DrawImage(img, x, y, w, h)
+}
+
+// BeginGroup starts a new transparent layer carrying over the drawing state
+func (c *layeredCanvas) BeginGroup(name string) {
+	c.group = newGGCanvas(c.width, c.height, c.scale, c.view)
+	c.groupName = name
+	c.groupDrawn = false
+	c.group.SetColor(c.color)
+	c.group.SetLineWidth(c.lineWidth)
+	c.group.SetDash(c.dashes...)
+}
+
+// EndGroup encodes the group's layer and returns to the base layer
+func (c *layeredCanvas) EndGroup() {
+	if c.group == nil {
+		return
+	}
+	if c.groupDrawn {
+		c.layers = append(c.layers, ResultLayer{Name: c.groupName, ImageData: encodeLayer(c.group.dc.Image())})
+	}
+	c.group = nil
+}
+
+// encodeLayer encodes a layer image as a PNG data URL
+func encodeLayer(img image.Image) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		slog.Error("Failed to encode layer", "err", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// renderLayers draws the visualization with each overlay element on its own
+// PNG
+func renderLayers(v visualization) []ResultLayer {
+	c := newLayeredCanvas(v.req.Width, v.req.Height, v.req.Scale, viewportFor(v))
+	drawVisualization(c, v)
+	return c.Layers()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+)
+
+// leaderboardPeriods are the spans a leaderboard can cover: today, this week
+// from Monday, both in UTC, or all time
+var leaderboardPeriods = []string{"day", "week", "all"}
+
+// LeaderboardQuery picks the attempts a leaderboard ranks
+type LeaderboardQuery struct {
+	WorkspaceID  string
+	TrainingType TrainingType
+	Difficulty   Difficulty
+	Daily        string    // the day of a daily challenge to rank instead, by match with its box
+	Since        time.Time // all time when zero
+	Limit        int
+}
+
+// LeaderboardEntry is a user's best attempt on a leaderboard
+type LeaderboardEntry struct {
+	Rank           int       `json:"rank"` // shared by equal scores
+	Name           string    `json:"name"` // the user's display name, or the provider's name for them
+	UserID         string    `json:"-"`
+	You            bool      `json:"you,omitempty"` // the entry is the caller's
+	CompositeScore float64   `json:"compositeScore"`
+	TargetScore    float64   `json:"targetScore,omitempty"` // on daily challenges, what's ranked
+	ID             string    `json:"id"`                    // not the attempt's
+	AttemptID      string    `json:"-"`
+	ThumbnailURL   string    `json:"thumbnailUrl"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// Leaderboard ranks the best attempts at an exercise over a period
+type Leaderboard struct {
+	TrainingType TrainingType       `json:"trainingType"`
+	Difficulty   Difficulty         `json:"difficulty"`
+	Period       string             `json:"period"`
+	Since        *time.Time         `json:"since,omitempty"`
+	Entries      []LeaderboardEntry `json:"entries"`
+}
+
+// rankable reports whether an attempt's score can be compared with others'.
+// Complete, unassisted attempts count, but not ones helped by a device's
+// noise floor or a time bonus, which rest on what the client reports.
+func rankable(result AnalysisResult) bool {
+	return !result.Partial && !result.Assisted && result.NoiseFloor == 0 && result.TimeModifier <= 0
+}
+
+// strokeFingerprint identifies a drawing by its points, so the same strokes
+// sent again, or by someone else, aren't ranked twice
+func strokeFingerprint(strokes []Stroke) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, stroke := range strokes {
+		for _, p := range stroke {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(p.X))
+			h.Write(buf[:])
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(p.Y))
+			h.Write(buf[:])
+		}
+		h.Write([]byte{0xff}) // ends the stroke
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// periodStart returns when a leaderboard period began, or the zero time for
+// all time
+func periodStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "day":
+		return today
+	case "week":
+		// Weeks start on Monday, as in progress charts
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	}
+	return time.Time{}
+}
+
+// leaderboardID is the ID an attempt is shown under on leaderboards, keyed
+// apart from its gallery one
+func leaderboardID(attemptID string) string {
+	return gallerySigner.mac("leaderboard:" + attemptID)[:22]
+}
+
+// leaderboardThumbnailURL is where the thumbnail of an entry on the
+// leaderboard a query picks is served. The query goes along, so the entry
+// can be found on the same board.
+func leaderboardThumbnailURL(q LeaderboardQuery, id string) string {
+	v := url.Values{}
+	if q.WorkspaceID != "" {
+		v.Set("workspace", q.WorkspaceID)
+	}
+	if q.Daily != "" {
+		v.Set("day", q.Daily)
+	} else {
+		v.Set("difficulty", string(q.Difficulty))
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", strconv.FormatInt(q.Since.Unix(), 10))
+	}
+	return sitePath(apiPrefix + "/leaderboard/" + string(q.TrainingType) + "/" + id + "/thumbnail.png?" + v.Encode())
+}
+
+// rankEntries numbers the entries of the leaderboard a query picks, best
+// first, with equal scores sharing a rank, and marks the caller's
+func rankEntries(ctx context.Context, q LeaderboardQuery, entries []LeaderboardEntry, score func(LeaderboardEntry) float64) {
+	user, _ := userFrom(ctx)
+	for i := range entries {
+		e := &entries[i]
+		e.Rank = i + 1
+		if i > 0 && score(*e) == score(entries[i-1]) {
+			e.Rank = entries[i-1].Rank
+		}
+		e.You = user.ID != "" && e.UserID == user.ID
+		e.ID = leaderboardID(e.AttemptID)
+		e.ThumbnailURL = leaderboardThumbnailURL(q, e.ID)
+	}
+}
+
+// handleLeaderboard ranks the best attempts of users who opted in, in the
+// caller's workspace or outside them all
+func handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := LeaderboardQuery{
+		WorkspaceID:  workspaceID(r.Context()),
+		TrainingType: TrainingType(query.Get("trainingType")),
+		Difficulty:   Difficulty(cmp.Or(query.Get("difficulty"), string(defaultDifficulty))),
+		Limit:        defaultLeaderboardLimit,
+	}
+	if q.TrainingType == "" {
+		writeError(w, fieldError(CodeInvalidValue, "trainingType", "Expected the trainingType to rank"), http.StatusBadRequest)
+		return
+	}
+	if _, ok := getScoringProfile(q.Difficulty); !ok {
+		writeError(w, fieldError(CodeInvalidValue, "difficulty", "Unknown difficulty %q", q.Difficulty), http.StatusBadRequest)
+		return
+	}
+	period := cmp.Or(query.Get("period"), "all")
+	if !slices.Contains(leaderboardPeriods, period) {
+		writeError(w, fieldError(CodeInvalidValue, "period", "Unknown period %q, expected one of %v", period, leaderboardPeriods), http.StatusBadRequest)
+		return
+	}
+	q.Since = periodStart(period, time.Now())
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLeaderboardLimit {
+			writeError(w, fieldError(CodeOutOfRange, "limit", "Limit must be between 1 and %d", maxLeaderboardLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	entries, err := store.Leaderboard(q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load leaderboard", "err", err)
+		httpError(w, "Failed to load leaderboard", http.StatusInternalServerError)
+		return
+	}
+	rankEntries(r.Context(), q, entries, func(e LeaderboardEntry) float64 { return e.CompositeScore })
+
+	board := Leaderboard{TrainingType: q.TrainingType, Difficulty: q.Difficulty, Period: period, Entries: entries}
+	if !q.Since.IsZero() {
+		board.Since = &q.Since
+	}
+	writeResponse(w, r, http.StatusOK, board)
+}
+
+// handleLeaderboardThumbnail serves the thumbnail of an attempt while it's on
+// the leaderboard its URL's query picks. Looking among as many entries as a
+// board can list finds any a board can show.
+func handleLeaderboardThumbnail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := LeaderboardQuery{
+		WorkspaceID:  workspaceID(r.Context()),
+		TrainingType: TrainingType(r.PathValue("trainingType")),
+		Difficulty:   Difficulty(query.Get("difficulty")),
+		Daily:        query.Get("day"),
+		Limit:        maxLeaderboardLimit,
+	}
+	if v := query.Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			httpError(w, "Not on the leaderboard", http.StatusNotFound)
+			return
+		}
+		q.Since = time.Unix(n, 0)
+	}
+	entries, err := store.Leaderboard(q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load leaderboard", "err", err)
+		httpError(w, "Failed to load thumbnail", http.StatusInternalServerError)
+		return
+	}
+	id := r.PathValue("id")
+	for _, e := range entries {
+		if leaderboardID(e.AttemptID) != id {
+			continue
+		}
+		data, err := loadThumbnail(e.AttemptID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to render thumbnail", "attemptId", e.AttemptID, "err", err)
+			httpError(w, "Failed to load thumbnail", http.StatusInternalServerError)
+			return
+		}
+		// Not immutable, as the attempt may be beaten or the user leave
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		http.ServeContent(w, r, "thumbnail.png", time.Time{}, bytes.NewReader(data))
+		return
+	}
+	httpError(w, "Not on the leaderboard", http.StatusNotFound)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Live message types
+const (
+	LiveStart  = "start"  // client: set up the exercise, clearing any strokes
+	LivePoints = "points" // client: points of the stroke being drawn
+	LiveEnd    = "end"    // client: the stroke being drawn is finished
+	LiveStroke = "stroke" // client: a whole stroke at once
+	LiveUndo   = "undo"   // client: remove the last stroke
+	LiveClear  = "clear"  // client: remove every stroke
+	LiveUpdate = "update" // server: the analysis of the strokes so far
+	LiveError  = "error"  // server: a message was rejected
+)
+
+// liveIdleTimeout is how long a live connection may go without a message
+const liveIdleTimeout = 10 * time.Minute
+
+// maxLiveStrokePoints caps the stroke in progress, which is otherwise only
+// limited by the size of each message
+const maxLiveStrokePoints = 10000
+
+// LiveMessage is a message sent over a live connection. Clients set type
+// and, depending on it, request or points; the server sends updates and errors.
+type LiveMessage struct {
+	Type    string           `json:"type"`
+	Request *AnalysisRequest `json:"request,omitempty"` // for start; strokes are ignored
+	Points  []Point          `json:"points,omitempty"`  // for points, end and stroke
+	Update  *LiveAnalysis    `json:"update,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// LiveAnalysis is the provisional analysis sent after each finished stroke
+type LiveAnalysis struct {
+	Strokes           int        `json:"strokes"`
+	ExpectedStrokes   int        `json:"expectedStrokes"`
+	Lines             []LiveLine `json:"lines"`
+	LeftVP            *Point     `json:"leftVP"`
+	RightVP           *Point     `json:"rightVP"`
+	ConvergenceErrorL float64    `json:"convergenceErrorL"`
+	ConvergenceErrorR float64    `json:"convergenceErrorR"`
+	AverageLineScore  float64    `json:"averageLineScore"`
+	PerspectiveScore  float64    `json:"perspectiveScore"`
+	CompositeScore    float64    `json:"compositeScore"`
+	Feedback          []string   `json:"feedback"`
+}
+
+// LiveLine is the line fitted to one stroke
+type LiveLine struct {
+	From  Point   `json:"from"` // ends of the ideal line over the stroke
+	To    Point   `json:"to"`
+	Angle float64 `json:"angle"`
+	Score float64 `json:"score"`
+	Group string  `json:"group,omitempty"` // vertical, left or right, once clustered
+}
+
+// liveSession is the drawing of one live connection
+type liveSession struct {
+	ctx     context.Context // the connection's, for its workspace
+	req     AnalysisRequest
+	started bool
+	drawing Stroke // the stroke in progress
+}
+
+// handleLive upgrades to a WebSocket for live analysis. Browsers may only
+// connect from this site or an origin allowed by CORS, so other sites can't
+// use a visitor's session.
+func handleLive(cfg config) http.HandlerFunc {
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.MaxPayloadBytes = int(cfg.maxBodyBytes)
+		serveLive(ws)
+	}}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !corsAllowed(cfg.corsOrigins, origin) {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				httpError(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		server.ServeHTTP(w, r)
+	}
+}
+
+// serveLive analyzes strokes as they're drawn, answering every finished
+// stroke with the line fits and provisional vanishing points so far.
+// Nothing is rendered or recorded in the history.
+func serveLive(ws *websocket.Conn) {
+	ctx := ws.Request().Context()
+	defer ws.Close()
+	s := liveSession{ctx: ctx}
+	for {
+		// The server's read and write timeouts are for requests, not for a
+		// connection that stays open while someone draws
+		ws.SetDeadline(time.Now().Add(liveIdleTimeout))
+		var msg LiveMessage
+		err := websocket.JSON.Receive(ws, &msg)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr) || errors.As(err, &typeErr):
+			err = errors.New("Invalid message")
+		case errors.Is(err, io.EOF):
+			return
+		case err != nil:
+			slog.DebugContext(ctx, "Live connection closed", "err", err)
+			return
+		}
+
+		reply := LiveMessage{}
+		if err == nil {
+			reply, err = s.handle(msg)
+		}
+		if err != nil {
+			reply = LiveMessage{Type: LiveError, Error: err.Error()}
+		}
+		if reply.Type == "" {
+			continue
+		}
+		if err := websocket.JSON.Send(ws, reply); err != nil {
+			return
+		}
+	}
+}
+
+// handle applies a client message, returning the update to send, if any
+func (s *liveSession) handle(msg LiveMessage) (LiveMessage, error) {
+	if msg.Type != LiveStart && !s.started {
+		return LiveMessage{}, errors.New("Send start first")
+	}
+
+	switch msg.Type {
+	case LiveStart:
+		if msg.Request == nil {
+			return LiveMessage{}, errors.New("Send the exercise settings in request")
+		}
+		req := *msg.Request
+		if !(req.Width > 0 && req.Width <= maxCanvasSize && req.Height > 0 && req.Height <= maxCanvasSize) {
+			return LiveMessage{}, fmt.Errorf("Width and height must be more than 0 and at most %g", maxCanvasSize)
+		}
+		// Only the scores are sent, so the reference photo isn't needed
+		req.Strokes, req.Background = nil, ""
+		*s = liveSession{ctx: s.ctx, req: req, started: true}
+		return LiveMessage{}, nil
+	case LivePoints:
+		if len(s.drawing)+len(msg.Points) > maxLiveStrokePoints {
+			s.drawing = nil
+			return LiveMessage{}, fmt.Errorf("A stroke can have at most %d points", maxLiveStrokePoints)
+		}
+		s.drawing = append(s.drawing, msg.Points...)
+		return LiveMessage{}, nil
+	case LiveEnd, LiveStroke:
+		stroke := append(s.drawing, msg.Points...)
+		s.drawing = nil
+		if len(stroke) > maxLiveStrokePoints {
+			return LiveMessage{}, fmt.Errorf("A stroke can have at most %d points", maxLiveStrokePoints)
+		}
+		if len(stroke) < 2 {
+			return LiveMessage{}, errors.New("A stroke needs at least 2 points")
+		}
+		s.req.Strokes = append(s.req.Strokes, stroke)
+		update, err := s.analyze()
+		if err != nil {
+			s.req.Strokes = s.req.Strokes[:len(s.req.Strokes)-1]
+		}
+		return update, err
+	case LiveUndo:
+		s.drawing = nil
+		strokes := s.req.Strokes
+		if len(strokes) > 0 {
+			s.req.Strokes = strokes[:len(strokes)-1]
+		}
+		update, err := s.analyze()
+		if err != nil {
+			s.req.Strokes = strokes
+		}
+		return update, err
+	case LiveClear:
+		s.drawing = nil
+		s.req.Strokes = nil
+		return s.analyze()
+	}
+	return LiveMessage{}, fmt.Errorf("Unknown message type %q", msg.Type)
+}
+
+// analyze scores the finished strokes. Each time takes a token from the
+// client's rate limit, so one connection can't score without end.
+func (s *liveSession) analyze() (LiveMessage, error) {
+	if len(s.req.Strokes) == 0 {
+		return LiveMessage{Type: LiveUpdate, Update: &LiveAnalysis{Lines: []LiveLine{}, Feedback: []string{}}}, nil
+	}
+	if charge, ok := s.ctx.Value(rateChargeKey{}).(func(int) (bool, time.Duration)); ok {
+		if ok, wait := charge(1); !ok {
+			return LiveMessage{}, fmt.Errorf("Too many requests, slow down; retry in %ds", int(math.Ceil(wait.Seconds())))
+		}
+	}
+	req := s.req
+	if err := validateWorkspaceRequest(s.ctx, &req); err != nil {
+		return LiveMessage{}, err
+	}
+	req.owner = ownerID(s.ctx)
+	result, vis := scoreStrokes(req)
+
+	lines := make([]LiveLine, len(req.Strokes))
+	for i, stroke := range req.Strokes {
+		from, to := idealSegment(stroke, vis.lines[i])
+		lines[i] = LiveLine{From: from, To: to, Angle: vis.lines[i].Angle, Score: vis.lines[i].Score}
+	}
+	for group, indices := range map[string][]int{"vertical": vis.verticals, "left": vis.leftGroup, "right": vis.rightGroup} {
+		for _, i := range indices {
+			lines[i].Group = group
+		}
+	}
+
+	return LiveMessage{Type: LiveUpdate, Update: &LiveAnalysis{
+		Strokes:           len(req.Strokes),
+		ExpectedStrokes:   result.ExpectedStrokes,
+		Lines:             lines,
+		LeftVP:            result.LeftVP,
+		RightVP:           result.RightVP,
+		ConvergenceErrorL: result.ConvergenceErrorL,
+		ConvergenceErrorR: result.ConvergenceErrorR,
+		AverageLineScore:  result.AverageLineScore,
+		PerspectiveScore:  result.PerspectiveScore,
+		CompositeScore:    result.CompositeScore,
+		Feedback:          result.Feedback,
+	}}, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// maxLoggedErrorBytes caps how much of an error response is copied into the
+// access log
+const maxLoggedErrorBytes = 512
+
+// validRequestID matches request IDs accepted from a proxy's X-Request-ID
+// header, so they can't inject anything into logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// setupLogging makes slog's default logger write in the configured format
+// and level. The standard log package writes through it too.
+func setupLogging(cfg config) {
+	opts := &slog.HandlerOptions{Level: cfg.logLevel}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if cfg.logFormat == LogFormatJSON {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+}
+
+// fatal logs an error and exits, for failures at startup
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLog is the per-request state the logging middleware keeps in the
+// request context: its ID, and attributes handlers add to its access log line
+type requestLog struct {
+	id string
+
+	mu    sync.Mutex
+	attrs []any
+}
+
+type requestLogKey struct{}
+
+// requestIDHandler adds the request ID to every record logged with the
+// request's context
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		r.AddAttrs(slog.String("requestId", rl.id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// addLogAttrs adds key-value pairs to the request's access log line, such as
+// the attempt ID and how long the analysis took
+func addLogAttrs(ctx context.Context, args ...any) {
+	rl, ok := ctx.Value(requestLogKey{}).(*requestLog)
+	if !ok {
+		return
+	}
+	rl.mu.Lock()
+	rl.attrs = append(rl.attrs, args...)
+	rl.mu.Unlock()
+}
+
+// newRequestID returns a random ID for a request without one
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestLogging assigns each request an ID, taken from X-Request-ID when
+// a proxy set one, returns it in the response, and writes an access log line
+// when the request finishes. Error responses have the start of their message
+// logged so failed analyses can be diagnosed.
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		rl := &requestLog{id: id}
+		ctx := context.WithValue(r.Context(), requestLogKey{}, rl)
+		w.Header().Set("X-Request-ID", id)
+
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", lw.status,
+			"bytes", lw.bytes,
+			"durationMs", milliseconds(time.Since(start)),
+			"remote", r.RemoteAddr,
+			"proto", r.Proto,
+		}
+		if lw.status >= 400 && len(lw.errorBody) > 0 {
+			var resp ErrorResponse
+			if json.Unmarshal(lw.errorBody, &resp) == nil && resp.Error != nil {
+				attrs = append(attrs, "error", resp.Error.Message, "errorCode", resp.Error.Code)
+			} else {
+				attrs = append(attrs, "error", string(lw.errorBody))
+			}
+		}
+		rl.mu.Lock()
+		attrs = append(attrs, rl.attrs...)
+		rl.mu.Unlock()
+
+		level := slog.LevelInfo
+		switch {
+		case lw.status >= 500:
+			level = slog.LevelError
+		case lw.status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "Request", attrs...)
+	})
+}
+
+// milliseconds rounds a duration to fractional milliseconds for logging
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// loggingResponseWriter records the status and size of a response, and the
+// start of error messages
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int
+	wrote     bool
+	errorBody []byte
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status = status
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	if w.status >= 400 && len(w.errorBody) < maxLoggedErrorBytes {
+		n := min(len(b), maxLoggedErrorBytes-len(w.errorBody))
+		w.errorBody = append(w.errorBody, bytes.TrimSpace(b[:n])...)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Hijack hands the connection over for WebSockets, which are logged as 101
+// when they're set up
+func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && !w.wrote {
+		w.status, w.wrote = http.StatusSwitchingProtocols, true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// parseLogLevel parses a level name such as "debug" or "warn"
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
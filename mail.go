@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// mailer is set at startup when an SMTP server is given
+var mailer *mailSender
+
+// mailSender emails users through an SMTP server, which is sent STARTTLS
+// when it offers it
+type mailSender struct {
+	addr string
+	from string
+	auth smtp.Auth // nil when the server doesn't need signing in to
+}
+
+// newMailSender sets up email, or returns nil when there's no SMTP server
+func newMailSender(cfg config) *mailSender {
+	if cfg.smtpAddr == "" {
+		return nil
+	}
+	m := &mailSender{addr: cfg.smtpAddr, from: cfg.smtpFrom}
+	if cfg.smtpUsername != "" {
+		host, _, _ := net.SplitHostPort(cfg.smtpAddr)
+		m.auth = smtp.PlainAuth("", cfg.smtpUsername, cfg.smtpPassword, host)
+	}
+	return m
+}
+
+// mailAttachment is a file sent with an email
+type mailAttachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// send emails a plain text message, with any attachments
+func (m *mailSender) send(to, subject, body string, attachments ...mailAttachment) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid address %q", to)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	text := strings.ReplaceAll(body, "\n", "\r\n")
+	if len(attachments) == 0 {
+		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n" + text)
+		return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg.String()))
+	}
+
+	boundary := "tradra-" + newAttemptID()
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, text)
+	for _, a := range attachments {
+		fmt.Fprintf(&msg, "--%s\r\nContent-Type: %s\r\nContent-Transfer-Encoding: base64\r\n", boundary, a.contentType)
+		fmt.Fprintf(&msg, "Content-Disposition: attachment; filename=%q\r\n\r\n", a.name)
+		// Lines of base64 can be at most 76 characters
+		encoded := base64.StdEncoding.EncodeToString(a.data)
+		for len(encoded) > 76 {
+			msg.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		msg.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&msg, "--%s--\r\n", boundary)
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg.String()))
+}
@@ -1,22 +1,16 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"embed"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"image/color"
-	"image/png"
-	"log"
+	"image"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
-	"path/filepath"
 	"sort"
 	"time"
-
-	"github.com/fogleman/gg"
 )
 
 //go:embed static/*
@@ -33,10 +27,11 @@ const (
 	ThreePointPerspective TrainingType = "3point"
 )
 
-// Point represents a 2D coordinate
+// Point represents a 2D coordinate, optionally with a timestamp in milliseconds
 type Point struct {
 	X float64 `json:"x"`
 	Y float64 `json:"y"`
+	T float64 `json:"t,omitempty"`
 }
 
 // Stroke represents a series of points
@@ -44,10 +39,33 @@ type Stroke []Point
 
 // AnalysisRequest contains the strokes to analyze
 type AnalysisRequest struct {
-	Strokes      []Stroke     `json:"strokes"`
-	Width        float64      `json:"width"`
-	Height       float64      `json:"height"`
-	TrainingType TrainingType `json:"trainingType"`
+	Strokes      []Stroke       `json:"strokes"`
+	Width        float64        `json:"width"`
+	Height       float64        `json:"height"`
+	TrainingType TrainingType   `json:"trainingType"`
+	DeviceID     string         `json:"deviceId"`
+	Difficulty   Difficulty     `json:"difficulty"`
+	Target       *TargetRequest `json:"target,omitempty"`
+	Format       string         `json:"format"` // "png" (default), "svg", "gif" or "pdf"
+	Theme        *Theme         `json:"theme,omitempty"`
+	Style        *Style         `json:"style,omitempty"`
+	Scale        float64        `json:"scale,omitempty"`           // device pixel ratio for the PNG, 1 to maxScale
+	Heatmap      bool           `json:"heatmap"`                   // color strokes by deviation instead of flat gray
+	FitVPs       bool           `json:"fitVanishingPoints"`        // zoom out so off-canvas VPs are in frame
+	GridRays     int            `json:"gridRays,omitempty"`        // perspective grid density: rays per image height from each VP
+	Locale       string         `json:"locale,omitempty"`          // language of rendered labels, e.g. "de" or "fr-CA"
+	Transparent  bool           `json:"transparent"`               // no background or stroke redraw, for compositing
+	Background   string         `json:"backgroundImage,omitempty"` // base64 PNG or JPEG drawn under the overlay
+	OmitImage    bool           `json:"omitImageData"`             // leave imageData/svg out and fetch imageUrl instead
+	Layers       bool           `json:"layers"`                    // also return each overlay element as its own PNG
+	SkipRender   bool           `json:"skipRender"`                // numbers only, for live feedback; nothing is saved
+	Antialias    string         `json:"antialias,omitempty"`       // PNG quality: "standard" (default) or "high"
+	DPI          float64        `json:"dpi,omitempty"`             // print resolution written to the PNG
+	Timed        bool           `json:"timed"`
+	ElapsedMs    float64        `json:"elapsedMs,omitempty"`
+
+	background image.Image // decoded Background, set by validateAnalysisRequest
+	owner      string      // whose calibration of DeviceID applies, set by analyzeStrokes
 }
 
 // Line represents a line in y = mx + b form
@@ -61,93 +79,433 @@ type Line struct {
 
 // AnalysisResult contains the analysis output
 type AnalysisResult struct {
-	ImageData          string       `json:"imageData"`
-	LineScores         []float64    `json:"lineScores"`
-	AverageLineScore   float64      `json:"averageLineScore"`
-	LeftVP             *Point       `json:"leftVP"`
-	RightVP            *Point       `json:"rightVP"`
-	ConvergenceErrorL  float64      `json:"convergenceErrorL"`
-	ConvergenceErrorR  float64      `json:"convergenceErrorR"`
-	PerspectiveScore   float64      `json:"perspectiveScore"`
-	SavedFilePath      string       `json:"savedFilePath"`
+	AttemptID             string         `json:"attemptId"`
+	ImageData             string         `json:"imageData"`
+	ImageURL              string         `json:"imageUrl,omitempty"`
+	ThumbnailURL          string         `json:"thumbnailUrl,omitempty"`
+	SVG                   string         `json:"svg,omitempty"`
+	Layers                []ResultLayer  `json:"layers,omitempty"`
+	LineScores            []float64      `json:"lineScores"`
+	StrokeTags            [][]string     `json:"strokeTags"`
+	AverageLineScore      float64        `json:"averageLineScore"`
+	NoiseFloor            float64        `json:"noiseFloor"`
+	LeftVP                *Point         `json:"leftVP"`
+	RightVP               *Point         `json:"rightVP"`
+	ConvergenceErrorL     float64        `json:"convergenceErrorL"`
+	ConvergenceErrorR     float64        `json:"convergenceErrorR"`
+	LeftConvergenceScore  float64        `json:"leftConvergenceScore"`
+	RightConvergenceScore float64        `json:"rightConvergenceScore"`
+	VerticalScore         float64        `json:"verticalScore"`
+	PerspectiveScore      float64        `json:"perspectiveScore"`
+	ConvergenceScore      float64        `json:"convergenceScore"`
+	ProportionScore       float64        `json:"proportionScore"`
+	CompositionScore      float64        `json:"compositionScore"`
+	CompositeScore        float64        `json:"compositeScore"`
+	ElapsedSeconds        float64        `json:"elapsedSeconds,omitempty"`
+	TimeModifier          float64        `json:"timeModifier"`
+	Percentile            *float64       `json:"percentile"` // against the caller's own earlier attempts
+	Difficulty            Difficulty     `json:"difficulty"`
+	Partial               bool           `json:"partial"`
+	Assisted              bool           `json:"assisted"`
+	AssistedStrokes       []int          `json:"assistedStrokes"`
+	ExpectedStrokes       int            `json:"expectedStrokes"`
+	Grade                 Grade          `json:"grade"`
+	TargetMatch           *TargetMatch   `json:"targetMatch,omitempty"`
+	Daily                 string         `json:"daily,omitempty"` // the day of the daily challenge it was entered in
+	Feedback              []string       `json:"feedback"`
+	SavedFilePath         string         `json:"savedFilePath"`
+	Streak                *Streak        `json:"streak,omitempty"`        // the signed-in user's, with this attempt
+	PersonalBests         []PersonalBest `json:"personalBests,omitempty"` // the metrics this attempt beat its maker's best at
 }
 
 func main() {
+	cfg, err := parseConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	setupLogging(cfg)
+	basePath = cfg.basePath
+
+	if cfg.restore != "" {
+		aside, err := restoreBackup(cfg.restore)
+		if err != nil {
+			fatal("Failed to restore backup", "file", cfg.restore, "err", err)
+		}
+		if aside != "" {
+			slog.Info("Moved the previous results aside", "dir", aside)
+		}
+	}
+
 	// Create results directory if it doesn't exist
 	if err := os.MkdirAll(resultsDir, 0755); err != nil {
-		log.Fatalf("Failed to create results directory: %v", err)
+		fatal("Failed to create results directory", "err", err)
 	}
 
-	http.HandleFunc("/", serveIndex)
-	http.HandleFunc("/analyze", handleAnalyze)
+	if store, err = openStorage(cfg.database); err != nil {
+		fatal("Failed to open the database", "err", err)
+	}
+	if objects, err = newObjectStore(cfg); err != nil {
+		fatal("Failed to set up object storage", "err", err)
+	}
+	go runRetention(cfg)
 
-	port := "8080"
-	fmt.Printf("Server starting on http://localhost:%s\n", port)
-	fmt.Printf("Results will be saved to: %s/\n", resultsDir)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
+	if err := loadSettings(cfg); err != nil {
+		fatal("Failed to load settings", "file", settingsFile, "err", err)
+	}
+	go reloadSettingsOnHangup(cfg)
+	setMaintenance(Maintenance{Enabled: cfg.maintenance})
 
-func serveIndex(w http.ResponseWriter, r *http.Request) {
-	data, err := staticFiles.ReadFile("static/index.html")
+	wm, err := loadWatermark()
 	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+		fatal("Failed to load watermark", "err", err)
 	}
-	w.Header().Set("Content-Type", "text/html")
-	w.Write(data)
+	siteWatermark = wm
+
+	site, err := loadStaticSite(staticRoot(cfg))
+	if err != nil {
+		fatal("Failed to load static files", "dir", cfg.staticDir, "err", err)
+	}
+	http.HandleFunc("/", site.handleIndex)
+	http.HandleFunc("GET /static/{path...}", site.handleAsset)
+	// Unknown API paths are errors rather than the page
+	http.HandleFunc("/api/", handleNotFound)
+	http.HandleFunc("GET /healthz", handleHealthz)
+	http.HandleFunc("GET /readyz", handleReadyz)
+	http.HandleFunc("GET "+openAPIPath, handleOpenAPI)
+	handleAPI("/analyze", requireAPIKey(rateLimit(idempotent(handleAnalyze, cfg), cfg), cfg))
+	handleAPI("/analyze/batch", requireAPIKey(rateLimit(idempotent(handleAnalyzeBatch, cfg), cfg), cfg))
+	// Jobs are new in v1, so they have no unversioned path
+	startJobWorkers(cfg.jobWorkers)
+	webhooks = newWebhookSender(cfg)
+	http.HandleFunc("POST "+apiPrefix+"/jobs", requireAPIKey(rateLimit(idempotent(handleSubmitJob, cfg), cfg), cfg))
+	http.HandleFunc("GET "+apiPrefix+"/jobs/{id}", requireAPIKey(handleJob, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/jobs/{id}/events", requireAPIKey(handleJobEvents, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/ws", requireAPIKey(rateLimit(handleLive(cfg), cfg), cfg))
+	handleAPI("/calibrate", requireAPIKey(rateLimit(idempotent(handleCalibrate, cfg), cfg), cfg))
+	handleAPI("/target", requireAPIKey(handleTarget, cfg))
+	handleAPI("/progress", requireAPIKey(handleProgress, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/progress/chart", requireAPIKey(handleProgressChart, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/next-exercise", requireAPIKey(handleNextExercise, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/personal-bests", requireAPIKey(handlePersonalBests, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/achievements", requireAPIKey(handleAchievements, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/leaderboard", requireAPIKey(handleLeaderboard, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/daily", requireAPIKey(handleDaily, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/attempts", requireAPIKey(handleListAttempts, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/attempts/export", requireAPIKey(handleExportAttempts, cfg))
+	http.HandleFunc("POST "+apiPrefix+"/attempts/import", requireAPIKey(handleImportAttempts, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/attempts/{id}", requireAPIKey(handleAttempt, cfg))
+	http.HandleFunc("PUT "+apiPrefix+"/attempts/{id}/notes", requireAPIKey(handlePutAttemptNotes, cfg))
+	http.HandleFunc("GET "+apiPrefix+"/attempts/{id}/replay", requireAPIKey(handleReplay, cfg))
+	handleAPI("/compare", requireAPIKey(handleCompare, cfg))
+	handleAPI("/report", requireAPIKey(handleReport, cfg))
+	// Saved results stay public so shared links and <img> tags work
+	handleAPI("GET /result/{id}/{file}", handleResultImage)
+	handleAPI("GET /result/{id}/thumbnail.png", handleThumbnail)
+	// So is the gallery, which shows nothing of who made its attempts
+	gallerySigner = newCookieSigner(cfg.sessionSecret)
+	http.HandleFunc("GET "+apiPrefix+"/gallery", handleGallery)
+	http.HandleFunc("GET "+apiPrefix+"/gallery/{trainingType}/{id}/thumbnail.png", handleGalleryThumbnail)
+	// Leaderboard thumbnails too, under IDs keyed like the gallery's
+	http.HandleFunc("GET "+apiPrefix+"/leaderboard/{trainingType}/{id}/thumbnail.png", handleLeaderboardThumbnail)
+
+	// Sign-in routes exist only when a login provider is configured
+	if cfg.loginProvider != "" {
+		if login, err = newAuth(cfg); err != nil {
+			fatal("Failed to set up login", "err", err)
+		}
+		http.HandleFunc("GET /auth/login", login.handleLogin)
+		http.HandleFunc("GET /auth/callback", login.handleCallback)
+		http.HandleFunc("POST /auth/logout", login.handleLogout)
+		http.HandleFunc("GET "+apiPrefix+"/me", handleMe)
+		http.HandleFunc("GET "+apiPrefix+"/me/profile", handleGetProfile)
+		http.HandleFunc("PUT "+apiPrefix+"/me/profile", handlePutProfile)
+		http.HandleFunc("GET "+apiPrefix+"/me/goals", handleListGoals)
+		http.HandleFunc("POST "+apiPrefix+"/me/goals", handleCreateGoal)
+		http.HandleFunc("DELETE "+apiPrefix+"/me/goals/{id}", handleDeleteGoal)
+		http.HandleFunc("POST "+apiPrefix+"/me/devices/link", handleCreateDeviceLink)
+		http.HandleFunc("POST "+apiPrefix+"/devices/link", rateLimit(handleLinkDevice, cfg))
+		http.HandleFunc("GET "+apiPrefix+"/me/sync/{name}", handleGetSyncItem)
+		http.HandleFunc("PUT "+apiPrefix+"/me/sync/{name}", handlePutSyncItem)
+		http.HandleFunc("DELETE "+apiPrefix+"/me/sync/{name}", handleDeleteSyncItem)
+		mailer = newMailSender(cfg)
+		go runGoalReminders()
+		go runWeeklyDigests(cfg)
+		http.HandleFunc("GET "+apiPrefix+"/me/data", handleAccountData)
+		http.HandleFunc("DELETE "+apiPrefix+"/me", handleDeleteAccount(cfg))
+		go runAccountPurges(cfg.deletionGrace)
+		http.HandleFunc("GET "+apiPrefix+"/attempts/{id}/comments", handleListComments)
+		http.HandleFunc("POST "+apiPrefix+"/attempts/{id}/comments", handleCreateComment)
+		http.HandleFunc("PUT "+apiPrefix+"/attempts/{id}/comments/settings", handlePutCommentSettings)
+		http.HandleFunc("DELETE "+apiPrefix+"/attempts/{id}/comments/{comment}", handleDeleteComment)
+		http.HandleFunc("POST "+apiPrefix+"/attempts/{id}/comments/{comment}/report", handleReportComment)
+		http.HandleFunc("GET "+apiPrefix+"/workspaces", handleMyWorkspaces)
+		http.HandleFunc("GET "+apiPrefix+"/workspaces/{workspace}", requireWorkspaceAdmin(handleWorkspace))
+		http.HandleFunc("PUT "+apiPrefix+"/workspaces/{workspace}/members/{user}", requireWorkspaceAdmin(handleSetMember))
+		http.HandleFunc("DELETE "+apiPrefix+"/workspaces/{workspace}/members/{user}", requireWorkspaceAdmin(handleRemoveMember))
+		http.HandleFunc("PUT "+apiPrefix+"/workspaces/{workspace}/exercises", requireWorkspaceAdmin(handleSetExercises))
+		http.HandleFunc("GET "+apiPrefix+"/workspaces/{workspace}/stats", requireWorkspaceAdmin(handleWorkspaceStats))
+		http.HandleFunc("GET "+apiPrefix+"/workspaces/{workspace}/classes", handleListClasses)
+		http.HandleFunc("POST "+apiPrefix+"/workspaces/{workspace}/classes", requireWorkspaceAdmin(handleCreateClass))
+		http.HandleFunc("GET "+apiPrefix+"/workspaces/{workspace}/classes/{class}", requireClassMember(false, handleClass))
+		http.HandleFunc("DELETE "+apiPrefix+"/workspaces/{workspace}/classes/{class}", requireWorkspaceAdmin(handleDeleteClass))
+		http.HandleFunc("GET "+apiPrefix+"/workspaces/{workspace}/classes/{class}/stats", requireClassMember(true, handleClassStats))
+		http.HandleFunc("PUT "+apiPrefix+"/workspaces/{workspace}/classes/{class}/members/{user}", requireClassMember(true, handleSetClassMember))
+		http.HandleFunc("DELETE "+apiPrefix+"/workspaces/{workspace}/classes/{class}/members/{user}", requireClassMember(true, handleRemoveClassMember))
+		http.HandleFunc("POST "+apiPrefix+"/workspaces/{workspace}/classes/{class}/assignments", requireClassMember(true, handleCreateAssignment))
+		http.HandleFunc("DELETE "+apiPrefix+"/workspaces/{workspace}/classes/{class}/assignments/{assignment}", requireClassMember(true, handleDeleteAssignment))
+	}
+
+	// Admin endpoints exist only when an admin token is set
+	loadEnvAPIKeys()
+	if cfg.adminToken != "" {
+		http.HandleFunc("GET "+apiPrefix+"/admin/keys", requireAdmin(handleListAPIKeys, cfg))
+		http.HandleFunc("POST "+apiPrefix+"/admin/keys", requireAdmin(handleCreateAPIKey, cfg))
+		http.HandleFunc("DELETE "+apiPrefix+"/admin/keys/{name}", requireAdmin(handleDeleteAPIKey, cfg))
+		http.HandleFunc("GET "+apiPrefix+"/admin/workspaces", requireAdmin(handleListWorkspaces, cfg))
+		http.HandleFunc("POST "+apiPrefix+"/admin/workspaces", requireAdmin(handleCreateWorkspace, cfg))
+		http.HandleFunc("DELETE "+apiPrefix+"/admin/workspaces/{workspace}", requireAdmin(handleDeleteWorkspace, cfg))
+		http.HandleFunc("GET "+apiPrefix+"/admin/settings", requireAdmin(handleGetSettings, cfg))
+		http.HandleFunc("PUT "+apiPrefix+"/admin/settings", requireAdmin(handlePutSettings, cfg))
+		http.HandleFunc("POST "+apiPrefix+"/admin/settings/reload", requireAdmin(handleReloadSettings(cfg), cfg))
+		http.HandleFunc("GET "+apiPrefix+"/admin/comments", requireAdmin(handleListReportedComments, cfg))
+		http.HandleFunc("DELETE "+apiPrefix+"/admin/comments/{comment}", requireAdmin(handleAdminDeleteComment, cfg))
+		http.HandleFunc("DELETE "+apiPrefix+"/admin/comments/{comment}/reports", requireAdmin(handleClearCommentReports, cfg))
+		http.HandleFunc("GET "+apiPrefix+"/admin/backup", requireAdmin(handleBackup, cfg))
+		http.HandleFunc("GET "+apiPrefix+"/admin/maintenance", requireAdmin(handleGetMaintenance, cfg))
+		http.HandleFunc("PUT "+apiPrefix+"/admin/maintenance", requireAdmin(handlePutMaintenance, cfg))
+	}
+
+	slog.Info("Server starting", "url", cfg.displayURL(), "results", resultsDir+"/")
+	if err := serve(cfg); err != nil && err != http.ErrServerClosed {
+		fatal("Server failed", "err", err)
+	}
+	if err := store.Close(); err != nil {
+		slog.Error("Failed to close the database", "err", err)
+	}
+	slog.Info("Server stopped")
 }
 
 func handleAnalyze(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req AnalysisRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
+	if err := validateWorkspaceRequest(r.Context(), &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result := analyzeStrokes(r.Context(), req)
+
+	writeResponse(w, r, http.StatusOK, result)
+}
+
+// validateAnalysisRequest fills in defaults and rejects requests that can't be analyzed
+func validateAnalysisRequest(req *AnalysisRequest) error {
 	// Set default training type if not specified
 	if req.TrainingType == "" {
 		req.TrainingType = TwoPointPerspective
 	}
 
-	// Validate stroke count based on training type
-	expectedStrokes := getExpectedStrokeCount(req.TrainingType)
-	if len(req.Strokes) != expectedStrokes {
-		http.Error(w, fmt.Sprintf("Expected exactly %d strokes for %s", expectedStrokes, req.TrainingType), http.StatusBadRequest)
-		return
+	// Set default difficulty and reject unknown ones
+	if req.Difficulty == "" {
+		req.Difficulty = defaultDifficulty
+	}
+	if _, ok := getScoringProfile(req.Difficulty); !ok {
+		return fieldError(CodeInvalidValue, "difficulty", "Unknown difficulty %q", req.Difficulty)
 	}
 
-	result := analyzeStrokes(req)
+	// Validate the output format
+	switch req.Format {
+	case "", FormatPNG, FormatSVG, FormatGIF, FormatPDF:
+	default:
+		return fieldError(CodeInvalidValue, "format", "Unknown format %q", req.Format)
+	}
+	if req.Layers && req.Format != "" && req.Format != FormatPNG {
+		return fieldError(CodeInvalidValue, "layers", "Layers are only rendered for PNG output; SVG output groups each layer already")
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
-}
+	// The canvas size scales the scores and the rendered image
+	if !(req.Width > 0 && req.Width <= maxCanvasSize) {
+		return fieldError(CodeOutOfRange, "width", "Width must be more than 0 and at most %g", maxCanvasSize)
+	}
+	if !(req.Height > 0 && req.Height <= maxCanvasSize) {
+		return fieldError(CodeOutOfRange, "height", "Height must be more than 0 and at most %g", maxCanvasSize)
+	}
 
-func getExpectedStrokeCount(trainingType TrainingType) int {
-	switch trainingType {
-	case OnePointPerspective:
-		return 8 // 4 verticals, 4 converging to center
-	case TwoPointPerspective:
-		return 9 // 3 verticals, 3 left, 3 right
-	case ThreePointPerspective:
-		return 9 // 3 to each vanishing point
+	// Default the render scale to 1x and cap it to keep images a sane size
+	if req.Scale == 0 {
+		req.Scale = 1
+	}
+	if req.Scale < 1 || req.Scale > maxScale {
+		return fieldError(CodeOutOfRange, "scale", "Scale must be between 1 and %g", maxScale)
+	}
+	if req.Width*req.Height*req.Scale*req.Scale > maxRenderPixels {
+		return fieldError(CodeOutOfRange, "scale", "The image would be larger than %d pixels; lower the scale", maxRenderPixels)
+	}
+
+	// Validate the PNG quality and print resolution
+	switch req.Antialias {
+	case "", AntialiasStandard, AntialiasHigh:
 	default:
-		return 9
+		return fieldError(CodeInvalidValue, "antialias", "Unknown antialias quality %q", req.Antialias)
+	}
+	if req.DPI < 0 || req.DPI > maxDPI {
+		return fieldError(CodeOutOfRange, "dpi", "DPI must be between 0 and %g", maxDPI)
+	}
+
+	if req.GridRays < 0 || req.GridRays > maxGridRays {
+		return fieldError(CodeOutOfRange, "gridRays", "gridRays must be between 0 and %d", maxGridRays)
+	}
+
+	// Validate the visualization theme colors
+	if _, err := req.Theme.palette(); err != nil {
+		return fieldError(CodeInvalidValue, "theme", "%v", err)
+	}
+
+	// Validate the overlay line widths, dashes and marker size
+	if _, err := req.Style.resolve(); err != nil {
+		return fieldError(CodeInvalidValue, "style", "%v", err)
+	}
+
+	// Decode the reference photo once so every render can use it
+	if req.Background != "" {
+		img, err := decodeBackground(req.Background)
+		if err != nil {
+			return fieldError(CodeInvalidValue, "backgroundImage", "%v", err)
+		}
+		req.background = img
+	}
+
+	// Validate stroke count based on training type. Fewer strokes than
+	// expected are scored as a partial attempt.
+	expectedStrokes := getExercise(req.TrainingType).ExpectedStrokes
+	if len(req.Strokes) == 0 || len(req.Strokes) > expectedStrokes {
+		return fieldError(CodeWrongStrokeCount, "strokes", "Expected 1 to %d strokes for %s", expectedStrokes, req.TrainingType)
+	}
+	// A single point has no direction to fit a line to
+	for i, stroke := range req.Strokes {
+		if len(stroke) < 2 {
+			return strokeError(CodeStrokeTooShort, i, "Stroke %d needs at least 2 points", i+1)
+		}
+	}
+	return nil
+}
+
+// analyzeStrokes scores an attempt, renders its visualization, and records it
+// in the history
+func analyzeStrokes(ctx context.Context, req AnalysisRequest) AnalysisResult {
+	if req.DeviceID == "" {
+		req.DeviceID = preferredDevice(ctx)
+	}
+	req.owner = ownerID(ctx)
+	start := time.Now()
+	result, vis := scoreStrokes(req)
+	scoreMs := milliseconds(time.Since(start))
+	addLogAttrs(ctx, "trainingType", req.TrainingType, "strokes", len(req.Strokes),
+		"compositeScore", result.CompositeScore, "scoreMs", scoreMs)
+	analysisStats.Add("count", 1)
+	analysisStats.AddFloat("scoreMs", scoreMs)
+
+	// Live feedback only needs the numbers. Rendering dominates the latency,
+	// and work in progress shouldn't be recorded as an attempt.
+	if req.SkipRender {
+		history, err := loadHistory(req.TrainingType)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load history", "err", err)
+		}
+		result.Percentile = calculatePercentile(result.CompositeScore, ownHistory(ctx, history))
+		return result
+	}
+
+	result.AttemptID = newAttemptID()
+	vis.attemptID = result.AttemptID
+
+	// Render the visualization in the requested format and save it
+	start = time.Now()
+	result.ImageData, result.SVG, result.SavedFilePath = renderVisualization(vis, result.PerspectiveScore)
+	if result.SavedFilePath != "" {
+		result.ImageURL = resultImageURL(result.AttemptID, result.SavedFilePath)
 	}
+	if req.OmitImage {
+		result.ImageData, result.SVG = "", ""
+	}
+	if req.Layers {
+		result.Layers = renderLayers(vis)
+	}
+	renderMs := milliseconds(time.Since(start))
+	addLogAttrs(ctx, "attemptId", result.AttemptID, "renderMs", renderMs)
+	analysisStats.Add("rendered", 1)
+	analysisStats.AddFloat("renderMs", renderMs)
+
+	// Compare against previous attempts and record this one
+	history, err := loadHistory(req.TrainingType)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load history", "err", err)
+	}
+	result.Percentile = calculatePercentile(result.CompositeScore, ownHistory(ctx, history))
+	summary := AttemptSummary{
+		ID:               result.AttemptID,
+		WorkspaceID:      workspaceID(ctx),
+		Timestamp:        time.Now(),
+		TrainingType:     req.TrainingType,
+		Difficulty:       result.Difficulty,
+		CompositeScore:   result.CompositeScore,
+		AverageLineScore: result.AverageLineScore,
+		PerspectiveScore: result.PerspectiveScore,
+		Partial:          result.Partial,
+		Assisted:         result.Assisted,
+		SavedFilePath:    result.SavedFilePath,
+		Fingerprint:      strokeFingerprint(req.Strokes),
+		Ranked:           rankable(result),
+	}
+	summary.UserID = ownerID(ctx)
+	if day, ok := dailyChallengeDay(req, summary.Timestamp); ok && result.TargetMatch != nil {
+		summary.Daily, summary.DailyScore = day, result.TargetMatch.Score
+		result.Daily = day
+	}
+	if err := recordAttempt(summary, req, result); err != nil {
+		slog.ErrorContext(ctx, "Failed to record attempt", "attemptId", result.AttemptID, "err", err)
+	} else {
+		result.ThumbnailURL = thumbnailURL(result.AttemptID)
+		result.PersonalBests = newPersonalBests(summary, history)
+		if summary.UserID != "" {
+			result.Streak = userStreak(ctx, summary.UserID)
+		}
+	}
+
+	return result
 }
 
-func analyzeStrokes(req AnalysisRequest) AnalysisResult {
+// scoreStrokes runs the analysis without rendering or recording anything,
+// returning the scores and what the visualization needs to draw them
+func scoreStrokes(req AnalysisRequest) (AnalysisResult, visualization) {
 	// Step 1: Calculate ideal lines for each stroke
 	lines := make([]Line, len(req.Strokes))
 	lineScores := make([]float64, len(req.Strokes))
 
+	shapes := make([]StrokeShape, len(req.Strokes))
+
+	profile, _ := getScoringProfile(req.Difficulty)
+
+	// Subtract the device's calibrated jitter from each line's RMSE
+	var noiseFloor float64
+	if cal, ok := getCalibration(req.owner, req.DeviceID); ok {
+		noiseFloor = cal.NoiseFloor
+	}
+
 	for i, stroke := range req.Strokes {
-		lines[i] = calculateIdealLine(stroke)
+		lines[i] = applyNoiseFloor(calculateIdealLine(stroke), noiseFloor)
+		lines[i].Score = calculateScore(lines[i].RMSE, profile.RMSEThreshold)
 		lineScores[i] = lines[i].Score
+		shapes[i] = measureStrokeShape(stroke, lines[i])
 	}
 
 	// Step 2: Cluster lines into groups (vertical, left-converging, right-converging)
@@ -164,19 +522,42 @@ func analyzeStrokes(req AnalysisRequest) AnalysisResult {
 		rightVP, convergenceErrorR = calculateVanishingPoint(lines, rightGroup)
 	}
 
-	// Step 4: Calculate perspective score
-	perspectiveScore := calculatePerspectiveScore(convergenceErrorL, convergenceErrorR, req.Width, req.Height)
-
-	// Step 5: Generate visualization
-	visualizationImg := generateVisualizationImage(req, lines, verticals, leftGroup, rightGroup, leftVP, rightVP)
-
-	// Step 6: Save result to file
-	savedPath := saveResultToFile(visualizationImg, req.TrainingType, perspectiveScore)
+	// Step 4: Calculate per-group and overall perspective scores.
+	// Errors are judged relative to how far the VP is from its strokes.
+	var leftScore, rightScore, vpDistanceL, vpDistanceR float64
+	if leftVP != nil {
+		vpDistanceL = vanishingPointDistance(req.Strokes, leftGroup, *leftVP)
+		leftScore = calculateConvergenceScore(convergenceErrorL, vpDistanceL, req.Width, req.Height, profile.ConvergenceTolerance)
+	}
+	if rightVP != nil {
+		vpDistanceR = vanishingPointDistance(req.Strokes, rightGroup, *rightVP)
+		rightScore = calculateConvergenceScore(convergenceErrorR, vpDistanceR, req.Width, req.Height, profile.ConvergenceTolerance)
+	}
+	// In 3-point perspective the verticals converge on a third vanishing
+	// point rather than stay parallel
+	var verticalScore float64
+	if req.TrainingType == ThreePointPerspective {
+		if vp, convergenceError := calculateVanishingPoint(lines, verticals); vp != nil {
+			vpDistance := vanishingPointDistance(req.Strokes, verticals, *vp)
+			verticalScore = calculateConvergenceScore(convergenceError, vpDistance, req.Width, req.Height, profile.ConvergenceTolerance)
+		}
+	} else {
+		verticalScore = calculateVerticalScore(lines, verticals, profile.VerticalTolerance)
+	}
+	perspectiveScore := calculatePerspectiveScore(convergenceErrorL, convergenceErrorR, vpDistanceL, vpDistanceR, req.Width, req.Height, profile.ConvergenceTolerance)
 
-	// Step 7: Convert to base64 for response
-	var buf bytes.Buffer
-	png.Encode(&buf, visualizationImg.Image())
-	imageData := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	// Step 5: Collect what the visualization draws
+	vis := visualization{
+		req:        req,
+		lines:      lines,
+		verticals:  verticals,
+		leftGroup:  leftGroup,
+		rightGroup: rightGroup,
+		leftVP:     leftVP,
+		rightVP:    rightVP,
+		leftError:  convergenceErrorL,
+		rightError: convergenceErrorR,
+	}
 
 	// Calculate average line score
 	avgScore := 0.0
@@ -185,17 +566,67 @@ func analyzeStrokes(req AnalysisRequest) AnalysisResult {
 	}
 	avgScore /= float64(len(lineScores))
 
-	return AnalysisResult{
-		ImageData:         imageData,
-		LineScores:        lineScores,
-		AverageLineScore:  avgScore,
-		LeftVP:            leftVP,
-		RightVP:           rightVP,
-		ConvergenceErrorL: convergenceErrorL,
-		ConvergenceErrorR: convergenceErrorR,
-		PerspectiveScore:  perspectiveScore,
-		SavedFilePath:     savedPath,
+	// Grade against the exercise rubric and combine the headline score
+	exercise := getExercise(req.TrainingType)
+	convergenceScore := (leftScore + rightScore + verticalScore) / 3.0
+	proportionScore := calculateProportionScore(req.Strokes, verticals)
+	compositionScore := calculateCompositionScore(req.Strokes, req.Width, req.Height)
+	grade := gradeAttempt(exercise, avgScore, convergenceScore, proportionScore, compositionScore)
+	compositeScore := calculateCompositeScore(exercise.ScoreWeights, avgScore, convergenceScore, proportionScore)
+
+	// Apply the speed bonus or penalty for timed drills
+	elapsed := req.ElapsedMs / 1000.0
+	if elapsed <= 0 {
+		elapsed = elapsedSeconds(req.Strokes)
+	}
+	var timeModifier float64
+	if req.Timed {
+		timeModifier = calculateTimeModifier(exercise.TimeBudget, elapsed)
+		compositeScore = math.Max(0, math.Min(100, compositeScore+timeModifier))
+	}
+	// Compare against the generated target box when one was given
+	var targetMatch *TargetMatch
+	if req.Target != nil {
+		targetReq := *req.Target
+		if targetReq.Width <= 0 || targetReq.Height <= 0 {
+			targetReq.Width, targetReq.Height = req.Width, req.Height
+		}
+		m := scoreAgainstTarget(req.Strokes, generateTarget(targetReq))
+		targetMatch = &m
 	}
+
+	assistedStrokes := detectAssistedStrokes(req.Strokes, lines, shapes)
+	strokeTags := tagStrokes(req.Strokes, lines, shapes, verticals, leftGroup, rightGroup, leftVP, rightVP, req.Width, req.Height)
+	feedback := generateFeedback(req.TrainingType, lines, shapes, verticals, leftGroup, rightGroup, leftScore, rightScore, verticalScore, proportionScore, compositionScore)
+
+	return AnalysisResult{
+		LineScores:            lineScores,
+		StrokeTags:            strokeTags,
+		AverageLineScore:      avgScore,
+		NoiseFloor:            noiseFloor,
+		LeftVP:                leftVP,
+		RightVP:               rightVP,
+		ConvergenceErrorL:     convergenceErrorL,
+		ConvergenceErrorR:     convergenceErrorR,
+		LeftConvergenceScore:  leftScore,
+		RightConvergenceScore: rightScore,
+		VerticalScore:         verticalScore,
+		PerspectiveScore:      perspectiveScore,
+		ConvergenceScore:      convergenceScore,
+		ProportionScore:       proportionScore,
+		CompositionScore:      compositionScore,
+		CompositeScore:        compositeScore,
+		ElapsedSeconds:        elapsed,
+		TimeModifier:          timeModifier,
+		Difficulty:            req.Difficulty,
+		Partial:               len(req.Strokes) < exercise.ExpectedStrokes,
+		Assisted:              len(assistedStrokes) > 0,
+		AssistedStrokes:       assistedStrokes,
+		ExpectedStrokes:       exercise.ExpectedStrokes,
+		Grade:                 grade,
+		TargetMatch:           targetMatch,
+		Feedback:              feedback,
+	}, vis
 }
 
 // calculateIdealLine uses linear regression to find the best-fit line
@@ -237,7 +668,7 @@ func calculateIdealLine(stroke Stroke) Line {
 			B:     meanX,           // Store x-position instead
 			Angle: 90.0,
 			RMSE:  rmse,
-			Score: calculateScore(rmse),
+			Score: calculateScore(rmse, defaultScoringProfiles[defaultDifficulty].RMSEThreshold),
 		}
 	}
 
@@ -270,15 +701,14 @@ func calculateIdealLine(stroke Stroke) Line {
 		B:     b,
 		Angle: angle,
 		RMSE:  rmse,
-		Score: calculateScore(rmse),
+		Score: calculateScore(rmse, defaultScoringProfiles[defaultDifficulty].RMSEThreshold),
 	}
 }
 
 // calculateScore converts RMSE to a 0-100 score
-func calculateScore(rmse float64) float64 {
+func calculateScore(rmse, threshold float64) float64 {
 	// Lower RMSE = higher score
 	// Use exponential decay: score = 100 * e^(-rmse/threshold)
-	threshold := 5.0 // Adjust based on typical canvas size
 	score := 100.0 * math.Exp(-rmse/threshold)
 	if score > 100 {
 		score = 100
@@ -413,16 +843,32 @@ func findIntersection(line1, line2 Line) *Point {
 }
 
 // calculatePerspectiveScore converts convergence errors to a score
-func calculatePerspectiveScore(errorL, errorR, width, height float64) float64 {
-	// Average the two convergence errors
-	avgError := (errorL + errorR) / 2.0
+func calculatePerspectiveScore(errorL, errorR, vpDistanceL, vpDistanceR, width, height, tolerance float64) float64 {
+	// Average the two normalized convergence errors
+	normalizedL := normalizeConvergenceError(errorL, vpDistanceL, width, height)
+	normalizedR := normalizeConvergenceError(errorR, vpDistanceR, width, height)
+	return convergenceErrorToScore((normalizedL+normalizedR)/2.0, tolerance)
+}
+
+// calculateConvergenceScore converts a single convergence error to a 0-100 score
+func calculateConvergenceScore(convergenceError, vpDistance, width, height, tolerance float64) float64 {
+	return convergenceErrorToScore(normalizeConvergenceError(convergenceError, vpDistance, width, height), tolerance)
+}
 
-	// Normalize by canvas diagonal
+// normalizeConvergenceError scales a convergence error by the canvas diagonal,
+// or by the VP distance when the VP is further away than that. A distant VP
+// spreads its intersections over a wide area even when the strokes are only
+// slightly off-angle, so for deep boxes this measures the angular error at the
+// strokes rather than the raw spread.
+func normalizeConvergenceError(convergenceError, vpDistance, width, height float64) float64 {
 	diagonal := math.Sqrt(width*width + height*height)
-	normalizedError := avgError / diagonal
+	return convergenceError / math.Max(diagonal, vpDistance)
+}
 
+// convergenceErrorToScore converts a normalized convergence error to a 0-100 score
+func convergenceErrorToScore(normalizedError, tolerance float64) float64 {
 	// Convert to 0-100 score (lower error = higher score)
-	score := 100.0 * math.Exp(-normalizedError*10.0)
+	score := 100.0 * math.Exp(-normalizedError/tolerance)
 	if score > 100 {
 		score = 100
 	}
@@ -432,156 +878,57 @@ func calculatePerspectiveScore(errorL, errorR, width, height float64) float64 {
 	return score
 }
 
-// generateVisualizationImage creates an overlay image showing the analysis
-func generateVisualizationImage(req AnalysisRequest, lines []Line, verticals, leftGroup, rightGroup []int, leftVP, rightVP *Point) *gg.Context {
-	width := int(req.Width)
-	height := int(req.Height)
-
-	dc := gg.NewContext(width, height)
-
-	// Draw white background
-	dc.SetColor(color.White)
-	dc.Clear()
-
-	// Set font
-	if err := dc.LoadFontFace("/System/Library/Fonts/HelveticaNeue.ttc", 14); err != nil {
-		log.Println("Could not load font, using default")
-	}
-
-	// Draw original strokes in light gray
-	dc.SetColor(color.RGBA{200, 200, 200, 255})
-	dc.SetLineWidth(2)
-	for _, stroke := range req.Strokes {
+// vanishingPointDistance returns the mean distance from the centers of a
+// group's strokes to its vanishing point
+func vanishingPointDistance(strokes []Stroke, group []int, vp Point) float64 {
+	total, count := 0.0, 0
+	for _, idx := range group {
+		stroke := strokes[idx]
 		if len(stroke) == 0 {
 			continue
 		}
-		dc.MoveTo(stroke[0].X, stroke[0].Y)
-		for _, p := range stroke[1:] {
-			dc.LineTo(p.X, p.Y)
-		}
-		dc.Stroke()
-	}
-
-	// Draw ideal lines in green and label them
-	dc.SetColor(color.RGBA{0, 200, 0, 255})
-	dc.SetLineWidth(2)
-	for i, stroke := range req.Strokes {
-		if len(stroke) < 2 {
-			continue
-		}
-		line := lines[i]
-
-		// Find stroke bounds
-		minX, maxX := stroke[0].X, stroke[0].X
-		minY, maxY := stroke[0].Y, stroke[0].Y
-		sumX, sumY := 0.0, 0.0
-		for _, p := range stroke {
-			sumX += p.X
-			sumY += p.Y
-			if p.X < minX {
-				minX = p.X
-			}
-			if p.X > maxX {
-				maxX = p.X
-			}
-			if p.Y < minY {
-				minY = p.Y
-			}
-			if p.Y > maxY {
-				maxY = p.Y
-			}
-		}
-
-		if line.M == math.MaxFloat64 {
-			// Vertical line
-			dc.DrawLine(line.B, minY, line.B, maxY)
-		} else {
-			y1 := line.M*minX + line.B
-			y2 := line.M*maxX + line.B
-			dc.DrawLine(minX, y1, maxX, y2)
-		}
-		dc.Stroke()
-		// Label with angle
-		dc.SetColor(color.RGBA{0, 100, 0, 200})
-		dc.DrawString(fmt.Sprintf("%.1f°", line.Angle), sumX/float64(len(stroke))+5, sumY/float64(len(stroke)))
+		total += distance(strokeCenter(stroke), vp)
+		count++
 	}
-
-	// Extend lines to vanishing points in red
-	dc.SetColor(color.RGBA{255, 0, 0, 120})
-	dc.SetLineWidth(1)
-
-	// Extend left group to left VP
-	if leftVP != nil {
-		for _, idx := range leftGroup {
-			stroke := req.Strokes[idx]
-			if len(stroke) > 0 {
-				// Extend from the point on the stroke furthest from the VP
-				p_furthest := stroke[0]
-				maxDist := 0.0
-				for _, p := range stroke {
-					dist := math.Hypot(p.X-leftVP.X, p.Y-leftVP.Y)
-					if dist > maxDist {
-						maxDist = dist
-						p_furthest = p
-					}
-				}
-				dc.DrawLine(p_furthest.X, p_furthest.Y, leftVP.X, leftVP.Y)
-				dc.Stroke()
-			}
-		}
-		// Draw VP marker
-		dc.SetColor(color.RGBA{255, 0, 0, 255})
-		dc.DrawCircle(leftVP.X, leftVP.Y, 8)
-		dc.Fill()
+	if count == 0 {
+		return 0
 	}
+	return total / float64(count)
+}
 
-	// Extend right group to right VP
-	dc.SetColor(color.RGBA{255, 0, 0, 120})
-	if rightVP != nil {
-		for _, idx := range rightGroup {
-			stroke := req.Strokes[idx]
-			if len(stroke) > 0 {
-				p_furthest := stroke[0]
-				maxDist := 0.0
-				for _, p := range stroke {
-					dist := math.Hypot(p.X-rightVP.X, p.Y-rightVP.Y)
-					if dist > maxDist {
-						maxDist = dist
-						p_furthest = p
-					}
-				}
-				dc.DrawLine(p_furthest.X, p_furthest.Y, rightVP.X, rightVP.Y)
-				dc.Stroke()
-			}
-		}
-		// Draw VP marker
-		dc.SetColor(color.RGBA{255, 0, 0, 255})
-		dc.DrawCircle(rightVP.X, rightVP.Y, 8)
-		dc.Fill()
+// strokeCenter returns the mean of a stroke's points
+func strokeCenter(stroke Stroke) Point {
+	var c Point
+	for _, p := range stroke {
+		c.X += p.X
+		c.Y += p.Y
 	}
-
-	// Add group count stats
-	dc.SetColor(color.Black)
-	stats := fmt.Sprintf("Verticals: %d, Left Group: %d, Right Group: %d", len(verticals), len(leftGroup), len(rightGroup))
-	dc.DrawString(stats, 10, 20)
-
-	return dc
+	c.X /= float64(len(stroke))
+	c.Y /= float64(len(stroke))
+	return c
 }
 
-// saveResultToFile saves the visualization to the results directory
-func saveResultToFile(dc *gg.Context, trainingType TrainingType, score float64) string {
-	// Generate filename with timestamp and score
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	scoreStr := fmt.Sprintf("%.0f", score)
-	filename := fmt.Sprintf("%s_%s_score-%s.png", timestamp, trainingType, scoreStr)
-	filepath := filepath.Join(resultsDir, filename)
+// calculateVerticalScore scores how close the vertical group is to true vertical.
+// In 1- and 2-point perspective verticals stay parallel, so any tilt is an error.
+func calculateVerticalScore(lines []Line, verticals []int, tolerance float64) float64 {
+	if len(verticals) == 0 {
+		return 0
+	}
 
-	// Save the image
-	if err := dc.SavePNG(filepath); err != nil {
-		log.Printf("Failed to save result to %s: %v", filepath, err)
-		return ""
+	// Average deviation from 90 degrees
+	deviationSum := 0.0
+	for _, idx := range verticals {
+		deviationSum += 90.0 - math.Abs(lines[idx].Angle)
 	}
+	avgDeviation := deviationSum / float64(len(verticals))
 
-	log.Printf("Saved result to: %s", filepath)
-	return filepath
+	// Convert to 0-100 score (a tilt equal to the tolerance drops to ~37)
+	score := 100.0 * math.Exp(-avgDeviation/tolerance)
+	if score > 100 {
+		score = 100
+	}
+	if score < 0 {
+		score = 0
+	}
+	return score
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultMaintenanceMessage is shown when the admin doesn't give one
+const defaultMaintenanceMessage = "Tradra is down for maintenance and will be back shortly."
+
+// Maintenance is whether users are turned away while the admin works on the
+// server, such as moving its storage, and what they're told
+type Maintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// maintenance is replaced as a whole when it's toggled
+var maintenance atomic.Pointer[Maintenance]
+
+// currentMaintenance returns the maintenance mode in effect
+func currentMaintenance() Maintenance {
+	if m := maintenance.Load(); m != nil {
+		return *m
+	}
+	return Maintenance{}
+}
+
+// setMaintenance turns maintenance mode on or off
+func setMaintenance(m Maintenance) {
+	if m.Enabled && m.Message == "" {
+		m.Message = defaultMaintenanceMessage
+	}
+	if !m.Enabled {
+		m.Message = ""
+	}
+	maintenance.Store(&m)
+}
+
+// maintenanceExempt reports whether a request is served during maintenance:
+// health checks, so the load balancer keeps the server, and the admin's
+// endpoints, so it can be brought back
+func maintenanceExempt(r *http.Request) bool {
+	p := r.URL.Path
+	return p == "/healthz" || p == "/readyz" ||
+		strings.HasPrefix(p, apiPrefix+"/admin/") || strings.HasPrefix(p, debugPrefix)
+}
+
+// withMaintenance answers everything else with 503 during maintenance, as a
+// page for browsers and JSON for API clients
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := currentMaintenance()
+		if !m.Enabled || maintenanceExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, maintenancePage, html.EscapeString(m.Message))
+			return
+		}
+		writeError(w, &APIError{Code: CodeMaintenance, Message: m.Message}, http.StatusServiceUnavailable)
+	})
+}
+
+// maintenancePage stands on its own, since the static files are turned away
+// too
+const maintenancePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Down for maintenance</title>
+<style>
+body { font-family: system-ui, sans-serif; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; background: #f5f5f5; color: #333; }
+main { max-width: 32em; padding: 2em; text-align: center; }
+</style>
+</head>
+<body>
+<main>
+<h1>Down for maintenance</h1>
+<p>%s</p>
+</main>
+</body>
+</html>
+`
+
+// checkRPCMaintenance fails calls with Unavailable during maintenance
+func checkRPCMaintenance() error {
+	if m := currentMaintenance(); m.Enabled {
+		return status.Error(codes.Unavailable, m.Message)
+	}
+	return nil
+}
+
+func maintenanceRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := checkRPCMaintenance(); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func maintenanceStreamRPC(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := checkRPCMaintenance(); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// handleGetMaintenance reports whether maintenance mode is on
+func handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, currentMaintenance())
+}
+
+// handlePutMaintenance turns maintenance mode on or off, for the next request
+func handlePutMaintenance(w http.ResponseWriter, r *http.Request) {
+	var m Maintenance
+	if !decodeRequest(w, r, &m) {
+		return
+	}
+	setMaintenance(m)
+	slog.InfoContext(r.Context(), "Changed maintenance mode", "enabled", m.Enabled)
+	writeResponse(w, r, http.StatusOK, currentMaintenance())
+}
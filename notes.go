@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxNotesLength = 2000 // characters
+	maxTags        = 10
+	maxTagLength   = 32
+)
+
+// AttemptNotes is what users keep with an attempt about how it was drawn,
+// such as "tired" or "new pen", to compare scores by
+type AttemptNotes struct {
+	Notes string   `json:"notes"`
+	Tags  []string `json:"tags"`
+}
+
+// normalizeTag trims and lowercases a tag, so "New pen" and "new pen" are one,
+// and rejects it unless it's letters, digits, spaces and hyphens
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		return "", fieldError(CodeOutOfRange, "tags", "Tags must be 1 to %d characters", maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' {
+			return "", fieldError(CodeInvalidValue, "tags", "Tag %q may only have letters, digits, spaces and hyphens", tag)
+		}
+	}
+	return tag, nil
+}
+
+// validateNotes normalizes notes and their tags, dropping repeated tags, and
+// rejects what an attempt can't keep
+func validateNotes(n *AttemptNotes) error {
+	n.Notes = strings.TrimSpace(n.Notes)
+	if utf8.RuneCountInString(n.Notes) > maxNotesLength {
+		return fieldError(CodeOutOfRange, "notes", "Notes must be at most %d characters", maxNotesLength)
+	}
+	tags := make([]string, 0, len(n.Tags))
+	for _, t := range n.Tags {
+		tag, err := normalizeTag(t)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTags {
+		return fieldError(CodeOutOfRange, "tags", "An attempt may have at most %d tags", maxTags)
+	}
+	n.Tags = tags
+	return nil
+}
+
+// handlePutAttemptNotes replaces the notes and tags of one of the caller's
+// own attempts. Anyone with the ID can see an attempt, but only whoever made
+// it, in the same workspace, can annotate it.
+func handlePutAttemptNotes(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var notes AttemptNotes
+	if !decodeRequest(w, r, &notes) {
+		return
+	}
+	if err := validateNotes(&notes); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	a, err := findAttempt(id)
+	if errors.Is(err, errAttemptNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		if a.UserID != ownerID(r.Context()) || a.WorkspaceID != workspaceID(r.Context()) {
+			httpError(w, "Only whoever made an attempt can annotate it", http.StatusForbidden)
+			return
+		}
+		err = store.SetAttemptNotes(id, notes.Notes, notes.Tags)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save attempt notes", "attemptId", id, "err", err)
+		httpError(w, "Failed to save notes", http.StatusInternalServerError)
+		return
+	}
+
+	a.Notes, a.Tags = notes.Notes, notes.Tags
+	writeResponse(w, r, http.StatusOK, newAttempt(a))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// objectPathPrefix marks a saved file path that names an object in the
+// object storage rather than a file on disk
+const objectPathPrefix = "s3:"
+
+// Time formats of Signature Version 4
+const (
+	sigV4Time = "20060102T150405Z"
+	sigV4Date = "20060102"
+)
+
+// maxObjectSize is the most of an object's body read back, well over any
+// image the server renders
+const maxObjectSize = 64 << 20
+
+// objects is set at startup when a bucket is given, and saved images and
+// thumbnails are then kept there
+var objects *objectStore
+
+// errObjectNotFound is returned for objects the bucket doesn't have
+var errObjectNotFound = errors.New("object not found")
+
+// objectStore keeps files in an S3-compatible bucket, signing requests with
+// AWS Signature Version 4, which the compatible stores accept too
+type objectStore struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	urlExpiry time.Duration
+	client    *http.Client
+}
+
+// newObjectStore sets up object storage, or returns nil when there's no
+// bucket
+func newObjectStore(cfg config) (*objectStore, error) {
+	if cfg.s3Bucket == "" {
+		return nil, nil
+	}
+	endpoint, err := url.Parse(cfg.s3Endpoint)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		return nil, fmt.Errorf("-s3-endpoint must be an http or https URL, not %q", cfg.s3Endpoint)
+	}
+	return &objectStore{
+		endpoint:  endpoint,
+		region:    cfg.s3Region,
+		bucket:    cfg.s3Bucket,
+		prefix:    cfg.s3Prefix,
+		accessKey: cfg.s3AccessKey,
+		secretKey: cfg.s3SecretKey,
+		pathStyle: cfg.s3PathStyle,
+		urlExpiry: cfg.s3URLExpiry,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// objectKey returns the key a saved file path names in the object storage,
+// reporting false for a file on disk
+func objectKey(savedPath string) (string, bool) {
+	return strings.CutPrefix(savedPath, objectPathPrefix)
+}
+
+// objectURL returns the URL of an object, its path escaped as it's signed
+func (o *objectStore) objectURL(key string) *url.URL {
+	u := *o.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if o.pathStyle {
+		path += "/" + o.bucket
+	} else {
+		u.Host = o.bucket + "." + u.Host
+	}
+	u.Path = path + "/" + key
+	u.RawPath = s3Escape(path, true) + "/" + s3Escape(key, true)
+	u.RawQuery = ""
+	return &u
+}
+
+// put uploads an object, replacing any with its key
+func (o *objectStore) put(ctx context.Context, key, contentType string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, o.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	_, err = o.do(req, data)
+	return err
+}
+
+// get downloads an object, returning errObjectNotFound when there's none
+func (o *objectStore) get(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return o.do(req, nil)
+}
+
+// exists reports whether the bucket has an object
+func (o *objectStore) exists(ctx context.Context, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, o.objectURL(key).String(), nil)
+	if err != nil {
+		return false, err
+	}
+	_, err = o.do(req, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// delete removes an object. Removing one that's gone already isn't an error.
+func (o *objectStore) delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, o.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = o.do(req, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+// do signs and sends a request with its body, returning the response body
+func (o *objectStore) do(req *http.Request, body []byte) ([]byte, error) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(sigV4Time))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		headers = append(headers, "content-type")
+	}
+	sort.Strings(headers)
+	var canonical strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonical.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(headers, ";")
+	signature, scope := o.signature(now, req.Method, req.URL.EscapedPath(), "", canonical.String(), signed, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.accessKey, scope, signed, signature))
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxObjectSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errObjectNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("object storage answered %s: %s", resp.Status, bytes.TrimSpace(data[:min(len(data), 512)]))
+	}
+	return data, nil
+}
+
+// presign returns a URL anyone can get an object from until the store's
+// urlExpiry has passed from now
+func (o *objectStore) presign(key string, now time.Time) string {
+	now = now.UTC()
+	u := o.objectURL(key)
+	scope := now.Format(sigV4Date) + "/" + o.region + "/s3/aws4_request"
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {o.accessKey + "/" + scope},
+		"X-Amz-Date":          {now.Format(sigV4Time)},
+		"X-Amz-Expires":       {strconv.Itoa(int(o.urlExpiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	rawQuery := s3Query(query)
+	signature, _ := o.signature(now, http.MethodGet, u.EscapedPath(), rawQuery, "host:"+u.Host+"\n", "host", "UNSIGNED-PAYLOAD")
+	u.RawQuery = rawQuery + "&X-Amz-Signature=" + signature
+	return u.String()
+}
+
+// redirect sends a client to a presigned URL of an object. URLs are signed
+// as of the start of the half of urlExpiry under way, so the same one is
+// given out for a while and browsers can cache the object.
+func (o *objectStore) redirect(w http.ResponseWriter, r *http.Request, key string) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(o.urlExpiry.Seconds()/2)))
+	http.Redirect(w, r, o.presign(key, time.Now().Truncate(o.urlExpiry/2)), http.StatusFound)
+}
+
+// signature signs a canonical request, returning the signature and the
+// credential scope it's for
+func (o *objectStore) signature(now time.Time, method, path, rawQuery, headers, signedHeaders, payloadHash string) (string, string) {
+	request := strings.Join([]string{method, path, rawQuery, headers, signedHeaders, payloadHash}, "\n")
+	requestHash := sha256.Sum256([]byte(request))
+	scope := now.Format(sigV4Date) + "/" + o.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format(sigV4Time) + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + o.secretKey)
+	for _, part := range []string{now.Format(sigV4Date), o.region, "s3", "aws4_request", toSign} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(part))
+		key = h.Sum(nil)
+	}
+	return hex.EncodeToString(key), scope
+}
+
+// s3Escape percent-encodes everything but the characters Signature Version 4
+// leaves alone, and slashes too when keepSlash is set
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes query parameters sorted by name, as they're signed
+func s3Query(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, v := range query[name] {
+			parts = append(parts, s3Escape(name, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// openAPIPath is where the OpenAPI document describing the API is served
+const openAPIPath = "/api/openapi.json"
+
+// schemaEnums lists the allowed values of string types, and of types that can
+// be given by name in place of an object
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeFor[TrainingType](): trainingTypeNames(),
+	reflect.TypeFor[Difficulty]():   {string(Beginner), string(Intermediate), string(Advanced)},
+	reflect.TypeFor[Theme]():        slices.Sorted(maps.Keys(themes)),
+	reflect.TypeFor[Style]():        slices.Sorted(maps.Keys(styles)),
+}
+
+// trainingTypeNames lists the training types there are exercises for
+func trainingTypeNames() []string {
+	var names []string
+	for t := range defaultExercises {
+		names = append(names, string(t))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// openAPIDocument is built on first use and served from then on
+var openAPIDocument = sync.OnceValue(func() []byte {
+	data, err := json.MarshalIndent(buildOpenAPI(), "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode OpenAPI document", "err", err)
+	}
+	return data
+})
+
+// handleOpenAPI serves an OpenAPI 3 description of the API for generating
+// clients in other languages
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument())
+}
+
+// buildOpenAPI describes the endpoints, with request and response schemas
+// generated from the Go types so they can't drift apart
+func buildOpenAPI() map[string]any {
+	g := schemaGenerator{components: map[string]any{}}
+	// MessagePack bodies have the same fields as JSON
+	jsonBody := func(t reflect.Type) map[string]any {
+		schema := map[string]any{"schema": g.schema(t)}
+		return map[string]any{"content": map[string]any{contentTypeJSON: schema, contentTypeMsgpack: schema}}
+	}
+	// Analyses can also be tradra.v1 protobuf messages, from tradrapb/tradra.proto
+	withProtobuf := func(body map[string]any, message string) map[string]any {
+		body["content"].(map[string]any)[contentTypeProtobuf] = map[string]any{
+			"schema": map[string]any{"type": "string", "format": "binary", "description": "A tradra.v1." + message},
+		}
+		return body
+	}
+	// Errors are always JSON
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content":     map[string]any{contentTypeJSON: map[string]any{"schema": g.schema(reflect.TypeFor[ErrorResponse]())}},
+		}
+	}
+	binaryBody := func(types ...string) map[string]any {
+		content := map[string]any{}
+		for _, t := range types {
+			content[t] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+		}
+		return map[string]any{"content": content}
+	}
+	operation := func(summary string, request map[string]any, response map[string]any, params ...map[string]any) map[string]any {
+		op := map[string]any{
+			"summary": summary,
+			"responses": map[string]any{
+				"200": withDescription(response, "Success"),
+				"400": errorResponse("The request is invalid"),
+			},
+		}
+		if request != nil {
+			op["requestBody"] = request
+			op["responses"].(map[string]any)["413"] = errorResponse("The request body is too large")
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		return op
+	}
+	notFound := func(op map[string]any) map[string]any {
+		op["responses"].(map[string]any)["404"] = errorResponse("The attempt doesn't exist")
+		return op
+	}
+
+	// Submissions can be retried safely with the same key
+	idempotencyKey := map[string]any{
+		"name": idempotencyHeader, "in": "header",
+		"description": "Repeating a request with the same key within a day replays the first response instead of running it again",
+		"schema":      map[string]any{"type": "string", "maxLength": maxIdempotencyKey},
+	}
+
+	paths := map[string]any{
+		"/analyze": map[string]any{
+			"post": operation("Analyze a set of strokes and return scores, grade, feedback and the visualization",
+				withProtobuf(jsonBody(reflect.TypeFor[AnalysisRequest]()), "AnalyzeRequest"),
+				withProtobuf(jsonBody(reflect.TypeFor[AnalysisResult]()), "AnalyzeResponse"), idempotencyKey),
+		},
+		"/analyze/batch": map[string]any{
+			"post": operation("Analyze up to 100 attempts concurrently, returning a result or error for each in order",
+				jsonBody(reflect.TypeFor[[]AnalysisRequest]()), jsonBody(reflect.TypeFor[[]BatchResult]()), idempotencyKey),
+		},
+		"/jobs": map[string]any{
+			"post": withStatus(operation("Queue an analysis or batch to run in the background, returning the job to poll",
+				jsonBody(reflect.TypeFor[JobRequest]()), jsonBody(reflect.TypeFor[Job]()), idempotencyKey), "202"),
+		},
+		"/jobs/{id}": map[string]any{
+			"get": operation("A job's status, and its result once it's done", nil,
+				jsonBody(reflect.TypeFor[Job]()), pathParam("id", "The job ID")),
+		},
+		"/jobs/{id}/events": map[string]any{
+			"get": map[string]any{
+				"summary":    "Stream a job's progress as server-sent events: progress, a result per batch attempt, then done with the job",
+				"parameters": []any{pathParam("id", "The job ID")},
+				"responses": map[string]any{
+					"200": map[string]any{"description": "An event stream", "content": map[string]any{"text/event-stream": map[string]any{}}},
+					"404": errorResponse("No such job"),
+				},
+			},
+		},
+		"/ws": map[string]any{
+			"get": map[string]any{
+				"summary": "Upgrade to a WebSocket that analyzes strokes as they're drawn, exchanging LiveMessage JSON",
+				"responses": map[string]any{
+					"101": map[string]any{"description": "Switching to the WebSocket protocol"},
+					"403": errorResponse("The page's origin isn't allowed"),
+				},
+			},
+		},
+		"/calibrate": map[string]any{
+			"post": operation("Measure a device's sensor jitter from straight test strokes",
+				jsonBody(reflect.TypeFor[CalibrationRequest]()), jsonBody(reflect.TypeFor[Calibration]()), idempotencyKey),
+		},
+		"/target": map[string]any{
+			"get": operation("Generate a deterministic box to copy", nil, jsonBody(reflect.TypeFor[Target]()),
+				queryParam("seed", "integer", "Seed the box is generated from, random when unset"),
+				queryParam("width", "number", "Canvas width"),
+				queryParam("height", "number", "Canvas height")),
+			"post": operation("Generate a box to copy, optionally fixing its vanishing points and corner",
+				jsonBody(reflect.TypeFor[TargetRequest]()), jsonBody(reflect.TypeFor[Target]())),
+		},
+		"/progress": map[string]any{
+			"get": operation("Per-exercise best scores, rolling averages and improvement", nil,
+				jsonBody(reflect.TypeFor[[]ExerciseProgress]()),
+				queryParam("window", "integer", "Number of attempts in each rolling average"),
+				queryParam("trainingType", "string", "Only include this exercise")),
+		},
+		"/progress/chart": map[string]any{
+			"get": operation("Complete attempts per exercise and day, week or month, with average and best scores, for charting", nil,
+				jsonBody(reflect.TypeFor[[]ChartPoint]()),
+				queryParam("bucket", "string", "day (the default), week or month"),
+				queryParam("trainingType", "string", "Only include this exercise"),
+				queryParam("tag", "string", "Only include attempts with this tag; repeat for attempts with all of them"),
+				queryParam("from", "string", "Only include attempts from this date (YYYY-MM-DD) or RFC 3339 time"),
+				queryParam("to", "string", "Only include attempts up to this date, inclusive, or before this RFC 3339 time")),
+		},
+		"/next-exercise": map[string]any{
+			"get": operation("The drill to do next, with every exercise's schedule, most urgent first", nil,
+				jsonBody(reflect.TypeFor[NextExercise]())),
+		},
+		"/personal-bests": map[string]any{
+			"get": operation("Your best composite, line and perspective scores at each exercise, with the attempts that set them", nil,
+				jsonBody(reflect.TypeFor[[]ExerciseBests]()),
+				queryParam("trainingType", "string", "Only include this exercise")),
+		},
+		"/achievements": map[string]any{
+			"get": operation("Every achievement with your progress toward it and when you earned it", nil,
+				jsonBody(reflect.TypeFor[[]AchievementStatus]()),
+				queryParam("earned", "boolean", "Only list the achievements you've earned")),
+		},
+		"/leaderboard": map[string]any{
+			"get": operation("Each opted-in user's best ranked attempt at an exercise, best first", nil,
+				jsonBody(reflect.TypeFor[Leaderboard]()),
+				queryParam("trainingType", "string", "The exercise to rank"),
+				queryParam("difficulty", "string", "The difficulty attempts were scored at, intermediate by default"),
+				queryParam("period", "string", "day, week (from Monday, in UTC) or all (the default)"),
+				queryParam("limit", "integer", "Entries to return, 1 to 100, 10 by default")),
+		},
+		"/gallery": map[string]any{
+			"get": operation("Recent high-scoring attempts of users who opted in, newest first, without who made them", nil,
+				jsonBody(reflect.TypeFor[[]GalleryEntry]()),
+				queryParam("trainingType", "string", "Only include this exercise"),
+				queryParam("limit", "integer", "Entries to return, 1 to 100, 20 by default")),
+		},
+		"/daily": map[string]any{
+			"get": operation("The day's box for everyone to copy, the same all day, with the best matches with it", nil,
+				jsonBody(reflect.TypeFor[DailyChallenge]()),
+				queryParam("day", "string", "An earlier day's challenge (YYYY-MM-DD), today's in UTC by default"),
+				queryParam("width", "number", "Canvas width to lay the box out on, 800 by default"),
+				queryParam("height", "number", "Canvas height to lay the box out on, 600 by default")),
+		},
+		"/attempts": map[string]any{
+			"get": operation("A page of the attempt history, with links to each attempt's result and images", nil,
+				jsonBody(reflect.TypeFor[AttemptPage]()),
+				queryParam("trainingType", "string", "Only include this exercise"),
+				queryParam("tag", "string", "Only include attempts with this tag; repeat for attempts with all of them"),
+				queryParam("from", "string", "Only include attempts from this date (YYYY-MM-DD) or RFC 3339 time"),
+				queryParam("to", "string", "Only include attempts up to this date, inclusive, or before this RFC 3339 time"),
+				queryParam("sort", "string", "timestamp (the default), compositeScore, averageLineScore or perspectiveScore"),
+				queryParam("order", "string", "desc (the default) or asc"),
+				queryParam("limit", "integer", "Attempts per page, 1 to 100, 20 by default"),
+				queryParam("offset", "integer", "Attempts to skip")),
+		},
+		"/attempts/export": map[string]any{
+			"get": operation("Download the attempt history as CSV, a row of metrics per attempt, or as JSON with the strokes", nil,
+				map[string]any{"content": map[string]any{
+					contentTypeJSON: map[string]any{"schema": g.schema(reflect.TypeFor[Export]())},
+					"text/csv":      map[string]any{"schema": map[string]any{"type": "string"}},
+				}},
+				queryParam("format", "string", "json (the default) or csv"),
+				queryParam("trainingType", "string", "Only include this exercise"),
+				queryParam("tag", "string", "Only include attempts with this tag; repeat for attempts with all of them"),
+				queryParam("from", "string", "Only include attempts from this date (YYYY-MM-DD) or RFC 3339 time"),
+				queryParam("to", "string", "Only include attempts up to this date, inclusive, or before this RFC 3339 time")),
+		},
+		"/attempts/import": map[string]any{
+			"post": operation("Add the attempts of a JSON export to your history, skipping any already kept",
+				jsonBody(reflect.TypeFor[Export]()), jsonBody(reflect.TypeFor[ImportResult]())),
+		},
+		"/attempts/{id}": map[string]any{
+			"get": notFound(operation("An attempt with its full result", nil,
+				jsonBody(reflect.TypeFor[Attempt]()), pathParam("id", "The attempt ID"))),
+		},
+		"/attempts/{id}/notes": map[string]any{
+			"put": notFound(operation("Replace the notes and tags of one of your attempts", jsonBody(reflect.TypeFor[AttemptNotes]()),
+				jsonBody(reflect.TypeFor[Attempt]()), pathParam("id", "The attempt ID"))),
+		},
+		"/attempts/{id}/replay": map[string]any{
+			"get": notFound(operation("The strokes of an attempt with when each point was drawn, to play it back", nil,
+				jsonBody(reflect.TypeFor[AttemptReplay]()), pathParam("id", "The attempt ID"))),
+		},
+		"/compare": map[string]any{
+			"post": notFound(operation("Render two attempts side by side with their scores",
+				jsonBody(reflect.TypeFor[CompareRequest]()), jsonBody(reflect.TypeFor[CompareResult]()))),
+		},
+		"/report": map[string]any{
+			"post": notFound(operation("A printable one-page PDF of an attempt",
+				jsonBody(reflect.TypeFor[ReportRequest]()), binaryBody("application/pdf"))),
+		},
+		"/result/{id}/{file}": map[string]any{
+			"get": notFound(operation("The saved visualization of an attempt, as returned in imageUrl", nil,
+				binaryBody("image/png", "image/svg+xml", "image/gif", "application/pdf"),
+				pathParam("id", "The attempt ID"),
+				pathParam("file", "image.png, image.svg, image.gif or image.pdf, matching the requested format"))),
+		},
+		"/result/{id}/thumbnail.png": map[string]any{
+			"get": notFound(operation("A small PNG of an attempt, as returned in thumbnailUrl", nil,
+				binaryBody("image/png"), pathParam("id", "The attempt ID"))),
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "tradra",
+			"version": strings.TrimPrefix(apiPrefix, "/api/"),
+		},
+		"servers":    []any{map[string]any{"url": sitePath(apiPrefix)}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.components},
+	}
+}
+
+func withDescription(response map[string]any, description string) map[string]any {
+	response = maps.Clone(response)
+	response["description"] = description
+	return response
+}
+
+// withStatus changes the status of an operation's success response
+func withStatus(op map[string]any, status string) map[string]any {
+	responses := op["responses"].(map[string]any)
+	responses[status] = responses["200"]
+	delete(responses, "200")
+	return op
+}
+
+func queryParam(name, typ, description string) map[string]any {
+	return map[string]any{"name": name, "in": "query", "description": description, "schema": map[string]any{"type": typ}}
+}
+
+func pathParam(name, description string) map[string]any {
+	return map[string]any{"name": name, "in": "path", "required": true, "description": description, "schema": map[string]any{"type": "string"}}
+}
+
+// schemaGenerator turns Go types into JSON schemas following their json
+// tags. Named structs become components referenced by name.
+type schemaGenerator struct {
+	components map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[time.Time]() {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if t.Kind() == reflect.Struct && t.Name() != "" {
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := g.components[t.Name()]; !ok {
+			g.components[t.Name()] = nil // reserve the name so recursive types terminate
+			g.components[t.Name()] = g.object(t)
+		}
+		if names, ok := schemaEnums[t]; ok {
+			// Given by name or as an object starting from a named preset
+			return map[string]any{"oneOf": []any{map[string]any{"type": "string", "enum": names}, ref}}
+		}
+		return ref
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if names, ok := schemaEnums[t]; ok {
+			return map[string]any{"type": "string", "enum": names}
+		}
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.object(t)
+	}
+	return map[string]any{}
+}
+
+// object describes a struct's exported fields by their JSON names
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
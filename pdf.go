@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+)
+
+// pdfDocument assembles a minimal PDF file. Objects are numbered from 1 in
+// the order they're added.
+type pdfDocument struct {
+	objects [][]byte
+}
+
+// add appends an object and returns its number
+func (d *pdfDocument) add(obj string) int {
+	d.objects = append(d.objects, []byte(obj))
+	return len(d.objects)
+}
+
+// reserve adds a placeholder for an object that refers to ones added later
+func (d *pdfDocument) reserve() int {
+	return d.add("")
+}
+
+// set replaces a reserved object
+func (d *pdfDocument) set(n int, obj string) {
+	d.objects[n-1] = []byte(obj)
+}
+
+// addStream appends a stream object, compressing its data
+func (d *pdfDocument) addStream(dict string, data []byte) int {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return d.add(fmt.Sprintf("<< %s /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", dict, buf.Len(), buf.Bytes()))
+}
+
+// addImage appends an image XObject, compositing any transparency over white
+func (d *pdfDocument) addImage(img image.Image) int {
+	b := img.Bounds()
+	rgb := make([]byte, 0, b.Dx()*b.Dy()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			a := uint32(c.A)
+			blend := func(v uint8) byte { return byte((uint32(v)*a + 255*(255-a)) / 255) }
+			rgb = append(rgb, blend(c.R), blend(c.G), blend(c.B))
+		}
+	}
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8", b.Dx(), b.Dy())
+	return d.addStream(dict, rgb)
+}
+
+// bytes writes the document with the given catalog as its root
+func (d *pdfDocument) bytes(root int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int, len(d.objects))
+	for i, obj := range d.objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+		buf.Write(obj)
+		buf.WriteString("\nendobj\n")
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(d.objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.objects)+1, root, xref)
+	return buf.Bytes()
+}
+
+// pdfText encodes a string as a PDF literal string in WinAnsiEncoding, which
+// the standard fonts use. Characters outside it are replaced.
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '—':
+			b.WriteString("\\227")
+		case r == '–':
+			b.WriteString("\\226")
+		case r == '’':
+			b.WriteString("\\222")
+		case r == '→':
+			b.WriteString("->")
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// wrapText splits text into lines of at most width characters
+func wrapText(s string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(s) {
+		if line != "" && len([]rune(line))+1+len([]rune(word)) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
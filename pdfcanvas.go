@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+)
+
+// circleKappa places Bézier control points to approximate a quarter circle
+const circleKappa = 0.5523
+
+// pdfCanvas draws the visualization as vector PDF operators on a single page
+// the size of the canvas in points. Each overlay group becomes an optional
+// content group, which Illustrator and Affinity import as layers.
+type pdfCanvas struct {
+	doc     *pdfDocument
+	content strings.Builder
+	width   float64
+	height  float64
+	unit    float64  // canvas units per output point, so sizes don't zoom
+	visible viewport // canvas-space region covered by the page
+
+	alphas []float64 // opacities used, as graphics states named /GS1, /GS2, ...
+	images []int     // image XObjects, named /Im1, /Im2, ...
+	groups []int     // optional content groups, named /OC1, /OC2, ...
+}
+
+// newPDFCanvas starts a page showing the viewport centered in a width×height
+// canvas. Canvas space has y pointing down, so the transform flips it.
+func newPDFCanvas(width, height float64, view viewport) *pdfCanvas {
+	zoom := view.zoom(width, height)
+	c := &pdfCanvas{
+		doc:     &pdfDocument{},
+		width:   width,
+		height:  height,
+		unit:    1 / zoom,
+		visible: view.visible(width, height),
+	}
+	tx, ty := (width-view.W*zoom)/2, (height-view.H*zoom)/2
+	fmt.Fprintf(&c.content, "%g 0 0 %g %g %g cm\n", zoom, -zoom, tx-zoom*view.X, height-ty+zoom*view.Y)
+	c.content.WriteString("1 J 1 j\n")
+	return c
+}
+
+// Bytes finishes the document and returns it
+func (c *pdfCanvas) Bytes() []byte {
+	catalog := c.doc.reserve()
+	pages := c.doc.reserve()
+	font := c.doc.add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	content := c.doc.addStream("", []byte(c.content.String()))
+
+	var states, xobjects, props, ocgs []string
+	for i, alpha := range c.alphas {
+		states = append(states, fmt.Sprintf("/GS%d << /CA %g /ca %g >>", i+1, alpha, alpha))
+	}
+	for i, img := range c.images {
+		xobjects = append(xobjects, fmt.Sprintf("/Im%d %d 0 R", i+1, img))
+	}
+	for i, g := range c.groups {
+		props = append(props, fmt.Sprintf("/OC%d %d 0 R", i+1, g))
+		ocgs = append(ocgs, fmt.Sprintf("%d 0 R", g))
+	}
+
+	resources := fmt.Sprintf("/Font << /F1 %d 0 R >> /ExtGState << %s >> /XObject << %s >> /Properties << %s >>",
+		font, strings.Join(states, " "), strings.Join(xobjects, " "), strings.Join(props, " "))
+	page := c.doc.add(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %g %g] /Contents %d 0 R /Resources << %s >> >>",
+		pages, c.width, c.height, content, resources))
+	c.doc.set(pages, fmt.Sprintf("<< /Type /Pages /Kids [%d 0 R] /Count 1 >>", page))
+	refs := strings.Join(ocgs, " ")
+	c.doc.set(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /OCProperties << /OCGs [%s] /D << /Order [%s] /ON [%s] >> >> >>",
+		pages, refs, refs, refs))
+	return c.doc.bytes(catalog)
+}
+
+// Clear fills the visible region, which is all of the page
+func (c *pdfCanvas) Clear(col color.Color) {
+	c.SetColor(col)
+	fmt.Fprintf(&c.content, "%.2f %.2f %.2f %.2f re f\n", c.visible.X, c.visible.Y, c.visible.W, c.visible.H)
+}
+
+// SetColor sets both the stroke and fill color, with opacity set through a
+// graphics state since PDF colors have no alpha
+func (c *pdfCanvas) SetColor(col color.Color) {
+	n := color.NRGBAModel.Convert(col).(color.NRGBA)
+	r, g, b := float64(n.R)/255, float64(n.G)/255, float64(n.B)/255
+	alpha := float64(n.A) / 255
+	state := slices.Index(c.alphas, alpha)
+	if state < 0 {
+		c.alphas = append(c.alphas, alpha)
+		state = len(c.alphas) - 1
+	}
+	fmt.Fprintf(&c.content, "%.3f %.3f %.3f RG %.3f %.3f %.3f rg /GS%d gs\n", r, g, b, r, g, b, state+1)
+}
+
+func (c *pdfCanvas) SetLineWidth(w float64) {
+	fmt.Fprintf(&c.content, "%g w\n", w*c.unit)
+}
+
+func (c *pdfCanvas) SetDash(dashes ...float64) {
+	scaled := make([]string, len(dashes))
+	for i, d := range dashes {
+		scaled[i] = fmt.Sprintf("%g", d*c.unit)
+	}
+	fmt.Fprintf(&c.content, "[%s] 0 d\n", strings.Join(scaled, " "))
+}
+
+// DrawLine clips the line to the page so rays to distant VPs stay short
+func (c *pdfCanvas) DrawLine(x1, y1, x2, y2 float64) {
+	x1, y1, x2, y2, ok := clipLine(x1, y1, x2, y2, c.visible)
+	if !ok {
+		return
+	}
+	fmt.Fprintf(&c.content, "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+func (c *pdfCanvas) DrawPolyline(points []Point) {
+	if len(points) == 0 {
+		return
+	}
+	fmt.Fprintf(&c.content, "%.2f %.2f m", points[0].X, points[0].Y)
+	for _, p := range points[1:] {
+		fmt.Fprintf(&c.content, " %.2f %.2f l", p.X, p.Y)
+	}
+	c.content.WriteString(" S\n")
+}
+
+// FillCircle draws the circle as four Bézier curves
+func (c *pdfCanvas) FillCircle(x, y, r float64) {
+	r *= c.unit
+	k := r * circleKappa
+	fmt.Fprintf(&c.content, "%.2f %.2f m ", x+r, y)
+	fmt.Fprintf(&c.content, "%.2f %.2f %.2f %.2f %.2f %.2f c ", x+r, y+k, x+k, y+r, x, y+r)
+	fmt.Fprintf(&c.content, "%.2f %.2f %.2f %.2f %.2f %.2f c ", x-k, y+r, x-r, y+k, x-r, y)
+	fmt.Fprintf(&c.content, "%.2f %.2f %.2f %.2f %.2f %.2f c ", x-r, y-k, x-k, y-r, x, y-r)
+	fmt.Fprintf(&c.content, "%.2f %.2f %.2f %.2f %.2f %.2f c f\n", x+k, y-r, x+r, y-k, x+r, y)
+}
+
+// DrawString flips the text matrix back so glyphs are upright
+func (c *pdfCanvas) DrawString(s string, x, y float64) {
+	fmt.Fprintf(&c.content, "BT /F1 %g Tf 1 0 0 -1 %.2f %.2f Tm %s Tj ET\n", labelFontSize*c.unit, x, y, pdfText(s))
+}
+
+// DrawImage embeds the image, flipped so its first row is at the top
+func (c *pdfCanvas) DrawImage(img image.Image, x, y, w, h float64) {
+	c.images = append(c.images, c.doc.addImage(img))
+	fmt.Fprintf(&c.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, -h, x, y+h, len(c.images))
+}
+
+func (c *pdfCanvas) BeginGroup(name string) {
+	c.groups = append(c.groups, c.doc.add(fmt.Sprintf("<< /Type /OCG /Name %s >>", pdfText(name))))
+	fmt.Fprintf(&c.content, "/OC /OC%d BDC\n", len(c.groups))
+}
+
+func (c *pdfCanvas) EndGroup() {
+	c.content.WriteString("EMC\n")
+}
@@ -0,0 +1,54 @@
+package main
+
+import "math"
+
+// Limits below which a stroke is considered too perfect to be freehand
+const (
+	assistedMinPoints    = 5    // pointer events produce far more samples on a real stroke
+	assistedMinLength    = 50.0 // pixels; short taps legitimately have few points
+	assistedMaxWobble    = 0.05 // pixels of high-frequency jitter
+	assistedMaxRMSE      = 0.25 // pixels of deviation from the ideal line
+	assistedMaxSpacingCV = 0.02 // coefficient of variation of point spacing
+)
+
+// detectAssistedStrokes returns the indices of strokes that look ruler or
+// line-tool assisted: long strokes with almost no samples, or strokes with
+// no jitter at all whose points are also perfectly evenly spaced
+func detectAssistedStrokes(strokes []Stroke, lines []Line, shapes []StrokeShape) []int {
+	assisted := []int{}
+	for i, stroke := range strokes {
+		if len(stroke) < 2 {
+			continue
+		}
+
+		fewPoints := len(stroke) < assistedMinPoints && shapes[i].Length > assistedMinLength
+		tooSmooth := len(stroke) >= 10 &&
+			shapes[i].Wobble < assistedMaxWobble &&
+			lines[i].RMSE < assistedMaxRMSE &&
+			spacingVariation(stroke) < assistedMaxSpacingCV
+
+		if fewPoints || tooSmooth {
+			assisted = append(assisted, i)
+		}
+	}
+	return assisted
+}
+
+// spacingVariation returns the coefficient of variation of the distances
+// between consecutive points
+func spacingVariation(stroke Stroke) float64 {
+	spacings := make([]float64, 0, len(stroke)-1)
+	for i := 1; i < len(stroke); i++ {
+		spacings = append(spacings, distance(stroke[i-1], stroke[i]))
+	}
+
+	mean := meanOf(spacings)
+	if mean == 0 {
+		return 0
+	}
+	var sumSq float64
+	for _, s := range spacings {
+		sumSq += (s - mean) * (s - mean)
+	}
+	return math.Sqrt(sumSq/float64(len(spacings))) / mean
+}
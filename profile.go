@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// maxDisplayName is the longest display name a user may pick
+const maxDisplayName = 64
+
+// maxDeviceID is the longest preferred device ID a profile may hold
+const maxDeviceID = 64
+
+// Handedness, which clients may use to lay out the canvas or mirror guides
+const (
+	HandLeft  = "left"
+	HandRight = "right"
+)
+
+// errUserNotFound is returned for users who've never been saved
+var errUserNotFound = errors.New("User not found")
+
+// ProfileSettings are what users choose for themselves
+type ProfileSettings struct {
+	DisplayName     string `json:"displayName,omitempty"`     // shown instead of the provider's name
+	Handedness      string `json:"handedness,omitempty"`      // left or right, or empty when not said
+	PreferredDevice string `json:"preferredDevice,omitempty"` // the calibrated device used when an analysis names none
+	Leaderboard     bool   `json:"leaderboard"`               // whether their best attempts are shown on leaderboards
+	Gallery         bool   `json:"gallery"`                   // whether their best attempts are shown, without their name, in the gallery
+	WeeklyDigest    bool   `json:"weeklyDigest"`              // whether they're emailed a summary of each week's practice
+}
+
+// Profile is a signed-in user's record, as the provider last reported it,
+// with their settings
+type Profile struct {
+	User
+	ProfileSettings
+	CreatedAt time.Time `json:"createdAt"`
+	Streak    *Streak   `json:"streak,omitempty"` // worked out from their attempts, not stored
+}
+
+// validateProfile rejects settings a profile can't hold
+func validateProfile(s ProfileSettings) error {
+	if len(s.DisplayName) > maxDisplayName {
+		return fieldError(CodeOutOfRange, "displayName", "Display name must be at most %d characters", maxDisplayName)
+	}
+	if s.Handedness != "" && s.Handedness != HandLeft && s.Handedness != HandRight {
+		return fieldError(CodeInvalidValue, "handedness", "Unknown handedness %q, expected left or right", s.Handedness)
+	}
+	if len(s.PreferredDevice) > maxDeviceID {
+		return fieldError(CodeOutOfRange, "preferredDevice", "Device ID must be at most %d characters", maxDeviceID)
+	}
+	return nil
+}
+
+// loadProfile returns a user's profile, saving them first if they signed in
+// before users were recorded
+func loadProfile(u User) (Profile, error) {
+	p, err := store.Profile(u.ID)
+	if errors.Is(err, errUserNotFound) {
+		if err := store.SaveUser(u); err != nil {
+			return p, err
+		}
+		p, err = store.Profile(u.ID)
+	}
+	return p, err
+}
+
+// preferredDevice returns the device the signed-in user of a context prefers,
+// or "" when there's none
+func preferredDevice(ctx context.Context) string {
+	u, ok := userFrom(ctx)
+	if !ok {
+		return ""
+	}
+	return userSettings(ctx, u).PreferredDevice
+}
+
+// userSettings returns the settings a user has saved, or none when they
+// haven't or they can't be loaded
+func userSettings(ctx context.Context, u User) ProfileSettings {
+	p, err := store.Profile(u.ID)
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		slog.ErrorContext(ctx, "Failed to load profile", "userId", u.ID, "err", err)
+	}
+	return p.ProfileSettings
+}
+
+// handleGetProfile returns the signed-in user's profile
+func handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	p, err := loadProfile(u)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load profile", "userId", u.ID, "err", err)
+		httpError(w, "Failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	p.Streak = userStreak(r.Context(), u.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, p)
+}
+
+// handlePutProfile replaces the signed-in user's settings
+func handlePutProfile(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	var settings ProfileSettings
+	if !decodeRequest(w, r, &settings) {
+		return
+	}
+	if err := validateProfile(settings); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	previous, err := loadProfile(u)
+	// Digests already on stay, so other settings can still be saved when
+	// email's turned off
+	if err == nil && settings.WeeklyDigest && !previous.WeeklyDigest {
+		if mailer == nil {
+			writeError(w, fieldError(CodeInvalidValue, "weeklyDigest", "This server doesn't send email"), http.StatusBadRequest)
+			return
+		}
+		if previous.Email == "" {
+			writeError(w, fieldError(CodeInvalidValue, "weeklyDigest", "Your login provider didn't give an email address to send digests to"), http.StatusBadRequest)
+			return
+		}
+	}
+	if err == nil {
+		err = store.SetProfile(u.ID, settings)
+	}
+	var p Profile
+	if err == nil {
+		p, err = store.Profile(u.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save profile", "userId", u.ID, "err", err)
+		httpError(w, "Failed to save profile", http.StatusInternalServerError)
+		return
+	}
+	p.Streak = userStreak(r.Context(), u.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, p)
+}
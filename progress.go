@@ -0,0 +1,180 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultProgressWindow is the number of attempts in each rolling average
+const defaultProgressWindow = 10
+
+// RollingAverage is the mean composite score of the attempts up to a point in time
+type RollingAverage struct {
+	Timestamp time.Time `json:"timestamp"`
+	Average   float64   `json:"average"`
+}
+
+// ExerciseProgress summarizes how scores for one exercise changed over time
+type ExerciseProgress struct {
+	TrainingType          TrainingType     `json:"trainingType"`
+	Attempts              int              `json:"attempts"`
+	FirstAttempt          time.Time        `json:"firstAttempt"`
+	LastAttempt           time.Time        `json:"lastAttempt"`
+	BestCompositeScore    float64          `json:"bestCompositeScore"`
+	BestLineScore         float64          `json:"bestLineScore"`
+	BestPerspectiveScore  float64          `json:"bestPerspectiveScore"`
+	CurrentAverage        float64          `json:"currentAverage"`
+	ImprovementPerDay     float64          `json:"improvementPerDay"`     // composite score points gained per day
+	ImprovementPerAttempt float64          `json:"improvementPerAttempt"` // composite score points gained per attempt
+	RollingAverages       []RollingAverage `json:"rollingAverages"`
+}
+
+// chartBuckets are the periods progress can be charted by
+var chartBuckets = []string{"day", "week", "month"}
+
+// ChartPoint aggregates an exercise's complete attempts over a day, week or
+// month, for charting
+type ChartPoint struct {
+	Start                 string       `json:"start"` // the first day of the bucket, YYYY-MM-DD in UTC
+	TrainingType          TrainingType `json:"trainingType"`
+	Attempts              int          `json:"attempts"`
+	AverageCompositeScore float64      `json:"averageCompositeScore"`
+	AverageLineScore      float64      `json:"averageLineScore"`
+	BestCompositeScore    float64      `json:"bestCompositeScore"`
+	BestPerspectiveScore  float64      `json:"bestPerspectiveScore"`
+}
+
+// handleProgressChart returns the caller's progress bucketed by period. It's
+// computed by the database, so charts don't need every attempt.
+func handleProgressChart(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAttemptRange(r.URL.Query())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	bucket := cmp.Or(r.URL.Query().Get("bucket"), "day")
+	if !slices.Contains(chartBuckets, bucket) {
+		writeError(w, fieldError(CodeInvalidValue, "bucket", "Unknown bucket %q, expected one of %v", bucket, chartBuckets), http.StatusBadRequest)
+		return
+	}
+	visibleAttempts(r.Context(), &f)
+
+	points, err := store.ProgressChart(f, bucket)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to chart progress", "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, points)
+}
+
+func handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := defaultProgressWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			httpError(w, "Invalid window", http.StatusBadRequest)
+			return
+		}
+		window = n
+	}
+
+	history, err := loadHistory(TrainingType(r.URL.Query().Get("trainingType")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load history", "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, calculateProgress(visibleHistory(r.Context(), history), window))
+}
+
+// calculateProgress groups complete attempts by exercise and computes trend metrics
+func calculateProgress(history []AttemptSummary, window int) []ExerciseProgress {
+	byType := map[TrainingType][]AttemptSummary{}
+	var types []TrainingType
+	for _, a := range history {
+		if a.Partial {
+			continue
+		}
+		if _, seen := byType[a.TrainingType]; !seen {
+			types = append(types, a.TrainingType)
+		}
+		byType[a.TrainingType] = append(byType[a.TrainingType], a)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	progress := make([]ExerciseProgress, 0, len(types))
+	for _, t := range types {
+		attempts := byType[t]
+		sort.Slice(attempts, func(i, j int) bool {
+			return attempts[i].Timestamp.Before(attempts[j].Timestamp)
+		})
+		progress = append(progress, exerciseProgress(t, attempts, window))
+	}
+	return progress
+}
+
+// exerciseProgress computes the metrics for one exercise's attempts, sorted by time
+func exerciseProgress(t TrainingType, attempts []AttemptSummary, window int) ExerciseProgress {
+	p := ExerciseProgress{
+		TrainingType: t,
+		Attempts:     len(attempts),
+		FirstAttempt: attempts[0].Timestamp,
+		LastAttempt:  attempts[len(attempts)-1].Timestamp,
+	}
+
+	days := make([]float64, len(attempts))
+	indices := make([]float64, len(attempts))
+	scores := make([]float64, len(attempts))
+	sum := 0.0
+	for i, a := range attempts {
+		p.BestCompositeScore = max(p.BestCompositeScore, a.CompositeScore)
+		p.BestLineScore = max(p.BestLineScore, a.AverageLineScore)
+		p.BestPerspectiveScore = max(p.BestPerspectiveScore, a.PerspectiveScore)
+
+		days[i] = a.Timestamp.Sub(p.FirstAttempt).Hours() / 24
+		indices[i] = float64(i)
+		scores[i] = a.CompositeScore
+
+		// Rolling average over the last `window` attempts
+		sum += a.CompositeScore
+		if i >= window {
+			sum -= attempts[i-window].CompositeScore
+		}
+		p.RollingAverages = append(p.RollingAverages, RollingAverage{
+			Timestamp: a.Timestamp,
+			Average:   sum / float64(min(i+1, window)),
+		})
+	}
+
+	p.CurrentAverage = p.RollingAverages[len(p.RollingAverages)-1].Average
+	p.ImprovementPerDay = regressionSlope(days, scores)
+	p.ImprovementPerAttempt = regressionSlope(indices, scores)
+	return p
+}
+
+// regressionSlope returns the least squares slope of y over x, or 0 when x has no spread
+func regressionSlope(x, y []float64) float64 {
+	meanX, meanY := meanOf(x), meanOf(y)
+	var sumXY, sumXX float64
+	for i := range x {
+		dx := x[i] - meanX
+		sumXY += dx * (y[i] - meanY)
+		sumXX += dx * dx
+	}
+	if sumXX == 0 {
+		return 0
+	}
+	return sumXY / sumXX
+}
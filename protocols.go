@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Protocols that can be picked with -protocols
+const (
+	protoHTTP1 = "http1" // HTTP/1.1, needed for WebSockets
+	protoH2    = "h2"    // HTTP/2 over TLS
+	protoH2C   = "h2c"   // HTTP/2 without TLS, for proxies that speak it
+	protoH3    = "h3"    // HTTP/3 over QUIC, on the same port over UDP
+)
+
+// parseProtocols reads the protocols to serve into those of the TCP server,
+// and whether HTTP/3 is served alongside it
+func parseProtocols(names []string) (http.Protocols, bool, error) {
+	var protocols http.Protocols
+	h3 := false
+	for _, name := range names {
+		switch name {
+		case protoHTTP1:
+			protocols.SetHTTP1(true)
+		case protoH2:
+			protocols.SetHTTP2(true)
+		case protoH2C:
+			protocols.SetUnencryptedHTTP2(true)
+		case protoH3:
+			h3 = true
+		default:
+			return protocols, false, fmt.Errorf("unknown protocol %q; use %s, %s, %s or %s", name, protoHTTP1, protoH2, protoH2C, protoH3)
+		}
+	}
+	if !protocols.HTTP1() && !protocols.HTTP2() && !protocols.UnencryptedHTTP2() {
+		return protocols, false, fmt.Errorf("-protocols needs at least one of %s, %s or %s, since %s is only offered over them", protoHTTP1, protoH2, protoH2C, protoH3)
+	}
+	return protocols, h3, nil
+}
+
+// withoutHTTP2 stops TLS from offering HTTP/2 when it's turned off. Autocert's
+// TLS config offers it by default, which would leave clients that pick it
+// talking to a server that won't answer.
+func withoutHTTP2(server *http.Server) {
+	if server.TLSConfig != nil && !server.Protocols.HTTP2() {
+		server.TLSConfig.NextProtos = slices.DeleteFunc(server.TLSConfig.NextProtos, func(p string) bool { return p == "h2" })
+	}
+}
+
+// advertiseHTTP3 adds the Alt-Svc header that tells browsers they can switch
+// to HTTP/3 for later requests
+func advertiseHTTP3(next http.Handler, h3 *http3.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// QR codes are made in byte mode at error correction level M, which is
+// plenty for the short links they carry, in versions 1 to 9, which hold up
+// to 180 bytes and count them in 8 bits
+const (
+	qrMaxVersion = 9
+	qrQuietZone  = 4 // modules of light margin around the code
+)
+
+// errQRTooLong is returned for text that doesn't fit in the largest version
+var errQRTooLong = errors.New("too long for a QR code")
+
+// qrBlocks is how a version splits its codewords at level M: the error
+// correction codewords per block, then the data codewords of each block
+var qrBlocks = [qrMaxVersion + 1]struct {
+	ecc  int
+	data []int
+}{
+	1: {10, []int{16}},
+	2: {16, []int{28}},
+	3: {26, []int{44}},
+	4: {18, []int{32, 32}},
+	5: {24, []int{43, 43}},
+	6: {16, []int{27, 27, 27, 27}},
+	7: {18, []int{31, 31, 31, 31}},
+	8: {22, []int{38, 38, 39, 39}},
+	9: {22, []int{36, 36, 36, 37, 37}},
+}
+
+// qrAlignment is where each version's alignment patterns are centered, on
+// both axes
+var qrAlignment = [qrMaxVersion + 1][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46},
+}
+
+// qrCode is a QR code's modules, true for dark ones, by row and column
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // finder, timing, alignment, format and version modules, which masks leave be
+}
+
+// encodeQR makes the QR code of text in the smallest version it fits in,
+// with the mask that makes it easiest to scan
+func encodeQR(text string) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= qrMaxVersion; v++ {
+		if qrDataLength(v)*8 >= 4+8+len(text)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(qrCodewords(version, []byte(text)))
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // masks undo themselves
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q, nil
+}
+
+// qrDataLength is how many data codewords a version holds
+func qrDataLength(version int) int {
+	n := 0
+	for _, d := range qrBlocks[version].data {
+		n += d
+	}
+	return n
+}
+
+// qrCodewords encodes text in byte mode, pads it to the version's data
+// codewords, adds error correction and interleaves the blocks
+func qrCodewords(version int, text []byte) []byte {
+	var bits qrBits
+	bits.append(0b0100, 4) // byte mode
+	bits.append(len(text), 8)
+	for _, b := range text {
+		bits.append(int(b), 8)
+	}
+	capacity := qrDataLength(version) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	data := bits.bytes()
+
+	blocks := qrBlocks[version]
+	divisor := reedSolomonDivisor(blocks.ecc)
+	var dataBlocks, eccBlocks [][]byte
+	for _, n := range blocks.data {
+		dataBlocks = append(dataBlocks, data[:n])
+		eccBlocks = append(eccBlocks, reedSolomonRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+	var out []byte
+	for i := 0; i < blocks.data[len(blocks.data)-1]; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecc; i++ {
+		for _, b := range eccBlocks {
+			out = append(out, b[i])
+		}
+	}
+	return out
+}
+
+// qrBits is a bit stream, one bit per element
+type qrBits []byte
+
+// append adds the low n bits of v, most significant first
+func (b *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, byte(v>>i&1))
+	}
+}
+
+// bytes packs the stream, whose length is a multiple of 8, into bytes
+func (b qrBits) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		out[i/8] |= bit << (7 - i%8)
+	}
+	return out
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// reedSolomonDivisor returns the generator polynomial of a degree, highest
+// coefficient first with the leading 1 left out
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+	return result
+}
+
+// reedSolomonRemainder returns the error correction codewords of data
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// newQRCode lays out a version's function patterns, with room left for the
+// format, which depends on the mask
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range size {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	for i := range size {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {3, size - 4}, {size - 4, 3}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				row, col := c[0]+dy, c[1]+dx
+				if row >= 0 && row < size && col >= 0 && col < size {
+					d := max(abs(dx), abs(dy))
+					q.set(row, col, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	pos := qrAlignment[version]
+	for i, row := range pos {
+		for j, col := range pos {
+			// Those where the finder patterns are
+			if i == 0 && j == 0 || i == 0 && j == len(pos)-1 || i == len(pos)-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(row+dy, col+dx, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	q.drawFormat(0)
+
+	if version >= 7 {
+		rem := version
+		for range 12 {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := range 18 {
+			dark := bits>>i&1 == 1
+			a, b := size-11+i%3, i/3
+			q.set(b, a, dark)
+			q.set(a, b, dark)
+		}
+	}
+	return q
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// set sets a function module
+func (q *qrCode) set(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.function[row][col] = true
+}
+
+// drawFormat draws both copies of the format, level M with a mask, and the
+// dark module beside them
+func (q *qrCode) drawFormat(mask int) {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(i, 8, bit(i))
+	}
+	q.set(7, 8, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(8, 14-i, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(8, q.size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(q.size-15+i, 8, bit(i))
+	}
+	q.set(q.size-8, 8, true)
+}
+
+// drawCodewords fills the modules that aren't function modules with
+// codewords, in pairs of columns zigzagging up and down from the bottom
+// right, skipping the vertical timing pattern
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			row := vert
+			if upward {
+				row = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				col := right - j
+				if !q.function[row][col] && i < len(data)*8 {
+					q.modules[row][col] = data[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the modules a mask picks, other than function modules
+func (q *qrCode) applyMask(mask int) {
+	for y := range q.size {
+		for x := range q.size {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard a masked code is to scan, as the standard does:
+// long runs, 2x2 blocks, patterns that look like finders and imbalance
+// between dark and light all count against it
+func (q *qrCode) penalty() int {
+	p := 0
+	at := func(row, col int, transpose bool) bool {
+		if transpose {
+			return q.modules[col][row]
+		}
+		return q.modules[row][col]
+	}
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, transpose := range []bool{false, true} {
+		for i := range q.size {
+			run := 1
+			for j := 1; j <= q.size; j++ {
+				if j < q.size && at(i, j, transpose) == at(i, j-1, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += run - 2
+				}
+				run = 1
+			}
+			// A finder's 1:1:3:1:1 with four light modules on either side,
+			// the margin counting as light
+			for j := 0; j+7 <= q.size; j++ {
+				match := true
+				for k, dark := range finder {
+					if at(i, j+k, transpose) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				light := func(from, to int) bool {
+					for c := from; c < to; c++ {
+						if c >= 0 && c < q.size && at(i, c, transpose) {
+							return false
+						}
+					}
+					return true
+				}
+				if light(j-4, j) || light(j+7, j+11) {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := range q.size {
+		for x := range q.size {
+			if q.modules[y][x] {
+				dark++
+			}
+			if y > 0 && x > 0 {
+				c := q.modules[y][x]
+				if q.modules[y-1][x] == c && q.modules[y][x-1] == c && q.modules[y-1][x-1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	p += abs(dark*20-total*10) / total * 10
+	return p
+}
+
+// png renders the code with scale pixels per module and its quiet zone
+func (q *qrCode) png(scale int) ([]byte, error) {
+	n := (q.size + 2*qrQuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, n, n), color.Palette{color.White, color.Black})
+	for y := range q.size {
+		for x := range q.size {
+			if !q.modules[y][x] {
+				continue
+			}
+			for dy := range scale {
+				for dx := range scale {
+					img.SetColorIndex((x+qrQuietZone)*scale+dx, (y+qrQuietZone)*scale+dy, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
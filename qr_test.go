@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// The vectors below are from ISO/IEC 18004: its worked example of version
+// 1-M, and its tables of format and version information and of block
+// structure. The decoder reads codes the way the standard lays them out,
+// without the encoder's helpers, so a round trip checks one against the
+// other.
+
+// TestReedSolomonISOExample checks the error correction of the standard's
+// version 1-M example, "01234567" in numeric mode
+func TestReedSolomonISOExample(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	want := []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("error correction = % X, want % X", got, want)
+	}
+}
+
+// qrFormatM is the format information of level M with each mask, most
+// significant bit first
+var qrFormatM = [8]string{
+	"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+	"100010111111001", "100000011001110", "100111110010111", "100101010100000",
+}
+
+func TestQRFormat(t *testing.T) {
+	for mask, want := range qrFormatM {
+		q := newQRCode(1)
+		q.drawFormat(mask)
+		first, second := readQRFormat(q.modules)
+		if first != want || second != want {
+			t.Errorf("mask %d: format = %s and %s, want %s", mask, first, second, want)
+		}
+	}
+}
+
+func TestQRVersion(t *testing.T) {
+	for version, want := range map[int]string{
+		7: "000111110010010100",
+		8: "001000010110111100",
+		9: "001001101010011001",
+	} {
+		q := newQRCode(version)
+		// Bit 0, the least significant, is nearest the corner
+		var first, second strings.Builder
+		for i := 17; i >= 0; i-- {
+			a, b := q.size-11+i%3, i/3
+			first.WriteByte(qrBit(q.modules[b][a]))
+			second.WriteByte(qrBit(q.modules[a][b]))
+		}
+		if first.String() != want || second.String() != want {
+			t.Errorf("version %d: version information = %s and %s, want %s", version, first.String(), second.String(), want)
+		}
+	}
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"A",
+		"https://tradra.example.com/?link=ABCD-EFGH",
+		strings.Repeat("0123456789", 8),
+		strings.Repeat("x", 180),
+	} {
+		q, err := encodeQR(text)
+		if err != nil {
+			t.Fatalf("encodeQR(%d bytes): %v", len(text), err)
+		}
+		got, err := decodeQR(q.modules)
+		if err != nil {
+			t.Errorf("decoding %d bytes: %v", len(text), err)
+			continue
+		}
+		if got != text {
+			t.Errorf("decoded %q, want %q", got, text)
+		}
+	}
+	if _, err := encodeQR(strings.Repeat("x", 181)); err != errQRTooLong {
+		t.Errorf("encodeQR(181 bytes) = %v, want errQRTooLong", err)
+	}
+}
+
+func TestQRPNG(t *testing.T) {
+	const scale = 3
+	q, err := encodeQR("https://tradra.example.com/?link=ABCD-EFGH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := q.png(scale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := (q.size + 2*qrQuietZone) * scale; img.Bounds().Dx() != n || img.Bounds().Dy() != n {
+		t.Fatalf("image is %v, want %dx%d", img.Bounds().Size(), n, n)
+	}
+	for y := -qrQuietZone; y < q.size+qrQuietZone; y++ {
+		for x := -qrQuietZone; x < q.size+qrQuietZone; x++ {
+			r, _, _, _ := img.At((x+qrQuietZone)*scale+scale/2, (y+qrQuietZone)*scale+scale/2).RGBA()
+			dark := x >= 0 && x < q.size && y >= 0 && y < q.size && q.modules[y][x]
+			if (r == 0) != dark {
+				t.Fatalf("module (%d, %d) is drawn dark=%v, want %v", x, y, r == 0, dark)
+			}
+		}
+	}
+}
+
+// qrStructure is each version's blocks at level M: how many there are with
+// a number of codewords in all and of data
+var qrStructure = map[int][]struct{ count, total, data int }{
+	1: {{1, 26, 16}},
+	2: {{1, 44, 28}},
+	3: {{1, 70, 44}},
+	4: {{2, 50, 32}},
+	5: {{2, 67, 43}},
+	6: {{4, 43, 27}},
+	7: {{4, 49, 31}},
+	8: {{2, 60, 38}, {2, 61, 39}},
+	9: {{3, 58, 36}, {2, 59, 37}},
+}
+
+// qrAlignmentCenters is where each version's alignment patterns are
+// centered, on both axes
+var qrAlignmentCenters = map[int][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46},
+}
+
+func qrBit(dark bool) byte {
+	if dark {
+		return '1'
+	}
+	return '0'
+}
+
+// readQRFormat reads both copies of the format information, most
+// significant bit first
+func readQRFormat(m [][]bool) (string, string) {
+	size := len(m)
+	var first, second strings.Builder
+	for col := 0; col <= 5; col++ {
+		first.WriteByte(qrBit(m[8][col]))
+	}
+	first.WriteByte(qrBit(m[8][7]))
+	first.WriteByte(qrBit(m[8][8]))
+	first.WriteByte(qrBit(m[7][8]))
+	for row := 5; row >= 0; row-- {
+		first.WriteByte(qrBit(m[row][8]))
+	}
+	for row := size - 1; row >= size-7; row-- {
+		second.WriteByte(qrBit(m[row][8]))
+	}
+	for col := size - 8; col < size; col++ {
+		second.WriteByte(qrBit(m[8][col]))
+	}
+	return first.String(), second.String()
+}
+
+// decodeQR reads a level M, byte mode QR code of version 1 to 9, checking
+// its error correction
+func decodeQR(m [][]bool) (string, error) {
+	size := len(m)
+	version := (size - 17) / 4
+	blocks, ok := qrStructure[version]
+	if !ok || size != 17+4*version {
+		return "", fmt.Errorf("unexpected size %d", size)
+	}
+	format, second := readQRFormat(m)
+	if format != second {
+		return "", fmt.Errorf("format copies differ: %s and %s", format, second)
+	}
+	mask := -1
+	for i, f := range qrFormatM {
+		if f == format {
+			mask = i
+		}
+	}
+	if mask < 0 {
+		return "", fmt.Errorf("format %s isn't level M", format)
+	}
+
+	// Modules that aren't data: finders with their separators and the
+	// format beside them, timing patterns, alignment patterns and version
+	// information
+	reserved := make([][]bool, size)
+	for i := range reserved {
+		reserved[i] = make([]bool, size)
+	}
+	fill := func(row0, col0, row1, col1 int) {
+		for row := max(row0, 0); row <= min(row1, size-1); row++ {
+			for col := max(col0, 0); col <= min(col1, size-1); col++ {
+				reserved[row][col] = true
+			}
+		}
+	}
+	fill(0, 0, 8, 8)
+	fill(0, size-8, 8, size-1)
+	fill(size-8, 0, size-1, 8)
+	fill(6, 0, 6, size-1)
+	fill(0, 6, size-1, 6)
+	centers := qrAlignmentCenters[version]
+	for i, row := range centers {
+		for j, col := range centers {
+			// None where the finders are
+			last := len(centers) - 1
+			if !(i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0) {
+				fill(row-2, col-2, row+2, col+2)
+			}
+		}
+	}
+	if version >= 7 {
+		fill(0, size-11, 5, size-9)
+		fill(size-11, 0, size-9, 5)
+	}
+
+	// Codewords run up and down pairs of columns from the bottom right,
+	// right column first, skipping the vertical timing pattern
+	var bits []byte
+	upward := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for k := range size {
+			row := k
+			if upward {
+				row = size - 1 - k
+			}
+			for _, col := range []int{right, right - 1} {
+				if reserved[row][col] {
+					continue
+				}
+				dark := m[row][col]
+				if qrMasked(mask, row, col) {
+					dark = !dark
+				}
+				bits = append(bits, qrBit(dark)-'0')
+			}
+		}
+		upward = !upward
+	}
+	total := 0
+	for _, b := range blocks {
+		total += b.count * b.total
+	}
+	if len(bits)/8 != total {
+		return "", fmt.Errorf("%d codewords, want %d", len(bits)/8, total)
+	}
+	codewords := make([]byte, total)
+	for i := range codewords {
+		for _, bit := range bits[i*8 : i*8+8] {
+			codewords[i] = codewords[i]<<1 | bit
+		}
+	}
+
+	// Data codewords are interleaved across blocks, then error correction
+	var split [][]byte
+	var sizes []int
+	for _, b := range blocks {
+		for range b.count {
+			split = append(split, nil)
+			sizes = append(sizes, b.data)
+		}
+	}
+	ecc := blocks[0].total - blocks[0].data
+	next := 0
+	for i := 0; i < sizes[len(sizes)-1]; i++ {
+		for b := range split {
+			if i < sizes[b] {
+				split[b] = append(split[b], codewords[next])
+				next++
+			}
+		}
+	}
+	for range ecc {
+		for b := range split {
+			split[b] = append(split[b], codewords[next])
+			next++
+		}
+	}
+	var data []byte
+	for b, block := range split {
+		if !qrSyndromesZero(block, ecc) {
+			return "", fmt.Errorf("block %d fails its error correction", b)
+		}
+		data = append(data, block[:sizes[b]]...)
+	}
+
+	if data[0]>>4 != 0b0100 {
+		return "", fmt.Errorf("mode %04b isn't byte mode", data[0]>>4)
+	}
+	n := int(data[0]&0x0F)<<4 | int(data[1]>>4)
+	if 2+n > len(data) {
+		return "", fmt.Errorf("%d bytes don't fit", n)
+	}
+	text := make([]byte, n)
+	for i := range text {
+		text[i] = data[1+i]<<4 | data[2+i]>>4
+	}
+	return string(text), nil
+}
+
+// qrMasked reports whether a mask flips the module at a row and column
+func qrMasked(mask, i, j int) bool {
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return i*j%2+i*j%3 == 0
+	case 6:
+		return (i*j%2+i*j%3)%2 == 0
+	default:
+		return ((i+j)%2+i*j%3)%2 == 0
+	}
+}
+
+// qrSyndromesZero reports whether a block, highest coefficient first, is a
+// codeword: zero at the first ecc powers of α in GF(2^8) modulo 0x11D
+func qrSyndromesZero(block []byte, ecc int) bool {
+	var exp [255]int
+	log := map[int]int{}
+	x := 1
+	for i := range exp {
+		exp[i] = x
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	mul := func(a, b int) int {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return exp[(log[a]+log[b])%255]
+	}
+	for i := range ecc {
+		s := 0
+		for _, c := range block {
+			s = mul(s, exp[i]) ^ int(c)
+		}
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"math"
+)
+
+// Anti-aliasing quality of PNG output
+const (
+	AntialiasStandard = "standard" // gg's own edge anti-aliasing
+	AntialiasHigh     = "high"     // rendered larger and downsampled, smoothing text and thin lines
+)
+
+// Supersampling for high quality anti-aliasing. The factor is reduced at high
+// render scales and for large canvases so the intermediate image stays a sane
+// size.
+const (
+	supersampleFactor    = 3
+	maxSupersampledScale = 8.0
+)
+
+// maxDPI caps the resolution written to PNG metadata
+const maxDPI = 2400.0
+
+// supersampling returns how many times larger to render a width×height
+// canvas for the given quality and render scale, keeping to maxRenderPixels
+func supersampling(antialias string, width, height, scale float64) int {
+	if antialias != AntialiasHigh {
+		return 1
+	}
+	factor := max(1, min(supersampleFactor, int(maxSupersampledScale/scale)))
+	for factor > 1 && width*height*math.Pow(scale*float64(factor), 2) > maxRenderPixels {
+		factor--
+	}
+	return factor
+}
+
+// downsample shrinks a supersampled image to width×height by averaging each
+// factor×factor block. Averaging premultiplied RGBA keeps edges against
+// transparency correct.
+func downsample(src *image.RGBA, width, height, factor int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sb := src.Bounds()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum [4]int
+			n := 0
+			for sy := y * factor; sy < min((y+1)*factor, sb.Dy()); sy++ {
+				for sx := x * factor; sx < min((x+1)*factor, sb.Dx()); sx++ {
+					i := src.PixOffset(sb.Min.X+sx, sb.Min.Y+sy)
+					for c := range sum {
+						sum[c] += int(src.Pix[i+c])
+					}
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			o := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[o+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// withPNGResolution inserts a pHYs chunk after the IHDR chunk of an encoded
+// PNG so it prints at the given dots per inch
+func withPNGResolution(data []byte, dpi float64) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4 // signature, then IHDR length, type, data and CRC
+	if len(data) < ihdrEnd {
+		return data
+	}
+
+	ppm := uint32(math.Round(dpi / 0.0254)) // pixels per meter
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], ppm)
+	binary.BigEndian.PutUint32(chunk[12:], ppm)
+	chunk[16] = 1 // unit is the meter
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often buckets of clients that have gone
+// quiet are dropped
+const rateLimitSweepInterval = time.Minute
+
+// rateLimiter is a token bucket per client IP. Each client may make burst
+// requests at once, refilled at rate requests per second, as currently set.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}, lastSweep: time.Now()}
+}
+
+// allow takes a token from the client's bucket. When it's empty, it returns
+// how long until the next token. Everything is allowed while the limit is off.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	return l.allowN(client, 1, now)
+}
+
+// allowN takes n tokens from the client's bucket, for requests that cost
+// more than one, or none when it has fewer and returns how long until it
+// will. More than the burst is never allowed, and the wait is 0.
+func (l *rateLimiter) allowN(client string, n int, now time.Time) (bool, time.Duration) {
+	limit := currentSettings().RateLimit
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	rate, burst := limit.Rate, float64(limit.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now, rate, burst)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	cost := float64(n)
+	if cost > burst {
+		return false, 0
+	}
+	if b.tokens < cost {
+		return false, time.Duration((cost - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens -= cost
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely, since a new bucket for
+// the same client would be identical
+func (l *rateLimiter) sweep(now time.Time, rate, burst float64) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(burst / rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateChargeKey is the context key of a rate-limited request's or stream's
+// charge function, which takes more tokens from its client's bucket
+type rateChargeKey struct{}
+
+// rateLimit wraps a handler so each client IP can only call it at the
+// configured rate, answering 429 with Retry-After when it's exceeded. Each
+// request takes a token; those that do more, like batches, take the rest
+// with chargeRate.
+func rateLimit(next http.HandlerFunc, cfg config) http.HandlerFunc {
+	limiter := newRateLimiter()
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientIP(r, cfg)
+		if ok, wait := limiter.allow(client, time.Now()); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		charge := func(n int) (bool, time.Duration) { return limiter.allowN(client, n, time.Now()) }
+		next(w, r.WithContext(context.WithValue(r.Context(), rateChargeKey{}, charge)))
+	}
+}
+
+// chargeRate takes the tokens of a request that does n analyses, beyond the
+// one rateLimit took, answering 429 and returning false when the client
+// doesn't have them. Requests that aren't rate limited are always allowed.
+func chargeRate(w http.ResponseWriter, r *http.Request, n int) bool {
+	charge, ok := r.Context().Value(rateChargeKey{}).(func(int) (bool, time.Duration))
+	if !ok || n <= 1 {
+		return true
+	}
+	ok, wait := charge(n - 1)
+	switch {
+	case !ok && wait == 0:
+		httpError(w, fmt.Sprintf("The rate limit allows at most %d analyses at once; send fewer", currentSettings().RateLimit.Burst), http.StatusTooManyRequests)
+	case !ok:
+		tooManyRequests(w, wait)
+	}
+	return ok
+}
+
+// tooManyRequests answers 429, saying when to retry
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	httpError(w, "Too many requests, slow down", http.StatusTooManyRequests)
+}
+
+// clientIP returns the IP a request came from. Behind trusted reverse
+// proxies it's the last address in X-Forwarded-For that isn't one of them:
+// each proxy appends the address it was connected from, so addresses left of
+// that are whatever the client sent.
+func clientIP(r *http.Request, cfg config) string {
+	ip := remoteIP(r)
+	if !cfg.trustedProxies.connectedFrom(r) {
+		return ip
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = hops[i]
+		if !cfg.trustedProxies.contains(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteIP returns the IP of a request's connection, or its address as it is
+// when it has no IP, as over a unix socket
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
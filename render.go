@@ -0,0 +1,867 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // reference photos may be JPEG
+	"image/png"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fogleman/gg"
+)
+
+// Output formats for the visualization
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+	FormatGIF = "gif" // animated replay of the drawing
+	FormatPDF = "pdf" // vector overlay for illustration software
+)
+
+// maxScale is the largest device pixel ratio the overlay is rendered at
+const maxScale = 4.0
+
+// maxCanvasSize is the largest width or height a drawing's canvas may have
+const maxCanvasSize = 4096.0
+
+// maxRenderPixels caps the pixels of any image rendered, at its scale and
+// supersampling included, so a small request can't exhaust memory
+const maxRenderPixels = 32 << 20
+
+// labelFontSize is the font size of overlay text in canvas units
+const labelFontSize = 14
+
+// labelFontPath is the font overlay text is drawn in, when it's installed
+const labelFontPath = "/System/Library/Fonts/HelveticaNeue.ttc"
+
+// maxFitExpansion limits how far, in canvas sizes, the viewport grows past each
+// side of the canvas to fit the vanishing points; VPs of near-parallel lines
+// can be arbitrarily far away
+const maxFitExpansion = 4.0
+
+// fitMargin is the space in canvas units kept around VPs when fitting them
+const fitMargin = 40.0
+
+// maxGridRays caps the perspective grid density
+const maxGridRays = 100
+
+// maxBackgroundPixels caps the size of a decoded reference photo
+const maxBackgroundPixels = 40_000_000
+
+// viewport is the region of canvas space shown in the output image
+type viewport struct {
+	X, Y, W, H float64
+}
+
+// visualization holds everything the renderer draws
+type visualization struct {
+	req                              AnalysisRequest
+	lines                            []Line
+	verticals, leftGroup, rightGroup []int
+	leftVP, rightVP                  *Point
+	leftError, rightError            float64 // convergence error of each group in pixels
+	attemptID                        string  // set once the attempt is recorded
+}
+
+// Canvas is a drawing surface the visualization can be rendered onto.
+// Shapes are drawn immediately using the current color and line width.
+type Canvas interface {
+	Clear(c color.Color)
+	SetColor(c color.Color)
+	SetLineWidth(w float64)
+	// SetDash sets the dash pattern in output pixels at 1x; no arguments draws solid lines
+	SetDash(dashes ...float64)
+	DrawLine(x1, y1, x2, y2 float64)
+	DrawPolyline(points []Point)
+	FillCircle(x, y, r float64)
+	DrawString(s string, x, y float64)
+	// DrawImage stretches an image over the x, y, w, h rectangle
+	DrawImage(img image.Image, x, y, w, h float64)
+	// BeginGroup and EndGroup bracket the shapes of one overlay element
+	BeginGroup(name string)
+	EndGroup()
+}
+
+// renderVisualization draws the analysis in the requested format and saves it
+// to the results directory. PNG and GIF output are returned as a base64 data
+// URI, SVG output as the raw document. Both are sized at the request's scale so they
+// stay sharp on high-DPI screens while using the canvas coordinate system.
+func renderVisualization(v visualization, score float64) (imageData, svg, savedPath string) {
+	width, height := v.req.Width, v.req.Height
+	scale := v.req.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+	view := viewportFor(v)
+
+	if v.req.Format == FormatSVG {
+		c := newSVGCanvas(width, height, scale, view)
+		drawVisualization(c, v)
+		doc := c.String()
+		savedPath = saveResultToFile([]byte(doc), FormatSVG, v.req.TrainingType, score, v.attemptID)
+		return "", doc, savedPath
+	}
+
+	if v.req.Format == FormatPDF {
+		c := newPDFCanvas(width, height, view)
+		drawVisualization(c, v)
+		data := c.Bytes()
+		savedPath = saveResultToFile(data, FormatPDF, v.req.TrainingType, score, v.attemptID)
+		return "data:application/pdf;base64," + base64.StdEncoding.EncodeToString(data), "", savedPath
+	}
+
+	if v.req.Format == FormatGIF {
+		data := renderReplay(v, replayScale(width, height, scale), view)
+		savedPath = saveResultToFile(data, FormatGIF, v.req.TrainingType, score, v.attemptID)
+		return "data:image/gif;base64," + base64.StdEncoding.EncodeToString(data), "", savedPath
+	}
+
+	// Render larger and shrink for high quality anti-aliasing
+	factor := supersampling(v.req.Antialias, width, height, scale)
+	c := newGGCanvas(width, height, scale*float64(factor), view)
+	drawVisualization(c, v)
+	img := c.dc.Image()
+	if factor > 1 {
+		img = downsample(img.(*image.RGBA), int(math.Round(width*scale)), int(math.Round(height*scale)), factor)
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	data := buf.Bytes()
+	if v.req.DPI > 0 {
+		data = withPNGResolution(data, v.req.DPI)
+	}
+	savedPath = saveResultToFile(data, FormatPNG, v.req.TrainingType, score, v.attemptID)
+	imageData = "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+	return imageData, "", savedPath
+}
+
+// viewportFor returns the canvas itself, or when the request asks to fit the
+// vanishing points, the canvas grown to include them
+func viewportFor(v visualization) viewport {
+	w, h := v.req.Width, v.req.Height
+	if !v.req.FitVPs {
+		return viewport{0, 0, w, h}
+	}
+
+	minX, minY, maxX, maxY := 0.0, 0.0, w, h
+	for _, vp := range []*Point{v.leftVP, v.rightVP} {
+		if vp == nil {
+			continue
+		}
+		minX = math.Min(minX, math.Max(vp.X-fitMargin, -w*maxFitExpansion))
+		maxX = math.Max(maxX, math.Min(vp.X+fitMargin, w*(1+maxFitExpansion)))
+		minY = math.Min(minY, math.Max(vp.Y-fitMargin, -h*maxFitExpansion))
+		maxY = math.Max(maxY, math.Min(vp.Y+fitMargin, h*(1+maxFitExpansion)))
+	}
+	return viewport{minX, minY, maxX - minX, maxY - minY}
+}
+
+// zoom returns the factor a viewport is scaled by to fit a width×height image
+func (vp viewport) zoom(width, height float64) float64 {
+	return math.Min(width/vp.W, height/vp.H)
+}
+
+// visible returns the region shown in a width×height image, which extends past
+// the viewport on two sides when their aspect ratios differ
+func (vp viewport) visible(width, height float64) viewport {
+	zoom := vp.zoom(width, height)
+	w, h := width/zoom, height/zoom
+	return viewport{vp.X - (w-vp.W)/2, vp.Y - (h-vp.H)/2, w, h}
+}
+
+// drawVisualization draws the overlay showing the analysis
+func drawVisualization(c Canvas, v visualization) {
+	req := v.req
+	pal, err := req.Theme.palette()
+	if err != nil {
+		// Requests are validated before analysis, so this only guards direct callers
+		pal, _ = (*Theme)(nil).palette()
+	}
+	style, err := req.Style.resolve()
+	if err != nil {
+		style = defaultStyle
+	}
+	loc := findLocale(req.Locale)
+
+	// Text offsets are in output pixels, converted to canvas units so labels
+	// keep their spacing when the view is zoomed out
+	view := viewportFor(v)
+	unit := 1 / view.zoom(req.Width, req.Height)
+	frame := view.visible(req.Width, req.Height)
+
+	// Legend entries are collected as each layer is drawn
+	var legend []legendEntry
+
+	// Draw background, outlining the canvas when the view extends past it.
+	// Transparent output has no background so it can be composited over the
+	// original drawing.
+	if !req.Transparent {
+		c.Clear(pal.background)
+	}
+	drawBackground(c, req)
+	if view != (viewport{0, 0, req.Width, req.Height}) {
+		c.BeginGroup("canvas-bounds")
+		c.SetColor(pal.stroke)
+		c.SetLineWidth(1)
+		c.DrawPolyline([]Point{{X: 0, Y: 0}, {X: req.Width, Y: 0}, {X: req.Width, Y: req.Height}, {X: 0, Y: req.Height}, {X: 0, Y: 0}})
+		c.EndGroup()
+	}
+
+	// Draw the perspective grid behind the strokes
+	if req.GridRays > 0 && (v.leftVP != nil || v.rightVP != nil) {
+		c.BeginGroup("grid")
+		c.SetColor(pal.grid)
+		c.SetLineWidth(1)
+		drawPerspectiveGrid(c, v.leftVP, v.rightVP, req.GridRays, frame)
+		c.EndGroup()
+		legend = append(legend, legendEntry{loc.text("Perspective grid"), pal.grid, nil})
+	}
+
+	// Draw original strokes, flat or colored by deviation. A flat redraw would
+	// only hide the original strokes under a transparent overlay, so it's skipped.
+	c.BeginGroup("strokes")
+	c.SetColor(pal.stroke)
+	c.SetLineWidth(style.StrokeWidth)
+	for i, stroke := range req.Strokes {
+		if len(stroke) == 0 {
+			continue
+		}
+		if req.Heatmap && len(stroke) >= 2 {
+			drawDeviationHeatmap(c, stroke, v.lines[i], heatmapTolerance(req.Difficulty))
+			continue
+		}
+		if !req.Transparent {
+			c.DrawPolyline(stroke)
+		}
+	}
+	c.EndGroup()
+	switch {
+	case req.Heatmap:
+		legend = append(legend,
+			legendEntry{loc.text("Your stroke, on the line"), heatmapColor(0), nil},
+			legendEntry{loc.text("Your stroke, off by the tolerance"), heatmapColor(1), nil},
+			legendEntry{loc.text("Your stroke, off by twice the tolerance"), heatmapColor(2), nil})
+	case !req.Transparent:
+		legend = append(legend, legendEntry{loc.text("Your stroke"), pal.stroke, nil})
+	}
+
+	// Draw ideal lines and label them
+	c.BeginGroup("ideal-lines")
+	c.SetLineWidth(style.IdealLineWidth)
+	c.SetDash(style.IdealLineDash...)
+	for i, stroke := range req.Strokes {
+		if len(stroke) < 2 {
+			continue
+		}
+		line := v.lines[i]
+		c.SetColor(pal.idealLine)
+		from, to := idealSegment(stroke, line)
+		c.DrawLine(from.X, from.Y, to.X, to.Y)
+
+		// Label with the line number, score and angle
+		center := strokeCenter(stroke)
+		c.SetColor(pal.angleLabel)
+		c.DrawString(loc.format("Line %d: %s", i+1, loc.number(line.Score, 0)), center.X+5*unit, center.Y)
+		c.DrawString(loc.number(line.Angle, 1)+"°", center.X+5*unit, center.Y+(labelFontSize+2)*unit)
+	}
+	c.SetDash()
+	c.EndGroup()
+	legend = append(legend, legendEntry{loc.text("Ideal line"), pal.idealLine, style.IdealLineDash})
+
+	// Draw the box implied by the VPs and the front edge for comparison
+	box := reconstructBox(req.Strokes, v.lines, v.verticals, v.leftVP, v.rightVP)
+	if box != nil {
+		c.BeginGroup("ideal-box")
+		c.SetColor(pal.idealBox)
+		c.SetLineWidth(style.IdealBoxWidth)
+		c.SetDash(style.IdealBoxDash...)
+		for _, edge := range box {
+			c.DrawLine(edge.A.X, edge.A.Y, edge.B.X, edge.B.Y)
+		}
+		c.SetDash()
+		c.EndGroup()
+		legend = append(legend, legendEntry{loc.text("Ideal box"), pal.idealBox, style.IdealBoxDash})
+	}
+
+	// Draw the horizon through both vanishing points
+	if v.leftVP != nil && v.rightVP != nil {
+		c.BeginGroup("horizon")
+		c.SetColor(pal.horizon)
+		c.SetLineWidth(style.HorizonWidth)
+		c.SetDash(style.HorizonDash...)
+		drawHorizon(c, *v.leftVP, *v.rightVP, req.Width)
+		c.SetDash()
+		c.EndGroup()
+		legend = append(legend, legendEntry{loc.text("Horizon"), pal.horizon, style.HorizonDash})
+	}
+
+	// Extend lines to vanishing points
+	c.BeginGroup("vp-rays")
+	c.SetLineWidth(style.VPRayWidth)
+	c.SetDash(style.VPRayDash...)
+	c.SetColor(pal.vpRay)
+	drawVPRays(c, req.Strokes, v.leftGroup, v.leftVP)
+	drawVPRays(c, req.Strokes, v.rightGroup, v.rightVP)
+	c.SetDash()
+	c.EndGroup()
+	legend = append(legend, legendEntry{loc.text("VP ray"), pal.vpRay, style.VPRayDash})
+
+	// Draw VP markers, labeled with how tightly each group converges
+	c.BeginGroup("vp-markers")
+	for _, m := range []struct {
+		label string
+		vp    *Point
+		err   float64
+		lines int
+	}{
+		{"Left VP: %s px error (%d lines)", v.leftVP, v.leftError, len(v.leftGroup)},
+		{"Right VP: %s px error (%d lines)", v.rightVP, v.rightError, len(v.rightGroup)},
+	} {
+		if m.vp == nil {
+			continue
+		}
+		c.SetColor(pal.vpMarker)
+		c.FillCircle(m.vp.X, m.vp.Y, style.MarkerRadius)
+		c.SetColor(pal.text)
+		label := loc.format(m.label, loc.number(m.err, 1), m.lines)
+		x, y := vpLabelPosition(*m.vp, label, style.MarkerRadius, frame, unit)
+		c.DrawString(label, x, y)
+	}
+	c.EndGroup()
+
+	// Add group count stats
+	c.BeginGroup("stats")
+	c.SetColor(pal.text)
+	stats := loc.format("Verticals: %d, Left Group: %d, Right Group: %d", len(v.verticals), len(v.leftGroup), len(v.rightGroup))
+	c.DrawString(stats, frame.X+10*unit, frame.Y+20*unit)
+	c.EndGroup()
+
+	drawLegend(c, pal.text, frame, unit, legend)
+	drawWatermark(c, siteWatermark, pal.text, frame, unit)
+}
+
+// idealSegment returns the part of a stroke's ideal line spanning the stroke
+func idealSegment(stroke Stroke, line Line) (Point, Point) {
+	minX, minY, maxX, maxY := strokeBounds(stroke)
+	if line.M == math.MaxFloat64 {
+		// Vertical line
+		return Point{X: line.B, Y: minY}, Point{X: line.B, Y: maxY}
+	}
+	return Point{X: minX, Y: line.M*minX + line.B}, Point{X: maxX, Y: line.M*maxX + line.B}
+}
+
+// vpLabelPosition places a VP's label to the right of its marker, kept inside
+// the frame so the numbers stay readable when the VP itself is off-image. The
+// text width is estimated since canvases can't measure text.
+func vpLabelPosition(vp Point, label string, radius float64, frame viewport, unit float64) (float64, float64) {
+	width := float64(utf8.RuneCountInString(label)) * labelFontSize * 0.55 * unit
+	x := vp.X + (radius+4)*unit
+	y := vp.Y + labelFontSize/3*unit
+	x = math.Max(frame.X+10*unit, math.Min(x, frame.X+frame.W-width-10*unit))
+	y = math.Max(frame.Y+(labelFontSize+30)*unit, math.Min(y, frame.Y+frame.H-10*unit))
+	return x, y
+}
+
+// drawBackground draws the reference photo the strokes were traced over,
+// stretched to the canvas. It's drawn on transparent output too since it was
+// asked for explicitly.
+func drawBackground(c Canvas, req AnalysisRequest) {
+	if req.background == nil {
+		return
+	}
+	c.BeginGroup("background-image")
+	c.DrawImage(req.background, 0, 0, req.Width, req.Height)
+	c.EndGroup()
+}
+
+// decodeBackground decodes a base64 PNG or JPEG, with or without a data: URL
+// prefix
+func decodeBackground(s string) (image.Image, error) {
+	if strings.HasPrefix(s, "data:") {
+		_, s, _ = strings.Cut(s, ",")
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("Background image is not valid base64")
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Background image: %v", err)
+	}
+	if cfg.Width*cfg.Height > maxBackgroundPixels {
+		return nil, fmt.Errorf("Background image is too large (%d×%d)", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Background image: %v", err)
+	}
+	return img, nil
+}
+
+// drawPerspectiveGrid draws rays from each VP through evenly spaced points on
+// the frame edge furthest from it, spaced so that one ray lies on the horizon,
+// plus the horizon itself
+func drawPerspectiveGrid(c Canvas, leftVP, rightVP *Point, rays int, frame viewport) {
+	step := frame.H / float64(rays)
+	for _, vp := range []*Point{leftVP, rightVP} {
+		if vp == nil {
+			continue
+		}
+		edgeX := frame.X
+		if vp.X < frame.X+frame.W/2 {
+			edgeX = frame.X + frame.W
+		}
+
+		// Spread the rays over the whole frame height, extended so the
+		// slopes cover the frame corners on the VP's side too
+		spread := math.Abs(edgeX-vp.X) / math.Max(math.Abs(edgeX-vp.X)-frame.W, frame.W/4)
+		top := vp.Y - (vp.Y-frame.Y)*spread
+		bottom := vp.Y + (frame.Y+frame.H-vp.Y)*spread
+		for y := vp.Y - math.Floor((vp.Y-top)/step)*step; y <= bottom; y += step {
+			c.DrawLine(vp.X, vp.Y, edgeX, y)
+		}
+	}
+
+	// A single VP still fixes the horizon's height
+	left, right := leftVP, rightVP
+	if left == nil {
+		left = &Point{X: right.X - 1, Y: right.Y}
+	}
+	if right == nil {
+		right = &Point{X: left.X + 1, Y: left.Y}
+	}
+	drawHorizon(c, *left, *right, frame.X+frame.W)
+}
+
+// drawHorizon draws the line through both vanishing points across the full
+// width of the canvas, so a tilted horizon is visible even when the VPs are
+// off-canvas
+func drawHorizon(c Canvas, left, right Point, width float64) {
+	dx := right.X - left.X
+	if math.Abs(dx) < 1e-9 {
+		return // VPs stacked vertically; there's no meaningful horizon
+	}
+	slope := (right.Y - left.Y) / dx
+	x1, x2 := math.Min(0, math.Min(left.X, right.X)), math.Max(width, math.Max(left.X, right.X))
+	c.DrawLine(x1, left.Y+slope*(x1-left.X), x2, left.Y+slope*(x2-left.X))
+}
+
+// heatmapTolerance is the deviation in pixels shown as yellow on the heatmap;
+// twice this is fully red
+func heatmapTolerance(d Difficulty) float64 {
+	profile, ok := getScoringProfile(d)
+	if !ok {
+		profile, _ = getScoringProfile(defaultDifficulty)
+	}
+	return profile.RMSEThreshold
+}
+
+// drawDeviationHeatmap draws each segment of a stroke colored by how far it
+// strays from the ideal line
+func drawDeviationHeatmap(c Canvas, stroke Stroke, line Line, tolerance float64) {
+	for i := 1; i < len(stroke); i++ {
+		p, q := stroke[i-1], stroke[i]
+		dev := (pointDeviation(p, line) + pointDeviation(q, line)) / 2
+		c.SetColor(heatmapColor(dev / tolerance))
+		c.DrawLine(p.X, p.Y, q.X, q.Y)
+	}
+}
+
+// pointDeviation returns the distance of a point from the ideal line, measured
+// the same way as the line's RMSE: horizontally for vertical lines and
+// vertically otherwise
+func pointDeviation(p Point, line Line) float64 {
+	if line.M == math.MaxFloat64 {
+		return math.Abs(p.X - line.B)
+	}
+	return math.Abs(p.Y - (line.M*p.X + line.B))
+}
+
+// heatmapColor maps a deviation in tolerances to green (0), yellow (1) and red (2+)
+func heatmapColor(t float64) color.Color {
+	t = math.Max(0, math.Min(t, 2))
+	if t <= 1 {
+		return color.NRGBA{uint8(255 * t), 200, 0, 255}
+	}
+	return color.NRGBA{255, uint8(200 * (2 - t)), 0, 255}
+}
+
+// legendEntry is a colored swatch and its label in the legend. The swatch
+// uses the layer's dash pattern so patterned styles can be told apart.
+type legendEntry struct {
+	label string
+	col   color.Color
+	dash  []float64
+}
+
+// drawLegend explains the overlay colors in the bottom-left corner of the frame
+// so shared screenshots are readable on their own
+func drawLegend(c Canvas, textColor color.Color, frame viewport, unit float64, entries []legendEntry) {
+	const rowHeight = labelFontSize + 6
+	c.BeginGroup("legend")
+	x := frame.X
+	y := frame.Y + frame.H - (10+float64(len(entries)-1)*rowHeight)*unit
+	for _, e := range entries {
+		c.SetColor(e.col)
+		c.SetLineWidth(2)
+		c.SetDash(e.dash...)
+		swatchY := y - labelFontSize/3*unit
+		c.DrawLine(x+10*unit, swatchY, x+34*unit, swatchY)
+		c.SetDash()
+		c.SetColor(textColor)
+		c.DrawString(e.label, x+42*unit, y)
+		y += rowHeight * unit
+	}
+	c.EndGroup()
+}
+
+// drawVPRays extends each stroke in a group to its vanishing point, starting
+// from the point on the stroke furthest from the VP
+func drawVPRays(c Canvas, strokes []Stroke, group []int, vp *Point) {
+	if vp == nil {
+		return
+	}
+	for _, idx := range group {
+		stroke := strokes[idx]
+		if len(stroke) == 0 {
+			continue
+		}
+		furthest := stroke[0]
+		maxDist := 0.0
+		for _, p := range stroke {
+			if dist := distance(p, *vp); dist > maxDist {
+				maxDist = dist
+				furthest = p
+			}
+		}
+		c.DrawLine(furthest.X, furthest.Y, vp.X, vp.Y)
+	}
+}
+
+// saveResultToFile saves the rendered visualization to the results directory.
+// The attempt ID, when there is one, keeps attempts in the same second apart.
+func saveResultToFile(data []byte, ext string, trainingType TrainingType, score float64, attemptID string) string {
+	// Generate filename with timestamp and score
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	scoreStr := fmt.Sprintf("%.0f", score)
+	filename := fmt.Sprintf("%s_%s_score-%s.%s", timestamp, trainingType, scoreStr, ext)
+	if attemptID != "" {
+		filename = fmt.Sprintf("%s_%s_score-%s_%s.%s", timestamp, trainingType, scoreStr, attemptID, ext)
+	}
+	if objects != nil {
+		key := objects.prefix + filename
+		if err := objects.put(context.Background(), key, mime.TypeByExtension("."+ext), data); err != nil {
+			slog.Error("Failed to save result", "key", key, "err", err)
+			return ""
+		}
+		slog.Info("Saved result", "key", key)
+		return objectPathPrefix + key
+	}
+	path := filepath.Join(resultsDir, filename)
+
+	// Save the image
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		slog.Error("Failed to save result", "path", path, "err", err)
+		return ""
+	}
+
+	slog.Info("Saved result", "path", path)
+	return path
+}
+
+// resultImageURL is where the saved visualization of an attempt is served
+func resultImageURL(id, savedPath string) string {
+	return sitePath(apiPrefix + "/result/" + id + "/image" + filepath.Ext(savedPath))
+}
+
+// handleResultImage serves the saved visualization of an attempt. Saved images
+// never change, so they can be cached indefinitely.
+func handleResultImage(w http.ResponseWriter, r *http.Request) {
+	attempt, err := findAttempt(r.PathValue("id"))
+	if errors.Is(err, errAttemptNotFound) || (err == nil && attempt.SavedFilePath == "") {
+		httpError(w, "Result not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load history", "err", err)
+		httpError(w, "Failed to load result", http.StatusInternalServerError)
+		return
+	}
+	if r.PathValue("file") != "image"+filepath.Ext(attempt.SavedFilePath) {
+		httpError(w, "Result not found", http.StatusNotFound)
+		return
+	}
+	if key, ok := objectKey(attempt.SavedFilePath); ok {
+		if objects == nil {
+			httpError(w, "Result not found", http.StatusNotFound)
+			return
+		}
+		objects.redirect(w, r, key)
+		return
+	}
+
+	f, err := os.Open(attempt.SavedFilePath)
+	if err != nil {
+		httpError(w, "Result not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		httpError(w, "Failed to load result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+attempt.ID+`"`)
+	http.ServeContent(w, r, r.PathValue("file"), info.ModTime(), f)
+}
+
+// ggCanvas draws onto a raster image using gg
+type ggCanvas struct {
+	dc         *gg.Context
+	scale      float64
+	fontScaled bool     // the font was loaded at the scaled size
+	visible    viewport // canvas-space region covered by the image
+}
+
+// newGGCanvas creates an image of width×height canvas units drawn at the given
+// scale, showing the viewport centered in it. Positions are transformed while
+// line widths, circle radii and text keep their size in output pixels, so the
+// overlay stays legible when zoomed out to fit the VPs. The font is loaded at
+// the scaled size so text is rasterized sharply rather than stretched by the
+// transform; the fixed-size fallback font is stretched instead.
+func newGGCanvas(width, height, scale float64, view viewport) *ggCanvas {
+	dc := gg.NewContext(int(math.Round(width*scale)), int(math.Round(height*scale)))
+	zoom := view.zoom(width, height)
+	dc.Scale(scale, scale)
+	dc.Translate((width-view.W*zoom)/2, (height-view.H*zoom)/2)
+	dc.Scale(zoom, zoom)
+	dc.Translate(-view.X, -view.Y)
+
+	visible := view.visible(width, height)
+
+	// Set font
+	fontScaled := true
+	if err := dc.LoadFontFace(labelFontPath, labelFontSize*scale); err != nil {
+		slog.Warn("Could not load font, using default", "path", labelFontPath)
+		fontScaled = false
+	}
+	return &ggCanvas{dc: dc, scale: scale, fontScaled: fontScaled, visible: visible}
+}
+
+func (c *ggCanvas) Clear(col color.Color) {
+	c.dc.SetColor(col)
+	c.dc.Clear()
+}
+
+func (c *ggCanvas) SetColor(col color.Color) { c.dc.SetColor(col) }
+
+// SetLineWidth sets the width in canvas units; gg strokes in device pixels,
+// so it's multiplied by the render scale
+func (c *ggCanvas) SetLineWidth(w float64) { c.dc.SetLineWidth(w * c.scale) }
+
+// SetDash scales the dash pattern like the line width
+func (c *ggCanvas) SetDash(dashes ...float64) {
+	scaled := make([]float64, len(dashes))
+	for i, d := range dashes {
+		scaled[i] = d * c.scale
+	}
+	c.dc.SetDash(scaled...)
+}
+
+// DrawLine clips the line to the image first, since rasterizing a line out to
+// a VP millions of pixels away takes as long as if it were all visible
+func (c *ggCanvas) DrawLine(x1, y1, x2, y2 float64) {
+	x1, y1, x2, y2, ok := clipLine(x1, y1, x2, y2, c.visible)
+	if !ok {
+		return
+	}
+	c.dc.DrawLine(x1, y1, x2, y2)
+	c.dc.Stroke()
+}
+
+func (c *ggCanvas) DrawPolyline(points []Point) {
+	if len(points) == 0 {
+		return
+	}
+	c.dc.MoveTo(points[0].X, points[0].Y)
+	for _, p := range points[1:] {
+		c.dc.LineTo(p.X, p.Y)
+	}
+	c.dc.Stroke()
+}
+
+func (c *ggCanvas) FillCircle(x, y, r float64) {
+	px, py := c.dc.TransformPoint(x, y)
+	c.dc.Push()
+	c.dc.Identity()
+	c.dc.DrawCircle(px, py, r*c.scale)
+	c.dc.Fill()
+	c.dc.Pop()
+}
+
+// DrawString draws text at the transformed position without zooming it
+func (c *ggCanvas) DrawString(s string, x, y float64) {
+	px, py := c.dc.TransformPoint(x, y)
+	c.dc.Push()
+	c.dc.Identity()
+	if c.fontScaled {
+		c.dc.DrawString(s, px, py)
+	} else {
+		c.dc.Scale(c.scale, c.scale)
+		c.dc.DrawString(s, px/c.scale, py/c.scale)
+	}
+	c.dc.Pop()
+}
+
+func (c *ggCanvas) DrawImage(img image.Image, x, y, w, h float64) {
+	b := img.Bounds()
+	c.dc.Push()
+	c.dc.Translate(x, y)
+	c.dc.Scale(w/float64(b.Dx()), h/float64(b.Dy()))
+	c.dc.DrawImage(img, -b.Min.X, -b.Min.Y)
+	c.dc.Pop()
+}
+
+// clipLine clips a line to a viewport grown by a small margin, returning false
+// when no part of it is inside (Liang–Barsky)
+func clipLine(x1, y1, x2, y2 float64, vp viewport) (float64, float64, float64, float64, bool) {
+	const margin = 10.0
+	minX, minY := vp.X-margin, vp.Y-margin
+	maxX, maxY := vp.X+vp.W+margin, vp.Y+vp.H+margin
+
+	dx, dy := x2-x1, y2-y1
+	t0, t1 := 0.0, 1.0
+	for _, edge := range [][2]float64{{-dx, x1 - minX}, {dx, maxX - x1}, {-dy, y1 - minY}, {dy, maxY - y1}} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return 0, 0, 0, 0, false
+		}
+	}
+	return x1 + t0*dx, y1 + t0*dy, x1 + t1*dx, y1 + t1*dy, true
+}
+
+func (c *ggCanvas) BeginGroup(name string) {}
+func (c *ggCanvas) EndGroup()              {}
+
+// svgCanvas writes the drawing as an SVG document. Each overlay element is
+// wrapped in a <g> with a class so it can be styled client-side.
+type svgCanvas struct {
+	buf           bytes.Buffer
+	width, height float64
+	unit          float64 // canvas units per output pixel, so sizes don't zoom
+	color         string
+	opacity       float64
+	lineWidth     float64
+	dashes        []float64
+}
+
+// newSVGCanvas starts a document whose viewBox is the viewport and whose
+// intrinsic size is the canvas size multiplied by scale
+func newSVGCanvas(width, height, scale float64, view viewport) *svgCanvas {
+	c := &svgCanvas{width: width, height: height, unit: 1 / view.zoom(width, height), color: "rgb(0,0,0)", opacity: 1, lineWidth: 1}
+	fmt.Fprintf(&c.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="%g %g %g %g">`+"\n", width*scale, height*scale, view.X, view.Y, view.W, view.H)
+	return c
+}
+
+// String closes the document and returns it
+func (c *svgCanvas) String() string {
+	return c.buf.String() + "</svg>\n"
+}
+
+func (c *svgCanvas) Clear(col color.Color) {
+	fill, opacity := svgColor(col)
+	fmt.Fprintf(&c.buf, `<rect class="background" x="-100%%" y="-100%%" width="300%%" height="300%%" fill="%s" fill-opacity="%g"/>`+"\n", fill, opacity)
+}
+
+func (c *svgCanvas) SetColor(col color.Color)  { c.color, c.opacity = svgColor(col) }
+func (c *svgCanvas) SetLineWidth(w float64)    { c.lineWidth = w }
+func (c *svgCanvas) SetDash(dashes ...float64) { c.dashes = dashes }
+
+func (c *svgCanvas) strokeAttrs() string {
+	attrs := fmt.Sprintf(`fill="none" stroke="%s" stroke-opacity="%g" stroke-width="%g" stroke-linecap="round" stroke-linejoin="round"`, c.color, c.opacity, c.lineWidth*c.unit)
+	if len(c.dashes) > 0 {
+		dashes := make([]string, len(c.dashes))
+		for i, d := range c.dashes {
+			dashes[i] = fmt.Sprintf("%g", d*c.unit)
+		}
+		attrs += fmt.Sprintf(` stroke-dasharray="%s"`, strings.Join(dashes, " "))
+	}
+	return attrs
+}
+
+func (c *svgCanvas) DrawLine(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&c.buf, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" %s/>`+"\n", x1, y1, x2, y2, c.strokeAttrs())
+}
+
+func (c *svgCanvas) DrawPolyline(points []Point) {
+	coords := make([]string, len(points))
+	for i, p := range points {
+		coords[i] = fmt.Sprintf("%.2f,%.2f", p.X, p.Y)
+	}
+	fmt.Fprintf(&c.buf, `<polyline points="%s" %s/>`+"\n", strings.Join(coords, " "), c.strokeAttrs())
+}
+
+func (c *svgCanvas) FillCircle(x, y, r float64) {
+	fmt.Fprintf(&c.buf, `<circle cx="%.2f" cy="%.2f" r="%g" fill="%s" fill-opacity="%g"/>`+"\n", x, y, r*c.unit, c.color, c.opacity)
+}
+
+func (c *svgCanvas) DrawString(s string, x, y float64) {
+	var text bytes.Buffer
+	xml.EscapeText(&text, []byte(s))
+	fmt.Fprintf(&c.buf, `<text x="%.2f" y="%.2f" font-family="sans-serif" font-size="%g" fill="%s" fill-opacity="%g">%s</text>`+"\n", x, y, labelFontSize*c.unit, c.color, c.opacity, text.String())
+}
+
+// DrawImage embeds the image as a PNG data URL
+func (c *svgCanvas) DrawImage(img image.Image, x, y, w, h float64) {
+	var data bytes.Buffer
+	png.Encode(&data, img)
+	fmt.Fprintf(&c.buf, `<image x="%.2f" y="%.2f" width="%.2f" height="%.2f" preserveAspectRatio="none" href="data:image/png;base64,%s"/>`+"\n", x, y, w, h, base64.StdEncoding.EncodeToString(data.Bytes()))
+}
+
+func (c *svgCanvas) BeginGroup(name string) {
+	fmt.Fprintf(&c.buf, `<g class="%s">`+"\n", name)
+}
+
+func (c *svgCanvas) EndGroup() {
+	c.buf.WriteString("</g>\n")
+}
+
+// svgColor converts a color to an SVG rgb() value and opacity
+func svgColor(col color.Color) (string, float64) {
+	r, g, b, a := col.RGBA()
+	if a == 0 {
+		return "rgb(0,0,0)", 0
+	}
+	// Un-premultiply the channels
+	r, g, b = min(r*0xffff/a, 0xffff), min(g*0xffff/a, 0xffff), min(b*0xffff/a, 0xffff)
+	return fmt.Sprintf("rgb(%d,%d,%d)", r>>8, g>>8, b>>8), float64(a) / 0xffff
+}
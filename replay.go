@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	colorpalette "image/color/palette"
+	"image/draw"
+	"image/gif"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// Replay timing
+const (
+	replayDrawFrames  = 40   // frames spent drawing the strokes
+	replayFadeFrames  = 6    // frames spent fading the analysis overlay in
+	replayFadeDelay   = 10   // hundredths of a second per fade frame
+	replayHoldDelay   = 300  // hundredths of a second the final frame is held
+	replayMinDelay    = 2    // browsers clamp shorter GIF delays to 10
+	replayPointStepMs = 10.0 // pacing used when the strokes have no timestamps
+	replayMaxGapMs    = 500.0
+)
+
+// maxReplayPixels caps the pixels of all a replay's frames together, which are
+// kept until it's encoded
+const maxReplayPixels = 4 * maxRenderPixels
+
+// replayScale lowers a render scale so a width×height replay's frames keep
+// to maxReplayPixels
+func replayScale(width, height, scale float64) float64 {
+	frames := float64(replayDrawFrames + replayFadeFrames)
+	return math.Min(scale, math.Sqrt(maxReplayPixels/frames/(width*height)))
+}
+
+// replayPoint is a point on the replay timeline
+type replayPoint struct {
+	stroke, index int
+	ms            float64
+}
+
+// renderReplay draws an animated GIF of the strokes being drawn in order,
+// paced by their timestamps, with the analysis overlay fading in at the end
+func renderReplay(v visualization, scale float64, view viewport) []byte {
+	pal, err := v.req.Theme.palette()
+	if err != nil {
+		pal, _ = (*Theme)(nil).palette()
+	}
+	style, err := v.req.Style.resolve()
+	if err != nil {
+		style = defaultStyle
+	}
+
+	timeline := replayTimeline(v.req.Strokes)
+	total := 0.0
+	if len(timeline) > 0 {
+		total = timeline[len(timeline)-1].ms
+	}
+
+	anim := &gif.GIF{}
+	q := newQuantizer()
+	delay := max(replayMinDelay, int(math.Round(total/replayDrawFrames/10)))
+
+	// Draw the strokes progressively
+	var drawn *image.RGBA
+	next := 0
+	partial := make([]Stroke, len(v.req.Strokes))
+	for frame := 1; frame <= replayDrawFrames; frame++ {
+		until := total * float64(frame) / replayDrawFrames
+		for next < len(timeline) && timeline[next].ms <= until {
+			p := timeline[next]
+			partial[p.stroke] = v.req.Strokes[p.stroke][:p.index+1]
+			next++
+		}
+
+		c := newGGCanvas(v.req.Width, v.req.Height, scale, view)
+		c.Clear(pal.background)
+		drawBackground(c, v.req)
+		c.SetColor(pal.stroke)
+		c.SetLineWidth(style.StrokeWidth)
+		for _, stroke := range partial {
+			if len(stroke) > 0 {
+				c.DrawPolyline(stroke)
+			}
+		}
+		drawn = c.dc.Image().(*image.RGBA)
+		anim.Image = append(anim.Image, q.paletted(drawn))
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	// Fade the analysis overlay in over the finished drawing
+	c := newGGCanvas(v.req.Width, v.req.Height, scale, view)
+	drawVisualization(c, v)
+	overlay := c.dc.Image()
+	for frame := 1; frame <= replayFadeFrames; frame++ {
+		blended := image.NewRGBA(drawn.Bounds())
+		draw.Draw(blended, blended.Bounds(), drawn, image.Point{}, draw.Src)
+		alpha := uint8(255 * frame / replayFadeFrames)
+		draw.DrawMask(blended, blended.Bounds(), overlay, image.Point{}, image.NewUniform(color.Alpha{alpha}), image.Point{}, draw.Over)
+		anim.Image = append(anim.Image, q.paletted(blended))
+		anim.Delay = append(anim.Delay, replayFadeDelay)
+	}
+	anim.Delay[len(anim.Delay)-1] = replayHoldDelay
+
+	var buf bytes.Buffer
+	gif.EncodeAll(&buf, anim)
+	return buf.Bytes()
+}
+
+// replayTimeline orders every point by when it was drawn. Timestamps are used
+// when every point has one; pauses between strokes are shortened so the replay
+// doesn't sit on a still frame.
+func replayTimeline(strokes []Stroke) []replayPoint {
+	timed := true
+	for _, stroke := range strokes {
+		for _, p := range stroke {
+			if p.T == 0 {
+				timed = false
+			}
+		}
+	}
+
+	var timeline []replayPoint
+	clock, last := 0.0, 0.0
+	for s, stroke := range strokes {
+		for i, p := range stroke {
+			step := replayPointStepMs
+			if timed && len(timeline) > 0 {
+				step = math.Max(0, math.Min(p.T-last, replayMaxGapMs))
+			}
+			if len(timeline) > 0 {
+				clock += step
+			}
+			last = p.T
+			timeline = append(timeline, replayPoint{stroke: s, index: i, ms: clock})
+		}
+	}
+	return timeline
+}
+
+// AttemptReplay is how an attempt was drawn, stroke by stroke in the order
+// they were started, for clients to play back
+type AttemptReplay struct {
+	AttemptID    string       `json:"attemptId"`
+	TrainingType TrainingType `json:"trainingType"`
+	Width        float64      `json:"width"`
+	Height       float64      `json:"height"`
+	// Timed is false when the strokes were sent without timestamps, and their
+	// points are paced evenly instead
+	Timed      bool           `json:"timed"`
+	DurationMs float64        `json:"durationMs"`
+	Strokes    []ReplayStroke `json:"strokes"`
+}
+
+// ReplayStroke is one stroke of a replay, with times in milliseconds from the
+// attempt's first point
+type ReplayStroke struct {
+	Index   int          `json:"index"` // its place in the attempt's strokes, which line scores follow
+	StartMs float64      `json:"startMs"`
+	EndMs   float64      `json:"endMs"`
+	Points  []TimedPoint `json:"points"`
+}
+
+// TimedPoint is a point of a replay, which always has its time
+type TimedPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	T float64 `json:"t"`
+}
+
+// replayStrokes puts the strokes on one clock starting at their first point
+// and orders them by when they were started. Untimed strokes get
+// replayPointStepMs per point, one after another.
+func replayStrokes(strokes []Stroke) (replay []ReplayStroke, timed bool) {
+	timed = len(strokes) > 0
+	start := math.Inf(1)
+	for _, stroke := range strokes {
+		for _, p := range stroke {
+			if p.T == 0 {
+				timed = false
+			}
+			start = math.Min(start, p.T)
+		}
+	}
+
+	clock := 0.0
+	for i, stroke := range strokes {
+		s := ReplayStroke{Index: i, Points: make([]TimedPoint, len(stroke))}
+		for j, p := range stroke {
+			if timed {
+				p.T -= start
+			} else {
+				if j > 0 {
+					clock += replayPointStepMs
+				}
+				p.T = clock
+			}
+			s.Points[j] = TimedPoint(p)
+		}
+		clock += replayPointStepMs
+		if len(stroke) > 0 {
+			s.StartMs, s.EndMs = s.Points[0].T, s.Points[len(stroke)-1].T
+		}
+		replay = append(replay, s)
+	}
+	sort.SliceStable(replay, func(i, j int) bool { return replay[i].StartMs < replay[j].StartMs })
+	return replay, timed
+}
+
+// handleReplay returns the strokes of a stored attempt with when each point
+// was drawn, to whoever may see the attempt
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := findVisibleAttempt(r.Context(), id)
+	var req AnalysisRequest
+	if err == nil {
+		req, err = loadAttempt(id)
+	}
+	if errors.Is(err, errAttemptNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load attempt", "attemptId", id, "err", err)
+		httpError(w, "Failed to load attempt", http.StatusInternalServerError)
+		return
+	}
+
+	replay := AttemptReplay{AttemptID: id, TrainingType: req.TrainingType, Width: req.Width, Height: req.Height}
+	replay.Strokes, replay.Timed = replayStrokes(req.Strokes)
+	for _, s := range replay.Strokes {
+		replay.DurationMs = math.Max(replay.DurationMs, s.EndMs)
+	}
+	writeResponse(w, r, http.StatusOK, replay)
+}
+
+// quantizer converts frames to the web-safe palette, caching the nearest
+// palette index per color since most pixels share a handful of colors
+type quantizer struct {
+	palette color.Palette
+	cache   map[color.RGBA]uint8
+}
+
+func newQuantizer() *quantizer {
+	return &quantizer{palette: colorpalette.WebSafe, cache: map[color.RGBA]uint8{}}
+}
+
+func (q *quantizer) paletted(img *image.RGBA) *image.Paletted {
+	b := img.Bounds()
+	out := image.NewPaletted(b, q.palette)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			idx, ok := q.cache[c]
+			if !ok {
+				idx = uint8(q.palette.Index(c))
+				q.cache[c] = idx
+			}
+			out.SetColorIndex(x, y, idx)
+		}
+	}
+	return out
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Report page layout, in points
+const (
+	reportMargin      = 40.0
+	reportImageHeight = 280.0 // tallest the visualization may be drawn
+	reportImageScale  = 2.0   // render scale for the embedded visualization
+	reportWrapChars   = 100   // feedback line length at the body font size
+)
+
+// ReportRequest names the attempt to report on, either as a stored attempt ID
+// or as strokes to analyze
+type ReportRequest struct {
+	AttemptID string           `json:"attemptId,omitempty"`
+	Attempt   *AnalysisRequest `json:"attempt,omitempty"`
+}
+
+// handleReport renders a one-page PDF of an attempt's scores for printing
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReportRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	attempt, err := reportAttempt(r.Context(), req)
+	if errors.Is(err, errAttemptNotFound) {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, vis := scoreStrokes(attempt)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="report.pdf"`)
+	w.Write(renderReport(result, vis, time.Now()))
+}
+
+// reportAttempt resolves the request into a validated analysis request. A
+// stored attempt must be one the caller may see.
+func reportAttempt(ctx context.Context, req ReportRequest) (AnalysisRequest, error) {
+	var attempt AnalysisRequest
+	switch {
+	case req.AttemptID != "" && req.Attempt == nil:
+		_, err := findVisibleAttempt(ctx, req.AttemptID)
+		var a AnalysisRequest
+		if err == nil {
+			a, err = loadAttempt(req.AttemptID)
+		}
+		if err != nil {
+			return attempt, fmt.Errorf("attempt %q: %w", req.AttemptID, err)
+		}
+		attempt = a
+	case req.Attempt != nil && req.AttemptID == "":
+		attempt = *req.Attempt
+	default:
+		return attempt, errors.New("Expected either attemptId or attempt")
+	}
+
+	// The report embeds a print-resolution PNG in the light theme
+	attempt.Format = FormatPNG
+	attempt.Scale = reportImageScale
+	attempt.Theme = nil
+	attempt.Transparent = false
+	if err := validateAnalysisRequest(&attempt); err != nil {
+		return attempt, err
+	}
+	return attempt, nil
+}
+
+// reportPage accumulates the content stream of a report page, laying text out
+// top to bottom
+type reportPage struct {
+	content strings.Builder
+	y       float64 // baseline of the next line, from the bottom of the page
+}
+
+// text writes a line at x on the current baseline. font is F1 (regular) or F2 (bold).
+func (p *reportPage) text(font string, size, x float64, s string) {
+	fmt.Fprintf(&p.content, "BT /%s %g Tf %.2f %.2f Td %s Tj ET\n", font, size, x, p.y, pdfText(s))
+}
+
+// line writes a line of text at the left margin and moves down
+func (p *reportPage) line(font string, size float64, s string) {
+	p.text(font, size, reportMargin, s)
+	p.y -= size * 1.4
+}
+
+// heading writes a section heading with a rule under it
+func (p *reportPage) heading(s string) {
+	p.y -= 8
+	p.text("F2", 12, reportMargin, s)
+	fmt.Fprintf(&p.content, "0.6 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n",
+		reportMargin, p.y-4, pdfPageWidth-reportMargin, p.y-4)
+	p.y -= 20
+}
+
+// renderReport lays out an attempt's visualization, per-stroke scores,
+// vanishing points and feedback on a single A4 page
+func renderReport(result AnalysisResult, v visualization, now time.Time) []byte {
+	doc := &pdfDocument{}
+	catalog := doc.reserve()
+	pages := doc.reserve()
+	regular := doc.add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	bold := doc.add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	c := newGGCanvas(v.req.Width, v.req.Height, v.req.Scale, viewportFor(v))
+	drawVisualization(c, v)
+	img := doc.addImage(c.dc.Image())
+
+	p := &reportPage{y: pdfPageHeight - reportMargin - 18}
+	p.line("F2", 18, getExercise(v.req.TrainingType).Name+" — Drawing Report")
+	p.line("F1", 10, fmt.Sprintf("%s · Difficulty: %s", now.Format("January 2, 2006 15:04"), v.req.Difficulty))
+	p.y -= 4
+	p.line("F2", 14, fmt.Sprintf("Grade %s — %.0f / 100", result.Grade.Letter, result.CompositeScore))
+
+	// Fit the visualization to the page width and the image height limit
+	contentWidth := pdfPageWidth - 2*reportMargin
+	fit := math.Min(contentWidth/v.req.Width, reportImageHeight/v.req.Height)
+	imgW, imgH := v.req.Width*fit, v.req.Height*fit
+	p.y -= imgH - 4
+	fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im1 Do Q\n", imgW, imgH, reportMargin+(contentWidth-imgW)/2, p.y)
+	fmt.Fprintf(&p.content, "0.6 G 0.5 w %.2f %.2f %.2f %.2f re S 0 G\n", reportMargin+(contentWidth-imgW)/2, p.y, imgW, imgH)
+	p.y -= 18
+
+	p.heading("Scores")
+	p.line("F1", 10, fmt.Sprintf("Line quality: %.0f    Perspective: %.0f    Convergence: %.0f    Proportion: %.0f    Composition: %.0f",
+		result.AverageLineScore, result.PerspectiveScore, result.ConvergenceScore, result.ProportionScore, result.CompositionScore))
+	if result.TargetMatch != nil {
+		p.line("F1", 10, fmt.Sprintf("Target match: %.0f", result.TargetMatch.Score))
+	}
+
+	p.heading("Vanishing Points")
+	for _, vp := range []struct {
+		name  string
+		point *Point
+		err   float64
+		score float64
+	}{
+		{"Left", result.LeftVP, result.ConvergenceErrorL, result.LeftConvergenceScore},
+		{"Right", result.RightVP, result.ConvergenceErrorR, result.RightConvergenceScore},
+	} {
+		if vp.point == nil {
+			p.line("F1", 10, vp.name+": not found")
+			continue
+		}
+		p.line("F1", 10, fmt.Sprintf("%s: (%.0f, %.0f)    convergence error %.1f px    score %.0f",
+			vp.name, vp.point.X, vp.point.Y, vp.err, vp.score))
+	}
+
+	p.heading("Strokes")
+	columns := []float64{reportMargin, reportMargin + 50, reportMargin + 120, reportMargin + 175, reportMargin + 235}
+	for i, h := range []string{"Stroke", "Group", "Score", "Angle", "Notes"} {
+		p.text("F2", 10, columns[i], h)
+	}
+	p.y -= 14
+	for i, line := range v.lines {
+		notes := ""
+		if i < len(result.StrokeTags) {
+			notes = strings.Join(result.StrokeTags[i], ", ")
+		}
+		cells := []string{
+			fmt.Sprintf("%d", i+1),
+			strokeGroup(v, i),
+			fmt.Sprintf("%.0f", result.LineScores[i]),
+			fmt.Sprintf("%.1f°", line.Angle),
+			notes,
+		}
+		for j, cell := range cells {
+			if cell != "" {
+				p.text("F1", 10, columns[j], cell)
+			}
+		}
+		p.y -= 14
+	}
+
+	if len(result.Feedback) > 0 {
+		p.heading("Feedback")
+		for _, f := range result.Feedback {
+			for _, l := range wrapText(f, reportWrapChars) {
+				if p.y < reportMargin {
+					break
+				}
+				p.line("F1", 10, l)
+			}
+			p.y -= 2
+		}
+	}
+
+	content := doc.addStream("", []byte(p.content.String()))
+	page := doc.add(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %g %g] /Contents %d 0 R /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> /XObject << /Im1 %d 0 R >> >> >>",
+		pages, pdfPageWidth, pdfPageHeight, content, regular, bold, img))
+	doc.set(pages, fmt.Sprintf("<< /Type /Pages /Kids [%d 0 R] /Count 1 >>", page))
+	doc.set(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+	return doc.bytes(catalog)
+}
+
+// strokeGroup names the line family a stroke was clustered into
+func strokeGroup(v visualization, i int) string {
+	for _, g := range []struct {
+		name    string
+		members []int
+	}{{"Vertical", v.verticals}, {"Left", v.leftGroup}, {"Right", v.rightGroup}} {
+		for _, m := range g.members {
+			if m == i {
+				return g.name
+			}
+		}
+	}
+	return "—"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// retentionInterval is how often attempts are checked for strokes and images
+// past their retention
+const retentionInterval = time.Hour
+
+// runRetention drops strokes and images past -retain-strokes and
+// -retain-images every retentionInterval while the server runs, when either
+// is set
+func runRetention(cfg config) {
+	if cfg.retainStrokes == 0 && cfg.retainImages == 0 {
+		return
+	}
+	for {
+		applyRetention(context.Background(), time.Now(), cfg.retainStrokes, cfg.retainImages)
+		time.Sleep(retentionInterval)
+	}
+}
+
+// applyRetention drops the strokes of attempts older than retainStrokes and
+// the images of those older than retainImages, leaving either be when it's 0.
+// Strokes still in files from before the database are removed with them.
+// Scores, results, notes and the attempts themselves are kept.
+func applyRetention(ctx context.Context, now time.Time, retainStrokes, retainImages time.Duration) {
+	if retainStrokes > 0 {
+		n, err := store.ClearAttemptRequests(now.Add(-retainStrokes), removeRequestFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to drop expired strokes", "err", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "Dropped expired strokes", "attempts", n)
+		}
+	}
+	if retainImages > 0 {
+		n, err := store.ClearSavedFiles(now.Add(-retainImages), removeAttemptImages)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to remove expired images", "removed", n, "err", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "Removed expired images", "attempts", n)
+		}
+	}
+}
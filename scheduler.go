@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// scheduleWindow is the number of recent complete attempts whose scores
+// decide when an exercise is due again
+const scheduleWindow = 5
+
+// reviewIntervals space out practice by how well an exercise is going: weak
+// exercises come back the next day, mastered ones after a week. Each applies
+// below its score.
+var reviewIntervals = []struct {
+	below    float64
+	interval time.Duration
+}{
+	{50, 24 * time.Hour},
+	{70, 2 * 24 * time.Hour},
+	{85, 4 * 24 * time.Hour},
+	{math.Inf(1), 7 * 24 * time.Hour},
+}
+
+// ScheduledExercise is when an exercise is next due and why
+type ScheduledExercise struct {
+	TrainingType  TrainingType `json:"trainingType"`
+	Name          string       `json:"name"`
+	Attempts      int          `json:"attempts"`
+	RecentScore   *float64     `json:"recentScore,omitempty"` // mean composite score of the last few complete attempts
+	LastPracticed *time.Time   `json:"lastPracticed,omitempty"`
+	DueAt         *time.Time   `json:"dueAt,omitempty"`
+	Overdue       float64      `json:"overdue"` // time since last practice over the interval; due at 1
+	Reason        string       `json:"reason"`
+}
+
+// NextExercise is the drill to do next, with the whole schedule most urgent
+// first
+type NextExercise struct {
+	Exercise ScheduledExercise   `json:"exercise"`
+	Schedule []ScheduledExercise `json:"schedule"`
+}
+
+// reviewInterval is how long an exercise with a recent score can rest
+func reviewInterval(score float64) time.Duration {
+	for _, r := range reviewIntervals {
+		if score < r.below {
+			return r.interval
+		}
+	}
+	return reviewIntervals[len(reviewIntervals)-1].interval
+}
+
+// scheduleExercises works out when each exercise is due from the attempts at
+// them. Exercises never tried come first, then the most overdue, with weaker
+// ones first among equals.
+func scheduleExercises(exercises []Exercise, history []AttemptSummary, now time.Time) []ScheduledExercise {
+	byType := map[TrainingType][]AttemptSummary{}
+	for _, a := range history {
+		if !a.Partial {
+			byType[a.TrainingType] = append(byType[a.TrainingType], a)
+		}
+	}
+
+	schedule := make([]ScheduledExercise, 0, len(exercises))
+	for _, ex := range exercises {
+		s := ScheduledExercise{TrainingType: ex.Type, Name: ex.Name}
+		attempts := byType[ex.Type]
+		s.Attempts = len(attempts)
+		if len(attempts) == 0 {
+			s.Reason = "You haven't tried this exercise yet"
+			schedule = append(schedule, s)
+			continue
+		}
+
+		sort.Slice(attempts, func(i, j int) bool { return attempts[i].Timestamp.Before(attempts[j].Timestamp) })
+		recent := attempts[max(0, len(attempts)-scheduleWindow):]
+		score := 0.0
+		for _, a := range recent {
+			score += a.CompositeScore
+		}
+		score /= float64(len(recent))
+		last := recent[len(recent)-1].Timestamp
+		interval := reviewInterval(score)
+		due := last.Add(interval)
+
+		s.RecentScore = &score
+		s.LastPracticed = &last
+		s.DueAt = &due
+		s.Overdue = float64(now.Sub(last)) / float64(interval)
+		days := int(interval.Hours() / 24)
+		if s.Overdue >= 1 {
+			s.Reason = fmt.Sprintf("Due: you average %.0f here, so it comes back every %s", score, pluralDays(days))
+		} else {
+			s.Reason = fmt.Sprintf("Not due until %s; you average %.0f here", due.UTC().Format(time.DateOnly), score)
+		}
+		schedule = append(schedule, s)
+	}
+
+	sort.SliceStable(schedule, func(i, j int) bool {
+		a, b := schedule[i], schedule[j]
+		if (a.Attempts == 0) != (b.Attempts == 0) {
+			return a.Attempts == 0
+		}
+		if a.Attempts == 0 || a.Overdue == b.Overdue {
+			return a.RecentScore != nil && b.RecentScore != nil && *a.RecentScore < *b.RecentScore
+		}
+		return a.Overdue > b.Overdue
+	})
+	return schedule
+}
+
+// pluralDays says a number of days
+func pluralDays(n int) string {
+	if n == 1 {
+		return "day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+// handleNextExercise recommends the drill the caller should do next, from the
+// exercises available to them and their own attempts
+func handleNextExercise(w http.ResponseWriter, r *http.Request) {
+	ws, ok := workspaceFrom(r.Context())
+	var exercises []Exercise
+	current := currentSettings().Exercises
+	for _, t := range slices.Sorted(maps.Keys(current)) {
+		if !ok || ws.allows(t) {
+			ex := current[t]
+			ex.Type = t
+			exercises = append(exercises, ex)
+		}
+	}
+	if len(exercises) == 0 {
+		httpError(w, "No exercises are available", http.StatusNotFound)
+		return
+	}
+
+	history, err := loadHistory("")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load history", "err", err)
+		httpError(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	// Admins see everyone's attempts in a workspace, but are scheduled by
+	// their own
+	attempts := visibleHistory(r.Context(), history)
+	owner := ownerID(r.Context())
+	attempts = slices.DeleteFunc(attempts, func(a AttemptSummary) bool { return a.UserID != owner })
+
+	schedule := scheduleExercises(exercises, attempts, time.Now())
+	writeResponse(w, r, http.StatusOK, NextExercise{Exercise: schedule[0], Schedule: schedule})
+}
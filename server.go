@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"google.golang.org/grpc"
+)
+
+// readHeaderTimeout is how long a client may take to send request headers
+const readHeaderTimeout = 10 * time.Second
+
+// serve runs the server over HTTP or HTTPS as configured, with the gRPC
+// service when it has an address and HTTP/3 when it's turned on, until it's
+// sent SIGINT or SIGTERM. Then it stops accepting connections and waits up
+// to the drain timeout for in-flight analyses to finish. A second signal exits immediately.
+func serve(cfg config) error {
+	var handler http.Handler = http.DefaultServeMux
+	handler = withDebug(handler, cfg)
+	handler = withWorkspace(handler)
+	handler = withSession(handler)
+	handler = limitBody(handler, cfg.maxBodyBytes)
+	handler = withTimeout(handler, cfg.handlerTimeout)
+	handler = withMaintenance(handler)
+	if len(cfg.corsOrigins) > 0 {
+		handler = withCORS(handler, cfg)
+	}
+	if len(cfg.allowIPs) > 0 || len(cfg.denyIPs) > 0 {
+		handler = withIPFilter(handler, cfg)
+	}
+	handler = withRequestLogging(handler)
+	handler = withCompression(handler)
+	if cfg.basePath != "" {
+		handler = withBasePath(handler)
+	}
+	server := &http.Server{
+		Addr:              cfg.addr,
+		Handler:           handler,
+		Protocols:         &cfg.protocols,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+	}
+	ln, err := listen(cfg.addr, cfg.socketMode)
+	if err != nil {
+		return err
+	}
+	servers := []*http.Server{server}
+	run := func() error { return server.Serve(ln) }
+	switch {
+	case len(cfg.autocert) > 0:
+		servers = append(servers, useAutocert(server, cfg))
+		run = func() error { return server.ServeTLS(ln, "", "") }
+	case cfg.tlsCert != "":
+		run = func() error { return server.ServeTLS(ln, cfg.tlsCert, cfg.tlsKey) }
+	}
+	withoutHTTP2(server)
+
+	// gRPC and HTTP/3 get their own listeners, with the same certificate
+	tlsConfig := server.TLSConfig
+	if cfg.tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	var h3 *http3.Server
+	var udp net.PacketConn
+	if cfg.http3 {
+		h3 = &http3.Server{
+			Addr:        cfg.addr,
+			Handler:     handler,
+			TLSConfig:   http3.ConfigureTLSConfig(tlsConfig),
+			IdleTimeout: cfg.idleTimeout,
+		}
+		if udp, err = net.ListenPacket("udp", cfg.addr); err != nil {
+			return err
+		}
+		defer udp.Close()
+		server.Handler = advertiseHTTP3(handler, h3)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 3)
+	go func() { errc <- run() }()
+	if h3 != nil {
+		go func() { errc <- h3.Serve(udp) }()
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.grpcAddr != "" {
+		grpcServer = newGRPCServer(cfg, tlsConfig)
+		grpcLn, err := listen(cfg.grpcAddr, cfg.socketMode)
+		if err != nil {
+			return err
+		}
+		go func() { errc <- serveGRPC(grpcServer, grpcLn) }()
+	}
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down, waiting for requests to finish", "drainTimeout", cfg.drainTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.drainTimeout)
+	defer cancel()
+	var errs []error
+	var wg sync.WaitGroup
+	if grpcServer != nil {
+		wg.Go(func() { stopGRPC(ctx, grpcServer) })
+	}
+	if h3 != nil {
+		wg.Go(func() { h3.Shutdown(ctx) })
+	}
+	for _, s := range servers {
+		errs = append(errs, s.Shutdown(ctx))
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// unixPrefix marks a listen address as the path of a unix socket
+const unixPrefix = "unix:"
+
+// listen opens a TCP address, a unix socket for addresses starting with
+// unixPrefix, or takes the socket systemd passed in for ones starting with
+// systemdPrefix. A unix socket left behind by a server that didn't shut
+// down cleanly is replaced, but not one another server is still listening
+// on. The socket is given mode and is removed when the listener is closed.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if name, ok := strings.CutPrefix(addr, systemdPrefix); ok {
+		return listenSystemd(name)
+	}
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// limitBody caps the size of request bodies so a client can't exhaust memory
+// by sending megabytes of points
+func limitBody(next http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withTimeout fails requests that take longer than the handler timeout with
+// 503. WebSocket connections, event streams and downloads are left alone
+// since they stay open by design, and http.TimeoutHandler can neither hand a
+// connection over nor flush, so it would hold a whole download in memory.
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	body, _ := json.Marshal(ErrorResponse{Error: &APIError{Code: CodeUnavailable, Message: "Request took too long"}})
+	timed := http.TimeoutHandler(next, timeout, string(body))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if longLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timed.ServeHTTP(timeoutWriter{w}, r)
+	})
+}
+
+// timeoutWriter labels the body http.TimeoutHandler sends on a timeout as
+// JSON, which it otherwise leaves to be sniffed as text
+type timeoutWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentTypeJSON)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// longLived reports whether a request opens a WebSocket or a job's event
+// stream, or downloads a backup or export, which are written as they're read
+func longLived(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+	switch r.URL.Path {
+	case apiPrefix + "/admin/backup", apiPrefix + "/me/data", apiPrefix + "/attempts/export":
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, apiPrefix+"/jobs/") && strings.HasSuffix(r.URL.Path, "/events")
+}
+
+// errNoProtobufMessage is returned for protobuf bodies of requests that have
+// no protobuf message
+var errNoProtobufMessage = errors.New("no protobuf message for this request")
+
+// decodeRequest decodes a JSON, MessagePack or protobuf request body into v,
+// going by its Content-Type. It answers the request and returns false when
+// the body is too large or can't be decoded.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := unmarshalBody(r.Body, requestFormat(r), v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		httpError(w, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	case errors.Is(err, errNoProtobufMessage):
+		httpError(w, "Protobuf bodies are only accepted by /analyze; send JSON or MessagePack", http.StatusUnsupportedMediaType)
+		return false
+	case err != nil:
+		httpError(w, "Invalid request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Session cookies
+const (
+	sessionCookie   = "tradra_session"
+	sessionDuration = 30 * 24 * time.Hour
+)
+
+// csrfHeader carries the session's CSRF token on requests that change state
+const csrfHeader = "X-CSRF-Token"
+
+// errInvalidCookie is returned for cookies that were tampered with or expired
+var errInvalidCookie = errors.New("invalid or expired cookie")
+
+// User is a signed-in user, identified by their login provider's account
+type User struct {
+	ID       string `json:"id"` // provider-prefixed, e.g. "google:1234"
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Provider string `json:"provider"`
+}
+
+// session is the content of the session cookie
+type session struct {
+	User    User      `json:"user"`
+	CSRF    string    `json:"csrf"` // token the page must echo in csrfHeader
+	Expires time.Time `json:"expires"`
+}
+
+// cookieSigner signs cookie values with HMAC-SHA256 so they can be trusted
+// without storing them on the server
+type cookieSigner struct {
+	key []byte
+}
+
+// newCookieSigner uses the given secret, or a random one, in which case
+// cookies stop being valid when the server restarts
+func newCookieSigner(secret string) cookieSigner {
+	if secret != "" {
+		return cookieSigner{key: []byte(secret)}
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return cookieSigner{key: key}
+}
+
+func (s cookieSigner) mac(payload string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// encode returns v as JSON followed by its signature
+func (s cookieSigner) encode(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.mac(payload), nil
+}
+
+// decode checks a value's signature and decodes it into v
+func (s cookieSigner) decode(value string, v any) error {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.mac(payload))) {
+		return errInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errInvalidCookie
+	}
+	return json.Unmarshal(data, v)
+}
+
+type sessionKey struct{}
+
+// userFrom returns the signed-in user of a request's context
+func userFrom(ctx context.Context) (User, bool) {
+	s, ok := ctx.Value(sessionKey{}).(session)
+	return s.User, ok
+}
+
+// csrfSafe reports whether a method only reads, so needs no CSRF token
+func csrfSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// withSession puts the signed-in user, if there is one, or else the guest
+// in the request context. Requests that change state with a session must
+// send its CSRF token, so other sites can't make them on the user's behalf.
+// It does nothing when login isn't configured.
+func withSession(next http.Handler) http.Handler {
+	if login == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := login.session(r)
+		if !ok {
+			if id, ok := login.guest(r); ok {
+				r = r.WithContext(context.WithValue(r.Context(), guestKey{}, id))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !csrfSafe(r.Method) && subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(s.CSRF)) != 1 {
+			httpError(w, "Missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), sessionKey{}, s))
+		addLogAttrs(r.Context(), "userId", s.User.ID)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// settingsFile holds the settings the admin has changed, in resultsDir
+const settingsFile = "settings.json"
+
+// maxExpectedStrokes caps the strokes an exercise may ask for
+const maxExpectedStrokes = 50
+
+// Settings are what the admin can change while the server runs: how strictly
+// each difficulty scores, how each exercise is graded, the achievements to
+// earn, and the rate limit. Training types and difficulties are built in, so
+// only theirs can be set.
+type Settings struct {
+	ScoringProfiles map[Difficulty]ScoringProfile `json:"scoringProfiles,omitempty"`
+	Exercises       map[TrainingType]Exercise     `json:"exercises,omitempty"`
+	Achievements    []Achievement                 `json:"achievements,omitempty"` // replace the built-in ones when given
+	RateLimit       *RateLimitSettings            `json:"rateLimit,omitempty"`    // the -rate-limit flags when unset
+}
+
+// RateLimitSettings is the per-client limit on analyses, off when Rate is 0
+type RateLimitSettings struct {
+	Rate  float64 `json:"rate"`  // requests per second
+	Burst int     `json:"burst"` // requests at once
+}
+
+var (
+	// settingsMu serializes changes; reads go through currentSettings
+	settingsMu sync.Mutex
+	// settings is replaced as a whole on every change, never modified
+	settings atomic.Pointer[Settings]
+	// flagRateLimit is the rate limit from the command line
+	flagRateLimit RateLimitSettings
+)
+
+// currentSettings returns the settings in effect
+func currentSettings() *Settings {
+	return settings.Load()
+}
+
+// loadSettings applies the saved settings, or the defaults when there are
+// none. It's run at startup and again to pick up edits to the file.
+func loadSettings(cfg config) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	flagRateLimit = RateLimitSettings{Rate: cfg.rateLimit, Burst: cfg.rateBurst}
+
+	var s Settings
+	data, err := os.ReadFile(filepath.Join(resultsDir, settingsFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	if err := s.normalize(); err != nil {
+		return err
+	}
+	settings.Store(&s)
+	return nil
+}
+
+// saveSettings validates, saves and applies new settings. What they leave
+// out is saved left out, so it follows the defaults.
+func saveSettings(s Settings) (*Settings, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	if err := s.normalize(); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(resultsDir, settingsFile), data, 0644); err != nil {
+		return nil, err
+	}
+	settings.Store(&s)
+	return &s, nil
+}
+
+// normalize fills in what the settings leave out with the defaults, and
+// rejects values that can't be scored with. An exercise or profile that's
+// given replaces the default one whole. The caller must hold settingsMu.
+func (s *Settings) normalize() error {
+	profiles := maps.Clone(defaultScoringProfiles)
+	for d, p := range s.ScoringProfiles {
+		if _, ok := defaultScoringProfiles[d]; !ok {
+			return fieldError(CodeInvalidValue, "scoringProfiles", "Unknown difficulty %q", d)
+		}
+		if p.RMSEThreshold <= 0 || p.ConvergenceTolerance <= 0 || p.VerticalTolerance <= 0 {
+			return fieldError(CodeOutOfRange, "scoringProfiles", "Tolerances for %s must be positive", d)
+		}
+		profiles[d] = p
+	}
+	s.ScoringProfiles = profiles
+
+	exs := maps.Clone(defaultExercises)
+	for t, ex := range s.Exercises {
+		if _, ok := defaultExercises[t]; !ok {
+			return fieldError(CodeInvalidValue, "exercises", "Unknown exercise %q", t)
+		}
+		ex.Type = t
+		if err := ex.validate(); err != nil {
+			return err
+		}
+		exs[t] = ex
+	}
+	s.Exercises = exs
+
+	if s.Achievements == nil {
+		s.Achievements = slices.Clone(defaultAchievements)
+	}
+	ids := map[string]bool{}
+	for _, a := range s.Achievements {
+		if err := a.validate(); err != nil {
+			return err
+		}
+		if ids[a.ID] {
+			return fieldError(CodeInvalidValue, "achievements", "Achievement %s is given twice", a.ID)
+		}
+		ids[a.ID] = true
+	}
+
+	if s.RateLimit == nil {
+		rl := flagRateLimit
+		s.RateLimit = &rl
+	}
+	if s.RateLimit.Rate < 0 || (s.RateLimit.Rate > 0 && s.RateLimit.Burst < 1) {
+		return fieldError(CodeOutOfRange, "rateLimit", "The rate can't be negative and the burst must be at least 1")
+	}
+	return nil
+}
+
+// validate checks an exercise can be graded
+func (ex Exercise) validate() error {
+	switch {
+	case ex.Name == "":
+		return fieldError(CodeInvalidValue, "exercises", "Exercise %s needs a name", ex.Type)
+	case ex.ExpectedStrokes < 1 || ex.ExpectedStrokes > maxExpectedStrokes:
+		return fieldError(CodeOutOfRange, "exercises", "Exercise %s must expect 1 to %d strokes", ex.Type, maxExpectedStrokes)
+	case !validWeights(ex.RubricWeights.LineQuality, ex.RubricWeights.Convergence, ex.RubricWeights.Proportion, ex.RubricWeights.Composition),
+		!validWeights(ex.ScoreWeights.LineQuality, ex.ScoreWeights.Convergence, ex.ScoreWeights.Proportion):
+		return fieldError(CodeOutOfRange, "exercises", "Weights for %s can't be negative and must add up to more than 0", ex.Type)
+	case ex.TimeBudget.Seconds <= 0 || ex.TimeBudget.MaxBonus < 0 || ex.TimeBudget.MaxPenalty < 0:
+		return fieldError(CodeOutOfRange, "exercises", "The time budget for %s must be positive, with no negative bonus or penalty", ex.Type)
+	}
+	return nil
+}
+
+// validate checks an achievement can be earned
+func (a Achievement) validate() error {
+	switch {
+	case a.ID == "" || a.Name == "":
+		return fieldError(CodeInvalidValue, "achievements", "Achievements need an ID and a name")
+	case !slices.Contains(achievementRules, a.Rule):
+		return fieldError(CodeInvalidValue, "achievements", "Unknown rule %q for %s, expected one of %v", a.Rule, a.ID, achievementRules)
+	case a.Threshold <= 0:
+		return fieldError(CodeOutOfRange, "achievements", "The threshold for %s must be positive", a.ID)
+	}
+	if _, ok := defaultExercises[a.TrainingType]; a.TrainingType != "" && !ok {
+		return fieldError(CodeInvalidValue, "achievements", "Unknown exercise %q for %s", a.TrainingType, a.ID)
+	}
+	return nil
+}
+
+// validWeights reports whether weights are non-negative and not all 0
+func validWeights(weights ...float64) bool {
+	sum := 0.0
+	for _, w := range weights {
+		if w < 0 {
+			return false
+		}
+		sum += w
+	}
+	return sum > 0
+}
+
+// reloadSettingsOnHangup rereads the settings file whenever the server is
+// sent SIGHUP
+func reloadSettingsOnHangup(cfg config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := loadSettings(cfg); err != nil {
+			slog.Warn("Failed to reload settings", "err", err)
+			continue
+		}
+		slog.Info("Reloaded settings")
+	}
+}
+
+// handleGetSettings returns the settings in effect, for the server's admin
+func handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, currentSettings())
+}
+
+// handlePutSettings replaces the settings, taking effect for the next
+// request. Anything left out goes back to its default.
+func handlePutSettings(w http.ResponseWriter, r *http.Request) {
+	var s Settings
+	if !decodeRequest(w, r, &s) {
+		return
+	}
+	saved, err := saveSettings(s)
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		writeError(w, err, http.StatusBadRequest)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to save settings", "err", err)
+		httpError(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Changed settings")
+	writeResponse(w, r, http.StatusOK, saved)
+}
+
+// handleReloadSettings rereads the settings file after it was edited by
+// hand, keeping the current settings if it's invalid
+func handleReloadSettings(cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := loadSettings(cfg); err != nil {
+			slog.WarnContext(r.Context(), "Failed to reload settings", "err", err)
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		slog.InfoContext(r.Context(), "Reloaded settings")
+		writeResponse(w, r, http.StatusOK, currentSettings())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Cache lifetimes for the frontend. Assets are requested with their content
+// hash in the URL, so they can be cached for good; the page itself is always
+// revalidated so a deploy shows up on the next load.
+const (
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+)
+
+// staticAsset is a frontend file with what's needed to cache it
+type staticAsset struct {
+	data    []byte
+	hash    string // start of the SHA-256 of data, for ETags and URLs
+	modTime time.Time
+}
+
+// staticSite is the frontend, loaded once and served from memory
+type staticSite struct {
+	assets map[string]*staticAsset // by name relative to the static root
+}
+
+// loadStaticSite reads every file in fsys, which must have an index.html.
+// References to assets in HTML, written as "/static/<name>", get ?v=<hash>
+// appended so browsers fetch them again exactly when they change, and the
+// base path put in front.
+func loadStaticSite(fsys fs.FS) (*staticSite, error) {
+	site := &staticSite{assets: map[string]*staticAsset{}}
+	var latest time.Time
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Embedded files have no modification time
+		modTime := info.ModTime()
+		if modTime.IsZero() {
+			modTime = staticModTime()
+		}
+		if modTime.After(latest) {
+			latest = modTime
+		}
+		site.assets[name] = newStaticAsset(data, modTime)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := site.assets["index.html"]; !ok {
+		return nil, errors.New("no index.html")
+	}
+
+	// Pages change whenever an asset they link to does
+	for name, page := range site.assets {
+		if path.Ext(name) != ".html" {
+			continue
+		}
+		data := page.data
+		for asset, a := range site.assets {
+			ref := `"/static/` + asset + `"`
+			data = bytes.ReplaceAll(data, []byte(ref), []byte(`"`+sitePath("/static/"+asset)+"?v="+a.hash+`"`))
+		}
+		site.assets[name] = newStaticAsset(data, latest)
+	}
+	return site, nil
+}
+
+func newStaticAsset(data []byte, modTime time.Time) *staticAsset {
+	sum := sha256.Sum256(data)
+	return &staticAsset{data: data, hash: hex.EncodeToString(sum[:8]), modTime: modTime}
+}
+
+// staticRoot is the directory the frontend is served from: -static-dir
+// when given, so operators can restyle the site without a rebuild, or the
+// files built into the binary
+func staticRoot(cfg config) fs.FS {
+	if cfg.staticDir != "" {
+		return os.DirFS(cfg.staticDir)
+	}
+	root, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
+
+// staticModTime is the Last-Modified of the embedded frontend: the commit
+// time of the build, or when the server started when that's unknown
+func staticModTime() time.Time {
+	if t, err := time.Parse(time.RFC3339, buildInfo().BuildTime); err == nil {
+		return t
+	}
+	return startTime
+}
+
+// serve writes an asset with its ETag and Last-Modified, answering
+// conditional requests with 304 and HEAD requests with just the headers
+func (a *staticAsset) serve(w http.ResponseWriter, r *http.Request, name, cacheControl string) {
+	w.Header().Set("ETag", `"`+a.hash+`"`)
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, name, a.modTime, bytes.NewReader(a.data))
+}
+
+// handleIndex serves the page for every path the API doesn't handle
+func (s *staticSite) handleIndex(w http.ResponseWriter, r *http.Request) {
+	s.assets["index.html"].serve(w, r, "index.html", revalidateCacheControl)
+}
+
+// handleAsset serves a file under /static/. Requests carrying the asset's
+// current hash may be cached for good; others, such as bookmarked old
+// versions, are revalidated.
+func (s *staticSite) handleAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("path")
+	a, ok := s.assets[name]
+	if !ok || strings.HasSuffix(name, ".html") {
+		handleNotFound(w, r)
+		return
+	}
+	cacheControl := revalidateCacheControl
+	if r.URL.Query().Get("v") == a.hash {
+		cacheControl = immutableCacheControl
+	}
+	a.serve(w, r, name, cacheControl)
+}
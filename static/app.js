@@ -0,0 +1,657 @@
+// Path the site is mounted under behind a proxy, taken from where this script was loaded
+const basePath = new URL(document.currentScript.src).pathname.replace(/\/static\/app\.js$/, '');
+
+// Canvas setup
+const canvas = document.getElementById('drawingCanvas');
+const ctx = canvas.getContext('2d');
+const resultImg = document.getElementById('resultCanvas');
+const instruction = document.getElementById('instruction');
+
+// UI elements
+const strokeCounter = document.getElementById('strokeCounter');
+const scoreBtn = document.getElementById('scoreBtn');
+const clearBtn = document.getElementById('clearBtn');
+const backBtn = document.getElementById('backBtn');
+const results = document.getElementById('results');
+const loading = document.getElementById('loading');
+
+// Training configuration
+const TRAINING_TYPE = '2point';
+const EXPECTED_STROKES = 9;
+
+// Device identity used to look up the calibrated noise floor (see POST /calibrate)
+let deviceId = localStorage.getItem('tradraDeviceId');
+if (!deviceId) {
+    deviceId = crypto.randomUUID ? crypto.randomUUID() : String(Date.now()) + Math.random().toString(16).slice(2);
+    localStorage.setItem('tradraDeviceId', deviceId);
+}
+
+// CSRF token of the signed-in session, sent with every POST (see /api/v1/me)
+let csrfToken = '';
+
+// Workspace the user is working in, if they belong to any (see /api/v1/workspaces)
+let workspace = localStorage.getItem('tradraWorkspace') || '';
+
+// Revisions of the draft and settings synced for the signed-in user, 0 when
+// none have been seen (see /api/v1/me/sync)
+let draftRevision = 0;
+let settingsRevision = 0;
+
+// Settings kept the same on all the user's devices
+const SYNCED_SETTINGS = ['difficulty', 'timed', 'heatmap', 'fitVPs', 'colorblind'];
+
+// State
+let strokes = [];
+let currentStroke = [];
+let isDrawing = false;
+let isAnalyzed = false;
+
+// Resize canvas to fill container
+function resizeCanvas() {
+    const container = canvas.parentElement;
+    canvas.width = container.clientWidth;
+    canvas.height = container.clientHeight;
+    redraw();
+}
+
+window.addEventListener('resize', resizeCanvas);
+resizeCanvas();
+
+// Drawing functions
+function startDrawing(e) {
+    if (isAnalyzed) return;
+
+    isDrawing = true;
+    currentStroke = [];
+
+    const rect = canvas.getBoundingClientRect();
+
+    // Use getCoalescedEvents for high precision if available
+    const events = e.getCoalescedEvents ? e.getCoalescedEvents() : [e];
+
+    events.forEach(event => {
+        const x = event.clientX - rect.left;
+        const y = event.clientY - rect.top;
+        currentStroke.push({ x, y, t: event.timeStamp });
+    });
+
+    instruction.classList.add('hidden');
+}
+
+function draw(e) {
+    if (!isDrawing || isAnalyzed) return;
+
+    e.preventDefault();
+
+    const rect = canvas.getBoundingClientRect();
+
+    // Use getCoalescedEvents for high precision
+    const events = e.getCoalescedEvents ? e.getCoalescedEvents() : [e];
+
+    events.forEach(event => {
+        const x = event.clientX - rect.left;
+        const y = event.clientY - rect.top;
+        currentStroke.push({ x, y, t: event.timeStamp });
+    });
+
+    redraw();
+}
+
+function stopDrawing(e) {
+    if (!isDrawing || isAnalyzed) return;
+
+    isDrawing = false;
+
+    if (currentStroke.length > 1) {
+        strokes.push([...currentStroke]);
+        updateStrokeCounter();
+        saveDraft();
+
+        // Auto-analyze when we reach the expected stroke count
+        if (strokes.length === EXPECTED_STROKES) {
+            setTimeout(analyzeDrawing, 300); // Small delay for better UX
+        }
+    }
+
+    currentStroke = [];
+    redraw();
+}
+
+// Pointer events (handles mouse, touch, and stylus)
+canvas.addEventListener('pointerdown', startDrawing);
+canvas.addEventListener('pointermove', draw);
+canvas.addEventListener('pointerup', stopDrawing);
+canvas.addEventListener('pointerleave', stopDrawing);
+
+function redraw() {
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+    ctx.strokeStyle = '#000';
+    ctx.lineWidth = 2;
+    ctx.lineCap = 'round';
+    ctx.lineJoin = 'round';
+
+    // Draw completed strokes
+    strokes.forEach(stroke => {
+        if (stroke.length < 2) return;
+
+        ctx.beginPath();
+        ctx.moveTo(stroke[0].x, stroke[0].y);
+
+        for (let i = 1; i < stroke.length; i++) {
+            ctx.lineTo(stroke[i].x, stroke[i].y);
+        }
+
+        ctx.stroke();
+    });
+
+    // Draw current stroke
+    if (currentStroke.length > 1) {
+        ctx.beginPath();
+        ctx.moveTo(currentStroke[0].x, currentStroke[0].y);
+
+        for (let i = 1; i < currentStroke.length; i++) {
+            ctx.lineTo(currentStroke[i].x, currentStroke[i].y);
+        }
+
+        ctx.stroke();
+    }
+}
+
+function updateStrokeCounter() {
+    strokeCounter.textContent = `Strokes: ${strokes.length}/${EXPECTED_STROKES}`;
+
+    if (strokes.length === EXPECTED_STROKES) {
+        strokeCounter.classList.add('complete');
+    } else {
+        strokeCounter.classList.remove('complete');
+    }
+
+    // Allow scoring an incomplete drawing as a partial attempt
+    const canScorePartial = strokes.length > 0 && strokes.length < EXPECTED_STROKES && !isAnalyzed;
+    scoreBtn.style.display = canScorePartial ? 'block' : 'none';
+}
+
+// Score now button
+scoreBtn.addEventListener('click', analyzeDrawing);
+
+// Clear button
+clearBtn.addEventListener('click', () => {
+    strokes = [];
+    currentStroke = [];
+    isDrawing = false;
+    isAnalyzed = false;
+
+    redraw();
+    updateStrokeCounter();
+    saveDraft();
+
+    results.style.display = 'none';
+    resultImg.style.display = 'none';
+    canvas.style.display = 'block';
+    backBtn.style.display = 'none';
+    clearBtn.style.display = 'block';
+    instruction.classList.remove('hidden');
+});
+
+// Analyze drawing function
+async function analyzeDrawing() {
+    loading.style.display = 'flex';
+
+    try {
+        const response = await fetch(basePath + '/api/v1/analyze', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken,
+                ...(workspace && { 'X-Workspace': workspace })
+            },
+            body: JSON.stringify({
+                strokes: strokes,
+                width: canvas.width,
+                height: canvas.height,
+                trainingType: TRAINING_TYPE,
+                deviceId: deviceId,
+                difficulty: document.getElementById('difficulty').value,
+                timed: document.getElementById('timed').checked,
+                heatmap: document.getElementById('heatmap').checked,
+                fitVanishingPoints: document.getElementById('fitVPs').checked,
+                ...(document.getElementById('colorblind').checked && { theme: 'colorblind', style: 'patterned' }),
+                omitImageData: true,
+                locale: navigator.language,
+                scale: Math.min(Math.max(window.devicePixelRatio || 1, 1), 4)
+            })
+        });
+
+        if (!response.ok) {
+            const body = await response.json().catch(() => null);
+            throw new Error(body?.error?.message || 'Analysis failed');
+        }
+
+        const result = await response.json();
+        displayResults(result);
+        discardDraft();
+        loadLeaderboard();
+        loadGallery();
+
+    } catch (error) {
+        alert('Error analyzing drawing: ' + error.message);
+    } finally {
+        loading.style.display = 'none';
+    }
+}
+
+// Back button
+backBtn.addEventListener('click', () => {
+    isAnalyzed = false;
+    results.style.display = 'none';
+    resultImg.style.display = 'none';
+    canvas.style.display = 'block';
+    backBtn.style.display = 'none';
+    clearBtn.style.display = 'block';
+    updateStrokeCounter();
+});
+
+function displayResults(result) {
+    isAnalyzed = true;
+    scoreBtn.style.display = 'none';
+
+    // Show result image
+    resultImg.src = result.imageUrl || result.imageData;
+    resultImg.style.display = 'block';
+    resultImg.style.width = canvas.width + 'px';
+    resultImg.style.height = canvas.height + 'px';
+    canvas.style.display = 'none';
+
+    // Update scores
+    const lineScore = Math.round(result.averageLineScore);
+    const perspScore = Math.round(result.perspectiveScore);
+
+    document.getElementById('lineScore').textContent = lineScore + '%';
+    document.getElementById('perspectiveScore').textContent = perspScore + '%';
+
+    // Apply color classes
+    document.getElementById('lineScore').className = 'result-value ' + getScoreClass(lineScore);
+    document.getElementById('perspectiveScore').className = 'result-value ' + getScoreClass(perspScore);
+
+    // Letter grade
+    const gradeEl = document.getElementById('grade');
+    gradeEl.textContent = result.grade.letter;
+    gradeEl.className = 'result-value ' + getScoreClass(Math.round(result.grade.score));
+
+    // Composite and per-group scores
+    setScore('compositeScore', result.compositeScore);
+    setScore('leftScore', result.leftConvergenceScore);
+    setScore('rightScore', result.rightConvergenceScore);
+    setScore('verticalScore', result.verticalScore);
+
+    // Feedback hints
+    const feedbackList = document.getElementById('feedback');
+    feedbackList.innerHTML = '';
+    (result.feedback || []).forEach(hint => {
+        const li = document.createElement('li');
+        li.textContent = hint;
+        feedbackList.appendChild(li);
+    });
+
+    // Comparison with previous attempts
+    const percentileEl = document.getElementById('percentile');
+    if (result.partial) {
+        percentileEl.textContent = `Partial attempt: ${result.lineScores.length} of ${result.expectedStrokes} strokes scored`;
+    } else if (result.percentile !== null && result.percentile !== undefined) {
+        percentileEl.textContent = `You scored better than ${Math.round(result.percentile)}% of your previous attempts`;
+    } else {
+        percentileEl.textContent = 'First attempt for this exercise';
+    }
+
+    // Practice streak of signed-in users, celebrating milestones
+    const streakEl = document.getElementById('streak');
+    const streak = result.streak;
+    streakEl.classList.toggle('milestone', !!streak?.milestone);
+    if (streak?.milestone) {
+        streakEl.textContent = `${streak.milestone}-day streak! Keep it up`;
+    } else if (streak?.current > 1) {
+        streakEl.textContent = `${streak.current} days in a row (longest ${streak.longest})`;
+    } else {
+        streakEl.textContent = '';
+    }
+
+    // New personal bests, worth celebrating
+    const bestsEl = document.getElementById('personalBests');
+    const bestNames = { compositeScore: 'score', averageLineScore: 'line score', perspectiveScore: 'perspective score' };
+    bestsEl.textContent = (result.personalBests || [])
+        .map(best => `New best ${bestNames[best.metric] || best.metric}! ${best.score.toFixed(1)}, up from ${best.previous.toFixed(1)}`)
+        .join(' · ');
+
+    // Show results panel
+    results.style.display = 'block';
+
+    // Update buttons
+    clearBtn.style.display = 'none';
+    backBtn.style.display = 'block';
+
+    // Log saved file path
+    if (result.savedFilePath) {
+        console.log('Result saved to:', result.savedFilePath);
+    }
+}
+
+function setScore(id, value) {
+    const score = Math.round(value);
+    const el = document.getElementById(id);
+    el.textContent = score + '%';
+    el.className = 'result-value ' + getScoreClass(score);
+}
+
+function getScoreClass(score) {
+    if (score >= 70) return 'good';
+    if (score >= 40) return 'medium';
+    return 'poor';
+}
+
+// Show who's signed in. /me only exists when the server has a login
+// provider, so nothing is shown otherwise.
+async function loadAccount() {
+    const account = document.getElementById('account');
+    const response = await fetch(basePath + '/api/v1/me');
+    if (response.status === 401) {
+        csrfToken = '';
+        workspace = '';
+        document.getElementById('leaderboardJoin').hidden = true;
+        document.getElementById('galleryJoin').hidden = true;
+        const link = document.createElement('a');
+        link.href = basePath + '/auth/login?return=' + encodeURIComponent(location.pathname);
+        link.textContent = 'Sign in';
+        const linkDevice = document.createElement('a');
+        linkDevice.href = '#';
+        linkDevice.textContent = 'Use a code';
+        linkDevice.addEventListener('click', (e) => {
+            e.preventDefault();
+            const code = prompt('Enter the code shown on your signed-in device');
+            if (code) redeemLink(code);
+        });
+        account.replaceChildren(link, linkDevice);
+    } else if (response.ok) {
+        const user = await response.json();
+        csrfToken = user.csrfToken;
+        const signOut = document.createElement('a');
+        signOut.href = '#';
+        signOut.textContent = 'Sign out';
+        signOut.addEventListener('click', async (e) => {
+            e.preventDefault();
+            await fetch(basePath + '/auth/logout', { method: 'POST', headers: { 'X-CSRF-Token': csrfToken } });
+            loadAccount();
+        });
+        const linkDevice = document.createElement('a');
+        linkDevice.href = '#';
+        linkDevice.textContent = 'Link a device';
+        linkDevice.addEventListener('click', (e) => {
+            e.preventDefault();
+            showDeviceLink();
+        });
+        account.replaceChildren('Signed in as ' + (user.displayName || user.name || user.email || user.id), linkDevice, signOut);
+        loadWorkspaces(account);
+        loadOptIns();
+        loadSettings();
+        loadDraft();
+    }
+}
+
+// Show a code and QR code another device can sign in to this account with
+async function showDeviceLink() {
+    const response = await fetch(basePath + '/api/v1/me/devices/link', {
+        method: 'POST',
+        headers: { 'X-CSRF-Token': csrfToken }
+    });
+    if (!response.ok) {
+        alert('Could not make a code to link a device with');
+        return;
+    }
+    const link = await response.json();
+    document.getElementById('linkQR').src = link.qrCode;
+    document.getElementById('linkCode').textContent = link.code;
+    document.getElementById('linkPanel').hidden = false;
+}
+
+document.getElementById('linkDone').addEventListener('click', () => {
+    document.getElementById('linkPanel').hidden = true;
+});
+
+// Sign this device in with a code from another, then pick up its draft and
+// settings
+async function redeemLink(code) {
+    const response = await fetch(basePath + '/api/v1/devices/link', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+        body: JSON.stringify({ code })
+    });
+    if (!response.ok) {
+        const body = await response.json().catch(() => null);
+        alert('Could not link this device: ' + (body?.error?.message || 'try a new code'));
+        return;
+    }
+    await loadAccount();
+    loadLeaderboard();
+}
+
+// saveSynced saves one of the user's synced items over the revision last
+// seen. When another device saved it since, this one's is newer, so it's
+// saved again over theirs.
+async function saveSynced(name, data, revision) {
+    const put = (revision) => fetch(basePath + '/api/v1/me/sync/' + name, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+        body: JSON.stringify({ data, revision })
+    });
+    let response = await put(revision);
+    if (response.status === 409) {
+        const current = await fetch(basePath + '/api/v1/me/sync/' + name);
+        response = await put(current.ok ? (await current.json()).revision : 0);
+    }
+    return response.ok ? (await response.json()).revision : revision;
+}
+
+// Keep the drawing in progress on the server, so it can be finished on
+// another device
+async function saveDraft() {
+    if (!csrfToken) return;
+    if (strokes.length === 0) {
+        discardDraft();
+        return;
+    }
+    draftRevision = await saveSynced('draft', {
+        trainingType: TRAINING_TYPE,
+        difficulty: document.getElementById('difficulty').value,
+        width: canvas.width,
+        height: canvas.height,
+        strokes: strokes,
+        deviceId: deviceId
+    }, draftRevision);
+}
+
+async function discardDraft() {
+    if (!csrfToken) return;
+    draftRevision = 0;
+    await fetch(basePath + '/api/v1/me/sync/draft', { method: 'DELETE', headers: { 'X-CSRF-Token': csrfToken } });
+}
+
+// Pick up a drawing saved by another device, scaled to this canvas, unless
+// this one's being scored
+async function loadDraft() {
+    const response = await fetch(basePath + '/api/v1/me/sync/draft');
+    if (!response.ok || isAnalyzed || isDrawing) return;
+    const item = await response.json();
+    if (item.revision <= draftRevision) return;
+    draftRevision = item.revision;
+    const draft = item.data;
+    if (draft.trainingType !== TRAINING_TYPE) return;
+    const sx = canvas.width / draft.width;
+    const sy = canvas.height / draft.height;
+    strokes = draft.strokes.map(stroke => stroke.map(p => ({ x: p.x * sx, y: p.y * sy, t: p.t })));
+    if (draft.difficulty) document.getElementById('difficulty').value = draft.difficulty;
+    instruction.classList.toggle('hidden', strokes.length > 0);
+    redraw();
+    updateStrokeCounter();
+}
+
+// Apply the settings the user last chose on any of their devices
+async function loadSettings() {
+    const response = await fetch(basePath + '/api/v1/me/sync/settings');
+    if (!response.ok) return;
+    const item = await response.json();
+    if (item.revision <= settingsRevision) return;
+    settingsRevision = item.revision;
+    for (const id of SYNCED_SETTINGS) {
+        const input = document.getElementById(id);
+        if (!(id in item.data)) continue;
+        if (input.type === 'checkbox') {
+            input.checked = !!item.data[id];
+        } else {
+            input.value = item.data[id];
+        }
+    }
+    loadLeaderboard();
+}
+
+for (const id of SYNCED_SETTINGS) {
+    document.getElementById(id).addEventListener('change', async () => {
+        if (!csrfToken) return;
+        const data = {};
+        for (const name of SYNCED_SETTINGS) {
+            const input = document.getElementById(name);
+            data[name] = input.type === 'checkbox' ? input.checked : input.value;
+        }
+        settingsRevision = await saveSynced('settings', data, settingsRevision);
+    });
+}
+
+// Catch up with the other devices when coming back to this one
+document.addEventListener('visibilitychange', () => {
+    if (document.visibilityState === 'visible' && csrfToken) {
+        loadSettings();
+        loadDraft();
+    }
+});
+
+// Show the best attempts at this exercise of those who opted in, in the
+// chosen workspace
+async function loadLeaderboard() {
+    const params = new URLSearchParams({
+        trainingType: TRAINING_TYPE,
+        difficulty: document.getElementById('difficulty').value,
+        period: document.getElementById('leaderboardPeriod').value
+    });
+    const response = await fetch(basePath + '/api/v1/leaderboard?' + params, {
+        headers: workspace ? { 'X-Workspace': workspace } : {}
+    });
+    const list = document.getElementById('leaderboardList');
+    const board = response.ok ? await response.json() : { entries: [] };
+    list.replaceChildren(...board.entries.map(entry => {
+        const item = document.createElement('li');
+        item.value = entry.rank;
+        item.textContent = entry.name + ' — ' + Math.round(entry.compositeScore) + '%';
+        item.classList.toggle('you', !!entry.you);
+        return item;
+    }));
+    if (board.entries.length === 0) {
+        const item = document.createElement('li');
+        item.className = 'empty';
+        item.textContent = 'No ranked attempts yet';
+        list.append(item);
+    }
+}
+
+// Show recent high-scoring attempts at this exercise, without who made them
+async function loadGallery() {
+    const params = new URLSearchParams({ trainingType: TRAINING_TYPE, limit: 6 });
+    const response = await fetch(basePath + '/api/v1/gallery?' + params);
+    const entries = response.ok ? await response.json() : [];
+    const list = document.getElementById('galleryList');
+    list.replaceChildren(...entries.map(entry => {
+        const figure = document.createElement('figure');
+        const img = document.createElement('img');
+        img.src = entry.thumbnailUrl;
+        img.alt = entry.grade + ' drawing';
+        figure.append(img, Math.round(entry.compositeScore) + '%');
+        return figure;
+    }));
+    if (entries.length === 0) {
+        list.textContent = 'No drawings shared yet';
+    }
+}
+
+// Let signed-in users opt in to leaderboards and the gallery from their
+// profile
+async function loadOptIns() {
+    const response = await fetch(basePath + '/api/v1/me/profile');
+    if (!response.ok) return;
+    const profile = await response.json();
+    const optIn = (field, reload) => {
+        const box = document.getElementById(field + 'OptIn');
+        box.checked = profile[field];
+        box.onchange = async () => {
+            profile[field] = box.checked;
+            await fetch(basePath + '/api/v1/me/profile', {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+                body: JSON.stringify({
+                    displayName: profile.displayName,
+                    handedness: profile.handedness,
+                    preferredDevice: profile.preferredDevice,
+                    leaderboard: profile.leaderboard,
+                    gallery: profile.gallery,
+                    weeklyDigest: profile.weeklyDigest
+                })
+            });
+            reload();
+        };
+        document.getElementById(field + 'Join').hidden = false;
+    };
+    optIn('leaderboard', loadLeaderboard);
+    optIn('gallery', loadGallery);
+}
+
+// Let members of workspaces pick which one their attempts go to
+async function loadWorkspaces(account) {
+    const response = await fetch(basePath + '/api/v1/workspaces');
+    const workspaces = response.ok ? await response.json() : [];
+    if (!workspaces.some(ws => ws.id === workspace)) {
+        workspace = '';
+        localStorage.removeItem('tradraWorkspace');
+    }
+    if (workspaces.length === 0) return;
+
+    const select = document.createElement('select');
+    select.add(new Option('Personal', ''));
+    for (const ws of workspaces) {
+        select.add(new Option(ws.name, ws.id));
+    }
+    select.value = workspace;
+    select.addEventListener('change', () => {
+        workspace = select.value;
+        localStorage.setItem('tradraWorkspace', workspace);
+        loadLeaderboard();
+    });
+    account.prepend(select);
+}
+
+document.getElementById('leaderboardPeriod').addEventListener('change', loadLeaderboard);
+document.getElementById('difficulty').addEventListener('change', loadLeaderboard);
+
+// Initialize, linking this device first when it was opened from another's QR code
+async function init() {
+    const params = new URLSearchParams(location.search);
+    const code = params.get('link');
+    await loadAccount();
+    if (code) {
+        params.delete('link');
+        history.replaceState(null, '', location.pathname + (params.size ? '?' + params : '') + location.hash);
+        if (confirm('Sign this device in to the account that showed the code?')) {
+            await redeemLink(code);
+        }
+    }
+}
+
+updateStrokeCounter();
+init();
+loadLeaderboard();
+loadGallery();
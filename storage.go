@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Storage keeps the attempt history and users' profiles. SQLite in the results directory suits a
+// single server; PostgreSQL lets several share one history.
+type Storage interface {
+	// AddAttempt records an attempt with the request it was analyzed from
+	// and its result, as JSON, when they're known
+	AddAttempt(a AttemptSummary, request, result []byte) error
+	// History returns the attempts of a training type, or of all of them
+	// when it's empty, oldest first
+	History(trainingType TrainingType) ([]AttemptSummary, error)
+	// Attempt returns the record of an attempt, or errAttemptNotFound
+	Attempt(id string) (StoredAttempt, error)
+	// ListAttempts returns a page of the attempts a filter matches, in its
+	// order, and how many it matches over all pages
+	ListAttempts(f AttemptFilter) ([]StoredAttempt, int, error)
+	// ExportAttempts calls fn with each attempt a filter picks, oldest first,
+	// with its request and result as JSON when they were kept; the filter's
+	// order and page are ignored
+	ExportAttempts(f AttemptFilter, fn func(a AttemptSummary, request, result []byte) error) error
+	// SetAttemptNotes replaces an attempt's notes and tags
+	SetAttemptNotes(id, notes string, tags []string) error
+	// ImportAttempt records an attempt from an export unless it's kept
+	// already, under its ID or as the same user's attempt at the exercise at
+	// the same time, and reports whether it was added
+	ImportAttempt(a AttemptSummary, request, result []byte) (bool, error)
+	// ProgressChart aggregates the complete attempts a filter picks by
+	// exercise and by the day, week or month they were made in, oldest first;
+	// the filter's order and page are ignored
+	ProgressChart(f AttemptFilter, bucket string) ([]ChartPoint, error)
+	// AttemptRequest returns the request an attempt was analyzed from as
+	// JSON, or errAttemptNotFound when it's unknown or wasn't kept
+	AttemptRequest(id string) ([]byte, error)
+	// AttemptResult returns an attempt's result as JSON, or
+	// errAttemptNotFound when it's unknown or wasn't kept
+	AttemptResult(id string) ([]byte, error)
+	// SaveUser records a user as they sign in, keeping the profile they've
+	// set, and cancels their account's deletion if it's pending
+	SaveUser(u User) error
+	// DeleteUser marks a user deleted now and hides their attempts until
+	// they're purged, or returns errUserNotFound when they've never been saved
+	DeleteUser(userID string, now time.Time) error
+	// DeletedUsers returns the users marked deleted before a time
+	DeletedUsers(before time.Time) ([]string, error)
+	// PurgeUser removes a deleted user for good with their attempts and
+	// goals, calling removeFiles with each attempt first, or returns
+	// errUserNotFound when they aren't marked deleted
+	PurgeUser(userID string, removeFiles func(AttemptSummary) error) error
+	// Profile returns a user's profile, or errUserNotFound when they've never
+	// been saved
+	Profile(userID string) (Profile, error)
+	// SetProfile replaces the settings of a saved user's profile
+	SetProfile(userID string, settings ProfileSettings) error
+	// PracticeDays returns the days, in UTC, a user recorded attempts on,
+	// oldest first
+	PracticeDays(userID string) ([]PracticeDay, error)
+	// Leaderboard returns the best ranked attempt of each user who opted in,
+	// best first, without their ranks
+	Leaderboard(q LeaderboardQuery) ([]LeaderboardEntry, error)
+	// Gallery returns the ranked attempts outside workspaces of users who
+	// opted in, newest first
+	Gallery(q GalleryQuery) ([]GalleryEntry, error)
+	// AddGoal records a new goal
+	AddGoal(g Goal) error
+	// Goals returns a user's goals, oldest first
+	Goals(userID string) ([]Goal, error)
+	// DeleteGoal removes one of a user's goals, returning errGoalNotFound
+	// when they have none with the ID
+	DeleteGoal(userID, id string) error
+	// ActiveGoals returns every user's goals whose deadline, YYYY-MM-DD, is
+	// today or later
+	ActiveGoals(today string) ([]Goal, error)
+	// DigestUsers returns the users who want weekly digests, have an email
+	// address and haven't been sent one for a week, which starts on a
+	// YYYY-MM-DD day, or a later one, named by their display name if they
+	// chose one
+	DigestUsers(week string) ([]User, error)
+	// ClaimDigest records a user's digest for a week being sent, reporting
+	// false when one has been already, such as by another server
+	ClaimDigest(userID, week string) (bool, error)
+	// MergeGuest gives a guest's attempts to a user, returning how many
+	MergeGuest(guestID, userID string) (int64, error)
+	// AddDeviceLink records a code for linking a device to a user until it
+	// expires, by the ID it's looked up by and the hash of all of it,
+	// replacing their earlier codes and forgetting expired ones. It returns
+	// errLinkTaken when another unexpired code has the ID.
+	AddDeviceLink(codeID, codeHash, userID string, expires, now time.Time) error
+	// ClaimDeviceLink uses up the unexpired code with an ID if its hash
+	// matches, returning the user it links to, or errLinkNotFound. Each
+	// mismatch counts against the code, which is forgotten after maxFailures.
+	ClaimDeviceLink(codeID, codeHash string, maxFailures int, now time.Time) (string, error)
+	// SyncItem returns one of the items a user syncs between devices, or
+	// errSyncNotFound
+	SyncItem(userID, name string) (SyncItem, error)
+	// PutSyncItem saves a synced item over the revision the device last saw,
+	// 0 for none, returning it with its new revision, or errSyncConflict when
+	// another device saved it since
+	PutSyncItem(userID, name string, data []byte, revision int64, now time.Time) (SyncItem, error)
+	// DeleteSyncItem removes a synced item, if there is one
+	DeleteSyncItem(userID, name string) error
+	// ClaimGoalReminder records a reminder about a goal being sent now,
+	// reporting false when one was recorded since previous, so servers
+	// sharing the database don't both send it
+	ClaimGoalReminder(id string, previous *time.Time, now time.Time) (bool, error)
+	// AddComment records a new comment on an attempt
+	AddComment(c Comment) error
+	// Comments returns the comments on an attempt, oldest first, leaving out
+	// those of deleted users and those reported by hideReports users or more
+	Comments(attemptID string, hideReports int) ([]Comment, error)
+	// Comment returns a comment, or errCommentNotFound
+	Comment(id string) (Comment, error)
+	// UserComments returns the comments a user wrote, oldest first
+	UserComments(userID string) ([]Comment, error)
+	// ReportedComments returns every reported comment, most reported first
+	ReportedComments() ([]Comment, error)
+	// DeleteComment removes a comment and its reports, returning
+	// errCommentNotFound when there's none with the ID
+	DeleteComment(id string) error
+	// ReportComment records a user reporting a comment, once however often
+	// they do
+	ReportComment(id, userID string) error
+	// ClearCommentReports forgets a comment's reports
+	ClearCommentReports(id string) error
+	// CommentsClosed reports whether an attempt's user closed its comments,
+	// returning errAttemptNotFound when there's no such attempt
+	CommentsClosed(attemptID string) (bool, error)
+	// SetCommentsClosed closes or reopens an attempt's comments
+	SetCommentsClosed(attemptID string, closed bool) error
+	// ClearAttemptRequests drops the strokes and options kept with attempts
+	// made before a time, keeping their scores and results, calling
+	// removeFiles with each first, and returns how many it dropped
+	ClearAttemptRequests(before time.Time, removeFiles func(AttemptSummary) error) (int64, error)
+	// ClearSavedFiles forgets the saved images of attempts made before a
+	// time, calling removeFiles with each first, and returns how many
+	ClearSavedFiles(before time.Time, removeFiles func(AttemptSummary) error) (int, error)
+	// Backup writes a consistent copy of the database to a new file at path,
+	// or returns errBackupUnsupported when it isn't kept in a file
+	Backup(path string) error
+	// Ping checks the storage can be reached
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// StoredAttempt is an attempt's record, and what else was kept of it
+type StoredAttempt struct {
+	AttemptSummary
+	HasRequest bool // it can be rendered again
+	HasResult  bool
+}
+
+// AttemptFilter picks attempts to list
+type AttemptFilter struct {
+	WorkspaceID  string       // attempts made in this workspace, or outside all of them when empty
+	UserID       string       // only this user's attempts, or the anonymous ones when empty
+	AllUsers     bool         // everyone's attempts, ignoring UserID
+	AnyWorkspace bool         // attempts in or outside any workspace, ignoring WorkspaceID
+	TrainingType TrainingType // only this exercise, when set
+	Tags         []string     // only attempts with all of these tags
+	From, To     time.Time    // from, inclusive, and to, exclusive, when set
+	Sort         string       // one of attemptSorts
+	Ascending    bool
+	Limit        int
+	Offset       int
+}
+
+// store is the storage in use, opened at startup
+var store Storage
+
+// openStorage opens the storage a DSN names: a postgres:// or postgresql://
+// URL for PostgreSQL, or SQLite in the results directory when it's empty
+func openStorage(dsn string) (Storage, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return openPostgres(dsn)
+	}
+	return openSQLite()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// streakMilestones are the streak lengths, in days, worth celebrating
+var streakMilestones = []int{3, 7, 14, 30, 50, 100, 200, 365}
+
+// PracticeDay is a day, in UTC, a user recorded attempts on
+type PracticeDay struct {
+	Day      string // YYYY-MM-DD
+	Attempts int
+}
+
+// Streak is how many days in a row, in UTC, a user has practiced
+type Streak struct {
+	Current       int    `json:"current"` // ending today, or yesterday while today's still open
+	Longest       int    `json:"longest"`
+	LastPracticed string `json:"lastPracticed,omitempty"` // YYYY-MM-DD
+	// PracticedToday is whether the current streak already includes today
+	PracticedToday bool `json:"practicedToday"`
+	// Milestone is the streak length just reached by today's first attempt,
+	// for the UI to celebrate
+	Milestone int `json:"milestone,omitempty"`
+}
+
+// calculateStreak works out a streak from the days practiced, oldest first
+func calculateStreak(days []PracticeDay, now time.Time) Streak {
+	var s Streak
+	if len(days) == 0 {
+		return s
+	}
+
+	run := 0
+	var prev time.Time
+	for _, d := range days {
+		day, err := time.Parse(time.DateOnly, d.Day)
+		if err != nil {
+			continue
+		}
+		if run > 0 && day.Equal(prev.AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run = 1
+		}
+		s.Longest = max(s.Longest, run)
+		prev = day
+	}
+
+	last := days[len(days)-1]
+	today := now.UTC().Format(time.DateOnly)
+	yesterday := now.UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	s.LastPracticed = last.Day
+	s.PracticedToday = last.Day == today
+	if s.PracticedToday || last.Day == yesterday {
+		s.Current = run
+	}
+	if s.PracticedToday && last.Attempts == 1 && slices.Contains(streakMilestones, s.Current) {
+		s.Milestone = s.Current
+	}
+	return s
+}
+
+// userStreak returns a user's streak, or nil when it can't be loaded
+func userStreak(ctx context.Context, userID string) *Streak {
+	days, err := store.PracticeDays(userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load practice days", "userId", userID, "err", err)
+		return nil
+	}
+	s := calculateStreak(days, time.Now())
+	return &s
+}
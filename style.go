@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Limits on style sizes, in output pixels at 1x
+const (
+	maxStyleWidth  = 50.0
+	maxMarkerSize  = 100.0
+	maxDashLength  = 100.0
+	maxDashEntries = 8
+)
+
+// defaultStyleName is the style used when a request doesn't pick one
+const defaultStyleName = "solid"
+
+// Style holds the overlay line widths, dash patterns and VP marker radius in
+// output pixels at 1x, so they grow with the render scale but not with the
+// zoom. In a request it can be given as a style name ("patterned") or as an
+// object; an object starts from the style named in its "name" field (or the
+// default) and overrides the fields it sets.
+type Style struct {
+	Name           string    `json:"name,omitempty"`
+	StrokeWidth    float64   `json:"strokeWidth"`
+	IdealLineWidth float64   `json:"idealLineWidth"`
+	IdealLineDash  []float64 `json:"idealLineDash,omitempty"`
+	IdealBoxWidth  float64   `json:"idealBoxWidth"`
+	IdealBoxDash   []float64 `json:"idealBoxDash,omitempty"`
+	HorizonWidth   float64   `json:"horizonWidth"`
+	HorizonDash    []float64 `json:"horizonDash,omitempty"`
+	VPRayWidth     float64   `json:"vpRayWidth"`
+	VPRayDash      []float64 `json:"vpRayDash,omitempty"`
+	MarkerRadius   float64   `json:"markerRadius"`
+}
+
+// styles holds the built-in named styles. "patterned" tells the overlay
+// elements apart by dash pattern as well as color, for color blind users.
+var styles = map[string]Style{
+	"solid": {
+		Name:           "solid",
+		StrokeWidth:    2,
+		IdealLineWidth: 2,
+		IdealBoxWidth:  2,
+		HorizonWidth:   1.5,
+		VPRayWidth:     1,
+		MarkerRadius:   8,
+	},
+	"patterned": {
+		Name:           "patterned",
+		StrokeWidth:    2,
+		IdealLineWidth: 2,
+		IdealBoxWidth:  2,
+		IdealBoxDash:   []float64{10, 5},
+		HorizonWidth:   1.5,
+		HorizonDash:    []float64{2, 5},
+		VPRayWidth:     1.5,
+		VPRayDash:      []float64{6, 4},
+		MarkerRadius:   8,
+	},
+}
+
+// defaultStyle is the overlay style used when a request doesn't set one
+var defaultStyle = styles[defaultStyleName]
+
+// DecodeMsgpack accepts a style name or object in MessagePack requests
+func (s *Style) DecodeMsgpack(dec *msgpack.Decoder) error {
+	return decodeMsgpackAsJSON(dec, s)
+}
+
+// UnmarshalJSON accepts either a style name or a style object
+func (s *Style) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		base, ok := styles[name]
+		if !ok {
+			return fmt.Errorf("unknown style %q", name)
+		}
+		*s = base
+		return nil
+	}
+
+	// Decode once to find the base style, then decode again on top of it
+	type plain Style
+	var probe plain
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	baseName := probe.Name
+	if baseName == "" {
+		baseName = defaultStyleName
+	}
+	base, ok := styles[baseName]
+	if !ok {
+		return fmt.Errorf("unknown style %q", baseName)
+	}
+
+	merged := plain(base)
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return err
+	}
+	*s = Style(merged)
+	return nil
+}
+
+// resolve validates the style; a nil style uses the defaults
+func (s *Style) resolve() (Style, error) {
+	if s == nil {
+		return defaultStyle, nil
+	}
+
+	sizes := []struct {
+		name  string
+		value float64
+		max   float64
+	}{
+		{"strokeWidth", s.StrokeWidth, maxStyleWidth},
+		{"idealLineWidth", s.IdealLineWidth, maxStyleWidth},
+		{"idealBoxWidth", s.IdealBoxWidth, maxStyleWidth},
+		{"horizonWidth", s.HorizonWidth, maxStyleWidth},
+		{"vpRayWidth", s.VPRayWidth, maxStyleWidth},
+		{"markerRadius", s.MarkerRadius, maxMarkerSize},
+	}
+	for _, f := range sizes {
+		if f.value <= 0 || f.value > f.max {
+			return Style{}, fmt.Errorf("style %s must be between 0 and %g", f.name, f.max)
+		}
+	}
+
+	dashes := []struct {
+		name  string
+		value []float64
+	}{
+		{"idealLineDash", s.IdealLineDash},
+		{"idealBoxDash", s.IdealBoxDash},
+		{"horizonDash", s.HorizonDash},
+		{"vpRayDash", s.VPRayDash},
+	}
+	for _, f := range dashes {
+		if len(f.value) > maxDashEntries {
+			return Style{}, fmt.Errorf("style %s has more than %d entries", f.name, maxDashEntries)
+		}
+		for _, d := range f.value {
+			if d <= 0 || d > maxDashLength {
+				return Style{}, fmt.Errorf("style %s entries must be between 0 and %g", f.name, maxDashLength)
+			}
+		}
+	}
+	return *s, nil
+}
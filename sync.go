@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	// errSyncNotFound is returned for items a user hasn't synced
+	errSyncNotFound = errors.New("Nothing synced")
+	// errSyncConflict is returned when another device saved an item since
+	// the revision a device last saw
+	errSyncConflict = errors.New("Changed on another device")
+)
+
+// SyncItem is something a user keeps the same on all their devices
+type SyncItem struct {
+	Data      json.RawMessage `json:"data"`
+	Revision  int64           `json:"revision"` // counts saves, so devices can tell theirs is stale
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// SyncRequest saves an item over the revision the device last saw, 0 when it
+// hasn't seen one
+type SyncRequest struct {
+	Data     json.RawMessage `json:"data"`
+	Revision int64           `json:"revision"`
+}
+
+// Draft is a drawing in progress, kept so it can be finished on another device
+type Draft struct {
+	TrainingType TrainingType `json:"trainingType"`
+	Difficulty   Difficulty   `json:"difficulty,omitempty"`
+	Width        float64      `json:"width"` // of the canvas the strokes were drawn on
+	Height       float64      `json:"height"`
+	Strokes      []Stroke     `json:"strokes"`
+	DeviceID     string       `json:"deviceId,omitempty"`
+}
+
+// syncKind is an item users can sync, with the most it may hold and what
+// it must be
+type syncKind struct {
+	maxBytes int
+	validate func(data []byte) error
+}
+
+// syncKinds are the items users can sync, by name
+var syncKinds = map[string]syncKind{
+	// settings are the page's own, which the server only keeps
+	"settings": {16 << 10, func(data []byte) error {
+		var settings map[string]any
+		if json.Unmarshal(data, &settings) != nil || settings == nil {
+			return fieldError(CodeInvalidValue, "data", "Settings must be an object")
+		}
+		return nil
+	}},
+	"draft": {1 << 20, func(data []byte) error {
+		var d Draft
+		if err := json.Unmarshal(data, &d); err != nil {
+			return fieldError(CodeInvalidValue, "data", "Invalid draft")
+		}
+		return validateDraft(d)
+	}},
+}
+
+// validateDraft rejects drafts that couldn't be finished and scored
+func validateDraft(d Draft) error {
+	if _, ok := defaultExercises[d.TrainingType]; !ok {
+		return fieldError(CodeInvalidValue, "data.trainingType", "Unknown training type %q", d.TrainingType)
+	}
+	if d.Difficulty != "" {
+		if _, ok := getScoringProfile(d.Difficulty); !ok {
+			return fieldError(CodeInvalidValue, "data.difficulty", "Unknown difficulty %q", d.Difficulty)
+		}
+	}
+	if d.Width <= 0 || d.Height <= 0 {
+		return fieldError(CodeOutOfRange, "data.width", "Width and height must be positive")
+	}
+	if len(d.DeviceID) > maxDeviceID {
+		return fieldError(CodeOutOfRange, "data.deviceId", "Device ID must be at most %d characters", maxDeviceID)
+	}
+	return nil
+}
+
+// syncKindOf returns the kind of item a request's path names, writing a 404
+// when it's none
+func syncKindOf(w http.ResponseWriter, r *http.Request) (string, syncKind, bool) {
+	name := r.PathValue("name")
+	kind, ok := syncKinds[name]
+	if !ok {
+		httpError(w, fmt.Sprintf("Unknown synced item %q", name), http.StatusNotFound)
+	}
+	return name, kind, ok
+}
+
+// handleGetSyncItem returns one of the signed-in user's synced items
+func handleGetSyncItem(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	name, _, ok := syncKindOf(w, r)
+	if !ok {
+		return
+	}
+	item, err := store.SyncItem(u.ID, name)
+	if errors.Is(err, errSyncNotFound) {
+		httpError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load synced item", "userId", u.ID, "name", name, "err", err)
+		httpError(w, "Failed to load synced item", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, item)
+}
+
+// handlePutSyncItem saves one of the signed-in user's synced items, unless
+// another device saved it after the revision this one sent
+func handlePutSyncItem(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	name, kind, ok := syncKindOf(w, r)
+	if !ok {
+		return
+	}
+	var req SyncRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if len(req.Data) > kind.maxBytes {
+		writeError(w, fieldError(CodeOutOfRange, "data", "Synced %s must be at most %d bytes", name, kind.maxBytes), http.StatusBadRequest)
+		return
+	}
+	if req.Revision < 0 {
+		writeError(w, fieldError(CodeOutOfRange, "revision", "Revision must not be negative"), http.StatusBadRequest)
+		return
+	}
+	if err := kind.validate(req.Data); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	item, err := store.PutSyncItem(u.ID, name, req.Data, req.Revision, time.Now())
+	if errors.Is(err, errSyncConflict) {
+		writeError(w, fieldError(CodeConflict, "revision", "%v; load it again to get its revision", err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save synced item", "userId", u.ID, "name", name, "err", err)
+		httpError(w, "Failed to save synced item", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, item)
+}
+
+// handleDeleteSyncItem removes one of the signed-in user's synced items,
+// such as a draft that was finished
+func handleDeleteSyncItem(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	name, _, ok := syncKindOf(w, r)
+	if !ok {
+		return
+	}
+	if err := store.DeleteSyncItem(u.ID, name); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete synced item", "userId", u.ID, "name", name, "err", err)
+		httpError(w, "Failed to delete synced item", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userSyncItems returns the items a user has synced, by name
+func userSyncItems(userID string) (map[string]SyncItem, error) {
+	items := map[string]SyncItem{}
+	for name := range syncKinds {
+		item, err := store.SyncItem(userID, name)
+		if errors.Is(err, errSyncNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items[name] = item
+	}
+	return items, nil
+}
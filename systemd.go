@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemdPrefix marks a listen address as a socket passed in by systemd
+// socket activation, optionally followed by its FileDescriptorName
+const systemdPrefix = "systemd:"
+
+// listenFDsStart is the first file descriptor systemd passes sockets on
+const listenFDsStart = 3
+
+// inheritedSocket is a socket passed in by systemd, under the name its
+// socket unit gave it
+type inheritedSocket struct {
+	name string
+	ln   net.Listener
+}
+
+var (
+	systemdMu      sync.Mutex
+	systemdSockets []inheritedSocket
+	systemdErr     error
+	systemdOnce    sync.Once
+)
+
+// loadSystemdSockets takes the sockets systemd passed in through LISTEN_FDS,
+// if they were meant for this process. The variables are cleared so nothing
+// the server starts inherits them.
+func loadSystemdSockets() ([]inheritedSocket, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	sockets := make([]inheritedSocket, n)
+	for i := range sockets {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d from systemd: %w", listenFDsStart+i, err)
+		}
+		sockets[i] = inheritedSocket{name: name, ln: ln}
+	}
+	return sockets, nil
+}
+
+// listenSystemd returns the socket systemd passed in under name, or the
+// first one left when name is empty. Each socket can only be used once.
+func listenSystemd(name string) (net.Listener, error) {
+	systemdMu.Lock()
+	defer systemdMu.Unlock()
+	systemdOnce.Do(func() { systemdSockets, systemdErr = loadSystemdSockets() })
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+	for i, s := range systemdSockets {
+		if name == "" || s.name == name {
+			systemdSockets = append(systemdSockets[:i], systemdSockets[i+1:]...)
+			return s.ln, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no socket was passed in by systemd; start the server from a socket unit")
+	}
+	return nil, fmt.Errorf("no socket named %q was passed in by systemd; set FileDescriptorName=%s in the socket unit", name, name)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// targetTolerance is the mean endpoint distance, as a fraction of the canvas
+// diagonal, at which a target match score drops to about 37
+const targetTolerance = 0.03
+
+// TargetRequest describes the box to generate. Anything left unset is chosen
+// from the seed, so the same request always yields the same target.
+type TargetRequest struct {
+	Seed    int64   `json:"seed"`
+	Width   float64 `json:"width"`
+	Height  float64 `json:"height"`
+	LeftVP  *Point  `json:"leftVP,omitempty"`
+	RightVP *Point  `json:"rightVP,omitempty"`
+	Corner  *Point  `json:"corner,omitempty"`
+}
+
+// Segment is a straight edge between two points
+type Segment struct {
+	A Point `json:"a"`
+	B Point `json:"b"`
+}
+
+// Target is a generated 2-point perspective box to copy.
+// Edges are ordered as 3 verticals, 3 left-converging, 3 right-converging.
+type Target struct {
+	Seed    int64     `json:"seed"`
+	Width   float64   `json:"width"`
+	Height  float64   `json:"height"`
+	LeftVP  Point     `json:"leftVP"`
+	RightVP Point     `json:"rightVP"`
+	Corner  Point     `json:"corner"` // top of the front vertical edge
+	Edges   []Segment `json:"edges"`
+}
+
+// TargetMatch reports how closely the strokes follow a target box
+type TargetMatch struct {
+	Score      float64   `json:"score"`
+	EdgeScores []float64 `json:"edgeScores"`
+	Matches    []int     `json:"matches"` // stroke index matched to each edge, -1 if none
+}
+
+func handleTarget(w http.ResponseWriter, r *http.Request) {
+	var req TargetRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Seed, _ = strconv.ParseInt(q.Get("seed"), 10, 64)
+		req.Width, _ = strconv.ParseFloat(q.Get("width"), 64)
+		req.Height, _ = strconv.ParseFloat(q.Get("height"), 64)
+	case http.MethodPost:
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.Width <= 0 || req.Height <= 0 {
+		httpError(w, "Width and height are required", http.StatusBadRequest)
+		return
+	}
+	if req.Seed == 0 {
+		req.Seed = time.Now().UnixNano()
+	}
+
+	writeResponse(w, r, http.StatusOK, generateTarget(req))
+}
+
+// generateTarget builds a deterministic 2-point perspective box from a seed
+func generateTarget(req TargetRequest) Target {
+	rng := rand.New(rand.NewSource(req.Seed))
+	w, h := req.Width, req.Height
+
+	// Random values are always drawn in the same order so overrides don't
+	// shift the rest of the generated box
+	horizon := h * (0.3 + 0.3*rng.Float64())
+	leftVP := Point{X: w * (-0.6 + 0.7*rng.Float64()), Y: horizon}
+	rightVP := Point{X: w * (0.9 + 0.7*rng.Float64()), Y: horizon}
+	corner := Point{X: w * (0.35 + 0.3*rng.Float64()), Y: horizon + h*(0.05+0.15*rng.Float64())}
+	boxHeight := h * (0.2 + 0.15*rng.Float64())
+	leftDepth := 0.15 + 0.2*rng.Float64()
+	rightDepth := 0.15 + 0.2*rng.Float64()
+
+	if req.LeftVP != nil {
+		leftVP = *req.LeftVP
+	}
+	if req.RightVP != nil {
+		rightVP = *req.RightVP
+	}
+	if req.Corner != nil {
+		corner = *req.Corner
+	}
+
+	frontTop := corner
+	frontBottom := Point{X: corner.X, Y: corner.Y + boxHeight}
+
+	// Side corners part of the way toward each VP
+	leftX := lerpPoint(frontTop, leftVP, leftDepth).X
+	rightX := lerpPoint(frontTop, rightVP, rightDepth).X
+
+	return Target{
+		Seed:    req.Seed,
+		Width:   w,
+		Height:  h,
+		LeftVP:  leftVP,
+		RightVP: rightVP,
+		Corner:  corner,
+		Edges:   perspectiveBox(frontTop, frontBottom, leftVP, rightVP, leftX, rightX),
+	}
+}
+
+// perspectiveBox builds the 9 visible edges of a 2-point perspective box from
+// its front vertical edge, its VPs, and the x-positions of its side edges.
+// Edges are ordered as 3 verticals, 3 left-converging, 3 right-converging.
+func perspectiveBox(frontTop, frontBottom, leftVP, rightVP Point, leftX, rightX float64) []Segment {
+	// Side corners on the edges running to each VP
+	leftTop := pointOnLineAtX(frontTop, leftVP, leftX)
+	leftBottom := pointOnLineAtX(frontBottom, leftVP, leftX)
+	rightTop := pointOnLineAtX(frontTop, rightVP, rightX)
+	rightBottom := pointOnLineAtX(frontBottom, rightVP, rightX)
+
+	// The far top corner is where the back edges cross
+	farTop := rightTop
+	if p := findIntersection(lineThrough(rightTop, leftVP), lineThrough(leftTop, rightVP)); p != nil {
+		farTop = *p
+	}
+
+	return []Segment{
+		{frontTop, frontBottom},
+		{leftTop, leftBottom},
+		{rightTop, rightBottom},
+		{frontTop, leftTop},
+		{frontBottom, leftBottom},
+		{rightTop, farTop},
+		{frontTop, rightTop},
+		{frontBottom, rightBottom},
+		{leftTop, farTop},
+	}
+}
+
+// reconstructBox returns the box implied by the drawing's VPs and its front
+// vertical, with the side edges at the x-positions of the outer verticals.
+// It returns nil unless both VPs were found and there are at least 3 verticals.
+func reconstructBox(strokes []Stroke, lines []Line, verticals []int, leftVP, rightVP *Point) []Segment {
+	if leftVP == nil || rightVP == nil || len(verticals) < 3 {
+		return nil
+	}
+
+	// Order the verticals left to right, like calculateProportionScore
+	sorted := append([]int(nil), verticals...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return strokeCenter(strokes[sorted[i]]).X < strokeCenter(strokes[sorted[j]]).X
+	})
+	front := sorted[len(sorted)/2]
+
+	x := strokeCenter(strokes[front]).X
+	if lines[front].M == math.MaxFloat64 {
+		x = lines[front].B
+	}
+	_, minY, _, maxY := strokeBounds(strokes[front])
+
+	leftX := strokeCenter(strokes[sorted[0]]).X
+	rightX := strokeCenter(strokes[sorted[len(sorted)-1]]).X
+	return perspectiveBox(Point{X: x, Y: minY}, Point{X: x, Y: maxY}, *leftVP, *rightVP, leftX, rightX)
+}
+
+// scoreAgainstTarget greedily matches each target edge to the closest unused
+// stroke and scores the mean endpoint distance
+func scoreAgainstTarget(strokes []Stroke, target Target) TargetMatch {
+	diagonal := math.Hypot(target.Width, target.Height)
+	used := make([]bool, len(strokes))
+	match := TargetMatch{
+		EdgeScores: make([]float64, len(target.Edges)),
+		Matches:    make([]int, len(target.Edges)),
+	}
+
+	total := 0.0
+	for i, edge := range target.Edges {
+		best, bestDist := -1, math.MaxFloat64
+		for j, stroke := range strokes {
+			if used[j] || len(stroke) < 2 {
+				continue
+			}
+			if d := segmentDistance(edge, Segment{stroke[0], stroke[len(stroke)-1]}); d < bestDist {
+				best, bestDist = j, d
+			}
+		}
+
+		match.Matches[i] = best
+		if best < 0 {
+			continue
+		}
+		used[best] = true
+		match.EdgeScores[i] = 100.0 * math.Exp(-bestDist/(diagonal*targetTolerance))
+		total += match.EdgeScores[i]
+	}
+
+	if len(target.Edges) > 0 {
+		match.Score = total / float64(len(target.Edges))
+	}
+	return match
+}
+
+// segmentDistance is the mean distance between matching endpoints,
+// regardless of which direction the stroke was drawn in
+func segmentDistance(a, b Segment) float64 {
+	forward := (distance(a.A, b.A) + distance(a.B, b.B)) / 2
+	backward := (distance(a.A, b.B) + distance(a.B, b.A)) / 2
+	return math.Min(forward, backward)
+}
+
+// lineThrough returns the line passing through two points
+func lineThrough(p, q Point) Line {
+	if math.Abs(q.X-p.X) < 1e-9 {
+		return Line{M: math.MaxFloat64, B: p.X, Angle: 90.0}
+	}
+	m := (q.Y - p.Y) / (q.X - p.X)
+	return Line{M: m, B: p.Y - m*p.X, Angle: math.Atan(m) * 180.0 / math.Pi}
+}
+
+// pointOnLineAtX returns the point on the line through p and q with the given x
+func pointOnLineAtX(p, q Point, x float64) Point {
+	if math.Abs(q.X-p.X) < 1e-9 {
+		return Point{X: x, Y: p.Y}
+	}
+	return Point{X: x, Y: p.Y + (q.Y-p.Y)*(x-p.X)/(q.X-p.X)}
+}
+
+// lerpPoint moves a fraction t of the way from p to q
+func lerpPoint(p, q Point, t float64) Point {
+	return Point{X: p.X + (q.X-p.X)*t, Y: p.Y + (q.Y-p.Y)*t}
+}
+
+// distance returns the Euclidean distance between two points
+func distance(p, q Point) float64 {
+	return math.Hypot(p.X-q.X, p.Y-q.Y)
+}
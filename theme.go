@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// defaultThemeName is the theme used when a request doesn't pick one
+const defaultThemeName = "light"
+
+// Theme holds the visualization colors as hex strings ("#rrggbb" or "#rrggbbaa").
+// In a request it can be given as a theme name ("dark") or as an object; an
+// object starts from the theme named in its "name" field (or the default) and
+// overrides the colors it sets.
+type Theme struct {
+	Name       string `json:"name,omitempty"`
+	Background string `json:"background"`
+	Stroke     string `json:"stroke"`
+	IdealLine  string `json:"idealLine"`
+	AngleLabel string `json:"angleLabel"`
+	IdealBox   string `json:"idealBox"`
+	VPRay      string `json:"vpRay"`
+	VPMarker   string `json:"vpMarker"`
+	Horizon    string `json:"horizon"`
+	Grid       string `json:"grid"`
+	Text       string `json:"text"`
+}
+
+// themes holds the built-in named themes
+var themes = map[string]Theme{
+	"light": {
+		Name:       "light",
+		Background: "#ffffff",
+		Stroke:     "#c8c8c8",
+		IdealLine:  "#00c800",
+		AngleLabel: "#006400c8",
+		IdealBox:   "#8e24aab4",
+		VPRay:      "#ff000078",
+		VPMarker:   "#ff0000",
+		Horizon:    "#0064c8b4",
+		Grid:       "#00000026",
+		Text:       "#000000",
+	},
+	"dark": {
+		Name:       "dark",
+		Background: "#1a1a1a",
+		Stroke:     "#5a5a5a",
+		IdealLine:  "#4caf50",
+		AngleLabel: "#a5d6a7c8",
+		IdealBox:   "#ce93d8b4",
+		VPRay:      "#ff525278",
+		VPMarker:   "#ff5252",
+		Horizon:    "#64b5f6b4",
+		Grid:       "#ffffff26",
+		Text:       "#ffffff",
+	},
+	// The colorblind themes use the Okabe–Ito palette, which stays
+	// distinguishable with red-green color blindness
+	"colorblind": {
+		Name:       "colorblind",
+		Background: "#ffffff",
+		Stroke:     "#c8c8c8",
+		IdealLine:  "#0072b2",
+		AngleLabel: "#005a8cc8",
+		IdealBox:   "#009e73b4",
+		VPRay:      "#e69f00b4",
+		VPMarker:   "#d55e00",
+		Horizon:    "#cc79a7b4",
+		Grid:       "#00000026",
+		Text:       "#000000",
+	},
+	"colorblind-dark": {
+		Name:       "colorblind-dark",
+		Background: "#1a1a1a",
+		Stroke:     "#5a5a5a",
+		IdealLine:  "#56b4e9",
+		AngleLabel: "#56b4e9c8",
+		IdealBox:   "#009e73c8",
+		VPRay:      "#e69f00a0",
+		VPMarker:   "#d55e00",
+		Horizon:    "#cc79a7c8",
+		Grid:       "#ffffff26",
+		Text:       "#ffffff",
+	},
+}
+
+// DecodeMsgpack accepts a theme name or object in MessagePack requests
+func (t *Theme) DecodeMsgpack(dec *msgpack.Decoder) error {
+	return decodeMsgpackAsJSON(dec, t)
+}
+
+// UnmarshalJSON accepts either a theme name or a theme object
+func (t *Theme) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		base, ok := themes[name]
+		if !ok {
+			return fmt.Errorf("unknown theme %q", name)
+		}
+		*t = base
+		return nil
+	}
+
+	// Decode once to find the base theme, then decode again on top of it
+	type plain Theme
+	var probe plain
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	baseName := probe.Name
+	if baseName == "" {
+		baseName = defaultThemeName
+	}
+	base, ok := themes[baseName]
+	if !ok {
+		return fmt.Errorf("unknown theme %q", baseName)
+	}
+
+	merged := plain(base)
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return err
+	}
+	*t = Theme(merged)
+	return nil
+}
+
+// palette holds a theme's parsed colors
+type palette struct {
+	background, stroke, idealLine, angleLabel, idealBox, vpRay, vpMarker, horizon, grid, text color.Color
+}
+
+// palette parses the theme colors; a nil theme uses the default theme
+func (t *Theme) palette() (palette, error) {
+	theme := themes[defaultThemeName]
+	if t != nil {
+		theme = *t
+	}
+
+	var p palette
+	var err error
+	fields := []struct {
+		name  string
+		value string
+		dst   *color.Color
+	}{
+		{"background", theme.Background, &p.background},
+		{"stroke", theme.Stroke, &p.stroke},
+		{"idealLine", theme.IdealLine, &p.idealLine},
+		{"angleLabel", theme.AngleLabel, &p.angleLabel},
+		{"idealBox", theme.IdealBox, &p.idealBox},
+		{"vpRay", theme.VPRay, &p.vpRay},
+		{"vpMarker", theme.VPMarker, &p.vpMarker},
+		{"horizon", theme.Horizon, &p.horizon},
+		{"grid", theme.Grid, &p.grid},
+		{"text", theme.Text, &p.text},
+	}
+	for _, f := range fields {
+		if *f.dst, err = parseHexColor(f.value); err != nil {
+			return palette{}, fmt.Errorf("theme %s: %w", f.name, err)
+		}
+	}
+	return p, nil
+}
+
+// parseHexColor parses "#rgb", "#rrggbb" or "#rrggbbaa"
+func parseHexColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
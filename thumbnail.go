@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// thumbnailWidth is the largest width of an attempt thumbnail in pixels
+const thumbnailWidth = 240
+
+// thumbnailURL is where an attempt's thumbnail is served
+func thumbnailURL(id string) string {
+	return sitePath(apiPrefix + "/result/" + id + "/thumbnail.png")
+}
+
+// thumbnailPath is where an attempt's thumbnail is cached, next to its request
+func thumbnailPath(id string) string {
+	return filepath.Join(resultsDir, attemptsDir, id+"_thumbnail.png")
+}
+
+// thumbnailKey is where an attempt's thumbnail is cached in object storage
+func thumbnailKey(id string) string {
+	return objects.prefix + "thumbnails/" + id + ".png"
+}
+
+// handleThumbnail serves a small PNG of a stored attempt for history and
+// gallery views. With object storage, thumbnails already there are served
+// from it.
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if objects != nil && validAttemptID(id) {
+		ok, err := objects.exists(r.Context(), thumbnailKey(id))
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to look for thumbnail", "attemptId", id, "err", err)
+		}
+		if ok {
+			objects.redirect(w, r, thumbnailKey(id))
+			return
+		}
+	}
+	data, err := loadThumbnail(id)
+	if errors.Is(err, errAttemptNotFound) {
+		httpError(w, "Result not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render thumbnail", "attemptId", id, "err", err)
+		httpError(w, "Failed to load thumbnail", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+id+`-thumbnail"`)
+	http.ServeContent(w, r, "thumbnail.png", time.Time{}, bytes.NewReader(data))
+}
+
+// loadThumbnail returns an attempt's thumbnail, rendering it from the stored
+// request and caching it the first time it's asked for
+func loadThumbnail(id string) ([]byte, error) {
+	if !validAttemptID(id) {
+		return nil, errAttemptNotFound
+	}
+	if objects != nil {
+		data, err := objects.get(context.Background(), thumbnailKey(id))
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, errObjectNotFound) {
+			slog.Warn("Failed to load thumbnail", "attemptId", id, "err", err)
+		}
+	} else if data, err := os.ReadFile(thumbnailPath(id)); err == nil {
+		return data, nil
+	}
+
+	req, err := loadAttempt(id)
+	if err != nil {
+		return nil, err
+	}
+	req.Format = FormatPNG
+	req.Scale = 1
+	req.Antialias = ""
+	req.DPI = 0
+	if err := validateAnalysisRequest(&req); err != nil {
+		return nil, fmt.Errorf("stored attempt is invalid: %w", err)
+	}
+	_, vis := scoreStrokes(req)
+
+	data := renderThumbnail(vis)
+	if objects != nil {
+		err = objects.put(context.Background(), thumbnailKey(id), "image/png", data)
+	} else {
+		err = os.WriteFile(thumbnailPath(id), data, 0644)
+	}
+	if err != nil {
+		slog.Error("Failed to cache thumbnail", "attemptId", id, "err", err)
+	}
+	return data, nil
+}
+
+// renderThumbnail draws the visualization at full size and shrinks it to at
+// most thumbnailWidth wide, so thin lines fade rather than disappear
+func renderThumbnail(v visualization) []byte {
+	c := newGGCanvas(v.req.Width, v.req.Height, 1, viewportFor(v))
+	drawVisualization(c, v)
+	img := c.dc.Image().(*image.RGBA)
+
+	b := img.Bounds()
+	factor := int(math.Ceil(float64(b.Dx()) / thumbnailWidth))
+	if factor > 1 {
+		img = downsample(img, (b.Dx()+factor-1)/factor, (b.Dy()+factor-1)/factor, factor)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		slog.Error("Failed to encode thumbnail", "err", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// useAutocert sets the server up to get certificates from Let's Encrypt and
+// starts the port 80 listener, which answers the ACME HTTP challenge and
+// redirects everything else to HTTPS. The TLS-ALPN challenge also works when
+// the server is on port 443. The returned listener is shut down with the
+// server.
+func useAutocert(server *http.Server, cfg config) *http.Server {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.autocert...),
+		Cache:      autocert.DirCache(cfg.autocertDir),
+	}
+	server.TLSConfig = m.TLSConfig()
+
+	challenge := &http.Server{Addr: ":80", Handler: m.HTTPHandler(nil)}
+	go func() {
+		if err := challenge.ListenAndServe(); err != http.ErrServerClosed {
+			slog.Error("ACME HTTP challenge listener stopped", "err", err)
+		}
+	}()
+	return challenge
+}
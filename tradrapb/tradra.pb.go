@@ -0,0 +1,1059 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: tradrapb/tradra.proto
+
+// The perspective analysis API for native apps and other backends. It mirrors
+// POST /api/v1/analyze and the /api/v1/ws WebSocket; see the README for what
+// each field does.
+
+package tradrapb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Point struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	X             float64                `protobuf:"fixed64,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y             float64                `protobuf:"fixed64,2,opt,name=y,proto3" json:"y,omitempty"`
+	T             float64                `protobuf:"fixed64,3,opt,name=t,proto3" json:"t,omitempty"` // milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Point) Reset() {
+	*x = Point{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Point) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Point) ProtoMessage() {}
+
+func (x *Point) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Point.ProtoReflect.Descriptor instead.
+func (*Point) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Point) GetX() float64 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *Point) GetY() float64 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *Point) GetT() float64 {
+	if x != nil {
+		return x.T
+	}
+	return 0
+}
+
+type Stroke struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Points        []*Point               `protobuf:"bytes,1,rep,name=points,proto3" json:"points,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stroke) Reset() {
+	*x = Stroke{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stroke) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stroke) ProtoMessage() {}
+
+func (x *Stroke) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stroke.ProtoReflect.Descriptor instead.
+func (*Stroke) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Stroke) GetPoints() []*Point {
+	if x != nil {
+		return x.Points
+	}
+	return nil
+}
+
+type AnalyzeRequest struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Strokes            []*Stroke              `protobuf:"bytes,1,rep,name=strokes,proto3" json:"strokes,omitempty"`
+	Width              float64                `protobuf:"fixed64,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height             float64                `protobuf:"fixed64,3,opt,name=height,proto3" json:"height,omitempty"`
+	TrainingType       string                 `protobuf:"bytes,4,opt,name=training_type,json=trainingType,proto3" json:"training_type,omitempty"`
+	DeviceId           string                 `protobuf:"bytes,5,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Difficulty         string                 `protobuf:"bytes,6,opt,name=difficulty,proto3" json:"difficulty,omitempty"`
+	Format             string                 `protobuf:"bytes,7,opt,name=format,proto3" json:"format,omitempty"` // png (default), svg, gif or pdf
+	Scale              float64                `protobuf:"fixed64,8,opt,name=scale,proto3" json:"scale,omitempty"`
+	Theme              string                 `protobuf:"bytes,9,opt,name=theme,proto3" json:"theme,omitempty"`  // a built-in theme by name
+	Style              string                 `protobuf:"bytes,10,opt,name=style,proto3" json:"style,omitempty"` // a built-in style by name
+	Locale             string                 `protobuf:"bytes,11,opt,name=locale,proto3" json:"locale,omitempty"`
+	Heatmap            bool                   `protobuf:"varint,12,opt,name=heatmap,proto3" json:"heatmap,omitempty"`
+	FitVanishingPoints bool                   `protobuf:"varint,13,opt,name=fit_vanishing_points,json=fitVanishingPoints,proto3" json:"fit_vanishing_points,omitempty"`
+	GridRays           int32                  `protobuf:"varint,14,opt,name=grid_rays,json=gridRays,proto3" json:"grid_rays,omitempty"`
+	Transparent        bool                   `protobuf:"varint,15,opt,name=transparent,proto3" json:"transparent,omitempty"`
+	OmitImage          bool                   `protobuf:"varint,16,opt,name=omit_image,json=omitImage,proto3" json:"omit_image,omitempty"`    // leave image out and fetch image_url instead
+	SkipRender         bool                   `protobuf:"varint,17,opt,name=skip_render,json=skipRender,proto3" json:"skip_render,omitempty"` // scores only; nothing is saved
+	Timed              bool                   `protobuf:"varint,18,opt,name=timed,proto3" json:"timed,omitempty"`
+	ElapsedMs          float64                `protobuf:"fixed64,19,opt,name=elapsed_ms,json=elapsedMs,proto3" json:"elapsed_ms,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *AnalyzeRequest) Reset() {
+	*x = AnalyzeRequest{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeRequest) ProtoMessage() {}
+
+func (x *AnalyzeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeRequest.ProtoReflect.Descriptor instead.
+func (*AnalyzeRequest) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AnalyzeRequest) GetStrokes() []*Stroke {
+	if x != nil {
+		return x.Strokes
+	}
+	return nil
+}
+
+func (x *AnalyzeRequest) GetWidth() float64 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *AnalyzeRequest) GetHeight() float64 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *AnalyzeRequest) GetTrainingType() string {
+	if x != nil {
+		return x.TrainingType
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetDifficulty() string {
+	if x != nil {
+		return x.Difficulty
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetScale() float64 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+func (x *AnalyzeRequest) GetTheme() string {
+	if x != nil {
+		return x.Theme
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetStyle() string {
+	if x != nil {
+		return x.Style
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetLocale() string {
+	if x != nil {
+		return x.Locale
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetHeatmap() bool {
+	if x != nil {
+		return x.Heatmap
+	}
+	return false
+}
+
+func (x *AnalyzeRequest) GetFitVanishingPoints() bool {
+	if x != nil {
+		return x.FitVanishingPoints
+	}
+	return false
+}
+
+func (x *AnalyzeRequest) GetGridRays() int32 {
+	if x != nil {
+		return x.GridRays
+	}
+	return 0
+}
+
+func (x *AnalyzeRequest) GetTransparent() bool {
+	if x != nil {
+		return x.Transparent
+	}
+	return false
+}
+
+func (x *AnalyzeRequest) GetOmitImage() bool {
+	if x != nil {
+		return x.OmitImage
+	}
+	return false
+}
+
+func (x *AnalyzeRequest) GetSkipRender() bool {
+	if x != nil {
+		return x.SkipRender
+	}
+	return false
+}
+
+func (x *AnalyzeRequest) GetTimed() bool {
+	if x != nil {
+		return x.Timed
+	}
+	return false
+}
+
+func (x *AnalyzeRequest) GetElapsedMs() float64 {
+	if x != nil {
+		return x.ElapsedMs
+	}
+	return 0
+}
+
+type AnalyzeResponse struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	AttemptId        string                 `protobuf:"bytes,1,opt,name=attempt_id,json=attemptId,proto3" json:"attempt_id,omitempty"`
+	Image            []byte                 `protobuf:"bytes,2,opt,name=image,proto3" json:"image,omitempty"`                          // the visualization in the requested format
+	ImageType        string                 `protobuf:"bytes,3,opt,name=image_type,json=imageType,proto3" json:"image_type,omitempty"` // its MIME type
+	ImageUrl         string                 `protobuf:"bytes,4,opt,name=image_url,json=imageUrl,proto3" json:"image_url,omitempty"`
+	ThumbnailUrl     string                 `protobuf:"bytes,5,opt,name=thumbnail_url,json=thumbnailUrl,proto3" json:"thumbnail_url,omitempty"`
+	CompositeScore   float64                `protobuf:"fixed64,6,opt,name=composite_score,json=compositeScore,proto3" json:"composite_score,omitempty"`
+	AverageLineScore float64                `protobuf:"fixed64,7,opt,name=average_line_score,json=averageLineScore,proto3" json:"average_line_score,omitempty"`
+	PerspectiveScore float64                `protobuf:"fixed64,8,opt,name=perspective_score,json=perspectiveScore,proto3" json:"perspective_score,omitempty"`
+	ConvergenceScore float64                `protobuf:"fixed64,9,opt,name=convergence_score,json=convergenceScore,proto3" json:"convergence_score,omitempty"`
+	ProportionScore  float64                `protobuf:"fixed64,10,opt,name=proportion_score,json=proportionScore,proto3" json:"proportion_score,omitempty"`
+	CompositionScore float64                `protobuf:"fixed64,11,opt,name=composition_score,json=compositionScore,proto3" json:"composition_score,omitempty"`
+	LineScores       []float64              `protobuf:"fixed64,12,rep,packed,name=line_scores,json=lineScores,proto3" json:"line_scores,omitempty"`
+	LeftVp           *Point                 `protobuf:"bytes,13,opt,name=left_vp,json=leftVp,proto3" json:"left_vp,omitempty"`
+	RightVp          *Point                 `protobuf:"bytes,14,opt,name=right_vp,json=rightVp,proto3" json:"right_vp,omitempty"`
+	Percentile       *float64               `protobuf:"fixed64,15,opt,name=percentile,proto3,oneof" json:"percentile,omitempty"`
+	Grade            *Grade                 `protobuf:"bytes,16,opt,name=grade,proto3" json:"grade,omitempty"`
+	Feedback         []string               `protobuf:"bytes,17,rep,name=feedback,proto3" json:"feedback,omitempty"`
+	Partial          bool                   `protobuf:"varint,18,opt,name=partial,proto3" json:"partial,omitempty"`
+	Assisted         bool                   `protobuf:"varint,19,opt,name=assisted,proto3" json:"assisted,omitempty"`
+	ExpectedStrokes  int32                  `protobuf:"varint,20,opt,name=expected_strokes,json=expectedStrokes,proto3" json:"expected_strokes,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *AnalyzeResponse) Reset() {
+	*x = AnalyzeResponse{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeResponse) ProtoMessage() {}
+
+func (x *AnalyzeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeResponse.ProtoReflect.Descriptor instead.
+func (*AnalyzeResponse) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AnalyzeResponse) GetAttemptId() string {
+	if x != nil {
+		return x.AttemptId
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetImageType() string {
+	if x != nil {
+		return x.ImageType
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetImageUrl() string {
+	if x != nil {
+		return x.ImageUrl
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetThumbnailUrl() string {
+	if x != nil {
+		return x.ThumbnailUrl
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetCompositeScore() float64 {
+	if x != nil {
+		return x.CompositeScore
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetAverageLineScore() float64 {
+	if x != nil {
+		return x.AverageLineScore
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetPerspectiveScore() float64 {
+	if x != nil {
+		return x.PerspectiveScore
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetConvergenceScore() float64 {
+	if x != nil {
+		return x.ConvergenceScore
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetProportionScore() float64 {
+	if x != nil {
+		return x.ProportionScore
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetCompositionScore() float64 {
+	if x != nil {
+		return x.CompositionScore
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetLineScores() []float64 {
+	if x != nil {
+		return x.LineScores
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetLeftVp() *Point {
+	if x != nil {
+		return x.LeftVp
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetRightVp() *Point {
+	if x != nil {
+		return x.RightVp
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetPercentile() float64 {
+	if x != nil && x.Percentile != nil {
+		return *x.Percentile
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetGrade() *Grade {
+	if x != nil {
+		return x.Grade
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetFeedback() []string {
+	if x != nil {
+		return x.Feedback
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetPartial() bool {
+	if x != nil {
+		return x.Partial
+	}
+	return false
+}
+
+func (x *AnalyzeResponse) GetAssisted() bool {
+	if x != nil {
+		return x.Assisted
+	}
+	return false
+}
+
+func (x *AnalyzeResponse) GetExpectedStrokes() int32 {
+	if x != nil {
+		return x.ExpectedStrokes
+	}
+	return 0
+}
+
+type Grade struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Letter        string                 `protobuf:"bytes,1,opt,name=letter,proto3" json:"letter,omitempty"`
+	Score         float64                `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Grade) Reset() {
+	*x = Grade{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Grade) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Grade) ProtoMessage() {}
+
+func (x *Grade) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Grade.ProtoReflect.Descriptor instead.
+func (*Grade) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Grade) GetLetter() string {
+	if x != nil {
+		return x.Letter
+	}
+	return ""
+}
+
+func (x *Grade) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+type LiveRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`       // start, points, end, stroke, undo or clear
+	Request       *AnalyzeRequest        `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"` // for start
+	Points        []*Point               `protobuf:"bytes,3,rep,name=points,proto3" json:"points,omitempty"`   // for points, end and stroke
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LiveRequest) Reset() {
+	*x = LiveRequest{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LiveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LiveRequest) ProtoMessage() {}
+
+func (x *LiveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LiveRequest.ProtoReflect.Descriptor instead.
+func (*LiveRequest) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *LiveRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *LiveRequest) GetRequest() *AnalyzeRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *LiveRequest) GetPoints() []*Point {
+	if x != nil {
+		return x.Points
+	}
+	return nil
+}
+
+type LiveResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"` // update, or error when a request was rejected
+	Update        *LiveAnalysis          `protobuf:"bytes,2,opt,name=update,proto3" json:"update,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LiveResponse) Reset() {
+	*x = LiveResponse{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LiveResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LiveResponse) ProtoMessage() {}
+
+func (x *LiveResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LiveResponse.ProtoReflect.Descriptor instead.
+func (*LiveResponse) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *LiveResponse) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *LiveResponse) GetUpdate() *LiveAnalysis {
+	if x != nil {
+		return x.Update
+	}
+	return nil
+}
+
+func (x *LiveResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type LiveAnalysis struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Strokes           int32                  `protobuf:"varint,1,opt,name=strokes,proto3" json:"strokes,omitempty"`
+	ExpectedStrokes   int32                  `protobuf:"varint,2,opt,name=expected_strokes,json=expectedStrokes,proto3" json:"expected_strokes,omitempty"`
+	Lines             []*LiveLine            `protobuf:"bytes,3,rep,name=lines,proto3" json:"lines,omitempty"`
+	LeftVp            *Point                 `protobuf:"bytes,4,opt,name=left_vp,json=leftVp,proto3" json:"left_vp,omitempty"`
+	RightVp           *Point                 `protobuf:"bytes,5,opt,name=right_vp,json=rightVp,proto3" json:"right_vp,omitempty"`
+	ConvergenceErrorL float64                `protobuf:"fixed64,6,opt,name=convergence_error_l,json=convergenceErrorL,proto3" json:"convergence_error_l,omitempty"`
+	ConvergenceErrorR float64                `protobuf:"fixed64,7,opt,name=convergence_error_r,json=convergenceErrorR,proto3" json:"convergence_error_r,omitempty"`
+	AverageLineScore  float64                `protobuf:"fixed64,8,opt,name=average_line_score,json=averageLineScore,proto3" json:"average_line_score,omitempty"`
+	PerspectiveScore  float64                `protobuf:"fixed64,9,opt,name=perspective_score,json=perspectiveScore,proto3" json:"perspective_score,omitempty"`
+	CompositeScore    float64                `protobuf:"fixed64,10,opt,name=composite_score,json=compositeScore,proto3" json:"composite_score,omitempty"`
+	Feedback          []string               `protobuf:"bytes,11,rep,name=feedback,proto3" json:"feedback,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *LiveAnalysis) Reset() {
+	*x = LiveAnalysis{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LiveAnalysis) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LiveAnalysis) ProtoMessage() {}
+
+func (x *LiveAnalysis) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LiveAnalysis.ProtoReflect.Descriptor instead.
+func (*LiveAnalysis) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *LiveAnalysis) GetStrokes() int32 {
+	if x != nil {
+		return x.Strokes
+	}
+	return 0
+}
+
+func (x *LiveAnalysis) GetExpectedStrokes() int32 {
+	if x != nil {
+		return x.ExpectedStrokes
+	}
+	return 0
+}
+
+func (x *LiveAnalysis) GetLines() []*LiveLine {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+func (x *LiveAnalysis) GetLeftVp() *Point {
+	if x != nil {
+		return x.LeftVp
+	}
+	return nil
+}
+
+func (x *LiveAnalysis) GetRightVp() *Point {
+	if x != nil {
+		return x.RightVp
+	}
+	return nil
+}
+
+func (x *LiveAnalysis) GetConvergenceErrorL() float64 {
+	if x != nil {
+		return x.ConvergenceErrorL
+	}
+	return 0
+}
+
+func (x *LiveAnalysis) GetConvergenceErrorR() float64 {
+	if x != nil {
+		return x.ConvergenceErrorR
+	}
+	return 0
+}
+
+func (x *LiveAnalysis) GetAverageLineScore() float64 {
+	if x != nil {
+		return x.AverageLineScore
+	}
+	return 0
+}
+
+func (x *LiveAnalysis) GetPerspectiveScore() float64 {
+	if x != nil {
+		return x.PerspectiveScore
+	}
+	return 0
+}
+
+func (x *LiveAnalysis) GetCompositeScore() float64 {
+	if x != nil {
+		return x.CompositeScore
+	}
+	return 0
+}
+
+func (x *LiveAnalysis) GetFeedback() []string {
+	if x != nil {
+		return x.Feedback
+	}
+	return nil
+}
+
+type LiveLine struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	From          *Point                 `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"` // ends of the ideal line over the stroke
+	To            *Point                 `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	Angle         float64                `protobuf:"fixed64,3,opt,name=angle,proto3" json:"angle,omitempty"`
+	Score         float64                `protobuf:"fixed64,4,opt,name=score,proto3" json:"score,omitempty"`
+	Group         string                 `protobuf:"bytes,5,opt,name=group,proto3" json:"group,omitempty"` // vertical, left or right, once clustered
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LiveLine) Reset() {
+	*x = LiveLine{}
+	mi := &file_tradrapb_tradra_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LiveLine) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LiveLine) ProtoMessage() {}
+
+func (x *LiveLine) ProtoReflect() protoreflect.Message {
+	mi := &file_tradrapb_tradra_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LiveLine.ProtoReflect.Descriptor instead.
+func (*LiveLine) Descriptor() ([]byte, []int) {
+	return file_tradrapb_tradra_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *LiveLine) GetFrom() *Point {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *LiveLine) GetTo() *Point {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+func (x *LiveLine) GetAngle() float64 {
+	if x != nil {
+		return x.Angle
+	}
+	return 0
+}
+
+func (x *LiveLine) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *LiveLine) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+var File_tradrapb_tradra_proto protoreflect.FileDescriptor
+
+const file_tradrapb_tradra_proto_rawDesc = "" +
+	"\n" +
+	"\x15tradrapb/tradra.proto\x12\ttradra.v1\"1\n" +
+	"\x05Point\x12\f\n" +
+	"\x01x\x18\x01 \x01(\x01R\x01x\x12\f\n" +
+	"\x01y\x18\x02 \x01(\x01R\x01y\x12\f\n" +
+	"\x01t\x18\x03 \x01(\x01R\x01t\"2\n" +
+	"\x06Stroke\x12(\n" +
+	"\x06points\x18\x01 \x03(\v2\x10.tradra.v1.PointR\x06points\"\xbf\x04\n" +
+	"\x0eAnalyzeRequest\x12+\n" +
+	"\astrokes\x18\x01 \x03(\v2\x11.tradra.v1.StrokeR\astrokes\x12\x14\n" +
+	"\x05width\x18\x02 \x01(\x01R\x05width\x12\x16\n" +
+	"\x06height\x18\x03 \x01(\x01R\x06height\x12#\n" +
+	"\rtraining_type\x18\x04 \x01(\tR\ftrainingType\x12\x1b\n" +
+	"\tdevice_id\x18\x05 \x01(\tR\bdeviceId\x12\x1e\n" +
+	"\n" +
+	"difficulty\x18\x06 \x01(\tR\n" +
+	"difficulty\x12\x16\n" +
+	"\x06format\x18\a \x01(\tR\x06format\x12\x14\n" +
+	"\x05scale\x18\b \x01(\x01R\x05scale\x12\x14\n" +
+	"\x05theme\x18\t \x01(\tR\x05theme\x12\x14\n" +
+	"\x05style\x18\n" +
+	" \x01(\tR\x05style\x12\x16\n" +
+	"\x06locale\x18\v \x01(\tR\x06locale\x12\x18\n" +
+	"\aheatmap\x18\f \x01(\bR\aheatmap\x120\n" +
+	"\x14fit_vanishing_points\x18\r \x01(\bR\x12fitVanishingPoints\x12\x1b\n" +
+	"\tgrid_rays\x18\x0e \x01(\x05R\bgridRays\x12 \n" +
+	"\vtransparent\x18\x0f \x01(\bR\vtransparent\x12\x1d\n" +
+	"\n" +
+	"omit_image\x18\x10 \x01(\bR\tomitImage\x12\x1f\n" +
+	"\vskip_render\x18\x11 \x01(\bR\n" +
+	"skipRender\x12\x14\n" +
+	"\x05timed\x18\x12 \x01(\bR\x05timed\x12\x1d\n" +
+	"\n" +
+	"elapsed_ms\x18\x13 \x01(\x01R\telapsedMs\"\x82\x06\n" +
+	"\x0fAnalyzeResponse\x12\x1d\n" +
+	"\n" +
+	"attempt_id\x18\x01 \x01(\tR\tattemptId\x12\x14\n" +
+	"\x05image\x18\x02 \x01(\fR\x05image\x12\x1d\n" +
+	"\n" +
+	"image_type\x18\x03 \x01(\tR\timageType\x12\x1b\n" +
+	"\timage_url\x18\x04 \x01(\tR\bimageUrl\x12#\n" +
+	"\rthumbnail_url\x18\x05 \x01(\tR\fthumbnailUrl\x12'\n" +
+	"\x0fcomposite_score\x18\x06 \x01(\x01R\x0ecompositeScore\x12,\n" +
+	"\x12average_line_score\x18\a \x01(\x01R\x10averageLineScore\x12+\n" +
+	"\x11perspective_score\x18\b \x01(\x01R\x10perspectiveScore\x12+\n" +
+	"\x11convergence_score\x18\t \x01(\x01R\x10convergenceScore\x12)\n" +
+	"\x10proportion_score\x18\n" +
+	" \x01(\x01R\x0fproportionScore\x12+\n" +
+	"\x11composition_score\x18\v \x01(\x01R\x10compositionScore\x12\x1f\n" +
+	"\vline_scores\x18\f \x03(\x01R\n" +
+	"lineScores\x12)\n" +
+	"\aleft_vp\x18\r \x01(\v2\x10.tradra.v1.PointR\x06leftVp\x12+\n" +
+	"\bright_vp\x18\x0e \x01(\v2\x10.tradra.v1.PointR\arightVp\x12#\n" +
+	"\n" +
+	"percentile\x18\x0f \x01(\x01H\x00R\n" +
+	"percentile\x88\x01\x01\x12&\n" +
+	"\x05grade\x18\x10 \x01(\v2\x10.tradra.v1.GradeR\x05grade\x12\x1a\n" +
+	"\bfeedback\x18\x11 \x03(\tR\bfeedback\x12\x18\n" +
+	"\apartial\x18\x12 \x01(\bR\apartial\x12\x1a\n" +
+	"\bassisted\x18\x13 \x01(\bR\bassisted\x12)\n" +
+	"\x10expected_strokes\x18\x14 \x01(\x05R\x0fexpectedStrokesB\r\n" +
+	"\v_percentile\"5\n" +
+	"\x05Grade\x12\x16\n" +
+	"\x06letter\x18\x01 \x01(\tR\x06letter\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\x01R\x05score\"\x80\x01\n" +
+	"\vLiveRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x123\n" +
+	"\arequest\x18\x02 \x01(\v2\x19.tradra.v1.AnalyzeRequestR\arequest\x12(\n" +
+	"\x06points\x18\x03 \x03(\v2\x10.tradra.v1.PointR\x06points\"i\n" +
+	"\fLiveResponse\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12/\n" +
+	"\x06update\x18\x02 \x01(\v2\x17.tradra.v1.LiveAnalysisR\x06update\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\xd6\x03\n" +
+	"\fLiveAnalysis\x12\x18\n" +
+	"\astrokes\x18\x01 \x01(\x05R\astrokes\x12)\n" +
+	"\x10expected_strokes\x18\x02 \x01(\x05R\x0fexpectedStrokes\x12)\n" +
+	"\x05lines\x18\x03 \x03(\v2\x13.tradra.v1.LiveLineR\x05lines\x12)\n" +
+	"\aleft_vp\x18\x04 \x01(\v2\x10.tradra.v1.PointR\x06leftVp\x12+\n" +
+	"\bright_vp\x18\x05 \x01(\v2\x10.tradra.v1.PointR\arightVp\x12.\n" +
+	"\x13convergence_error_l\x18\x06 \x01(\x01R\x11convergenceErrorL\x12.\n" +
+	"\x13convergence_error_r\x18\a \x01(\x01R\x11convergenceErrorR\x12,\n" +
+	"\x12average_line_score\x18\b \x01(\x01R\x10averageLineScore\x12+\n" +
+	"\x11perspective_score\x18\t \x01(\x01R\x10perspectiveScore\x12'\n" +
+	"\x0fcomposite_score\x18\n" +
+	" \x01(\x01R\x0ecompositeScore\x12\x1a\n" +
+	"\bfeedback\x18\v \x03(\tR\bfeedback\"\x94\x01\n" +
+	"\bLiveLine\x12$\n" +
+	"\x04from\x18\x01 \x01(\v2\x10.tradra.v1.PointR\x04from\x12 \n" +
+	"\x02to\x18\x02 \x01(\v2\x10.tradra.v1.PointR\x02to\x12\x14\n" +
+	"\x05angle\x18\x03 \x01(\x01R\x05angle\x12\x14\n" +
+	"\x05score\x18\x04 \x01(\x01R\x05score\x12\x14\n" +
+	"\x05group\x18\x05 \x01(\tR\x05group2\x92\x01\n" +
+	"\bAnalyzer\x12@\n" +
+	"\aAnalyze\x12\x19.tradra.v1.AnalyzeRequest\x1a\x1a.tradra.v1.AnalyzeResponse\x12D\n" +
+	"\rAnalyzeStream\x12\x16.tradra.v1.LiveRequest\x1a\x17.tradra.v1.LiveResponse(\x010\x01B\x11Z\x0ftradra/tradrapbb\x06proto3"
+
+var (
+	file_tradrapb_tradra_proto_rawDescOnce sync.Once
+	file_tradrapb_tradra_proto_rawDescData []byte
+)
+
+func file_tradrapb_tradra_proto_rawDescGZIP() []byte {
+	file_tradrapb_tradra_proto_rawDescOnce.Do(func() {
+		file_tradrapb_tradra_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tradrapb_tradra_proto_rawDesc), len(file_tradrapb_tradra_proto_rawDesc)))
+	})
+	return file_tradrapb_tradra_proto_rawDescData
+}
+
+var file_tradrapb_tradra_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_tradrapb_tradra_proto_goTypes = []any{
+	(*Point)(nil),           // 0: tradra.v1.Point
+	(*Stroke)(nil),          // 1: tradra.v1.Stroke
+	(*AnalyzeRequest)(nil),  // 2: tradra.v1.AnalyzeRequest
+	(*AnalyzeResponse)(nil), // 3: tradra.v1.AnalyzeResponse
+	(*Grade)(nil),           // 4: tradra.v1.Grade
+	(*LiveRequest)(nil),     // 5: tradra.v1.LiveRequest
+	(*LiveResponse)(nil),    // 6: tradra.v1.LiveResponse
+	(*LiveAnalysis)(nil),    // 7: tradra.v1.LiveAnalysis
+	(*LiveLine)(nil),        // 8: tradra.v1.LiveLine
+}
+var file_tradrapb_tradra_proto_depIdxs = []int32{
+	0,  // 0: tradra.v1.Stroke.points:type_name -> tradra.v1.Point
+	1,  // 1: tradra.v1.AnalyzeRequest.strokes:type_name -> tradra.v1.Stroke
+	0,  // 2: tradra.v1.AnalyzeResponse.left_vp:type_name -> tradra.v1.Point
+	0,  // 3: tradra.v1.AnalyzeResponse.right_vp:type_name -> tradra.v1.Point
+	4,  // 4: tradra.v1.AnalyzeResponse.grade:type_name -> tradra.v1.Grade
+	2,  // 5: tradra.v1.LiveRequest.request:type_name -> tradra.v1.AnalyzeRequest
+	0,  // 6: tradra.v1.LiveRequest.points:type_name -> tradra.v1.Point
+	7,  // 7: tradra.v1.LiveResponse.update:type_name -> tradra.v1.LiveAnalysis
+	8,  // 8: tradra.v1.LiveAnalysis.lines:type_name -> tradra.v1.LiveLine
+	0,  // 9: tradra.v1.LiveAnalysis.left_vp:type_name -> tradra.v1.Point
+	0,  // 10: tradra.v1.LiveAnalysis.right_vp:type_name -> tradra.v1.Point
+	0,  // 11: tradra.v1.LiveLine.from:type_name -> tradra.v1.Point
+	0,  // 12: tradra.v1.LiveLine.to:type_name -> tradra.v1.Point
+	2,  // 13: tradra.v1.Analyzer.Analyze:input_type -> tradra.v1.AnalyzeRequest
+	5,  // 14: tradra.v1.Analyzer.AnalyzeStream:input_type -> tradra.v1.LiveRequest
+	3,  // 15: tradra.v1.Analyzer.Analyze:output_type -> tradra.v1.AnalyzeResponse
+	6,  // 16: tradra.v1.Analyzer.AnalyzeStream:output_type -> tradra.v1.LiveResponse
+	15, // [15:17] is the sub-list for method output_type
+	13, // [13:15] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_tradrapb_tradra_proto_init() }
+func file_tradrapb_tradra_proto_init() {
+	if File_tradrapb_tradra_proto != nil {
+		return
+	}
+	file_tradrapb_tradra_proto_msgTypes[3].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tradrapb_tradra_proto_rawDesc), len(file_tradrapb_tradra_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tradrapb_tradra_proto_goTypes,
+		DependencyIndexes: file_tradrapb_tradra_proto_depIdxs,
+		MessageInfos:      file_tradrapb_tradra_proto_msgTypes,
+	}.Build()
+	File_tradrapb_tradra_proto = out.File
+	file_tradrapb_tradra_proto_goTypes = nil
+	file_tradrapb_tradra_proto_depIdxs = nil
+}
@@ -0,0 +1,164 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: tradrapb/tradra.proto
+
+// The perspective analysis API for native apps and other backends. It mirrors
+// POST /api/v1/analyze and the /api/v1/ws WebSocket; see the README for what
+// each field does.
+
+package tradrapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Analyzer_Analyze_FullMethodName       = "/tradra.v1.Analyzer/Analyze"
+	Analyzer_AnalyzeStream_FullMethodName = "/tradra.v1.Analyzer/AnalyzeStream"
+)
+
+// AnalyzerClient is the client API for Analyzer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AnalyzerClient interface {
+	// Analyze scores a finished attempt and renders its visualization
+	Analyze(ctx context.Context, in *AnalyzeRequest, opts ...grpc.CallOption) (*AnalyzeResponse, error)
+	// AnalyzeStream analyzes strokes as they're drawn, answering each finished
+	// stroke with the line fits and provisional vanishing points so far
+	AnalyzeStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LiveRequest, LiveResponse], error)
+}
+
+type analyzerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnalyzerClient(cc grpc.ClientConnInterface) AnalyzerClient {
+	return &analyzerClient{cc}
+}
+
+func (c *analyzerClient) Analyze(ctx context.Context, in *AnalyzeRequest, opts ...grpc.CallOption) (*AnalyzeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AnalyzeResponse)
+	err := c.cc.Invoke(ctx, Analyzer_Analyze_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyzerClient) AnalyzeStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LiveRequest, LiveResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Analyzer_ServiceDesc.Streams[0], Analyzer_AnalyzeStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LiveRequest, LiveResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Analyzer_AnalyzeStreamClient = grpc.BidiStreamingClient[LiveRequest, LiveResponse]
+
+// AnalyzerServer is the server API for Analyzer service.
+// All implementations must embed UnimplementedAnalyzerServer
+// for forward compatibility.
+type AnalyzerServer interface {
+	// Analyze scores a finished attempt and renders its visualization
+	Analyze(context.Context, *AnalyzeRequest) (*AnalyzeResponse, error)
+	// AnalyzeStream analyzes strokes as they're drawn, answering each finished
+	// stroke with the line fits and provisional vanishing points so far
+	AnalyzeStream(grpc.BidiStreamingServer[LiveRequest, LiveResponse]) error
+	mustEmbedUnimplementedAnalyzerServer()
+}
+
+// UnimplementedAnalyzerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAnalyzerServer struct{}
+
+func (UnimplementedAnalyzerServer) Analyze(context.Context, *AnalyzeRequest) (*AnalyzeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Analyze not implemented")
+}
+func (UnimplementedAnalyzerServer) AnalyzeStream(grpc.BidiStreamingServer[LiveRequest, LiveResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method AnalyzeStream not implemented")
+}
+func (UnimplementedAnalyzerServer) mustEmbedUnimplementedAnalyzerServer() {}
+func (UnimplementedAnalyzerServer) testEmbeddedByValue()                  {}
+
+// UnsafeAnalyzerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnalyzerServer will
+// result in compilation errors.
+type UnsafeAnalyzerServer interface {
+	mustEmbedUnimplementedAnalyzerServer()
+}
+
+func RegisterAnalyzerServer(s grpc.ServiceRegistrar, srv AnalyzerServer) {
+	// If the following call pancis, it indicates UnimplementedAnalyzerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Analyzer_ServiceDesc, srv)
+}
+
+func _Analyzer_Analyze_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnalyzeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyzerServer).Analyze(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Analyzer_Analyze_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyzerServer).Analyze(ctx, req.(*AnalyzeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Analyzer_AnalyzeStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AnalyzerServer).AnalyzeStream(&grpc.GenericServerStream[LiveRequest, LiveResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Analyzer_AnalyzeStreamServer = grpc.BidiStreamingServer[LiveRequest, LiveResponse]
+
+// Analyzer_ServiceDesc is the grpc.ServiceDesc for Analyzer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Analyzer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tradra.v1.Analyzer",
+	HandlerType: (*AnalyzerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Analyze",
+			Handler:    _Analyzer_Analyze_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AnalyzeStream",
+			Handler:       _Analyzer_AnalyzeStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "tradrapb/tradra.proto",
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"unicode/utf8"
+)
+
+// Watermark sizes in output pixels at 1x
+const (
+	watermarkLogoHeight = 32
+	watermarkMargin     = 10
+	watermarkOpacity    = 0.6
+)
+
+// watermark is an attribution stamped into the bottom-right corner of every
+// exported visualization, for site operators sharing results publicly
+type watermark struct {
+	text string
+	logo image.Image
+}
+
+// siteWatermark is set once at startup from the environment
+var siteWatermark watermark
+
+// loadWatermark reads the watermark text from TRADRA_WATERMARK and a PNG or
+// JPEG logo from the path in TRADRA_WATERMARK_LOGO. Both are optional.
+func loadWatermark() (watermark, error) {
+	wm := watermark{text: os.Getenv("TRADRA_WATERMARK")}
+	path := os.Getenv("TRADRA_WATERMARK_LOGO")
+	if path == "" {
+		return wm, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return wm, err
+	}
+	defer f.Close()
+	wm.logo, _, err = image.Decode(f)
+	if err != nil {
+		return wm, fmt.Errorf("watermark logo %s: %v", path, err)
+	}
+	return wm, nil
+}
+
+// drawWatermark draws the watermark in the bottom-right corner of the frame,
+// the logo right-aligned with the text to its left, faded so it doesn't
+// compete with the analysis
+func drawWatermark(c Canvas, wm watermark, textColor color.Color, frame viewport, unit float64) {
+	if wm.text == "" && wm.logo == nil {
+		return
+	}
+	c.BeginGroup("watermark")
+	right := frame.X + frame.W - watermarkMargin*unit
+	bottom := frame.Y + frame.H - watermarkMargin*unit
+	if wm.logo != nil {
+		b := wm.logo.Bounds()
+		h := watermarkLogoHeight * unit
+		w := h * float64(b.Dx()) / float64(b.Dy())
+		right -= w
+		c.DrawImage(wm.logo, right, bottom-h, w, h)
+		right -= 6 * unit
+	}
+	if wm.text != "" {
+		n := color.NRGBAModel.Convert(textColor).(color.NRGBA)
+		n.A = uint8(float64(n.A) * watermarkOpacity)
+		c.SetColor(n)
+		// Canvases can't measure text, so the width is estimated
+		width := float64(utf8.RuneCountInString(wm.text)) * labelFontSize * 0.55 * unit
+		c.DrawString(wm.text, right-width, bottom-labelFontSize/3*unit)
+	}
+	c.EndGroup()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Webhook delivery. Failed deliveries are retried with the wait doubling
+// each time, on network errors and on 429 and 5xx responses.
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+	webhookBackoff  = 5 * time.Second
+)
+
+// Webhook request headers. The signature is "t=<unix time>,v1=<hex
+// HMAC-SHA256 of the time, a dot and the body>", so receivers can check
+// both who sent it and that it isn't being replayed.
+const (
+	webhookEventHeader     = "X-Tradra-Event"
+	webhookSignatureHeader = "X-Tradra-Signature"
+	webhookEventJobDone    = "job.done"
+	webhookEventGoal       = "goal.reminder"
+)
+
+// errCallbackNotPublic is returned for callbacks to addresses that aren't
+// allowed, which no retry will fix
+var errCallbackNotPublic = errors.New("callback address isn't public")
+
+// webhookSecretPrefix starts every generated webhook secret
+const webhookSecretPrefix = "whsec_"
+
+// webhooks is set at startup
+var webhooks *webhookSender
+
+// webhookSender posts events to callback URLs
+type webhookSender struct {
+	client *http.Client
+	secret string // signs callbacks that have no API key secret
+}
+
+// webhook is where to send an event and how to sign it
+type webhook struct {
+	url    string
+	secret string
+}
+
+// newWebhookSender sets up delivery. Unless private addresses are allowed,
+// callbacks can't reach loopback, private or link-local addresses, which
+// is checked when connecting so DNS can't be used to get around it.
+func newWebhookSender(cfg config) *webhookSender {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !cfg.webhookAllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", errCallbackNotPublic, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would be dialed instead of the callback
+	transport.DialContext = dialer.DialContext
+	return &webhookSender{
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: transport,
+			// Following redirects would let a callback point anywhere
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		secret: cfg.webhookSecret,
+	}
+}
+
+// publicIP reports whether an address is reachable on the internet rather
+// than on this machine or its network
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+// validateCallbackURL checks a callback is an absolute http or https URL
+func validateCallbackURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return fieldError(CodeInvalidValue, "callbackUrl", "Callback URL must be an http or https URL, not %q", s)
+	}
+	return nil
+}
+
+// newWebhookSecret generates a secret for signing an API key's callbacks
+func newWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return webhookSecretPrefix + hex.EncodeToString(b)
+}
+
+// hookFor picks where a request's callback goes: the URL it gave, or else
+// its API key's. Callbacks are signed with the key's secret, or the server's
+// for requests without one. It returns nil when there's no callback.
+func (s *webhookSender) hookFor(ctx context.Context, callbackURL string) (*webhook, error) {
+	key, hasKey := apiKeyFrom(ctx)
+	if callbackURL == "" && hasKey {
+		callbackURL = key.CallbackURL
+	}
+	if callbackURL == "" {
+		return nil, nil
+	}
+	if err := validateCallbackURL(callbackURL); err != nil {
+		return nil, err
+	}
+	secret := s.secret
+	if hasKey && key.WebhookSecret != "" {
+		secret = key.WebhookSecret
+	}
+	if secret == "" {
+		return nil, errors.New("Callbacks need an API key with a webhook secret, or a server webhook secret")
+	}
+	return &webhook{url: callbackURL, secret: secret}, nil
+}
+
+// sign returns the signature header for a body sent at a time
+func (h *webhook) sign(t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts an event as JSON, retrying failed deliveries
+func (s *webhookSender) send(ctx context.Context, h *webhook, event string, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode webhook", "event", event, "err", err)
+		return
+	}
+
+	wait := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, h, event, body)
+		if err == nil {
+			slog.InfoContext(ctx, "Delivered webhook", "event", event, "url", h.url, "attempt", attempt)
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			slog.WarnContext(ctx, "Failed to deliver webhook", "event", event, "url", h.url, "attempt", attempt, "err", err)
+			return
+		}
+		slog.DebugContext(ctx, "Retrying webhook", "event", event, "url", h.url, "attempt", attempt, "err", err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post makes one delivery attempt, reporting whether a failure is worth
+// retrying
+func (s *webhookSender) post(ctx context.Context, h *webhook, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tradra-webhook")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookSignatureHeader, h.sign(time.Now(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, errCallbackNotPublic), err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return false, fmt.Errorf("callback answered %s", resp.Status)
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workspacesFile holds the workspaces inside the results directory
+const workspacesFile = "workspaces.json"
+
+// workspaceHeader picks the workspace a request works in. Links and forms
+// can use the workspace query parameter instead.
+const workspaceHeader = "X-Workspace"
+
+// maxWorkspaceName is the longest name a workspace may be given
+const maxWorkspaceName = 64
+
+// Workspace roles. Admins manage the members and exercises of their
+// workspace and see everyone's progress; members see their own.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// workspaceIDPattern keeps workspace IDs short and safe in URLs and headers
+var workspaceIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// errWorkspaceNotFound is returned for unknown workspaces, and for
+// workspaces the user isn't a member of so their existence isn't revealed
+var errWorkspaceNotFound = errors.New("Workspace not found")
+
+// Workspace is an isolated group, such as a drawing school's class, with its
+// own members, exercises and history
+type Workspace struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Members   map[string]string `json:"members,omitempty"`   // role by user ID
+	Exercises []TrainingType    `json:"exercises,omitempty"` // the training types allowed, or all when empty
+	Classes   map[string]Class  `json:"classes,omitempty"`   // by ID
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// WorkspaceSummary is a workspace as its members see it
+type WorkspaceSummary struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Role      string         `json:"role"`
+	Exercises []TrainingType `json:"exercises,omitempty"`
+}
+
+// CreateWorkspaceRequest sets up a workspace with its first admins
+type CreateWorkspaceRequest struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Admins    []string       `json:"admins"` // user IDs, as shown by /api/v1/me
+	Exercises []TrainingType `json:"exercises,omitempty"`
+}
+
+// MemberRequest sets a member's role
+type MemberRequest struct {
+	Role string `json:"role"`
+}
+
+var (
+	workspacesMu sync.Mutex
+	workspaces   map[string]Workspace // by ID, loaded on first use
+)
+
+// loadWorkspacesLocked reads the workspaces from disk on first use.
+// The caller must hold workspacesMu.
+func loadWorkspacesLocked() error {
+	if workspaces != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(resultsDir, workspacesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		workspaces = map[string]Workspace{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]Workspace{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	workspaces = loaded
+	return nil
+}
+
+// saveWorkspacesLocked writes the workspaces. The caller must hold workspacesMu.
+func saveWorkspacesLocked() error {
+	data, err := json.MarshalIndent(workspaces, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(resultsDir, workspacesFile), data, 0600)
+}
+
+// updateWorkspace applies change to a copy of a workspace and saves it,
+// keeping the old one if saving fails
+func updateWorkspace(id string, change func(*Workspace) error) (Workspace, error) {
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+	if err := loadWorkspacesLocked(); err != nil {
+		return Workspace{}, err
+	}
+	old, ok := workspaces[id]
+	if !ok {
+		return Workspace{}, errWorkspaceNotFound
+	}
+
+	ws := old
+	ws.Members = maps.Clone(old.Members)
+	ws.Exercises = slices.Clone(old.Exercises)
+	ws.Classes = maps.Clone(old.Classes)
+	if err := change(&ws); err != nil {
+		return Workspace{}, err
+	}
+
+	workspaces[id] = ws
+	if err := saveWorkspacesLocked(); err != nil {
+		workspaces[id] = old
+		return Workspace{}, err
+	}
+	return ws, nil
+}
+
+// validateExercises rejects training types that don't exist
+func validateExercises(types []TrainingType) error {
+	for _, t := range types {
+		if _, ok := defaultExercises[t]; !ok {
+			return fieldError(CodeInvalidValue, "exercises", "Unknown exercise %q", t)
+		}
+	}
+	return nil
+}
+
+// createWorkspace stores a new workspace
+func createWorkspace(req CreateWorkspaceRequest) (Workspace, error) {
+	if !workspaceIDPattern.MatchString(req.ID) {
+		return Workspace{}, errors.New("Workspace ID must be 1 to 32 lowercase letters, digits or dashes")
+	}
+	if req.Name == "" || len(req.Name) > maxWorkspaceName {
+		return Workspace{}, fmt.Errorf("Workspace name must be 1 to %d characters", maxWorkspaceName)
+	}
+	if len(req.Admins) == 0 {
+		return Workspace{}, errors.New("A workspace needs at least one admin")
+	}
+	if err := validateExercises(req.Exercises); err != nil {
+		return Workspace{}, err
+	}
+
+	ws := Workspace{ID: req.ID, Name: req.Name, Members: map[string]string{}, Exercises: req.Exercises, CreatedAt: time.Now()}
+	for _, user := range req.Admins {
+		ws.Members[user] = RoleAdmin
+	}
+
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+	if err := loadWorkspacesLocked(); err != nil {
+		return Workspace{}, err
+	}
+	if _, ok := workspaces[ws.ID]; ok {
+		return Workspace{}, fmt.Errorf("A workspace with ID %q already exists", ws.ID)
+	}
+	workspaces[ws.ID] = ws
+	if err := saveWorkspacesLocked(); err != nil {
+		delete(workspaces, ws.ID)
+		return Workspace{}, err
+	}
+	return ws, nil
+}
+
+// deleteWorkspace removes a workspace. Its attempts stay in the history but
+// no longer show up anywhere.
+func deleteWorkspace(id string) (bool, error) {
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+	if err := loadWorkspacesLocked(); err != nil {
+		return false, err
+	}
+	ws, ok := workspaces[id]
+	if !ok {
+		return false, nil
+	}
+	delete(workspaces, id)
+	if err := saveWorkspacesLocked(); err != nil {
+		workspaces[id] = ws
+		return false, err
+	}
+	return true, nil
+}
+
+// leaveWorkspaces removes a user from every workspace, even as its last
+// admin, for when their account is purged. The server's admin can still
+// delete a workspace left without one.
+func leaveWorkspaces(userID string) error {
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+	if err := loadWorkspacesLocked(); err != nil {
+		return err
+	}
+	old := maps.Clone(workspaces)
+	changed := false
+	for id, ws := range workspaces {
+		if _, ok := ws.Members[userID]; ok {
+			ws.Members = maps.Clone(ws.Members)
+			delete(ws.Members, userID)
+			ws.Classes = maps.Clone(ws.Classes)
+			dropFromClasses(&ws, userID)
+			workspaces[id] = ws
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := saveWorkspacesLocked(); err != nil {
+		workspaces = old
+		return err
+	}
+	return nil
+}
+
+// listWorkspaces returns every workspace by ID
+func listWorkspaces() ([]Workspace, error) {
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+	if err := loadWorkspacesLocked(); err != nil {
+		return nil, err
+	}
+	list := []Workspace{}
+	for _, ws := range workspaces {
+		list = append(list, ws)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// memberWorkspace returns a workspace and the user's role in it, or
+// errWorkspaceNotFound when the user isn't a member
+func memberWorkspace(id, userID string) (Workspace, string, error) {
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+	if err := loadWorkspacesLocked(); err != nil {
+		return Workspace{}, "", err
+	}
+	ws, ok := workspaces[id]
+	role := ws.Members[userID]
+	if !ok || role == "" {
+		return Workspace{}, "", errWorkspaceNotFound
+	}
+	return ws, role, nil
+}
+
+// summary is the workspace as a member with the given role sees it
+func (ws Workspace) summary(role string) WorkspaceSummary {
+	return WorkspaceSummary{ID: ws.ID, Name: ws.Name, Role: role, Exercises: ws.Exercises}
+}
+
+// allows reports whether attempts at a training type may be made in the workspace
+func (ws Workspace) allows(t TrainingType) bool {
+	return len(ws.Exercises) == 0 || slices.Contains(ws.Exercises, t)
+}
+
+// workspaceContext is the workspace a request works in and the user's role
+type workspaceContext struct {
+	Workspace
+	role string
+}
+
+type workspaceKey struct{}
+
+// workspaceFrom returns the workspace of a request's context, if it picked one
+func workspaceFrom(ctx context.Context) (workspaceContext, bool) {
+	ws, ok := ctx.Value(workspaceKey{}).(workspaceContext)
+	return ws, ok
+}
+
+// workspaceID returns the ID of a context's workspace, or "" outside one
+func workspaceID(ctx context.Context) string {
+	ws, _ := workspaceFrom(ctx)
+	return ws.ID
+}
+
+// withWorkspace puts the workspace a request picked in its context, after
+// checking the signed-in user is a member. Requests that don't pick one work
+// outside every workspace, as before workspaces existed.
+func withWorkspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(workspaceHeader)
+		if id == "" {
+			id = r.URL.Query().Get("workspace")
+		}
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, ok := userFrom(r.Context())
+		if !ok {
+			httpError(w, "Sign in to use a workspace", http.StatusUnauthorized)
+			return
+		}
+		ws, role, err := memberWorkspace(id, user.ID)
+		if errors.Is(err, errWorkspaceNotFound) {
+			writeError(w, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load workspaces", "err", err)
+			httpError(w, "Failed to load workspace", http.StatusInternalServerError)
+			return
+		}
+		addLogAttrs(r.Context(), "workspace", ws.ID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), workspaceKey{}, workspaceContext{ws, role})))
+	})
+}
+
+// validateWorkspaceRequest validates an analysis request and checks its
+// exercise is allowed in the context's workspace
+func validateWorkspaceRequest(ctx context.Context, req *AnalysisRequest) error {
+	if err := validateAnalysisRequest(req); err != nil {
+		return err
+	}
+	if ws, ok := workspaceFrom(ctx); ok && !ws.allows(req.TrainingType) {
+		return fieldError(CodeExerciseNotAllowed, "trainingType", "Exercise %q isn't available in this workspace", req.TrainingType)
+	}
+	return nil
+}
+
+// inWorkspace returns the attempts made in a workspace, or outside every
+// workspace when id is empty
+func inWorkspace(history []AttemptSummary, id string) []AttemptSummary {
+	var attempts []AttemptSummary
+	for _, a := range history {
+		if a.WorkspaceID == id {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts
+}
+
+// visibleHistory returns the attempts a request may see: in its workspace
+// everyone's for admins and their own for members, and outside every
+// workspace their own, the guest's, or the anonymous ones when nobody's
+// signed in
+func visibleHistory(ctx context.Context, history []AttemptSummary) []AttemptSummary {
+	ws, ok := workspaceFrom(ctx)
+	attempts := inWorkspace(history, ws.ID)
+	if ok && ws.role == RoleAdmin {
+		return attempts
+	}
+	owner := ownerID(ctx)
+	return slices.DeleteFunc(attempts, func(a AttemptSummary) bool { return a.UserID != owner })
+}
+
+// ownHistory returns the caller's own attempts in a request's workspace,
+// which even admins' scores are only compared with
+func ownHistory(ctx context.Context, history []AttemptSummary) []AttemptSummary {
+	owner := ownerID(ctx)
+	return slices.DeleteFunc(inWorkspace(history, workspaceID(ctx)), func(a AttemptSummary) bool { return a.UserID != owner })
+}
+
+// requireWorkspaceAdmin wraps a handler for /workspaces/{workspace}/... so
+// only the workspace's admins may call it
+func requireWorkspaceAdmin(next func(http.ResponseWriter, *http.Request, Workspace)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := userFrom(r.Context())
+		if !ok {
+			httpError(w, "Not signed in", http.StatusUnauthorized)
+			return
+		}
+		ws, role, err := memberWorkspace(r.PathValue("workspace"), user.ID)
+		if errors.Is(err, errWorkspaceNotFound) {
+			writeError(w, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load workspaces", "err", err)
+			httpError(w, "Failed to load workspace", http.StatusInternalServerError)
+			return
+		}
+		if role != RoleAdmin {
+			httpError(w, "Only workspace admins can do this", http.StatusForbidden)
+			return
+		}
+		next(w, r, ws)
+	}
+}
+
+// handleMyWorkspaces lists the workspaces the signed-in user is a member of
+func handleMyWorkspaces(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFrom(r.Context())
+	if !ok {
+		httpError(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	mine, err := userWorkspaces(user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load workspaces", "err", err)
+		httpError(w, "Failed to load workspaces", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, mine)
+}
+
+// userWorkspaces returns the workspaces a user is a member of
+func userWorkspaces(userID string) ([]WorkspaceSummary, error) {
+	all, err := listWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+	mine := []WorkspaceSummary{}
+	for _, ws := range all {
+		if role := ws.Members[userID]; role != "" {
+			mine = append(mine, ws.summary(role))
+		}
+	}
+	return mine, nil
+}
+
+// handleWorkspace returns a workspace with its members, for its admins
+func handleWorkspace(w http.ResponseWriter, r *http.Request, ws Workspace) {
+	writeResponse(w, r, http.StatusOK, ws)
+}
+
+// handleSetMember adds a member or changes their role. The last admin can't
+// be made a member, so every workspace stays manageable.
+func handleSetMember(w http.ResponseWriter, r *http.Request, ws Workspace) {
+	var req MemberRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Role != RoleAdmin && req.Role != RoleMember {
+		httpError(w, "Role must be admin or member", http.StatusBadRequest)
+		return
+	}
+	user := r.PathValue("user")
+	updated, err := updateWorkspace(ws.ID, func(ws *Workspace) error {
+		previous := ws.Members[user]
+		ws.Members[user] = req.Role
+		if previous == RoleAdmin && req.Role != RoleAdmin && !slices.Contains(slices.Collect(maps.Values(ws.Members)), RoleAdmin) {
+			return errLastAdmin
+		}
+		return nil
+	})
+	if errors.Is(err, errLastAdmin) {
+		writeError(w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update workspace", "workspace", ws.ID, "err", err)
+		httpError(w, "Failed to update workspace", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Set workspace member", "workspace", ws.ID, "member", user, "role", req.Role)
+	writeResponse(w, r, http.StatusOK, updated)
+}
+
+// handleRemoveMember removes a member. The last admin can't be removed, so
+// every workspace stays manageable.
+func handleRemoveMember(w http.ResponseWriter, r *http.Request, ws Workspace) {
+	user := r.PathValue("user")
+	_, err := updateWorkspace(ws.ID, func(ws *Workspace) error {
+		role, ok := ws.Members[user]
+		if !ok {
+			return errMemberNotFound
+		}
+		delete(ws.Members, user)
+		dropFromClasses(ws, user)
+		if role == RoleAdmin && !slices.Contains(slices.Collect(maps.Values(ws.Members)), RoleAdmin) {
+			return errLastAdmin
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errMemberNotFound):
+		writeError(w, err, http.StatusNotFound)
+		return
+	case errors.Is(err, errLastAdmin):
+		writeError(w, err, http.StatusConflict)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to update workspace", "workspace", ws.ID, "err", err)
+		httpError(w, "Failed to update workspace", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Removed workspace member", "workspace", ws.ID, "member", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Errors from changing members
+var (
+	errMemberNotFound = errors.New("Member not found")
+	errLastAdmin      = errors.New("Can't remove or demote the last admin")
+)
+
+// handleSetExercises sets the exercises allowed in a workspace, or allows
+// them all given an empty list
+func handleSetExercises(w http.ResponseWriter, r *http.Request, ws Workspace) {
+	var types []TrainingType
+	if !decodeRequest(w, r, &types) {
+		return
+	}
+	if err := validateExercises(types); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	updated, err := updateWorkspace(ws.ID, func(ws *Workspace) error {
+		ws.Exercises = types
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update workspace", "workspace", ws.ID, "err", err)
+		httpError(w, "Failed to update workspace", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Set workspace exercises", "workspace", ws.ID, "exercises", types)
+	writeResponse(w, r, http.StatusOK, updated)
+}
+
+// handleListWorkspaces lists every workspace, for the server's admin
+func handleListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	list, err := listWorkspaces()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load workspaces", "err", err)
+		httpError(w, "Failed to load workspaces", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, list)
+}
+
+// handleCreateWorkspace sets up a workspace, for the server's admin
+func handleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var req CreateWorkspaceRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.ID, req.Name = strings.TrimSpace(req.ID), strings.TrimSpace(req.Name)
+	ws, err := createWorkspace(req)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "Created workspace", "workspace", ws.ID)
+	w.Header().Set("Location", sitePath(apiPrefix+"/workspaces/"+ws.ID))
+	writeResponse(w, r, http.StatusCreated, ws)
+}
+
+// handleDeleteWorkspace removes a workspace, for the server's admin
+func handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("workspace")
+	ok, err := deleteWorkspace(id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete workspace", "workspace", id, "err", err)
+		httpError(w, "Failed to delete workspace", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		writeError(w, errWorkspaceNotFound, http.StatusNotFound)
+		return
+	}
+	slog.InfoContext(r.Context(), "Deleted workspace", "workspace", id)
+	w.WriteHeader(http.StatusNoContent)
+}